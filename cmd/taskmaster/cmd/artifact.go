@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// artifactChunkSize is the size of the chunks uploaded by `uploadArtifact`.
+const artifactChunkSize = 64 << 10
+
+// sandboxReadCommand is the hidden subcommand the worker reads artifacts through as the sandbox user.
+const sandboxReadCommand = "sandbox-read"
+
+// outsideFolder returns true if the relative path `file` leaves its base folder.
+func outsideFolder(file string) bool {
+	file = filepath.Clean(file)
+	return filepath.IsAbs(file) || file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator))
+}
+
+// collectArtifacts returns the regular files in `WorkDir` matching `Patterns`, keyed by their paths relative to `WorkDir`.
+// Returns error if a pattern leaves `WorkDir`, or a match is a symbolic link or resolves outside of `WorkDir`.
+func collectArtifacts(WorkDir string, Patterns []string) (map[string]string, error) {
+	root, err := filepath.EvalSymlinks(WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, pattern := range Patterns {
+		if outsideFolder(filepath.FromSlash(pattern)) {
+			return nil, fmt.Errorf("artifact path `%s` is outside of the working directory", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			log.Printf("artifact path `%s` matches no file", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Lstat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return nil, fmt.Errorf("artifact `%s` is a symbolic link", match)
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			// The file itself is not a link, but a directory on its path may be.
+			resolved, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				return nil, err
+			}
+			name, err := filepath.Rel(root, resolved)
+			if err != nil || outsideFolder(name) {
+				return nil, fmt.Errorf("artifact `%s` is outside of the working directory", match)
+			}
+			files[filepath.ToSlash(name)] = resolved
+		}
+	}
+	return files, nil
+}
+
+// openArtifact opens the regular file `file` without following symbolic links.
+func openArtifact(file string) (*os.File, error) {
+	input, err := openNoFollow(file)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := input.Stat(); err != nil || !info.Mode().IsRegular() {
+		input.Close()
+		return nil, fmt.Errorf("`%s` is not a regular file", file)
+	}
+	return input, nil
+}
+
+// userFile is the content of a file streamed by `sandbox-read` running as another user.
+type userFile struct {
+	cmd    *exec.Cmd
+	output io.ReadCloser
+	stderr bytes.Buffer
+	waited bool
+	err    error
+}
+
+// wait reaps the reader, only called once its output is drained or no longer needed.
+func (file *userFile) wait() error {
+	if !file.waited {
+		file.waited = true
+		if err := file.cmd.Wait(); err != nil {
+			file.err = fmt.Errorf("%v: %s", err, strings.TrimSpace(file.stderr.String()))
+		}
+	}
+	return file.err
+}
+
+// Read reports the failure of the reader instead of `io.EOF`, so a file it cannot read is never taken as empty.
+func (file *userFile) Read(data []byte) (int, error) {
+	n, err := file.output.Read(data)
+	if err == io.EOF {
+		if waitErr := file.wait(); waitErr != nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (file *userFile) Close() error {
+	if !file.waited {
+		file.cmd.Process.Kill()
+		file.wait()
+	}
+	return nil
+}
+
+// openAsUser opens `file` with the permissions of `User`, see `SandboxConfig.User`.
+// The worker usually runs as root, so reading the file itself would bypass the permissions of the task.
+func openAsUser(ctx context.Context, file string, User string) (io.ReadCloser, error) {
+	if len(User) == 0 {
+		return openArtifact(file)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, executable, sandboxReadCommand, file)
+	if err := setCredential(cmd, User); err != nil {
+		return nil, err
+	}
+	reader := &userFile{cmd: cmd}
+	cmd.Stderr = &reader.stderr
+	if reader.output, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// HandleSandboxRead copies the regular file in `args` to stdout without following symbolic links.
+// It is run by workers as the sandbox user and not meant to be used directly.
+func HandleSandboxRead(args ...string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s [file]", sandboxReadCommand)
+	}
+	input, err := openArtifact(args[0])
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	_, err = io.Copy(os.Stdout, input)
+	return err
+}
+
+// uploadArtifact attaches the content of `file`, read as `User`, to the task `ID` as `name`.
+func uploadArtifact(ctx context.Context, client pb.TaskMasterClient, group string, ID string, name string, file string, User string) error {
+	input, err := openAsUser(ctx, file, User)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	// Cancelling the stream drops the partial upload, while closing it would commit a truncated artifact.
+	ctx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+	stream, err := client.UploadArtifact(ctx)
+	if err != nil {
+		return err
+	}
+	// The first chunk names the artifact, errors of `Send` are reported by `CloseAndRecv`.
+	if err := stream.Send(&pb.UploadArtifactRequest{Group: group, ID: ID, Name: name}); err == nil {
+		buffer := make([]byte, artifactChunkSize)
+		for {
+			n, err := input.Read(buffer)
+			if n > 0 {
+				if err := stream.Send(&pb.UploadArtifactRequest{Data: buffer[:n]}); err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// uploadArtifacts uploads the files in `WorkDir` matching `Patterns` as the artifacts of the task `ID`.
+// The files are read as `User`, the user the task ran as.
+func uploadArtifacts(ctx context.Context, client pb.TaskMasterClient, group string, ID string, WorkDir string, Patterns []string, User string) error {
+	files, err := collectArtifacts(WorkDir, Patterns)
+	if err != nil {
+		return err
+	}
+	for name, file := range files {
+		if err := uploadArtifact(ctx, client, group, ID, name, file, User); err != nil {
+			return fmt.Errorf("cannot upload artifact `%s`: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// downloadArtifact writes `artifact` of the task `ID` under `OutputFolder` and verifies its hash.
+func downloadArtifact(ctx context.Context, client pb.TaskMasterClient, group string, ID string, artifact *pb.Artifact, OutputFolder string) error {
+	name := filepath.FromSlash(artifact.GetName())
+	if outsideFolder(name) {
+		return fmt.Errorf("invalid artifact name `%s`", artifact.GetName())
+	}
+	target := filepath.Join(OutputFolder, name)
+	if err := os.MkdirAll(filepath.Dir(target), fs.ModePerm); err != nil {
+		return err
+	}
+	stream, err := client.GetArtifact(ctx, &pb.GetArtifactRequest{Group: group, ID: ID, Name: artifact.GetName()})
+	if err != nil {
+		return err
+	}
+	output, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	hasher := sha256.New()
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.MultiWriter(output, hasher).Write(chunk.GetData()); err != nil {
+			return err
+		}
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != artifact.GetHash() {
+		return fmt.Errorf("artifact `%s` is corrupted", artifact.GetName())
+	}
+	return output.Close()
+}
+
+// DownloadArtifacts downloads the artifacts of the task `ID` in `WorkerGroup` into `OutputFolder`.
+// If `List` is set, the artifacts are only listed.
+func DownloadArtifacts(Context context.Context, Address string, WorkerGroup string, ID string, OutputFolder string, List bool, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	resp, err := client.GetTask(Context, &pb.GetTaskRequest{Group: WorkerGroup, ID: ID})
+	if err != nil {
+		return err
+	}
+	artifacts := resp.GetTask().GetArtifacts()
+	if len(artifacts) == 0 {
+		return fmt.Errorf("task `%s` has no artifact", ID)
+	}
+	for _, artifact := range artifacts {
+		if List {
+			fmt.Printf("%s\t%d\t%s\n", artifact.GetName(), artifact.GetSize(), artifact.GetHash())
+			continue
+		}
+		if err := downloadArtifact(Context, client, WorkerGroup, ID, artifact, OutputFolder); err != nil {
+			return err
+		}
+		fmt.Printf("Downloaded `%s` (%d bytes).\n", artifact.GetName(), artifact.GetSize())
+	}
+	return nil
+}
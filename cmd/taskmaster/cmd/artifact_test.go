@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeArtifact(t *testing.T, file string, content string) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	workDir, outside := t.TempDir(), t.TempDir()
+	writeArtifact(t, filepath.Join(workDir, "out", "result.txt"), "result")
+	writeArtifact(t, filepath.Join(workDir, "out", "log.txt"), "log")
+	writeArtifact(t, filepath.Join(outside, "secret.txt"), "secret")
+
+	files, err := collectArtifacts(workDir, []string{"out/*.txt", "missing/*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["out/result.txt"] == "" || files["out/log.txt"] == "" {
+		t.Errorf("expect the files in the working directory, got %v", files)
+	}
+	for _, pattern := range []string{"../*", filepath.Join(outside, "*")} {
+		if _, err := collectArtifacts(workDir, []string{pattern}); err == nil {
+			t.Errorf("expect pattern `%s` leaving the working directory to be rejected", pattern)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workDir, "link.txt")); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+	if _, err := collectArtifacts(workDir, []string{"*.txt"}); err == nil {
+		t.Error("expect symbolic links to be rejected")
+	}
+	if err := os.Symlink(outside, filepath.Join(workDir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collectArtifacts(workDir, []string{"linked/*"}); err == nil {
+		t.Error("expect files behind a linked directory to be rejected")
+	}
+	if _, err := openArtifact(filepath.Join(workDir, "link.txt")); err == nil {
+		t.Error("expect opening a symbolic link to fail")
+	}
+}
+
+func TestOpenAsUser(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "result.txt")
+	writeArtifact(t, file, "result")
+	input, err := openAsUser(context.Background(), file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	if data, err := io.ReadAll(input); err != nil || string(data) != "result" {
+		t.Errorf("expect the content of the file, got %q, %v", data, err)
+	}
+	if _, err := openAsUser(context.Background(), filepath.Dir(file), ""); err == nil {
+		t.Error("expect directories to be rejected")
+	}
+}
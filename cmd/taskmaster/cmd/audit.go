@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+)
+
+// AuditQuery selects the records of an audit log, empty fields match any record.
+type AuditQuery struct {
+	Group  string
+	ID     string
+	Caller string
+	Op     string
+	// Since selects the records within the duration if positive.
+	Since time.Duration
+	// JSON prints the records as JSON lines instead of columns.
+	JSON bool
+}
+
+func (query *AuditQuery) match(record *taskmaster.AuditRecord, now time.Time) bool {
+	return (len(query.Group) == 0 || record.Group == query.Group) &&
+		(len(query.ID) == 0 || record.ID == query.ID) &&
+		(len(query.Caller) == 0 || record.Caller == query.Caller) &&
+		(len(query.Op) == 0 || record.Op == query.Op) &&
+		(query.Since <= 0 || now.Sub(record.Time) <= query.Since)
+}
+
+// QueryAuditLog prints the records of the audit log at `Path` matching `Query`, oldest first.
+func QueryAuditLog(Path string, Query AuditQuery) error {
+	now := time.Now()
+	encoder := json.NewEncoder(os.Stdout)
+	return taskmaster.ReadAuditLog(Path, func(record taskmaster.AuditRecord) error {
+		if !Query.match(&record, now) {
+			return nil
+		}
+		if Query.JSON {
+			return encoder.Encode(record)
+		}
+		result := "ok"
+		if len(record.Error) > 0 {
+			result = record.Error
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.Time.Local().Format(time.RFC3339), record.Caller, record.Op,
+			record.Group, record.ID, record.DataHash, result)
+		return nil
+	})
+}
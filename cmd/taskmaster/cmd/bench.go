@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+const (
+	// benchBacklogLabel is required by the backlog tasks of a benchmark, which no benchmark worker has.
+	benchBacklogLabel = "bench-backlog"
+	// benchRetryDelay is the delay before retrying a failed or empty call.
+	benchRetryDelay = 10 * time.Millisecond
+	// benchCleanupTimeout bounds the cancellation of the backlog once the benchmark ends.
+	benchCleanupTimeout = time.Minute
+)
+
+// BenchConfig configures a benchmark run by `RunBenchmark`.
+type BenchConfig struct {
+	Group string
+	// Inserters and Workers are the numbers of concurrent inserting clients and fake workers.
+	Inserters int
+	Workers   int
+	// Tasks is the total number of tasks inserted, unlimited if zero. The run ends once all of them are finished.
+	Tasks int
+	// Duration is the maximum duration of the run.
+	Duration    time.Duration
+	PayloadSize int
+	// Backlog is the number of tasks inserted before the run which no worker can lease.
+	// They are skipped by every query, which shows how the lease path degrades with the queue size.
+	Backlog int
+	// LeaseDuration is the loan duration requested by the workers.
+	LeaseDuration time.Duration
+	// WorkTime is the time a worker holds each task before finishing it.
+	// Lease conflicts are expected once it exceeds `LeaseDuration`.
+	WorkTime time.Duration
+}
+
+// latencyRecorder collects the latencies of an operation.
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (recorder *latencyRecorder) observe(latency time.Duration) {
+	recorder.mu.Lock()
+	recorder.samples = append(recorder.samples, latency)
+	recorder.mu.Unlock()
+}
+
+// percentile returns the latency at quantile `q` of the sorted samples.
+func percentile(samples []time.Duration, q float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	index := int(q * float64(len(samples)-1))
+	return samples[index]
+}
+
+func (recorder *latencyRecorder) report(name string, elapsed time.Duration) string {
+	recorder.mu.Lock()
+	samples := append([]time.Duration(nil), recorder.samples...)
+	recorder.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var maxLatency time.Duration
+	if len(samples) > 0 {
+		maxLatency = samples[len(samples)-1]
+	}
+	return fmt.Sprintf("%-8s %8d ops %10.1f ops/s  p50 %-10v p90 %-10v p99 %-10v max %v",
+		name, len(samples), float64(len(samples))/elapsed.Seconds(),
+		percentile(samples, 0.5), percentile(samples, 0.9), percentile(samples, 0.99), maxLatency)
+}
+
+// benchStats holds the results of a benchmark run.
+type benchStats struct {
+	insert, lease, finish latencyRecorder
+
+	// emptyQueries counts the queries which found no task.
+	emptyQueries int64
+	// duplicateLeases counts the tasks handed out while another worker still believed to hold them.
+	duplicateLeases int64
+	// rejectedFinishes counts the finishes rejected because the task was no longer held by the worker.
+	rejectedFinishes int64
+	errors           int64
+	finished         int64
+
+	mu      sync.Mutex
+	holders map[string]int
+}
+
+// acquire records that `worker` leased `ID`, returns false if another worker still holds it.
+func (stats *benchStats) acquire(ID string, worker int) bool {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	holder, exists := stats.holders[ID]
+	stats.holders[ID] = worker
+	return !exists || holder == worker
+}
+
+func (stats *benchStats) release(ID string, worker int) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if stats.holders[ID] == worker {
+		delete(stats.holders, ID)
+	}
+}
+
+// startBenchServer serves an in-process task master on a loopback port with its snapshots in a temporary folder.
+// The returned function stops the server and removes the folder.
+func startBenchServer() (string, func(), error) {
+	folder, err := os.MkdirTemp("", "taskmaster-bench")
+	if err != nil {
+		return "", nil, err
+	}
+	server, err := taskmaster.NewTaskMasterServer(folder, time.Hour)
+	if err != nil {
+		os.RemoveAll(folder)
+		return "", nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		server.Close()
+		os.RemoveAll(folder)
+		return "", nil, err
+	}
+	grpcServer := grpc.NewServer()
+	grpcServer.RegisterService(&pb.TaskMaster_ServiceDesc, server)
+	go grpcServer.Serve(listener)
+	return listener.Addr().String(), func() {
+		grpcServer.Stop()
+		server.Close()
+		os.RemoveAll(folder)
+	}, nil
+}
+
+func benchInserter(ctx context.Context, client pb.TaskMasterClient, config BenchConfig, payload string, remaining *int64, stats *benchStats) {
+	for ctx.Err() == nil {
+		if config.Tasks > 0 && atomic.AddInt64(remaining, -1) < 0 {
+			return
+		}
+		startTime := time.Now()
+		_, err := client.Insert(ctx, &pb.InsertRequest{Group: config.Group, Data: payload})
+		if err != nil {
+			if config.Tasks > 0 {
+				// The task is inserted again, so the run still ends once all tasks are finished.
+				atomic.AddInt64(remaining, 1)
+			}
+			if ctx.Err() == nil {
+				atomic.AddInt64(&stats.errors, 1)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(benchRetryDelay):
+			}
+			continue
+		}
+		stats.insert.observe(time.Since(startTime))
+	}
+}
+
+func benchWorker(ctx context.Context, client pb.TaskMasterClient, config BenchConfig, worker int, stats *benchStats, done func()) {
+	for ctx.Err() == nil {
+		startTime := time.Now()
+		resp, err := client.Query(ctx, &pb.QueryRequest{Group: config.Group, LoanDuration: durationpb.New(config.LeaseDuration)})
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+			case status.Code(err) == codes.NotFound || status.Code(err) == codes.ResourceExhausted:
+				atomic.AddInt64(&stats.emptyQueries, 1)
+				select {
+				case <-ctx.Done():
+				case <-time.After(benchRetryDelay):
+				}
+			default:
+				atomic.AddInt64(&stats.errors, 1)
+			}
+			continue
+		}
+		stats.lease.observe(time.Since(startTime))
+		if !stats.acquire(resp.GetID(), worker) {
+			atomic.AddInt64(&stats.duplicateLeases, 1)
+		}
+		if config.WorkTime > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(config.WorkTime):
+			}
+		}
+		startTime = time.Now()
+		_, err = client.Finish(ctx, &pb.FinishRequest{Group: config.Group, ID: resp.GetID()})
+		stats.release(resp.GetID(), worker)
+		switch {
+		case err == nil:
+			stats.finish.observe(time.Since(startTime))
+			if finished := atomic.AddInt64(&stats.finished, 1); config.Tasks > 0 && finished >= int64(config.Tasks) {
+				done()
+			}
+		case ctx.Err() != nil:
+		case status.Code(err) == codes.NotFound:
+			atomic.AddInt64(&stats.rejectedFinishes, 1)
+		default:
+			atomic.AddInt64(&stats.errors, 1)
+		}
+	}
+}
+
+// cancelBacklog cancels the backlog tasks `IDs` of `group`, even if the benchmark is interrupted.
+func cancelBacklog(client pb.TaskMasterClient, group string, IDs []string) {
+	if len(IDs) == 0 {
+		return
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), benchCleanupTimeout)
+	defer cancelFn()
+	failed := 0
+	for _, ID := range IDs {
+		if _, err := client.CancelTask(ctx, &pb.CancelTaskRequest{Group: group, ID: ID}); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Cannot cancel %d of %d backlog tasks in group `%s`\n", failed, len(IDs), group)
+	}
+}
+
+// RunBenchmark runs inserters and fake workers against the task master at `Address` and prints throughput,
+// latency percentiles and lease conflicts. If `Address` is empty, an in-process server is benchmarked.
+// The backlog is cancelled once the run ends, but the other tasks inserted are left in `Config.Group`,
+// so a dedicated group should be used against real servers.
+func RunBenchmark(Context context.Context, Address string, Config BenchConfig, DialOption grpc.DialOption) error {
+	if Config.Inserters <= 0 && Config.Workers <= 0 {
+		return fmt.Errorf("at least one inserter or worker is required")
+	}
+	if Config.Duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if len(Config.Group) == 0 {
+		Config.Group = "bench"
+	}
+	if Config.LeaseDuration <= 0 {
+		Config.LeaseDuration = time.Minute
+	}
+	if len(Address) == 0 {
+		address, stop, err := startBenchServer()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		Address = address
+		DialOption = grpc.WithInsecure()
+	}
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+
+	backlog := make([]string, 0, Config.Backlog)
+	defer func() { cancelBacklog(client, Config.Group, backlog) }()
+	for i := 0; i < Config.Backlog; i++ {
+		resp, err := client.Insert(Context, &pb.InsertRequest{
+			Group:       Config.Group,
+			Data:        "backlog",
+			Constraints: []string{benchBacklogLabel},
+		})
+		if err != nil {
+			return fmt.Errorf("cannot insert the backlog: %v", err)
+		}
+		backlog = append(backlog, resp.GetID())
+	}
+
+	ctx, cancelFn := context.WithTimeout(Context, Config.Duration)
+	defer cancelFn()
+	stats := &benchStats{holders: make(map[string]int)}
+	payload := strings.Repeat("x", Config.PayloadSize)
+	remaining := int64(Config.Tasks)
+	wg := sync.WaitGroup{}
+	startTime := time.Now()
+	for i := 0; i < Config.Inserters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchInserter(ctx, client, Config, payload, &remaining, stats)
+		}()
+	}
+	for i := 0; i < Config.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			benchWorker(ctx, client, Config, worker, stats, cancelFn)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(startTime)
+
+	fmt.Printf("Benchmark of group `%s` on %s: %d inserters, %d workers, %d backlog tasks, %v elapsed\n",
+		Config.Group, Address, Config.Inserters, Config.Workers, Config.Backlog, elapsed.Round(time.Millisecond))
+	fmt.Println(stats.insert.report("insert", elapsed))
+	fmt.Println(stats.lease.report("lease", elapsed))
+	fmt.Println(stats.finish.report("finish", elapsed))
+	fmt.Printf("Empty queries: %d\n", stats.emptyQueries)
+	fmt.Printf("Lease conflicts: %d (duplicate leases: %d, rejected finishes: %d)\n",
+		stats.duplicateLeases+stats.rejectedFinishes, stats.duplicateLeases, stats.rejectedFinishes)
+	fmt.Printf("Errors: %d\n", stats.errors)
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// ShowClusterStatus prints the Raft status of the task master at `Address` and its peers.
+func ShowClusterStatus(Context context.Context, Address string, DialOption grpc.DialOption) error {
+	resp, err := getRaftStatus(Context, Address, DialOption)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Leader: %s (term %d)\n", resp.GetLeader(), resp.GetTerm())
+	for _, peer := range resp.GetPeers() {
+		status := resp
+		if peer != resp.GetID() {
+			if status, err = getRaftStatus(Context, peer, DialOption); err != nil {
+				fmt.Printf("%s: unreachable: %v\n", peer, err)
+				continue
+			}
+		}
+		fmt.Printf("%s: %s\n", peer, status.GetRole())
+		fmt.Printf("  term: %d\n", status.GetTerm())
+		fmt.Printf("  log: last %d, committed %d, applied %d, snapshot %d\n",
+			status.GetLastIndex(), status.GetCommitIndex(), status.GetAppliedIndex(), status.GetSnapshotIndex())
+	}
+	return nil
+}
+
+func getRaftStatus(Context context.Context, Address string, DialOption grpc.DialOption) (*pb.RaftStatusResponse, error) {
+	conn, err := grpc.Dial(Address, DialOption)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return pb.NewRaftClient(conn).GetStatus(Context, &pb.RaftStatusRequest{})
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsFlags holds the certificate flags shared by all subcommands.
+// They follow the `--ca`, `--crt` and `--key` convention of the tunnel command.
+type tlsFlags struct {
+	ca         *string
+	crt        *string
+	key        *string
+	serverName *string
+}
+
+func registerTLSFlags(flagSet *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		ca:         flagSet.String("ca", "", "CA certificate to verify the peer. Enables mTLS on the server side."),
+		crt:        flagSet.String("crt", "", "Certificate file. TLS is disabled if empty."),
+		key:        flagSet.String("key", "", "Private key file of the certificate."),
+		serverName: flagSet.String("tls-server-name", "", "If not empty, overrides the server name used to verify the server certificate."),
+	}
+}
+
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	caBytes, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("cannot parse certificate from %s", caFile)
+	}
+	return caPool, nil
+}
+
+// serverTLSConfig returns nil if TLS is not configured.
+func (f *tlsFlags) serverTLSConfig() (*tls.Config, error) {
+	if len(*f.crt) == 0 {
+		if len(*f.ca) > 0 {
+			return nil, fmt.Errorf("--ca requires --crt and --key to be set")
+		}
+		return nil, nil
+	}
+	certificate, err := loadCertificate(*f.crt, *f.key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*certificate},
+	}
+	if len(*f.ca) > 0 {
+		caPool, err := loadCAPool(*f.ca)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = caPool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// requireMutualTLS returns error unless `config` verifies client certificates, which `flagName` relies on
+// to identify callers.
+func requireMutualTLS(config *tls.Config, flagName string) error {
+	if config == nil || config.ClientCAs == nil || config.ClientAuth != tls.RequireAndVerifyClientCert {
+		return fmt.Errorf("%s requires mTLS, set --ca, --crt and --key", flagName)
+	}
+	return nil
+}
+
+// clientTLSConfig returns nil if TLS is not configured.
+func (f *tlsFlags) clientTLSConfig() (*tls.Config, error) {
+	if len(*f.ca) == 0 && len(*f.crt) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		ServerName: *f.serverName,
+	}
+	if len(*f.ca) > 0 {
+		caPool, err := loadCAPool(*f.ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = caPool
+	}
+	if len(*f.crt) > 0 {
+		certificate, err := loadCertificate(*f.crt, *f.key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+	return config, nil
+}
+
+// dialOption returns the gRPC transport credentials to connect to the task master.
+func (f *tlsFlags) dialOption() (grpc.DialOption, error) {
+	config, err := f.clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return grpc.WithInsecure(), nil
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// dumpChunkSize is the maximum size of the chunks sent by `ImportGroups`.
+const dumpChunkSize = 1 << 20
+
+// dumpRecord is a line of the dump files written by `ExportGroups`.
+type dumpRecord struct {
+	Group    string          `json:"group"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// openDump opens `Path` for reading, or standard input if `Path` is `-`.
+func openDump(Path string) (io.ReadCloser, error) {
+	if Path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(Path)
+}
+
+// ExportGroups writes the snapshots of `Groups`, or all groups if empty, to `Output` as JSON lines.
+func ExportGroups(Context context.Context, Address string, Groups []string, Output io.Writer, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	stream, err := client.Export(Context, &pb.ExportRequest{Groups: Groups})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(Output)
+	var current dumpRecord
+	flush := func() error {
+		if len(current.Group) == 0 {
+			return nil
+		}
+		if err := encoder.Encode(current); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported group `%s` (%d bytes).\n", current.Group, len(current.Snapshot))
+		current = dumpRecord{}
+		return nil
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+		if chunk.GetGroup() != current.Group {
+			if err := flush(); err != nil {
+				return err
+			}
+			current.Group = chunk.GetGroup()
+		}
+		current.Snapshot = append(current.Snapshot, chunk.GetData()...)
+	}
+}
+
+// ImportGroups imports the groups written by `ExportGroups` from `Input`.
+// Groups are merged into existing groups, keeping their tasks and leases, unless `Replace` is set.
+func ImportGroups(Context context.Context, Address string, Input io.Reader, Replace bool, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	stream, err := client.Import(Context)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(Input)
+	for {
+		record := dumpRecord{}
+		if err := decoder.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			stream.CloseSend()
+			return err
+		}
+		data := []byte(record.Snapshot)
+		for first := true; first || len(data) > 0; first = false {
+			size := len(data)
+			if size > dumpChunkSize {
+				size = dumpChunkSize
+			}
+			if err := stream.Send(&pb.ImportChunk{Group: record.Group, Data: data[:size], Replace: Replace}); err != nil {
+				// The reason is returned by `CloseAndRecv`.
+				_, err = stream.CloseAndRecv()
+				return err
+			}
+			data = data[size:]
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	for _, group := range resp.GetGroups() {
+		fmt.Printf("Imported %d tasks into group `%s`, %d tasks already exist.\n", group.GetImportedCount(), group.GetGroup(), group.GetSkippedCount())
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+)
+
+// CheckSnapshots prints the issues of the snapshots in `SnapshotFolder`, repairing them if `Repair` is set.
+// Returns error if any issue is left unrepaired.
+func CheckSnapshots(SnapshotFolder string, Repair bool) error {
+	result, err := taskmaster.CheckSnapshotFolder(SnapshotFolder, Repair)
+	if err != nil {
+		return err
+	}
+	groups := make([]string, 0, len(result))
+	for group := range result {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	unrepaired := 0
+	for _, group := range groups {
+		fmt.Printf("Group `%s`:\n", group)
+		for _, issue := range result[group] {
+			fmt.Printf("  %s\n", issue)
+			if !issue.Repaired {
+				unrepaired++
+			}
+		}
+	}
+	if unrepaired > 0 {
+		return fmt.Errorf("%d issues are not repaired", unrepaired)
+	}
+	if len(groups) == 0 {
+		fmt.Println("No issues found.")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+func printGroupSettings(group string, settings *pb.GroupSettings) {
+	fmt.Printf("Group `%s`:\n", group)
+	fmt.Printf("  max concurrency: %d\n", settings.GetMaxConcurrency())
+	fmt.Printf("  lease rate: %g/s\n", settings.GetLeaseRate())
+	fmt.Printf("  lease burst: %d\n", settings.GetLeaseBurst())
+	fmt.Printf("  max attempts: %d\n", settings.GetMaxAttempts())
+	fmt.Printf("  retention: %v\n", settings.GetRetention().AsDuration())
+	fmt.Printf("  loan duration: %v\n", settings.GetLoanDuration().AsDuration())
+	fmt.Printf("  max loan duration: %v\n", settings.GetMaxLoanDuration().AsDuration())
+	fmt.Printf("  callback URL: %s\n", settings.GetCallbackUrl())
+	fmt.Printf("  fair share: %v\n", settings.GetFairShare())
+	if len(settings.GetTenantWeights()) > 0 {
+		tenants := make([]string, 0, len(settings.GetTenantWeights()))
+		for tenant := range settings.GetTenantWeights() {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+		for _, tenant := range tenants {
+			fmt.Printf("  tenant weight `%s`: %g\n", tenant, settings.GetTenantWeights()[tenant])
+		}
+	}
+}
+
+// ShowGroupSettings prints the settings of `WorkerGroup`.
+func ShowGroupSettings(Context context.Context, Address string, WorkerGroup string, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	resp, err := client.GetGroupSettings(Context, &pb.GetGroupSettingsRequest{Group: WorkerGroup})
+	if err != nil {
+		return err
+	}
+	printGroupSettings(WorkerGroup, resp.GetSettings())
+	fmt.Printf("  paused: %v\n", resp.GetPaused())
+	fmt.Printf("  draining: %v\n", resp.GetDraining())
+	fmt.Printf("  tasks: %d\n", resp.GetTaskCount())
+	return nil
+}
+
+// UpdateGroupSettings applies `Update` on the current settings of `WorkerGroup`.
+func UpdateGroupSettings(Context context.Context, Address string, WorkerGroup string, DialOption grpc.DialOption, Update func(*pb.GroupSettings)) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	settings := &pb.GroupSettings{}
+	resp, err := client.GetGroupSettings(Context, &pb.GetGroupSettingsRequest{Group: WorkerGroup})
+	if err == nil {
+		settings = resp.GetSettings()
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+	Update(settings)
+	updated, err := client.UpdateGroupSettings(Context, &pb.UpdateGroupSettingsRequest{
+		Group:    WorkerGroup,
+		Settings: settings,
+	})
+	if err != nil {
+		return err
+	}
+	printGroupSettings(WorkerGroup, updated.GetSettings())
+	return nil
+}
+
+// ManageGroup performs `Action` (pause, resume, drain or delete) on `WorkerGroup`.
+func ManageGroup(Context context.Context, Address string, WorkerGroup string, Action string, Force bool, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	switch Action {
+	case "pause":
+		_, err = client.PauseGroup(Context, &pb.PauseGroupRequest{Group: WorkerGroup})
+	case "resume":
+		_, err = client.ResumeGroup(Context, &pb.ResumeGroupRequest{Group: WorkerGroup})
+	case "drain":
+		_, err = client.DrainGroup(Context, &pb.DrainGroupRequest{Group: WorkerGroup})
+	case "delete":
+		var resp *pb.DeleteGroupResponse
+		resp, err = client.DeleteGroup(Context, &pb.DeleteGroupRequest{Group: WorkerGroup, Force: Force})
+		if err == nil {
+			fmt.Printf("%d tasks are removed with the group.\n", resp.GetTaskCount())
+		}
+	default:
+		return fmt.Errorf("unknown action `%s`", Action)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Group `%s`: %s succeeded.\n", WorkerGroup, Action)
+	return nil
+}
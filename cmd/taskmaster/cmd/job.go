@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// printJobStatus prints the status of a job and returns error if any member task did not succeed.
+func printJobStatus(job *pb.JobStatus) error {
+	fmt.Printf("Job `%s`: %d tasks\n", job.GetJob(), job.GetTotal())
+	fmt.Printf("  pending: %d\n", job.GetPending())
+	fmt.Printf("  running: %d\n", job.GetRunning())
+	fmt.Printf("  done: %d\n", job.GetDone())
+	fmt.Printf("  failed: %d\n", job.GetFailed())
+	fmt.Printf("  cancelled: %d\n", job.GetCancelled())
+	fmt.Printf("  expired: %d\n", job.GetExpired())
+	if unsuccessful := job.GetFailed() + job.GetCancelled() + job.GetExpired(); unsuccessful > 0 {
+		return fmt.Errorf("%d tasks of job `%s` did not succeed", unsuccessful, job.GetJob())
+	}
+	return nil
+}
+
+// ShowJobStatus prints the status of `Job` in `WorkerGroup`.
+// Returns error if any member task failed, expired or was cancelled.
+func ShowJobStatus(Context context.Context, Address string, WorkerGroup string, Job string, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	resp, err := client.GetJob(Context, &pb.GetJobRequest{Group: WorkerGroup, Job: Job})
+	if err != nil {
+		return err
+	}
+	return printJobStatus(resp.GetStatus())
+}
+
+// WaitForJob blocks until all tasks of `Job` in `WorkerGroup` are terminal, then prints the status of the job.
+// Returns error if any member task failed, expired or was cancelled.
+func WaitForJob(Context context.Context, Address string, WorkerGroup string, Job string, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	resp, err := client.WaitJob(Context, &pb.WaitJobRequest{Group: WorkerGroup, Job: Job})
+	if err != nil {
+		return err
+	}
+	return printJobStatus(resp.GetStatus())
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// ShowReplicationStatus prints the replication status of the task master at `Address`, the caller must be allowed to follow it.
+func ShowReplicationStatus(Context context.Context, Address string, DialOption grpc.DialOption) error {
+	conn, err := grpc.Dial(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := pb.NewTaskMasterReplicationClient(conn).GetReplicationStatus(Context, &pb.GetReplicationStatusRequest{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Role: %s\n", resp.GetRole())
+	fmt.Printf("  leader: %s\n", resp.GetLeader())
+	fmt.Printf("  position: %d/%d\n", resp.GetEpoch(), resp.GetSequence())
+	if resp.GetLastContact() != nil {
+		fmt.Printf("  last contact: %v\n", resp.GetLastContact().AsTime().Local())
+	}
+	for _, peer := range resp.GetPeers() {
+		fmt.Printf("  peer: %s\n", peer)
+	}
+	return nil
+}
+
+// PromoteServer makes the follower at `Address` become the leader.
+func PromoteServer(Context context.Context, Address string, DialOption grpc.DialOption) error {
+	conn, err := grpc.Dial(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := pb.NewTaskMasterReplicationClient(conn).Promote(Context, &pb.PromoteRequest{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is the leader of epoch %d.\n", Address, resp.GetEpoch())
+	return nil
+}
@@ -4,43 +4,620 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	pb "github.com/xpy123993/toolbox/proto"
 )
 
 func HandleServe(args ...string) error {
 	flagSet := flag.NewFlagSet("serve", flag.ExitOnError)
 	snapshotInterval := flagSet.Duration("snapshot-interval", 30*time.Second, "Save interval of snapshots.")
-	httpAddr := flagSet.String("http-address", "", "If not empty, a task status page will be hold.")
+	shutdownTimeout := flagSet.Duration("shutdown-timeout", 30*time.Second, "On SIGINT or SIGTERM, how long ongoing RPCs are waited for before the final snapshots are written.")
+	httpAddr := flagSet.String("http-address", "", "If not empty, the dashboard on /tasks, metrics and the JSON API will be served.")
+	insertPolicy := flagSet.String("insert-policy", "", "If not empty, a JSON file mapping groups to the client identities allowed to insert.")
+	trustedRouters := flagSet.String("trusted-routers", "", "If not empty, the comma separated client identities of routers whose forwarded callers are trusted, so policies apply to the callers instead of the routers. Requires mTLS.")
+	follow := flagSet.String("follow", "", "If not empty, starts as a follower replicating the leader at this address.")
+	advertiseAddress := flagSet.String("advertise-address", "", "The address of this server reported to followers and redirected clients. Defaults to the serving channel.")
+	failoverTimeout := flagSet.Duration("failover-timeout", 0, "If not zero, a follower promotes itself once the leader is unreachable for this long, and the leader stops accepting mutations once half of its followers are unreachable for half of it. Use the same value on the leader and the followers.")
+	followers := flagSet.String("followers", "", "If not empty, the comma separated client identities allowed to follow this server and read its replication status. Requires mTLS. By default, followers must be allowed to manage every group by --insert-policy.")
+	maxArtifactSize := flagSet.Int64("max-artifact-size", 0, "If positive, workers can upload the files produced by tasks up to this many bytes each, stored under the snapshot folder. Not supported with replication or clusters.")
+	blobThreshold := flagSet.Int("blob-threshold", 0, "If positive, payloads larger than this many bytes are stored in the blob store under the snapshot folder. Not supported with replication or clusters.")
+	maxImportSize := flagSet.Int64("max-import-size", taskmaster.DefaultMaxImportSize, "The maximum size in bytes of the snapshot of each group imported at once, which is buffered in memory.")
+	webhookSecretFile := flagSet.String("webhook-secret-file", "", "If not empty, enables callback URLs. Events are signed with the secret in the file, which is also the --secret-file of cmd/webhook.")
+	webhookAllow := flagSet.String("webhook-allow", "", "The comma separated prefixes of the allowed callback URLs.")
+	auditLog := flagSet.String("audit-log", "", "If not empty, every mutation and every denied request is recorded with the identity of its caller in this JSON-lines file.")
+	auditLogSize := flagSet.Int64("audit-log-size", taskmaster.DefaultAuditFileSize, "The size in bytes of the audit log before it is rotated.")
+	auditLogFiles := flagSet.Int("audit-log-files", taskmaster.DefaultAuditFiles, "The number of rotated audit logs kept.")
+	configFile := flagSet.String("config", "", "If not empty, a JSON file defining the settings of groups, applied once the server is the leader and reloaded on SIGHUP.")
+	clusterPeers := flagSet.String("cluster-peers", "", "If not empty, joins a Raft cluster of the comma separated addresses, including the advertise address of this server.")
+	tlsConfig := registerTLSFlags(flagSet)
 	flagSet.Parse(args)
 	if len(flagSet.Args()) != 2 {
 		fmt.Println("Usage: serve [serving channel] [snapshot folder]")
 		fmt.Println("Example: serve --snapshot-interval=30s /example/taskmaster ./snapshots")
+		fmt.Println("Example: serve --follow=leader:8080 --failover-timeout=10s :8080 ./snapshots")
+		fmt.Println("Example: serve --cluster-peers=a:8080,b:8080,c:8080 --advertise-address=a:8080 :8080 ./snapshots")
+		fmt.Println("Example: serve --config=./taskmaster.json :8080 ./snapshots")
 		return fmt.Errorf("invalid arguments")
 	}
-	StartTaskMasterService(flagSet.Arg(0), flagSet.Arg(1), *snapshotInterval, *httpAddr)
+	serverTLSConfig, err := tlsConfig.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	var taskMasterOptions []taskmaster.ServerOption
+	var routers []string
+	if len(*trustedRouters) > 0 {
+		if err := requireMutualTLS(serverTLSConfig, "--trusted-routers"); err != nil {
+			return err
+		}
+		routers = strings.Split(*trustedRouters, ",")
+	}
+	if len(*insertPolicy) > 0 {
+		if err := requireMutualTLS(serverTLSConfig, "--insert-policy"); err != nil {
+			return err
+		}
+		policy, err := taskmaster.LoadInsertPolicy(*insertPolicy)
+		if err != nil {
+			return err
+		}
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithInsertPolicy(policy))
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	if len(*advertiseAddress) == 0 {
+		*advertiseAddress = flagSet.Arg(0)
+	}
+	if len(*webhookSecretFile) > 0 {
+		secret, err := os.ReadFile(*webhookSecretFile)
+		if err != nil {
+			return err
+		}
+		if len(*webhookAllow) == 0 {
+			return fmt.Errorf("--webhook-secret-file requires --webhook-allow")
+		}
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithWebhooks(taskmaster.WebhookConfig{
+			Secret:             strings.TrimSpace(string(secret)),
+			AllowedURLPrefixes: strings.Split(*webhookAllow, ","),
+		}))
+	}
+	if len(*auditLog) > 0 {
+		auditFile, err := taskmaster.OpenAuditLog(*auditLog, *auditLogSize, *auditLogFiles)
+		if err != nil {
+			return err
+		}
+		defer auditFile.Close()
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithAuditLog(auditFile))
+	}
+	taskMasterOptions = append(taskMasterOptions, taskmaster.WithMaxImportSize(*maxImportSize))
+	if *blobThreshold > 0 {
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithBlobStore(*blobThreshold))
+	}
+	if *maxArtifactSize > 0 {
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithArtifacts(*maxArtifactSize))
+	}
+	if len(*clusterPeers) > 0 {
+		if len(*follow) > 0 || *failoverTimeout > 0 {
+			return fmt.Errorf("--cluster-peers cannot be used with --follow or --failover-timeout")
+		}
+		taskMasterOptions = append(taskMasterOptions, taskmaster.WithCluster(taskmaster.ClusterConfig{
+			Address:    *advertiseAddress,
+			Peers:      strings.Split(*clusterPeers, ","),
+			DialOption: dialOption,
+		}))
+	}
+	replicationConfig := taskmaster.ReplicationConfig{
+		AdvertiseAddress: *advertiseAddress,
+		Leader:           *follow,
+		DialOption:       dialOption,
+		FailoverTimeout:  *failoverTimeout,
+	}
+	if len(*followers) > 0 {
+		if err := requireMutualTLS(serverTLSConfig, "--followers"); err != nil {
+			return err
+		}
+		replicationConfig.Followers = strings.Split(*followers, ",")
+	}
+	taskMasterOptions = append(taskMasterOptions, taskmaster.WithReplication(replicationConfig))
+	StartTaskMasterService(flagSet.Arg(0), flagSet.Arg(1), *snapshotInterval, *httpAddr, serverTLSConfig, *shutdownTimeout, *configFile, routers, taskMasterOptions)
 	return nil
 }
 
+// parseLabels parses comma separated key=value pairs.
+func parseLabels(value string) (map[string]string, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid label `%s`, expect key=value", pair)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// parseWeights parses comma separated key=weight pairs.
+func parseWeights(value string) (map[string]float64, error) {
+	pairs, err := parseLabels(value)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64, len(pairs))
+	for key, pair := range pairs {
+		weight, err := strconv.ParseFloat(pair, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight of `%s`: %v", key, err)
+		}
+		weights[key] = weight
+	}
+	return weights, nil
+}
+
 func HandleWorker(args ...string) error {
 	flagSet := flag.NewFlagSet("work", flag.ExitOnError)
 	taskGroup := flagSet.String("task-group", "default", "Group this worker is assigned to.")
 	taskTimeout := flagSet.Duration("task-timeout", time.Hour, "The timeout of executing each task.")
+	lease := flagSet.Duration("lease", RPCTimeout, "The lease duration of tasks, extended until the task finishes. Zero uses the loan duration of the group.")
+	httpAddr := flagSet.String("http-address", "", "If not empty, worker metrics will be served on /metrics.")
+	labels := flagSet.String("labels", "", "The comma separated key=value labels of this worker, matched against the constraints of tasks.")
+	sandbox := SandboxConfig{}
+	flagSet.DurationVar(&sandbox.MaxLimits.CPUTime, "max-cpu-time", 0, "If positive, the CPU time limit of each task, tasks may request lower limits.")
+	flagSet.Int64Var(&sandbox.MaxLimits.Memory, "max-memory", 0, "If positive, the address space limit in bytes of each task, tasks may request lower limits.")
+	flagSet.Int64Var(&sandbox.MaxLimits.OpenFiles, "max-open-files", 0, "If positive, the limit of open files of each task, tasks may request lower limits.")
+	flagSet.Int64Var(&sandbox.MaxLimits.OutputSize, "max-output-size", 0, "If positive, tasks writing more output in bytes are killed, tasks may request lower limits.")
+	flagSet.StringVar(&sandbox.User, "run-as", "", "If not empty, tasks are run as this user, optionally followed by :group. Requires the worker to run as root.")
+	flagSet.BoolVar(&sandbox.PrivateTemp, "private-tmp", false, "Runs each task with a private TMPDIR which is removed once the task exits.")
+	tlsConfig := registerTLSFlags(flagSet)
 	flagSet.Parse(args)
 	if len(flagSet.Args()) != 1 {
 		fmt.Println("Usage: work [task master channel]")
 		fmt.Println("Example: work /example/taskmaster --task-group=default --task-timeout=1h")
 		return fmt.Errorf("invalid arguments")
 	}
-	StartWorker(flagSet.Arg(0), *taskGroup, *taskTimeout)
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	workerLabels, err := parseLabels(*labels)
+	if err != nil {
+		return err
+	}
+	StartWorker(flagSet.Arg(0), *taskGroup, workerLabels, *taskTimeout, *lease, sandbox, dialOption, *httpAddr)
 	return nil
 }
 
 func HandleInsert(args ...string) error {
-	if len(args) < 3 {
+	flagSet := flag.NewFlagSet("insert", flag.ExitOnError)
+	labels := flagSet.String("labels", "", "The comma separated key=value labels of the task.")
+	constraints := flagSet.String("constraints", "", "The comma separated constraints on the labels of workers, in the form of key=value, key!=value, key or !key.")
+	ttl := flagSet.Duration("ttl", 0, "If positive, the task is dropped if not leased within the duration.")
+	deadline := flagSet.Duration("deadline", 0, "If positive, the task is cancelled if not finished within the duration, even if it is running.")
+	callbackURL := flagSet.String("callback-url", "", "If not empty, receives the event once the task is finished, failed, expired or cancelled.")
+	job := flagSet.String("job", "", "If not empty, the task is a member of the job, see the job command.")
+	tenant := flagSet.String("tenant", "", "The owner of the task under fair-share scheduling. Defaults to, and must match, the identity of the client certificate if any.")
+	limits := ResourceLimits{}
+	flagSet.DurationVar(&limits.CPUTime, "cpu-time", 0, "If positive, the CPU time limit of the task, within the limit of the worker.")
+	flagSet.Int64Var(&limits.Memory, "memory", 0, "If positive, the address space limit in bytes of the task, within the limit of the worker.")
+	flagSet.Int64Var(&limits.OpenFiles, "open-files", 0, "If positive, the limit of open files of the task, within the limit of the worker.")
+	flagSet.Int64Var(&limits.OutputSize, "output-size", 0, "If positive, the task is killed once it writes more output in bytes, within the limit of the worker.")
+	artifacts := flagSet.String("artifacts", "", "The comma separated paths or globs of the files uploaded once the task succeeds, relative to the fresh working directory the worker creates for the task.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) < 3 {
 		fmt.Println("Usage: insert [task master channel] [task group] [base command] [args ...]")
 		fmt.Println("Example: insert /example/taskmaster echo hello world")
+		fmt.Println("Example: insert --constraints=memory=large,!gpu /example/taskmaster default ./train.sh")
 		return fmt.Errorf("invalid arguments")
 	}
-	InsertTask(context.Background(), args[0], args[1], args[2], args[3:])
-	return nil
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	taskLabels, err := parseLabels(*labels)
+	if err != nil {
+		return err
+	}
+	var taskConstraints []string
+	if len(*constraints) > 0 {
+		taskConstraints = strings.Split(*constraints, ",")
+	}
+	var artifactPaths []string
+	if len(*artifacts) > 0 {
+		artifactPaths = strings.Split(*artifacts, ",")
+	}
+	return InsertTask(context.Background(), flagSet.Arg(0), flagSet.Arg(1), flagSet.Arg(2), flagSet.Args()[3:], limits, artifactPaths, taskLabels, taskConstraints, *tenant, *ttl, *deadline, *callbackURL, *job, dialOption)
+}
+
+func HandleWatch(args ...string) error {
+	flagSet := flag.NewFlagSet("watch", flag.ExitOnError)
+	taskID := flagSet.String("task", "", "If not empty, only prints the events of the task.")
+	cursor := flagSet.String("cursor", "", "If not empty, resumes after the event with the cursor.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) < 1 || len(flagSet.Args()) > 2 {
+		fmt.Println("Usage: watch [task master channel] [task group]")
+		fmt.Println("Example: watch /example/taskmaster default")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	return WatchEvents(context.Background(), flagSet.Arg(0), flagSet.Arg(1), *taskID, *cursor, dialOption)
+}
+
+func HandleJob(args ...string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: job [status | wait] [args]")
+		return fmt.Errorf("invalid arguments")
+	}
+	flagSet := flag.NewFlagSet("job "+args[0], flag.ExitOnError)
+	tlsConfig := registerTLSFlags(flagSet)
+	switch args[0] {
+	case "status", "wait":
+		timeout := flagSet.Duration("timeout", 0, "If positive, wait gives up after the duration.")
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 3 {
+			fmt.Printf("Usage: job %s [task master channel] [task group] [job]\n", args[0])
+			fmt.Printf("Example: job %s /example/taskmaster default shards\n", args[0])
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		if args[0] == "status" {
+			return ShowJobStatus(context.Background(), flagSet.Arg(0), flagSet.Arg(1), flagSet.Arg(2), dialOption)
+		}
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancelFn context.CancelFunc
+			ctx, cancelFn = context.WithTimeout(ctx, *timeout)
+			defer cancelFn()
+		}
+		return WaitForJob(ctx, flagSet.Arg(0), flagSet.Arg(1), flagSet.Arg(2), dialOption)
+	}
+	fmt.Println("Usage: job [status | wait] [args]")
+	return fmt.Errorf("invalid arguments")
+}
+
+func HandleArtifacts(args ...string) error {
+	flagSet := flag.NewFlagSet("artifacts", flag.ExitOnError)
+	output := flagSet.String("output", ".", "The folder the artifacts are downloaded into.")
+	list := flagSet.Bool("list", false, "Only lists the artifacts with their sizes and hashes.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) != 3 {
+		fmt.Println("Usage: artifacts [task master channel] [task group] [task ID]")
+		fmt.Println("Example: artifacts --output=./results /example/taskmaster default 6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	return DownloadArtifacts(context.Background(), flagSet.Arg(0), flagSet.Arg(1), flagSet.Arg(2), *output, *list, dialOption)
+}
+
+func HandleAudit(args ...string) error {
+	flagSet := flag.NewFlagSet("audit", flag.ExitOnError)
+	query := AuditQuery{}
+	flagSet.StringVar(&query.Group, "group", "", "If not empty, only prints the records of the group.")
+	flagSet.StringVar(&query.ID, "task", "", "If not empty, only prints the records of the task.")
+	flagSet.StringVar(&query.Caller, "caller", "", "If not empty, only prints the records of the caller.")
+	flagSet.StringVar(&query.Op, "op", "", "If not empty, only prints the records of the operation, such as insert or cancel.")
+	flagSet.DurationVar(&query.Since, "since", 0, "If positive, only prints the records within the duration.")
+	flagSet.BoolVar(&query.JSON, "json", false, "Prints the records as JSON lines.")
+	flagSet.Parse(args)
+	if len(flagSet.Args()) != 1 {
+		fmt.Println("Usage: audit [audit log]")
+		fmt.Println("Example: audit --op=insert --since=24h ./audit.jsonl")
+		return fmt.Errorf("invalid arguments")
+	}
+	return QueryAuditLog(flagSet.Arg(0), query)
+}
+
+func HandleExport(args ...string) error {
+	flagSet := flag.NewFlagSet("export", flag.ExitOnError)
+	groups := flagSet.String("groups", "", "The comma separated groups to export, all groups if empty.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) != 2 {
+		fmt.Println("Usage: export [task master channel] [dump file]")
+		fmt.Println("Example: export --groups=default /example/taskmaster ./dump.jsonl")
+		fmt.Println("Example: export source:8080 - | import target:8080 -")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	var exportGroups []string
+	if len(*groups) > 0 {
+		exportGroups = strings.Split(*groups, ",")
+	}
+	if flagSet.Arg(1) == "-" {
+		return ExportGroups(context.Background(), flagSet.Arg(0), exportGroups, os.Stdout, dialOption)
+	}
+	file, err := os.Create(flagSet.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := ExportGroups(context.Background(), flagSet.Arg(0), exportGroups, file, dialOption); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func HandleImport(args ...string) error {
+	flagSet := flag.NewFlagSet("import", flag.ExitOnError)
+	replace := flagSet.Bool("replace", false, "Replaces existing groups instead of merging the missing tasks into them.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) != 2 {
+		fmt.Println("Usage: import [task master channel] [dump file]")
+		fmt.Println("Example: import /example/taskmaster ./dump.jsonl")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	input, err := openDump(flagSet.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return ImportGroups(context.Background(), flagSet.Arg(0), input, *replace, dialOption)
+}
+
+func HandleFsck(args ...string) error {
+	flagSet := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flagSet.Bool("repair", false, "Repairs the issues and upgrades the snapshots to the current schema version.")
+	flagSet.Parse(args)
+	if len(flagSet.Args()) != 1 {
+		fmt.Println("Usage: fsck [snapshot folder]")
+		fmt.Println("Example: fsck --repair ./snapshots")
+		fmt.Println("The server using the snapshot folder must be stopped.")
+		return fmt.Errorf("invalid arguments")
+	}
+	return CheckSnapshots(flagSet.Arg(0), *repair)
+}
+
+func HandleBench(args ...string) error {
+	flagSet := flag.NewFlagSet("bench", flag.ExitOnError)
+	config := BenchConfig{}
+	flagSet.StringVar(&config.Group, "group", "bench", "The group the benchmark inserts into and leases from.")
+	flagSet.IntVar(&config.Inserters, "inserters", 4, "The number of concurrent inserting clients.")
+	flagSet.IntVar(&config.Workers, "workers", 4, "The number of concurrent fake workers, which finish each leased task.")
+	flagSet.IntVar(&config.Tasks, "tasks", 0, "If positive, the total number of tasks inserted, the benchmark ends once all of them are finished.")
+	flagSet.DurationVar(&config.Duration, "duration", 10*time.Second, "The maximum duration of the benchmark.")
+	flagSet.IntVar(&config.PayloadSize, "payload-size", 64, "The size in bytes of the payload of each task.")
+	flagSet.IntVar(&config.Backlog, "backlog", 0, "The number of tasks inserted before the benchmark which no worker can lease, to measure how leasing degrades with the queue size. They are cancelled once the benchmark ends.")
+	flagSet.DurationVar(&config.LeaseDuration, "lease", time.Minute, "The loan duration requested by the workers.")
+	flagSet.DurationVar(&config.WorkTime, "work-time", 0, "The time a worker holds each task before finishing it.")
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args)
+	if len(flagSet.Args()) > 1 {
+		fmt.Println("Usage: bench [task master channel]")
+		fmt.Println("Example: bench --inserters=8 --workers=8 --duration=30s")
+		fmt.Println("Example: bench --group=bench --tasks=10000 /example/taskmaster")
+		fmt.Println("Without a channel, an in-process server is benchmarked.")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	return RunBenchmark(context.Background(), flagSet.Arg(0), config, dialOption)
+}
+
+func HandleGroup(args ...string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: group [show | limit | pause | resume | drain | delete] [args]")
+		return fmt.Errorf("invalid arguments")
+	}
+	flagSet := flag.NewFlagSet("group "+args[0], flag.ExitOnError)
+	tlsConfig := registerTLSFlags(flagSet)
+	switch args[0] {
+	case "show":
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 2 {
+			fmt.Println("Usage: group show [task master channel] [task group]")
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		return ShowGroupSettings(context.Background(), flagSet.Arg(0), flagSet.Arg(1), dialOption)
+	case "limit":
+		maxConcurrency := flagSet.Int("max-concurrency", 0, "The maximum number of tasks leased at once. Zero means unlimited.")
+		leaseRate := flagSet.Float64("lease-rate", 0, "The maximum number of leases handed out per second. Zero means unlimited.")
+		leaseBurst := flagSet.Int("lease-burst", 0, "The number of leases can be handed out at once under --lease-rate.")
+		maxAttempts := flagSet.Int("max-attempts", 0, "The number of failed attempts before a task is marked as failed. Zero means retry forever.")
+		retention := flagSet.Duration("retention", 0, "How long finished tasks are kept. Zero means the server default.")
+		fairShare := flagSet.Bool("fair-share", false, "Hands out the tasks of different tenants in proportion to their weights instead of in the order of creation.")
+		callbackURL := flagSet.String("callback-url", "", "Receives the events of the tasks without their own callback URLs.")
+		tenantWeights := flagSet.String("tenant-weights", "", "The comma separated tenant=weight pairs under --fair-share, tenants not listed have weight 1.")
+		loanDuration := flagSet.Duration("loan-duration", 0, "The lease duration of the workers not asking for one. Zero means the server default.")
+		maxLoanDuration := flagSet.Duration("max-loan-duration", 0, "The maximum lease duration handed out, longer requests are capped. Zero means unlimited.")
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 2 {
+			fmt.Println("Usage: group limit [task master channel] [task group]")
+			fmt.Println("Example: group limit --max-concurrency=4 --lease-rate=0.5 /example/taskmaster default")
+			fmt.Println("Example: group limit --fair-share --tenant-weights=alice=2,bob=1 /example/taskmaster default")
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		weights, err := parseWeights(*tenantWeights)
+		if err != nil {
+			return err
+		}
+		return UpdateGroupSettings(context.Background(), flagSet.Arg(0), flagSet.Arg(1), dialOption, func(settings *pb.GroupSettings) {
+			flagSet.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "max-concurrency":
+					settings.MaxConcurrency = int32(*maxConcurrency)
+				case "lease-rate":
+					settings.LeaseRate = *leaseRate
+				case "lease-burst":
+					settings.LeaseBurst = int32(*leaseBurst)
+				case "max-attempts":
+					settings.MaxAttempts = int32(*maxAttempts)
+				case "retention":
+					settings.Retention = durationpb.New(*retention)
+				case "fair-share":
+					settings.FairShare = *fairShare
+				case "tenant-weights":
+					settings.TenantWeights = weights
+				case "callback-url":
+					settings.CallbackUrl = *callbackURL
+				case "loan-duration":
+					settings.LoanDuration = durationpb.New(*loanDuration)
+				case "max-loan-duration":
+					settings.MaxLoanDuration = durationpb.New(*maxLoanDuration)
+				}
+			})
+		})
+	case "pause", "resume", "drain", "delete":
+		force := flagSet.Bool("force", false, "Only used by delete, deletes the group even if it still has tasks.")
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 2 {
+			fmt.Printf("Usage: group %s [task master channel] [task group]\n", args[0])
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		return ManageGroup(context.Background(), flagSet.Arg(0), flagSet.Arg(1), args[0], *force, dialOption)
+	default:
+		fmt.Println("Usage: group [show | limit | pause | resume | drain | delete] [args]")
+		return fmt.Errorf("unknown group command `%s`", args[0])
+	}
+}
+
+func HandleCluster(args ...string) error {
+	if len(args) < 1 || args[0] != "status" {
+		fmt.Println("Usage: cluster status [task master channel]")
+		return fmt.Errorf("invalid arguments")
+	}
+	flagSet := flag.NewFlagSet("cluster status", flag.ExitOnError)
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args[1:])
+	if len(flagSet.Args()) != 1 {
+		fmt.Println("Usage: cluster status [task master channel]")
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	return ShowClusterStatus(context.Background(), flagSet.Arg(0), dialOption)
+}
+
+func HandleRouter(args ...string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: router [serve | locate | migrate] [args]")
+		return fmt.Errorf("invalid arguments")
+	}
+	flagSet := flag.NewFlagSet("router "+args[0], flag.ExitOnError)
+	tlsConfig := registerTLSFlags(flagSet)
+	switch args[0] {
+	case "serve":
+		backends := flagSet.String("backends", "", "The comma separated addresses of the task master servers.")
+		refreshInterval := flagSet.Duration("refresh-interval", taskmaster.DefaultRouterRefreshInterval, "The interval of discovering the groups of the backends.")
+		policyFile := flagSet.String("policy", "", "If not empty, a JSON file mapping groups to the client identities allowed to insert into and manage them through the router, in the format of --insert-policy of serve. Other calls require an authenticated client.")
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 1 || len(*backends) == 0 {
+			fmt.Println("Usage: router serve --backends=[addresses] [serving channel]")
+			fmt.Println("Example: router serve --backends=a:8080,b:8080,c:8080 :8080")
+			return fmt.Errorf("invalid arguments")
+		}
+		serverTLSConfig, err := tlsConfig.serverTLSConfig()
+		if err != nil {
+			return err
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		var policy taskmaster.InsertPolicy
+		if len(*policyFile) > 0 {
+			if err := requireMutualTLS(serverTLSConfig, "--policy"); err != nil {
+				return err
+			}
+			if policy, err = taskmaster.LoadInsertPolicy(*policyFile); err != nil {
+				return err
+			}
+		}
+		return StartRouter(flagSet.Arg(0), strings.Split(*backends, ","), *refreshInterval, serverTLSConfig, dialOption, policy)
+	case "locate":
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 2 {
+			fmt.Println("Usage: router locate [router channel] [task group]")
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		return ShowRoute(context.Background(), flagSet.Arg(0), flagSet.Arg(1), dialOption)
+	case "migrate":
+		flagSet.Parse(args[1:])
+		if len(flagSet.Args()) != 3 {
+			fmt.Println("Usage: router migrate [router channel] [task group] [backend]")
+			return fmt.Errorf("invalid arguments")
+		}
+		dialOption, err := tlsConfig.dialOption()
+		if err != nil {
+			return err
+		}
+		return MigrateGroup(context.Background(), flagSet.Arg(0), flagSet.Arg(1), flagSet.Arg(2), dialOption)
+	default:
+		fmt.Println("Usage: router [serve | locate | migrate] [args]")
+		return fmt.Errorf("unknown router command `%s`", args[0])
+	}
+}
+
+func HandleReplication(args ...string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: replication [status | promote] [args]")
+		return fmt.Errorf("invalid arguments")
+	}
+	flagSet := flag.NewFlagSet("replication "+args[0], flag.ExitOnError)
+	tlsConfig := registerTLSFlags(flagSet)
+	flagSet.Parse(args[1:])
+	if len(flagSet.Args()) != 1 {
+		fmt.Printf("Usage: replication %s [task master channel]\n", args[0])
+		return fmt.Errorf("invalid arguments")
+	}
+	dialOption, err := tlsConfig.dialOption()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "status":
+		return ShowReplicationStatus(context.Background(), flagSet.Arg(0), dialOption)
+	case "promote":
+		return PromoteServer(context.Background(), flagSet.Arg(0), dialOption)
+	default:
+		fmt.Println("Usage: replication [status | promote] [args]")
+		return fmt.Errorf("unknown replication command `%s`", args[0])
+	}
 }
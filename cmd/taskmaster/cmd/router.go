@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// StartRouter serves a router forwarding the calls to `Backends` on `Address`.
+// If `Policy` is not nil, the callers are authorized by it, see `taskmaster.RouterConfig`.
+func StartRouter(Address string, Backends []string, RefreshInterval time.Duration, TLSConfig *tls.Config, DialOption grpc.DialOption, Policy taskmaster.InsertPolicy) error {
+	router, err := taskmaster.NewRouter(taskmaster.RouterConfig{
+		Backends:        Backends,
+		DialOption:      DialOption,
+		RefreshInterval: RefreshInterval,
+		Policy:          Policy,
+	})
+	if err != nil {
+		return err
+	}
+	defer router.Close()
+	listener, err := net.Listen("tcp", Address)
+	if err != nil {
+		return err
+	}
+	var serverOptions []grpc.ServerOption
+	if TLSConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(TLSConfig)))
+	}
+	server := grpc.NewServer(serverOptions...)
+	server.RegisterService(&pb.TaskMaster_ServiceDesc, router)
+	server.RegisterService(&pb.TaskMasterRouter_ServiceDesc, router.RouterServer())
+	log.Printf("Routing to %v on %v", Backends, listener.Addr())
+	return server.Serve(listener)
+}
+
+// ShowRoute prints the backend serving `WorkerGroup`.
+func ShowRoute(Context context.Context, Address string, WorkerGroup string, DialOption grpc.DialOption) error {
+	conn, err := grpc.Dial(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := pb.NewTaskMasterRouterClient(conn).GetRoute(Context, &pb.GetRouteRequest{Group: WorkerGroup})
+	if err != nil {
+		return err
+	}
+	if resp.GetMigrated() {
+		fmt.Printf("Group `%s` is served by %s (migrated).\n", WorkerGroup, resp.GetBackend())
+	} else {
+		fmt.Printf("Group `%s` is served by %s.\n", WorkerGroup, resp.GetBackend())
+	}
+	return nil
+}
+
+// MigrateGroup moves `WorkerGroup` to `Backend` through the router at `Address`.
+func MigrateGroup(Context context.Context, Address string, WorkerGroup string, Backend string, DialOption grpc.DialOption) error {
+	conn, err := grpc.Dial(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := pb.NewTaskMasterRouterClient(conn).MigrateGroup(Context, &pb.MigrateGroupRequest{Group: WorkerGroup, Backend: Backend})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Group `%s` is migrated to %s with %d active tasks.\n", WorkerGroup, Backend, resp.GetTaskCount())
+	return nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// sandboxExecCommand is the hidden subcommand the worker runs tasks through to apply their resource limits.
+const sandboxExecCommand = "sandbox-exec"
+
+// ResourceLimits bounds the resources of a task, zero means unlimited.
+type ResourceLimits struct {
+	CPUTime time.Duration
+	// Memory is the size in bytes of the address space.
+	Memory    int64
+	OpenFiles int64
+	// OutputSize is the size in bytes of the combined stdout and stderr, the task is killed once it writes more.
+	OutputSize int64
+}
+
+// SandboxConfig configures how a worker runs its tasks.
+type SandboxConfig struct {
+	// MaxLimits are applied to every task, tasks may request lower limits but not higher ones.
+	MaxLimits ResourceLimits
+	// User is the user, optionally followed by `:group`, which tasks are run as. Switching users requires root.
+	User string
+	// PrivateTemp runs each task with a fresh TMPDIR, which is removed once the task exits.
+	PrivateTemp bool
+}
+
+// cpuSeconds rounds `CPUTime` up to whole seconds, the granularity of the CPU time limit.
+func cpuSeconds(CPUTime time.Duration) int64 {
+	return int64((CPUTime + time.Second - 1) / time.Second)
+}
+
+// effectiveLimit returns the limit of a task requesting `requested` from a worker allowing at most `maximum`.
+func effectiveLimit(name string, requested int64, maximum int64) (int64, error) {
+	if requested <= 0 {
+		return maximum, nil
+	}
+	if maximum > 0 && requested > maximum {
+		return 0, fmt.Errorf("task requests %s of %d, beyond the limit %d of the worker", name, requested, maximum)
+	}
+	return requested, nil
+}
+
+// taskLimits returns the limits of a task requesting `Requested` from a worker allowing at most `Maximum`.
+// Returns error if any requested limit is beyond the maximum.
+func taskLimits(Requested *pb.ResourceLimits, Maximum ResourceLimits) (ResourceLimits, error) {
+	limits := ResourceLimits{}
+	seconds, err := effectiveLimit("CPU seconds", Requested.GetCpuSeconds(), cpuSeconds(Maximum.CPUTime))
+	if err != nil {
+		return limits, err
+	}
+	limits.CPUTime = time.Duration(seconds) * time.Second
+	if limits.Memory, err = effectiveLimit("memory bytes", Requested.GetMemoryBytes(), Maximum.Memory); err != nil {
+		return limits, err
+	}
+	if limits.OpenFiles, err = effectiveLimit("open files", Requested.GetOpenFiles(), Maximum.OpenFiles); err != nil {
+		return limits, err
+	}
+	if limits.OutputSize, err = effectiveLimit("output bytes", Requested.GetOutputBytes(), Maximum.OutputSize); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// cappedOutput collects the output of a task, calling `exceeded` once more than `limit` bytes are written.
+type cappedOutput struct {
+	mu       sync.Mutex
+	data     []byte
+	limit    int64
+	size     int64
+	exceeded func()
+}
+
+func (output *cappedOutput) Write(data []byte) (int, error) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.size += int64(len(data))
+	if output.limit > 0 && output.size > output.limit {
+		output.exceeded()
+		return 0, fmt.Errorf("output exceeds the limit of %d bytes", output.limit)
+	}
+	output.data = append(output.data, data...)
+	return len(data), nil
+}
+
+// runSandboxed runs `Command` within `Limits` under `Config` in `WorkDir`, and returns its combined output.
+// An empty `WorkDir` runs the task in the working directory of the worker.
+// Limits on CPU time, memory and open files are applied as rlimits by re-executing this binary,
+// so they only bound the task and not the worker.
+func runSandboxed(Context context.Context, Command *pb.Command, Limits ResourceLimits, Config SandboxConfig, WorkDir string) ([]byte, error) {
+	ctx, cancelFn := context.WithCancel(Context)
+	defer cancelFn()
+
+	name, args := Command.GetBaseCommand(), Command.GetArguments()
+	if Limits.CPUTime > 0 || Limits.Memory > 0 || Limits.OpenFiles > 0 {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+		args = append([]string{
+			sandboxExecCommand,
+			"--cpu-seconds=" + strconv.FormatInt(cpuSeconds(Limits.CPUTime), 10),
+			"--memory=" + strconv.FormatInt(Limits.Memory, 10),
+			"--open-files=" + strconv.FormatInt(Limits.OpenFiles, 10),
+			"--", name,
+		}, args...)
+		name = executable
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	if err := setCredential(cmd, Config.User); err != nil {
+		return nil, err
+	}
+	if len(WorkDir) > 0 {
+		if err := chownToCredential(WorkDir, cmd); err != nil {
+			return nil, err
+		}
+		cmd.Dir = WorkDir
+	}
+	if Config.PrivateTemp {
+		folder, err := os.MkdirTemp("", "taskmaster-task")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(folder)
+		if err := chownToCredential(folder, cmd); err != nil {
+			return nil, err
+		}
+		cmd.Env = append(os.Environ(), "TMPDIR="+folder, "TMP="+folder, "TEMP="+folder)
+	}
+	exceeded := false
+	output := &cappedOutput{limit: Limits.OutputSize, exceeded: func() {
+		exceeded = true
+		cancelFn()
+	}}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	if exceeded {
+		return output.data, fmt.Errorf("output exceeds the limit of %d bytes", output.limit)
+	}
+	return output.data, err
+}
+
+// HandleSandboxExec applies the resource limits in `args` to the process, then replaces it with the task.
+// It is run by workers and not meant to be used directly.
+func HandleSandboxExec(args ...string) error {
+	flagSet := flag.NewFlagSet(sandboxExecCommand, flag.ExitOnError)
+	limits := ResourceLimits{}
+	seconds := flagSet.Int64("cpu-seconds", 0, "If positive, the CPU time limit in seconds.")
+	flagSet.Int64Var(&limits.Memory, "memory", 0, "If positive, the address space limit in bytes.")
+	flagSet.Int64Var(&limits.OpenFiles, "open-files", 0, "If positive, the limit of open files.")
+	flagSet.Parse(args)
+	if len(flagSet.Args()) < 1 {
+		fmt.Println("Usage: sandbox-exec [base command] [args ...]")
+		return fmt.Errorf("invalid arguments")
+	}
+	limits.CPUTime = time.Duration(*seconds) * time.Second
+	return execWithLimits(limits, flagSet.Args())
+}
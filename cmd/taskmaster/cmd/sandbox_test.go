@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+func TestTaskLimits(t *testing.T) {
+	maximum := ResourceLimits{CPUTime: 1500 * time.Millisecond, Memory: 1 << 30, OutputSize: 4096}
+	limits, err := taskLimits(&pb.ResourceLimits{MemoryBytes: 1 << 20, OpenFiles: 64}, maximum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ResourceLimits{CPUTime: 2 * time.Second, Memory: 1 << 20, OpenFiles: 64, OutputSize: 4096}
+	if limits != expected {
+		t.Errorf("expect limits %+v, got %+v", expected, limits)
+	}
+	for _, requested := range []*pb.ResourceLimits{
+		{CpuSeconds: 3},
+		{MemoryBytes: 2 << 30},
+		{OutputBytes: 8192},
+	} {
+		if _, err := taskLimits(requested, maximum); err == nil {
+			t.Errorf("expect limits %v beyond the maximum to be rejected", requested)
+		}
+	}
+}
+
+func TestCappedOutput(t *testing.T) {
+	exceeded := 0
+	output := &cappedOutput{limit: 8, exceeded: func() { exceeded++ }}
+	if n, err := output.Write([]byte("12345678")); n != 8 || err != nil {
+		t.Errorf("expect writes within the limit to succeed, got %d, %v", n, err)
+	}
+	if _, err := output.Write([]byte("9")); err == nil || exceeded != 1 {
+		t.Errorf("expect writes beyond the limit to fail, got %v", err)
+	}
+	if string(output.data) != "12345678" {
+		t.Errorf("expect the output within the limit to be kept, got %q", output.data)
+	}
+}
+
+func TestRunSandboxedOutputLimit(t *testing.T) {
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	command := &pb.Command{BaseCommand: shell, Arguments: []string{"-c", "while true; do echo output; done"}}
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	data, err := runSandboxed(ctx, command, ResourceLimits{OutputSize: 1024}, SandboxConfig{}, "")
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("expect the task to be killed once it exceeds the output limit, got %v", err)
+	}
+	if len(data) > 1024 {
+		t.Errorf("expect at most 1024 bytes of output, got %d", len(data))
+	}
+	if ctx.Err() != nil {
+		t.Error("expect the task to be killed before the timeout")
+	}
+}
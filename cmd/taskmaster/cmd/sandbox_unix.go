@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setCredential makes `cmd` run as `User`, optionally followed by `:group`, or the user's primary group.
+func setCredential(cmd *exec.Cmd, User string) error {
+	if len(User) == 0 {
+		return nil
+	}
+	parts := strings.SplitN(User, ":", 2)
+	account, err := user.Lookup(parts[0])
+	if err != nil {
+		return err
+	}
+	gid := account.Gid
+	if len(parts) == 2 {
+		group, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return err
+		}
+		gid = group.Gid
+	}
+	uidValue, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid of user `%s`: %v", parts[0], err)
+	}
+	gidValue, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid `%s`: %v", gid, err)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uidValue), Gid: uint32(gidValue), Groups: []uint32{}},
+	}
+	return nil
+}
+
+// chownToCredential gives `folder` to the user `cmd` runs as, if it switches users.
+func chownToCredential(folder string, cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Credential == nil {
+		return nil
+	}
+	return os.Chown(folder, int(cmd.SysProcAttr.Credential.Uid), int(cmd.SysProcAttr.Credential.Gid))
+}
+
+// openNoFollow opens `file` for reading, failing if it is a symbolic link.
+// It does not block on FIFOs, which are rejected by the caller as they are not regular files.
+func openNoFollow(file string) (*os.File, error) {
+	return os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+}
+
+// execWithLimits sets `Limits` as the rlimits of the process and replaces it with `Args`.
+func execWithLimits(Limits ResourceLimits, Args []string) error {
+	rlimits := []struct {
+		resource int
+		value    int64
+	}{
+		{syscall.RLIMIT_CPU, int64(Limits.CPUTime.Seconds())},
+		{syscall.RLIMIT_AS, Limits.Memory},
+		{syscall.RLIMIT_NOFILE, Limits.OpenFiles},
+	}
+	for _, rlimit := range rlimits {
+		if rlimit.value <= 0 {
+			continue
+		}
+		value := uint64(rlimit.value)
+		if err := syscall.Setrlimit(rlimit.resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+			return fmt.Errorf("cannot set resource limit %d: %v", rlimit.resource, err)
+		}
+	}
+	path, err := exec.LookPath(Args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, Args, os.Environ())
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, User string) error {
+	if len(User) > 0 {
+		return fmt.Errorf("switching users is not supported on windows")
+	}
+	return nil
+}
+
+func chownToCredential(folder string, cmd *exec.Cmd) error {
+	return nil
+}
+
+func openNoFollow(file string) (*os.File, error) {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil, fmt.Errorf("`%s` is a symbolic link", file)
+	}
+	return os.Open(file)
+}
+
+func execWithLimits(Limits ResourceLimits, Args []string) error {
+	return fmt.Errorf("resource limits are not supported on windows")
+}
@@ -2,40 +2,187 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/xpy123993/toolbox/pkg/metrics"
 	"github.com/xpy123993/toolbox/pkg/taskmaster"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	pb "github.com/xpy123993/toolbox/proto"
 )
 
+// configRetryInterval is the interval a config not fully applied is retried at, see `maintainConfig`.
+const configRetryInterval = 10 * time.Second
+
 // StartTaskMasterService creates a task master service on `Channel`.
-func StartTaskMasterService(Address string, SnapshotFolder string, SnapshotInterval time.Duration, httpAddr string) {
+// If `TLSConfig` is not nil, both the RPC and the HTTP service are served with TLS.
+// On SIGINT or SIGTERM, ongoing RPCs are drained for up to `ShutdownTimeout`, both listeners are stopped
+// and the final snapshots of all groups are written before returning.
+// If `ConfigFile` is not empty, the config is applied once the server is the leader and reloaded on SIGHUP, see `taskmaster.LoadConfig`.
+// The callers forwarded by `TrustedRouters` are identified as themselves, see `taskmaster.TrustedRouterInterceptor`.
+func StartTaskMasterService(Address string, SnapshotFolder string, SnapshotInterval time.Duration, httpAddr string,
+	TLSConfig *tls.Config, ShutdownTimeout time.Duration, ConfigFile string, TrustedRouters []string, TaskMasterOptions []taskmaster.ServerOption) {
 	flag.Parse()
 
+	var config *taskmaster.Config
+	if len(ConfigFile) > 0 {
+		var err error
+		if config, err = taskmaster.LoadConfig(ConfigFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 	listener, err := net.Listen("tcp", Address)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	taskmaster, err := taskmaster.NewTaskMasterServer(SnapshotFolder, SnapshotInterval)
+	registry := metrics.NewRegistry()
+	taskMaster, err := taskmaster.NewTaskMasterServer(SnapshotFolder, SnapshotInterval, append(TaskMasterOptions, taskmaster.WithMetrics(registry))...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	rpcLatency := registry.NewHistogram("taskmaster_rpc_duration_seconds", "Latency of RPCs handled by the server.", nil, "method", "code")
+	rpcInterceptor := metrics.UnaryServerInterceptor(rpcLatency)
+	var httpServer *http.Server
 	if len(httpAddr) > 0 {
-		http.HandleFunc("/tasks", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Add("Content-Type", "text/html")
-			taskmaster.RenderStatusPage(context.Background(), rw)
-		})
-		go http.ListenAndServe(httpAddr, nil)
-	}
-	server := grpc.NewServer()
-	server.RegisterService(&pb.TaskMaster_ServiceDesc, taskmaster)
+		http.Handle("/tasks", taskmaster.DashboardHandler())
+		http.Handle("/metrics", registry)
+		http.Handle(taskmaster.GatewayPrefix, taskmaster.NewGatewayHandler(taskMaster, rpcInterceptor))
+		httpServer = &http.Server{Addr: httpAddr, TLSConfig: TLSConfig}
+		go func() {
+			var err error
+			if TLSConfig != nil {
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Printf("HTTP server stopped: %v", err)
+			}
+		}()
+	}
+	serverOptions := []grpc.ServerOption{grpc.ChainUnaryInterceptor(rpcInterceptor)}
+	if len(TrustedRouters) > 0 {
+		serverOptions = append(serverOptions,
+			grpc.ChainUnaryInterceptor(taskmaster.TrustedRouterInterceptor(TrustedRouters)),
+			grpc.ChainStreamInterceptor(taskmaster.TrustedRouterStreamInterceptor(TrustedRouters)))
+	}
+	if TLSConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(TLSConfig)))
+	}
+	server := grpc.NewServer(serverOptions...)
+	server.RegisterService(&pb.TaskMaster_ServiceDesc, taskMaster)
+	server.RegisterService(&pb.TaskMasterReplication_ServiceDesc, taskMaster.ReplicationServer())
+	if raftServer := taskMaster.RaftServer(); raftServer != nil {
+		server.RegisterService(&pb.Raft_ServiceDesc, raftServer)
+	}
+	healthpb.RegisterHealthServer(server, taskMaster.HealthServer())
+
+	shutdownDone := make(chan struct{})
+	if config != nil {
+		reloads := make(chan os.Signal, 1)
+		signal.Notify(reloads, syscall.SIGHUP)
+		go maintainConfig(taskMaster, ConfigFile, config, reloads, shutdownDone)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Printf("Received %v, shutting down", <-signals)
+		shutdownTaskMasterService(taskMaster, server, httpServer, ShutdownTimeout)
+		close(shutdownDone)
+	}()
 	log.Printf("Serving on %v", listener.Addr())
-	server.Serve(listener)
+	if err := server.Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+	// `Serve` returns once the shutdown starts, wait until the final snapshots are written.
+	<-shutdownDone
+}
+
+// applyConfig applies `config` loaded from `filename` and logs the updated groups.
+func applyConfig(taskMaster *taskmaster.ServerImpl, filename string, config *taskmaster.Config) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), configRetryInterval)
+	defer cancelFn()
+	updated, err := taskMaster.ApplyConfig(ctx, config)
+	if len(updated) > 0 {
+		log.Printf("Config `%s` updated groups %s", filename, strings.Join(updated, ", "))
+	}
+	return err
+}
+
+// maintainConfig applies `config` loaded from `filename`, and the config reloaded on each signal from `reloads`, until `done` is closed.
+// Only the leader applies configs, so a config is retried until it is fully applied,
+// which is once the server becomes the leader for followers.
+func maintainConfig(taskMaster *taskmaster.ServerImpl, filename string, config *taskmaster.Config, reloads <-chan os.Signal, done <-chan struct{}) {
+	retry := time.NewTicker(configRetryInterval)
+	defer retry.Stop()
+	lastError := ""
+	for {
+		if config != nil {
+			if err := applyConfig(taskMaster, filename, config); err != nil {
+				// Followers fail the same way on every retry, only changes are logged.
+				if err.Error() != lastError {
+					log.Printf("Config `%s` is not fully applied, retrying every %v: %v", filename, configRetryInterval, err)
+					lastError = err.Error()
+				}
+			} else {
+				config, lastError = nil, ""
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-reloads:
+			log.Printf("Reloading config `%s`", filename)
+			reloaded, err := taskmaster.LoadConfig(filename)
+			if err != nil {
+				log.Printf("Config is not applied: %v", err)
+				continue
+			}
+			config, lastError = reloaded, ""
+		case <-retry.C:
+		}
+	}
+}
+
+// shutdownTaskMasterService drains the RPCs for up to `timeout`, stops the listeners and writes the final snapshots.
+func shutdownTaskMasterService(taskMaster *taskmaster.ServerImpl, server *grpc.Server, httpServer *http.Server, timeout time.Duration) {
+	// Streaming calls such as `Watch` would hold the graceful stop until the timeout otherwise.
+	taskMaster.Drain()
+	ctx, cancelFn := context.WithTimeout(context.Background(), timeout)
+	defer cancelFn()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("RPCs are not drained within %v, stopping", timeout)
+		server.Stop()
+	}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("cannot stop HTTP server: %v", err)
+			httpServer.Close()
+		}
+	}
+	if err := taskMaster.Shutdown(); err != nil {
+		log.Printf("cannot write the final snapshots: %v", err)
+		return
+	}
+	log.Printf("Final snapshots are written")
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+)
+
+func TestMaintainConfig(t *testing.T) {
+	ctx := context.Background()
+	// The leader is unreachable, so the server stays a follower until promoted.
+	server, err := taskmaster.NewTaskMasterServer(t.TempDir(), time.Hour, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		Leader:     "127.0.0.1:1",
+		DialOption: grpc.WithInsecure(),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filename, []byte(`{"groups": {"default": {"max_attempts": 3}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := taskmaster.LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan os.Signal)
+	done := make(chan struct{})
+	defer close(done)
+	go maintainConfig(server, filename, config, reloads, done)
+
+	// The follower cannot apply the config, it is retried once the server is the leader.
+	reloads <- syscall.SIGHUP
+	if _, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"}); err == nil {
+		t.Error("expect followers not to apply the config")
+	}
+	if _, err := server.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	reloads <- syscall.SIGHUP
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"})
+		if err == nil && resp.GetSettings().GetMaxAttempts() == 3 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("expect the config to be applied once the server is the leader, got %v, %v", resp, err)
+		}
+	}
+}
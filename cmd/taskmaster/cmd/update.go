@@ -3,15 +3,24 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/xpy123993/toolbox/proto"
 )
 
 // InsertTask inserts a task into `WorkerGroup` of the task master.
-func InsertTask(Context context.Context, Address string, WorkerGroup string, BaseCommand string, Arguments []string) error {
-	client, err := createTaskMasterClient(Address)
+// The task is only handed out to workers with labels satisfying `Constraints`.
+// If positive, the task expires after `TTL` and is cancelled after `Deadline`.
+// If `Job` is not empty, the task is a member of the job.
+// The task is run within `Limits`, which must be within the limits of the worker running it.
+// Files matching `ArtifactPaths` are uploaded by the worker once the task succeeds, see `DownloadArtifacts`.
+func InsertTask(Context context.Context, Address string, WorkerGroup string, BaseCommand string, Arguments []string, Limits ResourceLimits, ArtifactPaths []string,
+	Labels map[string]string, Constraints []string, Tenant string, TTL time.Duration, Deadline time.Duration, CallbackURL string, Job string, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
 	if err != nil {
 		return err
 	}
@@ -20,10 +29,29 @@ func InsertTask(Context context.Context, Address string, WorkerGroup string, Bas
 		return err
 	}
 
-	resp, err := client.Insert(Context, &pb.InsertRequest{
-		Group: WorkerGroup,
-		Data:  string(data),
-	})
+	request := &pb.InsertRequest{
+		Group:         WorkerGroup,
+		Data:          string(data),
+		Labels:        Labels,
+		Constraints:   Constraints,
+		Tenant:        Tenant,
+		CallbackUrl:   CallbackURL,
+		Job:           Job,
+		ArtifactPaths: ArtifactPaths,
+		Limits: &pb.ResourceLimits{
+			CpuSeconds:  cpuSeconds(Limits.CPUTime),
+			MemoryBytes: Limits.Memory,
+			OpenFiles:   Limits.OpenFiles,
+			OutputBytes: Limits.OutputSize,
+		},
+	}
+	if TTL > 0 {
+		request.ExpireTime = timestamppb.New(time.Now().Add(TTL))
+	}
+	if Deadline > 0 {
+		request.Deadline = timestamppb.New(time.Now().Add(Deadline))
+	}
+	resp, err := client.Insert(Context, request)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+// WatchEvents prints the events of `WorkerGroup`, or of all groups if empty, until `Context` is done.
+// The watch is resumed from the last printed event after the connection breaks.
+func WatchEvents(Context context.Context, Address string, WorkerGroup string, TaskID string, Cursor string, DialOption grpc.DialOption) error {
+	client, err := createTaskMasterClient(Address, DialOption)
+	if err != nil {
+		return err
+	}
+	for {
+		stream, err := client.Watch(Context, &pb.WatchRequest{Group: WorkerGroup, ID: TaskID, Cursor: Cursor})
+		for err == nil {
+			var event *pb.TaskEvent
+			if event, err = stream.Recv(); err == nil {
+				fmt.Printf("%s %-9s %s/%s state=%s attempts=%d cursor=%s\n", event.GetTime().AsTime().Local().Format(time.RFC3339),
+					event.GetType(), event.GetGroup(), event.GetID(), event.GetTask().GetState(), event.GetTask().GetAttempts(), event.GetCursor())
+				Cursor = event.GetCursor()
+			}
+		}
+		if Context.Err() != nil {
+			return nil
+		}
+		if code := status.Code(err); code != codes.Unavailable && code != codes.Internal {
+			return err
+		}
+		log.Printf("watch is interrupted, resuming: %v", err)
+		time.Sleep(time.Second)
+	}
+}
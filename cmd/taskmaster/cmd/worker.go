@@ -2,32 +2,82 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
-	"os/exec"
+	"net/http"
+	"os"
 	"time"
 
+	"github.com/xpy123993/toolbox/pkg/metrics"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
 	"golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 
 	pb "github.com/xpy123993/toolbox/proto"
 )
 
+// RPCTimeout is the default lease duration requested by workers, extended until the task finishes.
 const RPCTimeout = 5 * time.Minute
 
+// minExtendInterval is the minimum interval between the lease extensions of a worker.
+const minExtendInterval = time.Second
+
+// workerMetrics holds the metrics exported by a worker.
+type workerMetrics struct {
+	executionTime   *metrics.HistogramVec
+	failures        *metrics.CounterVec
+	extensionErrors *metrics.CounterVec
+	queryErrors     *metrics.CounterVec
+}
+
+func newWorkerMetrics(registry *metrics.Registry) *workerMetrics {
+	return &workerMetrics{
+		executionTime:   registry.NewHistogram("taskmaster_worker_task_duration_seconds", "Execution time of tasks.", nil, "group", "result"),
+		failures:        registry.NewCounter("taskmaster_worker_task_failures_total", "Number of tasks failed to execute or commit.", "group"),
+		extensionErrors: registry.NewCounter("taskmaster_worker_extension_errors_total", "Number of failed lease extensions.", "group"),
+		queryErrors:     registry.NewCounter("taskmaster_worker_query_errors_total", "Number of failed task queries, including no task available.", "group", "code"),
+	}
+}
+
+// requestedLoanDuration returns the lease duration requested by a worker, nil to use the default of the group.
+func requestedLoanDuration(lease time.Duration) *durationpb.Duration {
+	if lease > 0 {
+		return durationpb.New(lease)
+	}
+	return nil
+}
+
+// extendInterval returns the interval of extending a lease of `lease`, or expiring at `deadline` if the lease duration is decided by the server.
+// Leases are extended halfway, so a failed extension can be retried before the lease expires.
+func extendInterval(lease time.Duration, deadline time.Time) time.Duration {
+	if lease <= 0 {
+		lease = time.Until(deadline)
+	}
+	if lease/2 < minExtendInterval {
+		return minExtendInterval
+	}
+	return lease / 2
+}
+
 func workerRoutinue(
-	backgroundContext context.Context, workerGroup string, timeout time.Duration, taskmasterClient pb.TaskMasterClient) error {
+	backgroundContext context.Context, workerGroup string, labels map[string]string, timeout time.Duration, lease time.Duration, sandbox SandboxConfig, taskmasterClient pb.TaskMasterClient, workerMetrics *workerMetrics) error {
 
 	routineContext, cancelFn := context.WithTimeout(backgroundContext, timeout)
 	defer cancelFn()
 
 	resp, err := taskmasterClient.Query(routineContext, &pb.QueryRequest{
 		Group:        workerGroup,
-		LoanDuration: durationpb.New(RPCTimeout),
+		LoanDuration: requestedLoanDuration(lease),
+		WorkerLabels: labels,
 	})
 	if err != nil {
+		workerMetrics.queryErrors.With(workerGroup, status.Code(err).String()).Inc()
 		return err
 	}
 	taskID := resp.GetID()
@@ -36,14 +86,20 @@ func workerRoutinue(
 	defer tracker.Finish()
 	log.Printf("working on task `%s`", taskID)
 
+	payload := []byte(resp.GetData())
+	if len(resp.GetBlob()) > 0 {
+		if payload, err = fetchBlob(routineContext, taskmasterClient, workerGroup, resp.GetID(), resp.GetBlob()); err != nil {
+			return err
+		}
+	}
 	command := pb.Command{}
-	if err := proto.Unmarshal([]byte(resp.GetData()), &command); err != nil {
+	if err := proto.Unmarshal(payload, &command); err != nil {
 		return err
 	}
 	tracker.LazyPrintf("%s", command.String())
 
 	go func() {
-		ticker := time.NewTicker(RPCTimeout)
+		ticker := time.NewTicker(extendInterval(lease, resp.GetDeadline().AsTime()))
 		defer ticker.Stop()
 		for {
 			select {
@@ -53,9 +109,10 @@ func workerRoutinue(
 				if _, err := taskmasterClient.Extend(routineContext, &pb.TaskExtendRequest{
 					Group:        workerGroup,
 					ID:           taskID,
-					LoanDuration: durationpb.New(2 * RPCTimeout),
+					LoanDuration: requestedLoanDuration(lease),
 				}); err != nil {
 					log.Print(err)
+					workerMetrics.extensionErrors.With(workerGroup).Inc()
 					cancelFn()
 					return
 				}
@@ -64,41 +121,102 @@ func workerRoutinue(
 		}
 	}()
 
-	cmd := exec.CommandContext(routineContext, command.BaseCommand, command.Arguments...)
-	data, err := cmd.CombinedOutput()
-	if err != nil {
+	startTime := time.Now()
+	var data []byte
+	limits, err := taskLimits(resp.GetLimits(), sandbox.MaxLimits)
+	// A task beyond the limits of the worker would fail the same way on every retry.
+	permanent := err != nil
+	// Tasks producing artifacts run in their own folder, so only their own files can be uploaded.
+	workDir := ""
+	if err == nil && len(resp.GetArtifactPaths()) > 0 {
+		if workDir, err = os.MkdirTemp("", "taskmaster-work"); err == nil {
+			defer os.RemoveAll(workDir)
+		}
+	}
+	if err == nil {
+		data, err = runSandboxed(routineContext, &command, limits, sandbox, workDir)
+	}
+	if err == nil && len(resp.GetArtifactPaths()) > 0 {
+		err = uploadArtifacts(routineContext, taskmasterClient, workerGroup, taskID, workDir, resp.GetArtifactPaths(), sandbox.User)
+	}
+	failed := err != nil
+	if failed {
+		workerMetrics.executionTime.With(workerGroup, "failure").Observe(time.Since(startTime).Seconds())
+		workerMetrics.failures.With(workerGroup).Inc()
 		tracker.LazyPrintf(err.Error())
 		tracker.SetError()
-		return err
+		data = append(data, fmt.Sprintf("\n%v\n", err)...)
+	} else {
+		workerMetrics.executionTime.With(workerGroup, "success").Observe(time.Since(startTime).Seconds())
+		tracker.LazyPrintf("Result: %s", data)
+	}
+	if len(data) > taskmaster.MaxLogSize {
+		data = data[len(data)-taskmaster.MaxLogSize:]
 	}
-	tracker.LazyPrintf("Result: %s", data)
 
 	if _, err := taskmasterClient.Finish(routineContext, &pb.FinishRequest{
-		Group: workerGroup,
-		ID:    taskID,
+		Group:     workerGroup,
+		ID:        taskID,
+		Failed:    failed,
+		Permanent: permanent,
+		Log:       string(data),
 	}); err != nil {
+		workerMetrics.failures.With(workerGroup).Inc()
 		return err
 	}
+	if failed {
+		return fmt.Errorf("task `%s` failed: %v", taskID, err)
+	}
 	tracker.LazyPrintf("commited")
 	log.Printf("task `%s` is commited", taskID)
 	return nil
 }
 
-func createTaskMasterClient(Address string) (pb.TaskMasterClient, error) {
-	client, err := grpc.Dial(Address, grpc.WithInsecure())
+// fetchBlob downloads the payload of task `ID` stored in the blob store and verifies its hash.
+func fetchBlob(ctx context.Context, client pb.TaskMasterClient, group string, ID string, hash string) ([]byte, error) {
+	stream, err := client.GetBlob(ctx, &pb.GetBlobRequest{Group: group, Hash: hash, ID: ID})
+	if err != nil {
+		return nil, err
+	}
+	data := []byte{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.GetData()...)
+	}
+	if digest := sha256.Sum256(data); hex.EncodeToString(digest[:]) != hash {
+		return nil, fmt.Errorf("blob `%s` is corrupted", hash)
+	}
+	return data, nil
+}
+
+// createTaskMasterClient connects to the task master at `Address`, calls rejected by followers are redirected to the leader.
+func createTaskMasterClient(Address string, DialOption grpc.DialOption) (pb.TaskMasterClient, error) {
+	client, err := grpc.Dial(Address, DialOption, grpc.WithUnaryInterceptor(taskmaster.LeaderRedirectInterceptor(DialOption)))
 	if err != nil {
 		return nil, err
 	}
 	return pb.NewTaskMasterClient(client), nil
 }
 
-func worker(Address string, WorkerGroup string, WorkerTimeout time.Duration) error {
-	client, err := createTaskMasterClient(Address)
+func worker(Address string, WorkerGroup string, Labels map[string]string, WorkerTimeout time.Duration, LeaseDuration time.Duration, Sandbox SandboxConfig, DialOption grpc.DialOption, HTTPAddress string) error {
+	client, err := createTaskMasterClient(Address, DialOption)
 	if err != nil {
 		return err
 	}
+	registry := metrics.NewRegistry()
+	workerMetrics := newWorkerMetrics(registry)
+	if len(HTTPAddress) > 0 {
+		http.Handle("/metrics", registry)
+		go http.ListenAndServe(HTTPAddress, nil)
+	}
 	for {
-		if err := workerRoutinue(context.Background(), WorkerGroup, WorkerTimeout, client); err != nil {
+		if err := workerRoutinue(context.Background(), WorkerGroup, Labels, WorkerTimeout, LeaseDuration, Sandbox, client, workerMetrics); err != nil {
 			log.Printf("worker returns error status: %v", err)
 			<-time.After(30 * time.Second)
 		}
@@ -106,6 +224,10 @@ func worker(Address string, WorkerGroup string, WorkerTimeout time.Duration) err
 }
 
 // StartWorker creates a worker job to periodically fetch task from `WorkGroup` of task master.
-func StartWorker(Address string, WorkerGroup string, WorkerTimeout time.Duration) {
-	log.Print(worker(Address, WorkerGroup, WorkerTimeout))
+// Only tasks with constraints satisfied by `Labels` are handed out to the worker.
+// Tasks are leased for `LeaseDuration` and extended until they finish, zero uses the loan duration of the group.
+// Tasks are run within the limits and as the user of `Sandbox`.
+// If `HTTPAddress` is not empty, worker metrics are served on `/metrics`.
+func StartWorker(Address string, WorkerGroup string, Labels map[string]string, WorkerTimeout time.Duration, LeaseDuration time.Duration, Sandbox SandboxConfig, DialOption grpc.DialOption, HTTPAddress string) {
+	log.Print(worker(Address, WorkerGroup, Labels, WorkerTimeout, LeaseDuration, Sandbox, DialOption, HTTPAddress))
 }
@@ -9,7 +9,7 @@ import (
 
 func main() {
 	if len(os.Args) <= 1 {
-		fmt.Println("Usage: taskmaster [serve | work | insert] [args]")
+		fmt.Println("Usage: taskmaster [serve | work | insert | group | job | artifacts | watch | replication | cluster | router | export | import | fsck | audit | bench] [args]")
 		return
 	}
 	switch os.Args[1] {
@@ -28,8 +28,81 @@ func main() {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+	case "group":
+		if err := cmd.HandleGroup(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "replication":
+		if err := cmd.HandleReplication(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "cluster":
+		if err := cmd.HandleCluster(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "job":
+		if err := cmd.HandleJob(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "artifacts":
+		if err := cmd.HandleArtifacts(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "watch":
+		if err := cmd.HandleWatch(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "router":
+		if err := cmd.HandleRouter(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "export":
+		if err := cmd.HandleExport(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "import":
+		if err := cmd.HandleImport(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "fsck":
+		if err := cmd.HandleFsck(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "audit":
+		if err := cmd.HandleAudit(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "bench":
+		if err := cmd.HandleBench(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "sandbox-exec":
+		// Run by workers to apply the resource limits of tasks, not listed in the usage.
+		if err := cmd.HandleSandboxExec(os.Args[2:]...); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	case "sandbox-read":
+		// Run by workers to read artifacts as the sandbox user, not listed in the usage.
+		// Errors go to stderr, as stdout carries the content of the file.
+		if err := cmd.HandleSandboxRead(os.Args[2:]...); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
 	default:
-		fmt.Println("Usage: taskmaster [serve | work | insert] [args]")
+		fmt.Println("Usage: taskmaster [serve | work | insert | group | job | artifacts | watch | replication | cluster | router | export | import | fsck | audit | bench] [args]")
 		os.Exit(1)
 	}
 }
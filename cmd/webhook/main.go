@@ -1,15 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,37 +22,84 @@ var (
 	serverFlags = flag.NewFlagSet("server", flag.PanicOnError)
 )
 
-func handleServerMode(args ...string) {
-	address := serverFlags.String("address", ":8080", "Serving address.")
-	baseCommand := serverFlags.String("base", "", "The path of the interpreter to execute commands.")
-	hashedPasswordStr := serverFlags.String("password", "", "The token generated from token command.")
-	serverFlags.Parse(args)
+// maxBodySize is the maximum size of the bodies of signed requests, which are passed to the command as its standard input.
+const maxBodySize = 1 << 20
 
-	hashedPassword, err := base64.RawStdEncoding.DecodeString(*hashedPasswordStr)
-	if err != nil {
-		log.Fatalf("failed to parse hash password flag: %v", err)
+// defaultMaxEventAge is the default maximum age of the events in signed requests, see `newHandler`.
+const defaultMaxEventAge = 5 * time.Minute
+
+// eventTime returns the time of the task master event encoded in `body`.
+func eventTime(body []byte) (time.Time, error) {
+	event := struct {
+		Time time.Time `json:"time"`
+	}{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return time.Time{}, err
 	}
+	if event.Time.IsZero() {
+		return time.Time{}, fmt.Errorf("the event has no time")
+	}
+	return event.Time, nil
+}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(r.Header.Get("X-WEBHOOK-TOKEN"))); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		cmd := exec.CommandContext(r.Context(), *baseCommand, serverFlags.Args()...)
+// newHandler returns the handler running `command` for each authorized request.
+//
+// If `secret` is empty, requests are authorized by the token in `X-WEBHOOK-TOKEN` matching `hashedPassword`,
+// and their `WEBHOOK_ENV_` form values are passed to the command as environment variables.
+// Otherwise requests, such as the callbacks of the task master, must be signed with the secret in `X-WEBHOOK-SIGNATURE`,
+// and their bodies are passed to the command as its standard input. As the signature only covers the body,
+// signed requests must not carry a query, and events older than `maxAge` are rejected so captured requests cannot be replayed later.
+func newHandler(hashedPassword []byte, secret string, maxAge time.Duration, command []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmd := exec.CommandContext(r.Context(), command[0], command[1:]...)
 		cmd.Env = os.Environ()
-		for k, v := range r.Form {
-			if len(v) == 0 {
-				continue
+		if len(secret) == 0 {
+			if err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(r.Header.Get("X-WEBHOOK-TOKEN"))); err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for k, v := range r.Form {
+				if len(v) == 0 {
+					continue
+				}
+				if !strings.HasPrefix(k, "WEBHOOK_ENV_") {
+					http.Error(w, "Environment variable must has prefix `WEBHOOK_ENV_`.", http.StatusBadRequest)
+					return
+				}
+				cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v[0]))
+			}
+		} else {
+			if len(r.URL.RawQuery) > 0 {
+				http.Error(w, "Signed requests must not carry a query", http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			if !strings.HasPrefix(k, "WEBHOOK_ENV_") {
-				http.Error(w, "Environment variable must has prefix `WEBHOOK_ENV_`.", http.StatusBadRequest)
+			if len(body) > maxBodySize {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 				return
 			}
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v[0]))
+			if !taskmaster.VerifyWebhook(secret, body, r.Header.Get(taskmaster.WebhookSignatureHeader)) {
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+				return
+			}
+			sent, err := eventTime(body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+				return
+			}
+			if age := time.Since(sent); age > maxAge || age < -maxAge {
+				http.Error(w, "The event is outside of the accepted time window", http.StatusUnauthorized)
+				return
+			}
+			cmd.Stdin = bytes.NewReader(body)
 		}
 		cmd.Stdout = w
 		cmd.Stderr = w
@@ -56,6 +108,33 @@ func handleServerMode(args ...string) {
 			return
 		}
 	})
+}
+
+func handleServerMode(args ...string) {
+	address := serverFlags.String("address", ":8080", "Serving address.")
+	baseCommand := serverFlags.String("base", "", "The path of the interpreter to execute commands.")
+	hashedPasswordStr := serverFlags.String("password", "", "The token generated from token command.")
+	secretFile := serverFlags.String("secret-file", "", "If not empty, requests must be signed with the secret in the file instead of carrying the token, such as the callbacks of the task master.")
+	maxEventAge := serverFlags.Duration("max-event-age", defaultMaxEventAge, "Under --secret-file, signed events older than this, or this far in the future, are rejected as replays.")
+	serverFlags.Parse(args)
+
+	hashedPassword, err := base64.RawStdEncoding.DecodeString(*hashedPasswordStr)
+	if err != nil {
+		log.Fatalf("failed to parse hash password flag: %v", err)
+	}
+	secret := ""
+	if len(*secretFile) > 0 {
+		data, err := os.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatalf("failed to read the secret file: %v", err)
+		}
+		secret = strings.TrimSpace(string(data))
+		if len(secret) == 0 {
+			log.Fatalf("the secret file `%s` is empty", *secretFile)
+		}
+	}
+
+	http.Handle("/", newHandler(hashedPassword, secret, *maxEventAge, append([]string{*baseCommand}, serverFlags.Args()...)))
 	log.Printf("Serving at %s", *address)
 	http.ListenAndServe(*address, nil)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func eventBody(sent time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"type": "finished", "time": %q}`, sent.UTC().Format(time.RFC3339Nano)))
+}
+
+func signed(body []byte) map[string]string {
+	return map[string]string{taskmaster.WebhookSignatureHeader: taskmaster.SignWebhook("secret", body)}
+}
+
+func serveRequest(handler http.Handler, body []byte, header map[string]string) *httptest.ResponseRecorder {
+	return serveRequestTo(handler, "/", body, header)
+}
+
+func serveRequestTo(handler http.Handler, target string, body []byte, header map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSignedRequests(t *testing.T) {
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat is not available")
+	}
+	handler := newHandler(nil, "secret", time.Minute, []string{cat})
+	body := eventBody(time.Now())
+
+	resp := serveRequest(handler, body, signed(body))
+	if resp.Code != http.StatusOK || resp.Body.String() != string(body) {
+		t.Errorf("expect the body to be passed to the command, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if resp := serveRequest(handler, body, nil); resp.Code != http.StatusUnauthorized {
+		t.Errorf("expect unsigned requests to be rejected, got %d", resp.Code)
+	}
+	other := map[string]string{taskmaster.WebhookSignatureHeader: taskmaster.SignWebhook("other", body)}
+	if resp := serveRequest(handler, body, other); resp.Code != http.StatusUnauthorized {
+		t.Errorf("expect requests signed with another secret to be rejected, got %d", resp.Code)
+	}
+	if resp := serveRequestTo(handler, "/?WEBHOOK_ENV_X=injected", body, signed(body)); resp.Code != http.StatusBadRequest {
+		t.Errorf("expect signed requests with a query to be rejected, got %d", resp.Code)
+	}
+	for _, sent := range []time.Time{time.Now().Add(-time.Hour), time.Now().Add(time.Hour)} {
+		stale := eventBody(sent)
+		if resp := serveRequest(handler, stale, signed(stale)); resp.Code != http.StatusUnauthorized {
+			t.Errorf("expect events sent at %v to be rejected, got %d", sent, resp.Code)
+		}
+	}
+	untimed := []byte(`{"type": "finished"}`)
+	if resp := serveRequest(handler, untimed, signed(untimed)); resp.Code != http.StatusBadRequest {
+		t.Errorf("expect events without time to be rejected, got %d", resp.Code)
+	}
+	large := bytes.Repeat([]byte("a"), maxBodySize+1)
+	if resp := serveRequest(handler, large, signed(large)); resp.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expect large bodies to be rejected, got %d", resp.Code)
+	}
+}
+
+func TestTokenRequests(t *testing.T) {
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat is not available")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("token"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newHandler(hashedPassword, "", 0, []string{cat})
+	body := []byte(`{"type": "finished"}`)
+
+	resp := serveRequest(handler, body, map[string]string{"X-WEBHOOK-TOKEN": "token"})
+	if resp.Code != http.StatusOK || resp.Body.Len() != 0 {
+		t.Errorf("expect the command to run without the body, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if resp := serveRequest(handler, body, map[string]string{"X-WEBHOOK-TOKEN": "other"}); resp.Code != http.StatusUnauthorized {
+		t.Errorf("expect requests with a wrong token to be rejected, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,109 @@
+// Package clock abstracts the passage of time, so time dependent code can be driven by a simulated clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time and creates tickers.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker delivering the time on its channel every `Interval`.
+	NewTicker(Interval time.Duration) Ticker
+}
+
+// Ticker is the interface of `time.Ticker`.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(Interval time.Duration) Ticker {
+	return realTicker{time.NewTicker(Interval)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (ticker realTicker) C() <-chan time.Time { return ticker.ticker.C }
+func (ticker realTicker) Stop()               { ticker.ticker.Stop() }
+
+// Fake is a clock which only moves forward by `Advance`.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake creates a fake clock starting at `Start`.
+func NewFake(Start time.Time) *Fake {
+	return &Fake{now: Start}
+}
+
+// Now returns the current time of the clock.
+func (clock *Fake) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// NewTicker creates a ticker fired by `Advance`.
+func (clock *Fake) NewTicker(Interval time.Duration) Ticker {
+	if Interval <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	ticker := &fakeTicker{clock: clock, interval: Interval, next: clock.now.Add(Interval), c: make(chan time.Time, 1)}
+	clock.tickers = append(clock.tickers, ticker)
+	return ticker
+}
+
+// Advance moves the clock forward by `Duration` and fires the tickers due in between.
+// Like `time.Ticker`, ticks are dropped if the receiver has not received the previous tick.
+func (clock *Fake) Advance(Duration time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(Duration)
+	for _, ticker := range clock.tickers {
+		if ticker.next.After(clock.now) {
+			continue
+		}
+		select {
+		case ticker.c <- ticker.next:
+		default:
+		}
+		for !ticker.next.After(clock.now) {
+			ticker.next = ticker.next.Add(ticker.interval)
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock    *Fake
+	interval time.Duration
+	next     time.Time
+	c        chan time.Time
+}
+
+func (ticker *fakeTicker) C() <-chan time.Time { return ticker.c }
+
+func (ticker *fakeTicker) Stop() {
+	ticker.clock.mu.Lock()
+	defer ticker.clock.mu.Unlock()
+	for i, other := range ticker.clock.tickers {
+		if other == ticker {
+			ticker.clock.tickers = append(ticker.clock.tickers[:i], ticker.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
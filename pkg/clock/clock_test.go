@@ -0,0 +1,39 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/clock"
+)
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	ticker := fake.NewTicker(time.Second)
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick before the interval")
+	default:
+	}
+	// Ticks are dropped while the previous tick is not received.
+	fake.Advance(3 * time.Second)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected tick at %v", tick)
+	}
+	fake.Advance(500 * time.Millisecond)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("unexpected tick at %v", tick)
+	}
+	ticker.Stop()
+	fake.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Error("unexpected tick after stopped")
+	default:
+	}
+	if now := fake.Now(); !now.Equal(start.Add(time.Hour + 4*time.Second)) {
+		t.Errorf("unexpected time %v", now)
+	}
+}
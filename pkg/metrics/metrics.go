@@ -0,0 +1,123 @@
+// Package metrics registers counters, gauges and histograms with the Prometheus client library,
+// and exports them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// DefaultBuckets are the histogram buckets suitable for latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// Registry holds a set of metrics to export.
+type Registry struct {
+	registry *prometheus.Registry
+
+	mu    sync.Mutex
+	hooks []func()
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{registry: prometheus.NewRegistry()}
+}
+
+// OnScrape registers `hook` to be called before the metrics are exported.
+// It is useful to update gauges derived from other states.
+func (registry *Registry) OnScrape(hook func()) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.hooks = append(registry.hooks, hook)
+}
+
+// Gather implements `prometheus.Gatherer`, the hooks registered by `OnScrape` are called first.
+func (registry *Registry) Gather() ([]*dto.MetricFamily, error) {
+	registry.mu.Lock()
+	hooks := append([]func(){}, registry.hooks...)
+	registry.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	return registry.registry.Gather()
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (registry *Registry) WriteText(w io.Writer) error {
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler to serve the metrics.
+func (registry *Registry) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(rw, r)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ vec *prometheus.CounterVec }
+
+// NewCounter registers a counter with `labelNames`.
+func (registry *Registry) NewCounter(name string, help string, labelNames ...string) *CounterVec {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	registry.registry.MustRegister(vec)
+	return &CounterVec{vec: vec}
+}
+
+// With returns the counter with `labelValues`.
+func (vec *CounterVec) With(labelValues ...string) prometheus.Counter {
+	return vec.vec.WithLabelValues(labelValues...)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ vec *prometheus.GaugeVec }
+
+// NewGauge registers a gauge with `labelNames`.
+func (registry *Registry) NewGauge(name string, help string, labelNames ...string) *GaugeVec {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	registry.registry.MustRegister(vec)
+	return &GaugeVec{vec: vec}
+}
+
+// With returns the gauge with `labelValues`.
+func (vec *GaugeVec) With(labelValues ...string) prometheus.Gauge {
+	return vec.vec.WithLabelValues(labelValues...)
+}
+
+// Reset removes all series of the gauge.
+func (vec *GaugeVec) Reset() {
+	vec.vec.Reset()
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct{ vec *prometheus.HistogramVec }
+
+// NewHistogram registers a histogram with `labelNames`.
+// `buckets` are upper bounds in increasing order, `DefaultBuckets` is used if nil.
+func (registry *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labelNames)
+	registry.registry.MustRegister(vec)
+	return &HistogramVec{vec: vec}
+}
+
+// With returns the histogram with `labelValues`.
+func (vec *HistogramVec) With(labelValues ...string) prometheus.Observer {
+	return vec.vec.WithLabelValues(labelValues...)
+}
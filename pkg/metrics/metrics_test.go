@@ -0,0 +1,42 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xpy123993/toolbox/pkg/metrics"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_events_total", "Number of events.", "group")
+	gauge := registry.NewGauge("test_depth", "Queue depth.")
+	histogram := registry.NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+
+	counter.With(`a"b`).Inc()
+	counter.With("c").Add(2)
+	histogram.With("query").Observe(0.05)
+	histogram.With("query").Observe(0.5)
+	histogram.With("query").Observe(5)
+	registry.OnScrape(func() { gauge.With().Set(3) })
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	output := recorder.Body.String()
+	for _, expected := range []string{
+		"# TYPE test_events_total counter\n",
+		`test_events_total{group="a\"b"} 1` + "\n",
+		`test_events_total{group="c"} 2` + "\n",
+		"test_depth 3\n",
+		`test_latency_seconds_bucket{method="query",le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{method="query",le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{method="query",le="+Inf"} 3` + "\n",
+		`test_latency_seconds_sum{method="query"} 5.55` + "\n",
+		`test_latency_seconds_count{method="query"} 3` + "\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expect %q in output:\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,882 @@
+// Package raft implements the Raft consensus algorithm over gRPC for a cluster with a static membership.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Entry is an entry of the replicated log.
+type Entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	// Data is empty for the entries appended by new leaders to commit the entries of previous terms.
+	Data []byte `json:"data,omitempty"`
+}
+
+// StateMachine is replicated by the log.
+// The methods are called from a single routine in the order of the log.
+type StateMachine interface {
+	// Apply applies the data of a committed entry.
+	// The result is returned by `Propose` if the entry is proposed by this node.
+	Apply(Data []byte) interface{}
+	// Snapshot returns the state after all the applied entries.
+	Snapshot() ([]byte, error)
+	// Restore replaces the state with a snapshot.
+	Restore(Data []byte) error
+}
+
+// Node roles.
+const (
+	RoleFollower  = "follower"
+	RoleCandidate = "candidate"
+	RoleLeader    = "leader"
+)
+
+const (
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultElectionTimeout   = time.Second
+	DefaultSnapshotThreshold = 8192
+
+	// maxBytesPerRequest caps the size of entries sent in an `AppendEntries` request, at least one entry is sent.
+	maxBytesPerRequest = 1 << 20
+	// snapshotChunkSize is the size of each `InstallSnapshot` request.
+	snapshotChunkSize = 1 << 20
+)
+
+// Config configures a node.
+type Config struct {
+	// ID is the address of the node, it must be one of `Peers`.
+	ID string
+	// Peers are the addresses of all nodes in the cluster including this node.
+	Peers []string
+	// Dir stores the persisted state of the node.
+	Dir string
+	// DialOption is used to connect to the other nodes.
+	DialOption grpc.DialOption
+	// HeartbeatInterval is the interval of heartbeats sent by the leader. Defaults to `DefaultHeartbeatInterval`.
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the minimum time without heartbeats before a follower starts an election,
+	// the actual timeout is randomized up to twice of it. Defaults to `DefaultElectionTimeout`.
+	ElectionTimeout time.Duration
+	// SnapshotThreshold is the number of applied entries after which the log is compacted into a snapshot.
+	// Defaults to `DefaultSnapshotThreshold`.
+	SnapshotThreshold uint64
+}
+
+// NotLeaderError is returned by `Propose` if the node is not the leader.
+type NotLeaderError struct {
+	// Leader is the address of the current leader, empty if unknown.
+	Leader string
+}
+
+func (err *NotLeaderError) Error() string {
+	if len(err.Leader) == 0 {
+		return "not the leader, the current leader is unknown"
+	}
+	return fmt.Sprintf("not the leader, the current leader is `%s`", err.Leader)
+}
+
+var (
+	// ErrEntryLost is returned by `Propose` if the entry is overwritten by another leader before committed.
+	ErrEntryLost = errors.New("the entry is overwritten by another leader")
+	// ErrUnknownResult is returned by `Propose` if the entry is committed but replaced by a snapshot from another leader before applied.
+	ErrUnknownResult = errors.New("the entry is replaced by a snapshot before applied")
+	// ErrStopped is returned by `Propose` once the node is stopped.
+	ErrStopped = errors.New("the node is stopped")
+)
+
+type proposalResult struct {
+	value interface{}
+	err   error
+}
+
+type proposal struct {
+	term uint64
+	done chan proposalResult
+}
+
+// Node is a member of a Raft cluster, it implements the RPC service `Raft`.
+type Node struct {
+	pb.UnimplementedRaftServer
+
+	config  Config
+	machine StateMachine
+	storage *storage
+	clients map[string]pb.RaftClient
+	conns   []*grpc.ClientConn
+
+	mu       sync.Mutex
+	term     uint64
+	votedFor string
+	role     string
+	leader   string
+	// entries are the log entries after the snapshot.
+	entries          []Entry
+	snapshot         *snapshot
+	commitIndex      uint64
+	appliedIndex     uint64
+	electionDeadline time.Time
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	proposals        map[uint64]*proposal
+	replicateNotify  map[string]chan struct{}
+	// receivedSnapshot buffers the chunks of an incoming snapshot.
+	receivedSnapshot []byte
+	stopped          bool
+
+	applyNotify chan struct{}
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewNode loads the persisted state of a node and starts it.
+// The node should be registered to a gRPC server serving on `ID`.
+func NewNode(Config Config, Machine StateMachine) (*Node, error) {
+	found := false
+	for _, peer := range Config.Peers {
+		found = found || peer == Config.ID
+	}
+	if !found {
+		return nil, fmt.Errorf("`%s` is not one of the peers", Config.ID)
+	}
+	if Config.HeartbeatInterval <= 0 {
+		Config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if Config.ElectionTimeout <= 0 {
+		Config.ElectionTimeout = DefaultElectionTimeout
+	}
+	if Config.SnapshotThreshold == 0 {
+		Config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if Config.DialOption == nil {
+		Config.DialOption = grpc.WithInsecure()
+	}
+	storage, state, loadedSnapshot, entries, err := openStorage(Config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		config:          Config,
+		machine:         Machine,
+		storage:         storage,
+		clients:         make(map[string]pb.RaftClient),
+		term:            state.Term,
+		votedFor:        state.VotedFor,
+		role:            RoleFollower,
+		entries:         entries,
+		snapshot:        &snapshot{},
+		nextIndex:       make(map[string]uint64),
+		matchIndex:      make(map[string]uint64),
+		proposals:       make(map[uint64]*proposal),
+		replicateNotify: make(map[string]chan struct{}),
+		applyNotify:     make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+	}
+	if loadedSnapshot != nil {
+		if err := Machine.Restore(loadedSnapshot.Data); err != nil {
+			storage.close()
+			return nil, fmt.Errorf("cannot restore snapshot: %v", err)
+		}
+		node.snapshot = loadedSnapshot
+		node.commitIndex = loadedSnapshot.Index
+		node.appliedIndex = loadedSnapshot.Index
+	}
+	for _, peer := range Config.Peers {
+		if peer == Config.ID {
+			continue
+		}
+		conn, err := grpc.Dial(peer, Config.DialOption)
+		if err != nil {
+			node.Stop()
+			return nil, err
+		}
+		node.conns = append(node.conns, conn)
+		node.clients[peer] = pb.NewRaftClient(conn)
+	}
+	node.resetElectionTimer()
+	node.wg.Add(2)
+	go node.run()
+	go node.applyRoutine()
+	return node, nil
+}
+
+// Stop stops the node, the persisted state is kept.
+func (node *Node) Stop() {
+	node.mu.Lock()
+	if node.stopped {
+		node.mu.Unlock()
+		return
+	}
+	node.stopped = true
+	close(node.stop)
+	node.mu.Unlock()
+	node.wg.Wait()
+	for _, conn := range node.conns {
+		conn.Close()
+	}
+	node.storage.close()
+}
+
+// lastIndex must be called with `mu` held.
+func (node *Node) lastIndex() uint64 {
+	if len(node.entries) == 0 {
+		return node.snapshot.Index
+	}
+	return node.entries[len(node.entries)-1].Index
+}
+
+// termAt returns the term of the entry at `index`, returns false if the entry is not in the log.
+// Must be called with `mu` held.
+func (node *Node) termAt(index uint64) (uint64, bool) {
+	if index == node.snapshot.Index {
+		return node.snapshot.Term, true
+	}
+	if index < node.snapshot.Index || index > node.lastIndex() {
+		return 0, false
+	}
+	return node.entries[index-node.snapshot.Index-1].Term, true
+}
+
+// entriesFrom returns a copy of the entries starting from `index` within `maxBytes`.
+// Must be called with `mu` held.
+func (node *Node) entriesFrom(index uint64, maxBytes int) []Entry {
+	if index <= node.snapshot.Index || index > node.lastIndex() {
+		return nil
+	}
+	result := []Entry{}
+	size := 0
+	for _, entry := range node.entries[index-node.snapshot.Index-1:] {
+		if len(result) > 0 && size+len(entry.Data) > maxBytes {
+			break
+		}
+		size += len(entry.Data)
+		result = append(result, entry)
+	}
+	return result
+}
+
+// persistState must be called with `mu` held.
+func (node *Node) persistState() {
+	if err := node.storage.saveState(hardState{Term: node.term, VotedFor: node.votedFor}); err != nil {
+		log.Fatalf("cannot persist raft state: %v", err)
+	}
+}
+
+// appendLocal must be called with `mu` held.
+func (node *Node) appendLocal(entries ...Entry) {
+	if err := node.storage.appendEntries(entries); err != nil {
+		log.Fatalf("cannot persist raft log: %v", err)
+	}
+	node.entries = append(node.entries, entries...)
+}
+
+// truncateFrom removes the entries starting from `index`.
+// Must be called with `mu` held.
+func (node *Node) truncateFrom(index uint64) {
+	node.entries = node.entries[:index-node.snapshot.Index-1]
+	if err := node.storage.rewriteLog(node.entries); err != nil {
+		log.Fatalf("cannot persist raft log: %v", err)
+	}
+	for proposalIndex, proposal := range node.proposals {
+		if proposalIndex >= index {
+			proposal.done <- proposalResult{err: ErrEntryLost}
+			delete(node.proposals, proposalIndex)
+		}
+	}
+}
+
+// resetElectionTimer must be called with `mu` held.
+func (node *Node) resetElectionTimer() {
+	timeout := node.config.ElectionTimeout + time.Duration(rand.Int63n(int64(node.config.ElectionTimeout)))
+	node.electionDeadline = time.Now().Add(timeout)
+}
+
+func (node *Node) notifyApply() {
+	select {
+	case node.applyNotify <- struct{}{}:
+	default:
+	}
+}
+
+// becomeFollower must be called with `mu` held.
+func (node *Node) becomeFollower(term uint64) {
+	if term > node.term {
+		node.term = term
+		node.votedFor = ""
+		node.persistState()
+	}
+	if node.role == RoleLeader {
+		node.leader = ""
+	}
+	node.role = RoleFollower
+	node.replicateNotify = make(map[string]chan struct{})
+	node.resetElectionTimer()
+}
+
+// becomeLeader must be called with `mu` held.
+func (node *Node) becomeLeader() {
+	if node.stopped {
+		return
+	}
+	node.role = RoleLeader
+	node.leader = node.config.ID
+	lastIndex := node.lastIndex()
+	for peer := range node.clients {
+		node.nextIndex[peer] = lastIndex + 1
+		node.matchIndex[peer] = 0
+	}
+	// An empty entry commits the entries of the previous terms.
+	node.appendLocal(Entry{Index: lastIndex + 1, Term: node.term})
+	node.replicateNotify = make(map[string]chan struct{})
+	for peer, client := range node.clients {
+		notify := make(chan struct{}, 1)
+		node.replicateNotify[peer] = notify
+		node.wg.Add(1)
+		go node.replicate(peer, client, node.term, notify)
+	}
+	node.advanceCommit()
+	log.Printf("raft: `%s` becomes the leader of term %d", node.config.ID, node.term)
+}
+
+// advanceCommit commits the entries of the current term replicated to the majority.
+// Must be called with `mu` held.
+func (node *Node) advanceCommit() {
+	for index := node.lastIndex(); index > node.commitIndex; index-- {
+		if term, _ := node.termAt(index); term != node.term {
+			return
+		}
+		count := 1
+		for _, match := range node.matchIndex {
+			if match >= index {
+				count++
+			}
+		}
+		if count > len(node.config.Peers)/2 {
+			node.commitIndex = index
+			node.notifyApply()
+			return
+		}
+	}
+}
+
+func (node *Node) run() {
+	defer node.wg.Done()
+	ticker := time.NewTicker(node.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-node.stop:
+			return
+		case <-ticker.C:
+			node.mu.Lock()
+			if node.role != RoleLeader && time.Now().After(node.electionDeadline) {
+				node.startElection()
+			}
+			node.mu.Unlock()
+		}
+	}
+}
+
+// startElection must be called with `mu` held.
+func (node *Node) startElection() {
+	node.role = RoleCandidate
+	node.term++
+	node.votedFor = node.config.ID
+	node.leader = ""
+	node.persistState()
+	node.resetElectionTimer()
+	term := node.term
+	lastIndex := node.lastIndex()
+	lastTerm, _ := node.termAt(lastIndex)
+	votes := 1
+	if votes > len(node.config.Peers)/2 {
+		node.becomeLeader()
+		return
+	}
+	request := &pb.RequestVoteRequest{
+		Term:         term,
+		Candidate:    node.config.ID,
+		LastLogIndex: lastIndex,
+		LastLogTerm:  lastTerm,
+	}
+	for _, client := range node.clients {
+		go func(client pb.RaftClient) {
+			ctx, cancelFn := context.WithTimeout(context.Background(), node.config.ElectionTimeout)
+			defer cancelFn()
+			resp, err := client.RequestVote(ctx, request)
+			if err != nil {
+				return
+			}
+			node.mu.Lock()
+			defer node.mu.Unlock()
+			if resp.GetTerm() > node.term {
+				node.becomeFollower(resp.GetTerm())
+				return
+			}
+			if node.role != RoleCandidate || node.term != term || !resp.GetGranted() {
+				return
+			}
+			if votes++; votes > len(node.config.Peers)/2 {
+				node.becomeLeader()
+			}
+		}(client)
+	}
+}
+
+func (node *Node) replicate(peer string, client pb.RaftClient, term uint64, notify chan struct{}) {
+	defer node.wg.Done()
+	ticker := time.NewTicker(node.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		more, ok := node.sendAppend(peer, client, term)
+		if !ok {
+			return
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-node.stop:
+			return
+		case <-notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func toProtoEntries(entries []Entry) []*pb.RaftEntry {
+	result := make([]*pb.RaftEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, &pb.RaftEntry{Index: entry.Index, Term: entry.Term, Data: entry.Data})
+	}
+	return result
+}
+
+// sendAppend sends the entries after the next index of `peer`.
+// Returns whether there are more entries to send, and false if the node is no longer the leader of `term`.
+func (node *Node) sendAppend(peer string, client pb.RaftClient, term uint64) (bool, bool) {
+	node.mu.Lock()
+	if node.role != RoleLeader || node.term != term {
+		node.mu.Unlock()
+		return false, false
+	}
+	next := node.nextIndex[peer]
+	if next <= node.snapshot.Index {
+		snapshot := node.snapshot
+		node.mu.Unlock()
+		return node.sendSnapshot(peer, client, term, snapshot)
+	}
+	prevIndex := next - 1
+	prevTerm, _ := node.termAt(prevIndex)
+	entries := node.entriesFrom(next, maxBytesPerRequest)
+	request := &pb.AppendEntriesRequest{
+		Term:         term,
+		Leader:       node.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  prevTerm,
+		Entries:      toProtoEntries(entries),
+		CommitIndex:  node.commitIndex,
+	}
+	node.mu.Unlock()
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), node.config.ElectionTimeout)
+	defer cancelFn()
+	resp, err := client.AppendEntries(ctx, request)
+	if err != nil {
+		return false, true
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if resp.GetTerm() > node.term {
+		node.becomeFollower(resp.GetTerm())
+		return false, false
+	}
+	if node.role != RoleLeader || node.term != term {
+		return false, false
+	}
+	if resp.GetSuccess() {
+		if match := prevIndex + uint64(len(entries)); match > node.matchIndex[peer] {
+			node.matchIndex[peer] = match
+		}
+		node.nextIndex[peer] = node.matchIndex[peer] + 1
+		node.advanceCommit()
+	} else {
+		next := resp.GetConflictIndex()
+		if next > prevIndex {
+			next = prevIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+		node.nextIndex[peer] = next
+	}
+	return node.nextIndex[peer] <= node.lastIndex(), true
+}
+
+func (node *Node) sendSnapshot(peer string, client pb.RaftClient, term uint64, snapshot *snapshot) (bool, bool) {
+	for offset := 0; ; {
+		end := offset + snapshotChunkSize
+		if end > len(snapshot.Data) {
+			end = len(snapshot.Data)
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), node.config.ElectionTimeout)
+		resp, err := client.InstallSnapshot(ctx, &pb.InstallSnapshotRequest{
+			Term:      term,
+			Leader:    node.config.ID,
+			LastIndex: snapshot.Index,
+			LastTerm:  snapshot.Term,
+			Offset:    uint64(offset),
+			Data:      snapshot.Data[offset:end],
+			Done:      end == len(snapshot.Data),
+		})
+		cancelFn()
+		if err != nil {
+			return false, true
+		}
+		node.mu.Lock()
+		if resp.GetTerm() > node.term {
+			node.becomeFollower(resp.GetTerm())
+		}
+		isLeader := node.role == RoleLeader && node.term == term
+		node.mu.Unlock()
+		if !isLeader {
+			return false, false
+		}
+		if end == len(snapshot.Data) {
+			break
+		}
+		offset = end
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.role != RoleLeader || node.term != term {
+		return false, false
+	}
+	if snapshot.Index > node.matchIndex[peer] {
+		node.matchIndex[peer] = snapshot.Index
+	}
+	node.nextIndex[peer] = node.matchIndex[peer] + 1
+	node.advanceCommit()
+	return node.nextIndex[peer] <= node.lastIndex(), true
+}
+
+func (node *Node) applyRoutine() {
+	defer node.wg.Done()
+	for {
+		select {
+		case <-node.stop:
+			return
+		case <-node.applyNotify:
+		}
+		for node.applyCommitted() {
+		}
+		node.maybeSnapshot()
+	}
+}
+
+// applyCommitted applies a batch of the committed entries, returns false if all of them are applied.
+func (node *Node) applyCommitted() bool {
+	node.mu.Lock()
+	if node.appliedIndex < node.snapshot.Index {
+		snapshot := node.snapshot
+		node.mu.Unlock()
+		if err := node.machine.Restore(snapshot.Data); err != nil {
+			log.Fatalf("cannot restore snapshot: %v", err)
+		}
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		node.appliedIndex = snapshot.Index
+		for index, proposal := range node.proposals {
+			if index <= snapshot.Index {
+				proposal.done <- proposalResult{err: ErrUnknownResult}
+				delete(node.proposals, index)
+			}
+		}
+		return true
+	}
+	if node.appliedIndex >= node.commitIndex {
+		node.mu.Unlock()
+		return false
+	}
+	entries := node.entriesFrom(node.appliedIndex+1, maxBytesPerRequest)
+	if len(entries) > 0 && entries[len(entries)-1].Index > node.commitIndex {
+		entries = entries[:node.commitIndex-node.appliedIndex]
+	}
+	node.mu.Unlock()
+	for _, entry := range entries {
+		var value interface{}
+		if len(entry.Data) > 0 {
+			value = node.machine.Apply(entry.Data)
+		}
+		node.mu.Lock()
+		node.appliedIndex = entry.Index
+		if proposal, exists := node.proposals[entry.Index]; exists {
+			delete(node.proposals, entry.Index)
+			if proposal.term == entry.Term {
+				proposal.done <- proposalResult{value: value}
+			} else {
+				proposal.done <- proposalResult{err: ErrEntryLost}
+			}
+		}
+		node.mu.Unlock()
+	}
+	return true
+}
+
+// maybeSnapshot compacts the log once enough entries are applied.
+// Must be called from the apply routine, so the state machine is at the applied index.
+func (node *Node) maybeSnapshot() {
+	node.mu.Lock()
+	applied := node.appliedIndex
+	needed := applied-node.snapshot.Index >= node.config.SnapshotThreshold
+	node.mu.Unlock()
+	if !needed {
+		return
+	}
+	data, err := node.machine.Snapshot()
+	if err != nil {
+		log.Printf("raft: cannot take snapshot: %v", err)
+		return
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if applied <= node.snapshot.Index {
+		return
+	}
+	term, _ := node.termAt(applied)
+	newSnapshot := &snapshot{Index: applied, Term: term, Data: data}
+	if err := node.storage.saveSnapshot(newSnapshot); err != nil {
+		log.Fatalf("cannot persist raft snapshot: %v", err)
+	}
+	node.entries = append([]Entry(nil), node.entries[applied-node.snapshot.Index:]...)
+	node.snapshot = newSnapshot
+	if err := node.storage.rewriteLog(node.entries); err != nil {
+		log.Fatalf("cannot persist raft log: %v", err)
+	}
+}
+
+// Propose appends `Data` to the log and waits until it is applied.
+// Returns the result of `StateMachine.Apply`, or `NotLeaderError` if the node is not the leader.
+// The entry may still be committed if `ctx` is done first.
+func (node *Node) Propose(ctx context.Context, Data []byte) (interface{}, error) {
+	if len(Data) == 0 {
+		return nil, fmt.Errorf("cannot propose empty data")
+	}
+	node.mu.Lock()
+	if node.role != RoleLeader {
+		leader := node.leader
+		node.mu.Unlock()
+		return nil, &NotLeaderError{Leader: leader}
+	}
+	entry := Entry{Index: node.lastIndex() + 1, Term: node.term, Data: Data}
+	node.appendLocal(entry)
+	proposal := &proposal{term: node.term, done: make(chan proposalResult, 1)}
+	node.proposals[entry.Index] = proposal
+	node.advanceCommit()
+	for _, notify := range node.replicateNotify {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+	node.mu.Unlock()
+	select {
+	case result := <-proposal.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-node.stop:
+		return nil, ErrStopped
+	}
+}
+
+// Status describes the state of a node.
+type Status struct {
+	ID            string
+	Role          string
+	Term          uint64
+	Leader        string
+	LastIndex     uint64
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	SnapshotIndex uint64
+}
+
+// Status returns the state of the node.
+func (node *Node) Status() Status {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return Status{
+		ID:            node.config.ID,
+		Role:          node.role,
+		Term:          node.term,
+		Leader:        node.leader,
+		LastIndex:     node.lastIndex(),
+		CommitIndex:   node.commitIndex,
+		AppliedIndex:  node.appliedIndex,
+		SnapshotIndex: node.snapshot.Index,
+	}
+}
+
+// RequestVote implements the RPC method `Raft.RequestVote`.
+func (node *Node) RequestVote(ctx context.Context, request *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if request.GetTerm() > node.term {
+		node.becomeFollower(request.GetTerm())
+	}
+	resp := &pb.RequestVoteResponse{Term: node.term}
+	if request.GetTerm() < node.term {
+		return resp, nil
+	}
+	lastIndex := node.lastIndex()
+	lastTerm, _ := node.termAt(lastIndex)
+	upToDate := request.GetLastLogTerm() > lastTerm || (request.GetLastLogTerm() == lastTerm && request.GetLastLogIndex() >= lastIndex)
+	if (len(node.votedFor) == 0 || node.votedFor == request.GetCandidate()) && upToDate {
+		node.votedFor = request.GetCandidate()
+		node.persistState()
+		node.resetElectionTimer()
+		resp.Granted = true
+	}
+	return resp, nil
+}
+
+// AppendEntries implements the RPC method `Raft.AppendEntries`.
+func (node *Node) AppendEntries(ctx context.Context, request *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if request.GetTerm() < node.term {
+		return &pb.AppendEntriesResponse{Term: node.term}, nil
+	}
+	if request.GetTerm() > node.term || node.role != RoleFollower {
+		node.becomeFollower(request.GetTerm())
+	}
+	node.leader = request.GetLeader()
+	node.resetElectionTimer()
+	resp := &pb.AppendEntriesResponse{Term: node.term}
+
+	prevIndex := request.GetPrevLogIndex()
+	if prevIndex > node.lastIndex() {
+		resp.ConflictIndex = node.lastIndex() + 1
+		return resp, nil
+	}
+	if prevIndex >= node.snapshot.Index {
+		if term, _ := node.termAt(prevIndex); term != request.GetPrevLogTerm() {
+			// Skips the whole conflicting term.
+			conflict := prevIndex
+			for conflict > node.snapshot.Index+1 {
+				if previous, _ := node.termAt(conflict - 1); previous != term {
+					break
+				}
+				conflict--
+			}
+			resp.ConflictIndex = conflict
+			return resp, nil
+		}
+	}
+	for i, entry := range request.GetEntries() {
+		if entry.GetIndex() <= node.snapshot.Index {
+			continue
+		}
+		if entry.GetIndex() <= node.lastIndex() {
+			if term, _ := node.termAt(entry.GetIndex()); term == entry.GetTerm() {
+				continue
+			}
+			node.truncateFrom(entry.GetIndex())
+		}
+		newEntries := make([]Entry, 0, len(request.GetEntries())-i)
+		for _, entry := range request.GetEntries()[i:] {
+			newEntries = append(newEntries, Entry{Index: entry.GetIndex(), Term: entry.GetTerm(), Data: entry.GetData()})
+		}
+		node.appendLocal(newEntries...)
+		break
+	}
+	lastNewIndex := prevIndex + uint64(len(request.GetEntries()))
+	if commit := request.GetCommitIndex(); commit > node.commitIndex {
+		if commit > lastNewIndex {
+			commit = lastNewIndex
+		}
+		if commit > node.commitIndex {
+			node.commitIndex = commit
+			node.notifyApply()
+		}
+	}
+	resp.Success = true
+	return resp, nil
+}
+
+// InstallSnapshot implements the RPC method `Raft.InstallSnapshot`.
+func (node *Node) InstallSnapshot(ctx context.Context, request *pb.InstallSnapshotRequest) (*pb.InstallSnapshotResponse, error) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if request.GetTerm() < node.term {
+		return &pb.InstallSnapshotResponse{Term: node.term}, nil
+	}
+	if request.GetTerm() > node.term || node.role != RoleFollower {
+		node.becomeFollower(request.GetTerm())
+	}
+	node.leader = request.GetLeader()
+	node.resetElectionTimer()
+	resp := &pb.InstallSnapshotResponse{Term: node.term}
+
+	if request.GetOffset() == 0 {
+		node.receivedSnapshot = nil
+	}
+	if request.GetOffset() != uint64(len(node.receivedSnapshot)) {
+		return nil, status.Errorf(codes.FailedPrecondition, "unexpected snapshot offset %d, expect %d", request.GetOffset(), len(node.receivedSnapshot))
+	}
+	node.receivedSnapshot = append(node.receivedSnapshot, request.GetData()...)
+	if !request.GetDone() {
+		return resp, nil
+	}
+	data := node.receivedSnapshot
+	node.receivedSnapshot = nil
+	if request.GetLastIndex() <= node.snapshot.Index {
+		return resp, nil
+	}
+	newSnapshot := &snapshot{Index: request.GetLastIndex(), Term: request.GetLastTerm(), Data: data}
+	if err := node.storage.saveSnapshot(newSnapshot); err != nil {
+		log.Fatalf("cannot persist raft snapshot: %v", err)
+	}
+	if term, ok := node.termAt(newSnapshot.Index); ok && term == newSnapshot.Term {
+		node.entries = append([]Entry(nil), node.entries[newSnapshot.Index-node.snapshot.Index:]...)
+	} else {
+		node.entries = nil
+	}
+	node.snapshot = newSnapshot
+	if err := node.storage.rewriteLog(node.entries); err != nil {
+		log.Fatalf("cannot persist raft log: %v", err)
+	}
+	if node.commitIndex < newSnapshot.Index {
+		node.commitIndex = newSnapshot.Index
+	}
+	node.notifyApply()
+	return resp, nil
+}
+
+// GetStatus implements the RPC method `Raft.GetStatus`.
+func (node *Node) GetStatus(ctx context.Context, request *pb.RaftStatusRequest) (*pb.RaftStatusResponse, error) {
+	status := node.Status()
+	return &pb.RaftStatusResponse{
+		ID:            status.ID,
+		Role:          status.Role,
+		Term:          status.Term,
+		Leader:        status.Leader,
+		LastIndex:     status.LastIndex,
+		CommitIndex:   status.CommitIndex,
+		AppliedIndex:  status.AppliedIndex,
+		SnapshotIndex: status.SnapshotIndex,
+		Peers:         node.config.Peers,
+	}, nil
+}
@@ -0,0 +1,228 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/raft"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+)
+
+// counter sums the numbers applied.
+type counter struct {
+	mu    sync.Mutex
+	value int
+}
+
+func (c *counter) Apply(Data []byte) interface{} {
+	delta, _ := strconv.Atoi(string(Data))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += delta
+	return c.value
+}
+
+func (c *counter) Snapshot() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return []byte(strconv.Itoa(c.value)), nil
+}
+
+func (c *counter) Restore(Data []byte) error {
+	value, err := strconv.Atoi(string(Data))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = value
+	return nil
+}
+
+func (c *counter) get() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+type testMember struct {
+	address  string
+	dir      string
+	listener net.Listener
+	server   *grpc.Server
+	node     *raft.Node
+	machine  *counter
+}
+
+type testCluster struct {
+	t       *testing.T
+	peers   []string
+	members []*testMember
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	cluster := &testCluster{t: t}
+	for i := 0; i < size; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cluster.peers = append(cluster.peers, listener.Addr().String())
+		cluster.members = append(cluster.members, &testMember{address: listener.Addr().String(), dir: t.TempDir(), listener: listener})
+	}
+	for i := range cluster.members {
+		cluster.start(i)
+	}
+	t.Cleanup(func() {
+		for i := range cluster.members {
+			cluster.stop(i)
+		}
+	})
+	return cluster
+}
+
+func (cluster *testCluster) start(i int) {
+	member := cluster.members[i]
+	if member.listener == nil {
+		listener, err := net.Listen("tcp", member.address)
+		if err != nil {
+			cluster.t.Fatal(err)
+		}
+		member.listener = listener
+	}
+	member.machine = &counter{}
+	node, err := raft.NewNode(raft.Config{
+		ID:                member.address,
+		Peers:             cluster.peers,
+		Dir:               member.dir,
+		DialOption:        grpc.WithInsecure(),
+		HeartbeatInterval: 20 * time.Millisecond,
+		ElectionTimeout:   150 * time.Millisecond,
+		SnapshotThreshold: 16,
+	}, member.machine)
+	if err != nil {
+		cluster.t.Fatal(err)
+	}
+	member.node = node
+	member.server = grpc.NewServer()
+	member.server.RegisterService(&pb.Raft_ServiceDesc, node)
+	go member.server.Serve(member.listener)
+}
+
+func (cluster *testCluster) stop(i int) {
+	member := cluster.members[i]
+	if member.node == nil {
+		return
+	}
+	member.server.Stop()
+	member.node.Stop()
+	member.node = nil
+	member.listener = nil
+}
+
+func (cluster *testCluster) waitForLeader() int {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for i, member := range cluster.members {
+			if member.node != nil && member.node.Status().Role == raft.RoleLeader {
+				return i
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cluster.t.Fatal("no leader is elected")
+	return -1
+}
+
+// propose retries `data` on the leader until it is applied.
+func (cluster *testCluster) propose(data string) interface{} {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		leader := cluster.members[cluster.waitForLeader()]
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+		value, err := leader.node.Propose(ctx, []byte(data))
+		cancelFn()
+		if err == nil {
+			return value
+		}
+		notLeader := &raft.NotLeaderError{}
+		if !errors.As(err, &notLeader) && !errors.Is(err, raft.ErrEntryLost) && !errors.Is(err, context.DeadlineExceeded) {
+			cluster.t.Fatal(err)
+		}
+	}
+	cluster.t.Fatalf("cannot propose %s", data)
+	return nil
+}
+
+func (cluster *testCluster) waitForValue(value int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		converged := true
+		for _, member := range cluster.members {
+			if member.node != nil && member.machine.get() != value {
+				converged = false
+			}
+		}
+		if converged {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cluster.t.Fatalf("members do not converge to %d", value)
+}
+
+func TestReplicate(t *testing.T) {
+	cluster := newTestCluster(t, 3)
+	for i := 1; i <= 10; i++ {
+		if value := cluster.propose("1"); value != i {
+			t.Fatalf("expect %d, got %v", i, value)
+		}
+	}
+	cluster.waitForValue(10)
+
+	follower := (cluster.waitForLeader() + 1) % 3
+	_, err := cluster.members[follower].node.Propose(context.Background(), []byte("1"))
+	notLeader := &raft.NotLeaderError{}
+	if !errors.As(err, &notLeader) || notLeader.Leader != cluster.members[cluster.waitForLeader()].address {
+		t.Errorf("expect followers to report the leader, got %v", err)
+	}
+}
+
+func TestLeaderFailure(t *testing.T) {
+	cluster := newTestCluster(t, 3)
+	cluster.propose("1")
+	leader := cluster.waitForLeader()
+	cluster.stop(leader)
+	for i := 0; i < 40; i++ {
+		cluster.propose("1")
+	}
+	cluster.waitForValue(41)
+
+	// The restarted member catches up with a snapshot.
+	cluster.start(leader)
+	cluster.waitForValue(41)
+	if status := cluster.members[leader].node.Status(); status.SnapshotIndex == 0 {
+		t.Errorf("expect the log to be compacted, got %+v", status)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	cluster := newTestCluster(t, 3)
+	for i := 0; i < 20; i++ {
+		cluster.propose("2")
+	}
+	for i := range cluster.members {
+		cluster.stop(i)
+	}
+	for i := range cluster.members {
+		cluster.start(i)
+	}
+	cluster.propose("1")
+	cluster.waitForValue(41)
+}
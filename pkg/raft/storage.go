@@ -0,0 +1,187 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+)
+
+const (
+	stateFileName    = "state.json"
+	snapshotFileName = "snapshot.json"
+	logFileName      = "log.jsonl"
+)
+
+// hardState is the state must be persisted before responding to RPCs.
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// snapshot is the state machine after applying the entries up to `Index`.
+type snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data"`
+}
+
+// storage persists the state of a node in a folder.
+// The log is stored as JSON lines, appended on proposals and rewritten on truncations and compactions.
+type storage struct {
+	dir     string
+	logFile *os.File
+}
+
+// writeFileSync atomically replaces `name` with `data`.
+func writeFileSync(name string, data []byte) error {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
+
+// openStorage loads the persisted state from `dir`.
+func openStorage(dir string) (*storage, hardState, *snapshot, []Entry, error) {
+	state := hardState{}
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		return nil, state, nil, nil, err
+	}
+	if data, err := os.ReadFile(path.Join(dir, stateFileName)); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("corrupted %s: %v", stateFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	var loadedSnapshot *snapshot
+	if data, err := os.ReadFile(path.Join(dir, snapshotFileName)); err == nil {
+		loadedSnapshot = &snapshot{}
+		if err := json.Unmarshal(data, loadedSnapshot); err != nil {
+			return nil, state, nil, nil, fmt.Errorf("corrupted %s: %v", snapshotFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	entries, err := readLog(path.Join(dir, logFileName))
+	if err != nil {
+		return nil, state, nil, nil, err
+	}
+	if loadedSnapshot != nil {
+		start := 0
+		for start < len(entries) && entries[start].Index <= loadedSnapshot.Index {
+			start++
+		}
+		entries = entries[start:]
+	}
+	s := &storage{dir: dir}
+	// Rewrites the log to drop a partially written tail.
+	if err := s.rewriteLog(entries); err != nil {
+		return nil, state, nil, nil, err
+	}
+	return s, state, loadedSnapshot, entries, nil
+}
+
+// readLog returns the entries in the log file, a partially written last line is ignored.
+func readLog(name string) ([]Entry, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		if len(entries) > 0 && entry.Index != entries[len(entries)-1].Index+1 {
+			return nil, fmt.Errorf("corrupted log: entry %d follows %d", entry.Index, entries[len(entries)-1].Index)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (s *storage) saveState(state hardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path.Join(s.dir, stateFileName), data)
+}
+
+func (s *storage) saveSnapshot(snapshot *snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path.Join(s.dir, snapshotFileName), data)
+}
+
+func (s *storage) appendEntries(entries []Entry) error {
+	buffer := []byte{}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buffer = append(append(buffer, data...), '\n')
+	}
+	if _, err := s.logFile.Write(buffer); err != nil {
+		return err
+	}
+	return s.logFile.Sync()
+}
+
+// rewriteLog replaces the log file with `entries`.
+func (s *storage) rewriteLog(entries []Entry) error {
+	if s.logFile != nil {
+		s.logFile.Close()
+	}
+	buffer := []byte{}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buffer = append(append(buffer, data...), '\n')
+	}
+	name := path.Join(s.dir, logFileName)
+	if err := writeFileSync(name, buffer); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.logFile = file
+	return nil
+}
+
+func (s *storage) close() error {
+	if s.logFile == nil {
+		return nil
+	}
+	return s.logFile.Close()
+}
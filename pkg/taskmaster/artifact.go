@@ -0,0 +1,217 @@
+package taskmaster
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// artifactFolder stores the artifacts in the snapshot folder.
+const artifactFolder = "artifacts"
+
+// Artifact is a file produced by a task and uploaded by its worker.
+type Artifact struct {
+	// Name is the path of the file relative to the working directory of the task.
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 hash of the content, which addresses it in the artifact store.
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// WithArtifacts lets workers attach the files produced by tasks, up to `MaxSize` bytes each.
+// Artifacts are stored in the `artifacts` folder under the snapshot folder and removed along with their tasks.
+// Like the blob store, artifacts are only stored on the local disk, so it cannot be used with `WithCluster` or replication.
+func WithArtifacts(MaxSize int64) ServerOption {
+	return func(server *ServerImpl) {
+		server.artifactMaxSize = MaxSize
+	}
+}
+
+// startArtifactStore opens the artifact store and starts removing the artifacts of removed tasks.
+func (server *ServerImpl) startArtifactStore() error {
+	if server.replicated() {
+		return fmt.Errorf("artifacts cannot be used with cluster or replication")
+	}
+	store, err := NewBlobStore(path.Join(server.snapshotFolder, artifactFolder))
+	if err != nil {
+		return err
+	}
+	server.artifacts = store
+	go server.collectPeriodically(store, server.referencedArtifacts)
+	return nil
+}
+
+// referencedArtifacts returns the hashes of the artifacts attached to any task.
+func (server *ServerImpl) referencedArtifacts() map[string]bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	referenced := make(map[string]bool)
+	for _, scheduler := range server.schedulerGroup {
+		for _, task := range scheduler.Tasks() {
+			for _, artifact := range task.Artifacts {
+				referenced[artifact.Hash] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// ValidateArtifactPaths returns error if any of `Paths` is not a valid glob.
+func ValidateArtifactPaths(Paths []string) error {
+	for _, pattern := range Paths {
+		if len(pattern) == 0 {
+			return fmt.Errorf("artifact paths must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact path `%s`: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// validateArtifactName returns error if `name` is not a clean relative path within the working directory.
+func validateArtifactName(name string) error {
+	if len(name) == 0 || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid artifact name `%s`, expect a relative path", name)
+	}
+	return nil
+}
+
+// attachAt attaches `artifact` to the leased task `ID`, replacing the artifact of the same name.
+func (master *Scheduler) attachAt(ID string, artifact Artifact, now time.Time) error {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	task, ok := master.ownedTasks[ID]
+	if !ok || task.StateAt(now) != StateLeased {
+		return fmt.Errorf("no leased task with ID `%s`", ID)
+	}
+	artifacts := make([]Artifact, 0, len(task.Artifacts)+1)
+	for _, existing := range task.Artifacts {
+		if existing.Name != artifact.Name {
+			artifacts = append(artifacts, existing)
+		}
+	}
+	task.Artifacts = append(artifacts, artifact)
+	master.putTask(task)
+	return nil
+}
+
+// UploadArtifact implements the RPC method `TaskMaster.UploadArtifact`.
+// Only the lease holder of the task can attach artifacts to it.
+func (server *ServerImpl) UploadArtifact(stream pb.TaskMaster_UploadArtifactServer) error {
+	if server.artifacts == nil {
+		return status.Errorf(codes.FailedPrecondition, "artifacts are disabled")
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if err := validateArtifactName(first.GetName()); err != nil {
+		return status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	scheduler, err := server.getScheduler(first.GetGroup())
+	if err != nil {
+		return err
+	}
+	task, exists := scheduler.GetTask(first.GetID())
+	if !exists || task.StateAt(server.clock.Now()) != StateLeased {
+		return status.Errorf(codes.FailedPrecondition, "no leased task with ID `%s`", first.GetID())
+	}
+	if caller := CallerIdentity(stream.Context()); task.LeaseHolder != caller {
+		err := status.Errorf(codes.PermissionDenied, "`%s` does not hold the lease of task `%s`", caller, first.GetID())
+		server.auditDenial(stream.Context(), command{Op: opAttach, Group: first.GetGroup(), ID: first.GetID()}, err)
+		return err
+	}
+	reader := &artifactReader{stream: stream, pending: first.GetData()}
+	hash, size, err := server.artifacts.Write(reader, server.artifactMaxSize)
+	if errors.Is(err, errBlobTooLarge) {
+		return status.Errorf(codes.ResourceExhausted, "artifact `%s` is larger than %d bytes", first.GetName(), server.artifactMaxSize)
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "cannot store artifact: %v", err)
+	}
+	artifact := Artifact{Name: first.GetName(), Hash: hash, Size: size}
+	if result := server.execute(stream.Context(), command{Op: opAttach, Group: first.GetGroup(), ID: first.GetID(), Artifact: &artifact}); result.err != nil {
+		return result.err
+	}
+	return stream.SendAndClose(&pb.UploadArtifactResponse{Artifact: &pb.Artifact{Name: artifact.Name, Hash: artifact.Hash, Size: artifact.Size}})
+}
+
+// artifactReader reads the data of the chunks of an upload stream.
+type artifactReader struct {
+	stream  pb.TaskMaster_UploadArtifactServer
+	pending []byte
+}
+
+func (reader *artifactReader) Read(buffer []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		chunk, err := reader.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		reader.pending = chunk.GetData()
+	}
+	n := copy(buffer, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// GetArtifact implements the RPC method `TaskMaster.GetArtifact`.
+// Like `GetBlob`, only the lease holder of the task and the managers of its group can read its artifacts.
+func (server *ServerImpl) GetArtifact(request *pb.GetArtifactRequest, stream pb.TaskMaster_GetArtifactServer) error {
+	if server.artifacts == nil {
+		return status.Errorf(codes.FailedPrecondition, "artifacts are disabled")
+	}
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return err
+	}
+	task, exists := scheduler.GetTask(request.GetID())
+	if !exists {
+		return status.Errorf(codes.NotFound, "task `%s` not found", request.GetID())
+	}
+	if task.LeaseHolder != CallerIdentity(stream.Context()) {
+		if err := server.authorizeAdmin(stream.Context(), command{Op: opGetArtifact, Group: request.GetGroup(), ID: request.GetID()}); err != nil {
+			return err
+		}
+	}
+	var artifact *Artifact
+	for i := range task.Artifacts {
+		if task.Artifacts[i].Name == request.GetName() {
+			artifact = &task.Artifacts[i]
+		}
+	}
+	if artifact == nil {
+		return status.Errorf(codes.NotFound, "task `%s` has no artifact `%s`", request.GetID(), request.GetName())
+	}
+	file, err := server.artifacts.Open(artifact.Hash)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot open artifact: %v", err)
+	}
+	defer file.Close()
+	buffer := make([]byte, blobChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if err := stream.Send(&pb.GetArtifactResponse{Data: buffer[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot read artifact: %v", err)
+		}
+	}
+}
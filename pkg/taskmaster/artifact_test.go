@@ -0,0 +1,139 @@
+package taskmaster_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func uploadTestArtifact(ctx context.Context, client pb.TaskMasterClient, ID string, name string, data []byte) (*pb.UploadArtifactResponse, error) {
+	stream, err := client.UploadArtifact(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&pb.UploadArtifactRequest{Group: "default", ID: ID, Name: name}); err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > 3 {
+			n = 3
+		}
+		if err := stream.Send(&pb.UploadArtifactRequest{Data: data[:n]}); err != nil {
+			break
+		}
+		data = data[n:]
+	}
+	return stream.CloseAndRecv()
+}
+
+func readTestArtifact(ctx context.Context, client pb.TaskMasterClient, ID string, name string) ([]byte, error) {
+	stream, err := client.GetArtifact(ctx, &pb.GetArtifactRequest{Group: "default", ID: ID, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	data := []byte{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.GetData()...)
+	}
+}
+
+func TestServerArtifacts(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t, taskmaster.WithArtifacts(16))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	client := dialTestServer(t, address)
+
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data", ArtifactPaths: []string{"[invalid"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect invalid artifact paths to be rejected, got %v", err)
+	}
+	inserted, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data", ArtifactPaths: []string{"out/*.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploadTestArtifact(ctx, client, inserted.GetID(), "out/result.txt", []byte("result")); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect uploads to pending tasks to be rejected, got %v", err)
+	}
+	leased, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := leased.GetArtifactPaths(); len(paths) != 1 || paths[0] != "out/*.txt" {
+		t.Errorf("unexpected artifact paths %v", paths)
+	}
+	if _, err := uploadTestArtifact(ctx, client, leased.GetID(), "../escape.txt", []byte("result")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect names outside of the working directory to be rejected, got %v", err)
+	}
+	if _, err := uploadTestArtifact(ctx, client, leased.GetID(), "out/large.txt", bytes.Repeat([]byte("x"), 17)); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expect artifacts over the size limit to be rejected, got %v", err)
+	}
+	uploaded, err := uploadTestArtifact(ctx, client, leased.GetID(), "out/result.txt", []byte("result"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.GetArtifact().GetSize() != 6 {
+		t.Errorf("unexpected artifact %v", uploaded.GetArtifact())
+	}
+	if _, err := client.Finish(ctx, &pb.FinishRequest{Group: "default", ID: leased.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := client.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: leased.GetID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifacts := task.GetTask().GetArtifacts(); len(artifacts) != 1 || artifacts[0].GetName() != "out/result.txt" {
+		t.Fatalf("unexpected artifacts %v", artifacts)
+	}
+	data, err := readTestArtifact(ctx, client, leased.GetID(), "out/result.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "result" {
+		t.Errorf("unexpected artifact content %q", data)
+	}
+}
+
+func TestArtifactAuthorization(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t, taskmaster.WithArtifacts(16), taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"*": {"admin"}}))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	holder, other := dialTestServer(t, address), dialTestServer(t, address)
+
+	if _, err := server.Insert(contextWithSubject("admin"), &pb.InsertRequest{Group: "default", Data: "data", ArtifactPaths: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+	leased, err := holder.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploadTestArtifact(ctx, other, leased.GetID(), "result.txt", []byte("forged")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expect uploads by callers other than the lease holder to be rejected, got %v", err)
+	}
+	if _, err := uploadTestArtifact(ctx, holder, leased.GetID(), "result.txt", []byte("result")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := readTestArtifact(ctx, holder, leased.GetID(), "result.txt"); err != nil || string(data) != "result" {
+		t.Errorf("expect the lease holder to read the artifact, got %q, %v", data, err)
+	}
+	if _, err := readTestArtifact(ctx, other, leased.GetID(), "result.txt"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expect callers other than the lease holder and the managers to be rejected, got %v", err)
+	}
+}
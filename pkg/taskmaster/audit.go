@@ -0,0 +1,240 @@
+package taskmaster
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAuditFileSize is the size of the audit file before it is rotated if not specified.
+	DefaultAuditFileSize = 64 << 20
+	// DefaultAuditFiles is the number of rotated audit files kept if not specified.
+	DefaultAuditFiles = 10
+)
+
+// AuditRecord is a line of the audit log.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Caller is the subject of the client certificate of the caller if authenticated, otherwise its peer address.
+	Caller string `json:"caller"`
+	Op     string `json:"op"`
+	Group  string `json:"group,omitempty"`
+	ID     string `json:"id,omitempty"`
+	// DataHash is the hex encoded SHA-256 hash of the payload of the inserted task.
+	DataHash string `json:"data_hash,omitempty"`
+	// Error is the error of the mutation or the reason the request is denied, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditLog appends the mutations of a server to a JSON-lines file.
+// Once the file exceeds its size limit, it is rotated to `<path>.1`, shifting older files to `<path>.2` and so on.
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+// OpenAuditLog opens the audit log at `Path` for appending.
+// The file is rotated once it exceeds `MaxSize` bytes, keeping at most `MaxFiles` rotated files.
+func OpenAuditLog(Path string, MaxSize int64, MaxFiles int) (*AuditLog, error) {
+	if MaxSize <= 0 {
+		MaxSize = DefaultAuditFileSize
+	}
+	if MaxFiles <= 0 {
+		MaxFiles = DefaultAuditFiles
+	}
+	auditLog := &AuditLog{path: Path, maxSize: MaxSize, maxFiles: MaxFiles}
+	if err := auditLog.open(); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (auditLog *AuditLog) open() error {
+	file, err := os.OpenFile(auditLog.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	auditLog.file = file
+	auditLog.size = info.Size()
+	return nil
+}
+
+func (auditLog *AuditLog) rotatedFile(index int) string {
+	return fmt.Sprintf("%s.%d", auditLog.path, index)
+}
+
+// rotate must be called with `mu` held.
+func (auditLog *AuditLog) rotate() error {
+	if err := auditLog.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(auditLog.rotatedFile(auditLog.maxFiles))
+	for i := auditLog.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(auditLog.rotatedFile(i), auditLog.rotatedFile(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(auditLog.path, auditLog.rotatedFile(1)); err != nil {
+		return err
+	}
+	return auditLog.open()
+}
+
+// Append writes `Record` to the log, the file is synced before returning.
+func (auditLog *AuditLog) Append(Record AuditRecord) error {
+	line, err := json.Marshal(Record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if auditLog.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	if auditLog.size > 0 && auditLog.size+int64(len(line)) > auditLog.maxSize {
+		if err := auditLog.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := auditLog.file.Write(line)
+	auditLog.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return auditLog.file.Sync()
+}
+
+// Close syncs and closes the audit file, later records are rejected.
+func (auditLog *AuditLog) Close() error {
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if auditLog.file == nil {
+		return nil
+	}
+	err := auditLog.file.Sync()
+	if closeErr := auditLog.file.Close(); err == nil {
+		err = closeErr
+	}
+	auditLog.file = nil
+	return err
+}
+
+// ReadAuditLog calls `Visit` with the records of the audit log at `Path` and its rotated files, oldest first.
+// Stops at the first error returned by `Visit`.
+func ReadAuditLog(Path string, Visit func(AuditRecord) error) error {
+	files := []string{}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", Path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append([]string{name}, files...)
+	}
+	files = append(files, Path)
+	for _, name := range files {
+		if err := readAuditFile(name, Visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAuditFile(name string, visit func(AuditRecord) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		record := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		if err := visit(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Operations recorded in the audit log only if denied, in addition to the operations of commands.
+const (
+	opGetGroupSnapshot  = "get_group_snapshot"
+	opExport            = "export"
+	opImport            = "import"
+	opGetBlob           = "get_blob"
+	opGetArtifact       = "get_artifact"
+	opWatch             = "watch"
+	opFollow            = "follow"
+	opPromote           = "promote"
+	opReplicationStatus = "get_replication_status"
+)
+
+// WithAuditLog records every mutation of the server and every denied request,
+// along with the identity of its caller, in `Log`. `Log` is closed once the server is closed.
+func WithAuditLog(Log *AuditLog) ServerOption {
+	return func(server *ServerImpl) {
+		server.audit = Log
+	}
+}
+
+// recordAudit appends `cmd` to the audit log if enabled. Failures are logged but do not fail the mutation.
+func (server *ServerImpl) recordAudit(ctx context.Context, cmd *command, result *commandResult) {
+	if server.audit == nil || cmd.Op == opPrune {
+		return
+	}
+	// Empty queues are not worth recording.
+	if cmd.Op == opLease && result.err != nil {
+		return
+	}
+	record := AuditRecord{
+		Time:   cmd.Time,
+		Caller: CallerIdentity(ctx),
+		Op:     cmd.Op,
+		Group:  cmd.Group,
+		ID:     cmd.ID,
+	}
+	if cmd.Op == opLease && result.task != nil {
+		record.ID = result.task.ID
+	}
+	if cmd.Op == opInsert {
+		// Offloaded payloads are already addressed by their hashes.
+		record.DataHash = cmd.Blob
+		if len(record.DataHash) == 0 {
+			digest := sha256.Sum256([]byte(cmd.Data))
+			record.DataHash = hex.EncodeToString(digest[:])
+		}
+	}
+	if result.err != nil {
+		record.Error = result.err.Error()
+	}
+	if err := server.audit.Append(record); err != nil {
+		log.Printf("cannot write audit log: %v", err)
+	}
+}
+
+// auditDenial records `request` denied with `err` in the audit log if enabled.
+func (server *ServerImpl) auditDenial(ctx context.Context, request command, err error) {
+	request.Time = server.clock.Now()
+	server.recordAudit(ctx, &request, &commandResult{err: err})
+}
@@ -0,0 +1,145 @@
+package taskmaster_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+)
+
+func readAuditLog(t *testing.T, name string) []taskmaster.AuditRecord {
+	records := []taskmaster.AuditRecord{}
+	if err := taskmaster.ReadAuditLog(name, func(record taskmaster.AuditRecord) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	name := path.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := taskmaster.OpenAuditLog(name, 256, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	for i := 0; i < 20; i++ {
+		if err := auditLog.Append(taskmaster.AuditRecord{Time: time.Now(), Caller: "tester", Op: "insert", ID: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expect at most 2 rotated files, got %v", err)
+	}
+	records := readAuditLog(t, name)
+	if len(records) == 0 || len(records) >= 20 {
+		t.Fatalf("expect old records to be removed, got %d records", len(records))
+	}
+	for i, record := range records {
+		if expected := fmt.Sprint(20 - len(records) + i); record.ID != expected {
+			t.Errorf("expect record %s, got %s", expected, record.ID)
+		}
+	}
+}
+
+func TestServerAudit(t *testing.T) {
+	ctx := context.Background()
+	name := path.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := taskmaster.OpenAuditLog(name, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	server := createTestServer(t, taskmaster.WithAuditLog(auditLog))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	resp, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "rm -rf /"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelTask(ctx, &pb.CancelTaskRequest{Group: "default", ID: resp.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelTask(ctx, &pb.CancelTaskRequest{Group: "default", ID: "unknown"}); err == nil {
+		t.Fatal("expect cancelling unknown tasks to fail")
+	}
+
+	records := readAuditLog(t, name)
+	if len(records) != 3 {
+		t.Fatalf("expect 3 records, got %v", records)
+	}
+	digest := sha256.Sum256([]byte("rm -rf /"))
+	if insert := records[0]; insert.Op != "insert" || insert.ID != resp.GetID() || insert.DataHash != hex.EncodeToString(digest[:]) || len(insert.Caller) == 0 {
+		t.Errorf("unexpected insert record %+v", insert)
+	}
+	if cancel := records[1]; cancel.Op != "cancel" || cancel.ID != resp.GetID() || len(cancel.Error) > 0 {
+		t.Errorf("unexpected cancel record %+v", cancel)
+	}
+	if failed := records[2]; failed.Op != "cancel" || len(failed.Error) == 0 {
+		t.Errorf("expect failed mutations to be recorded, got %+v", failed)
+	}
+
+	server.Close()
+	if err := auditLog.Append(taskmaster.AuditRecord{Time: time.Now(), Op: "insert"}); err == nil {
+		t.Error("expect the audit log to be closed with the server")
+	}
+}
+
+func TestAuditDenials(t *testing.T) {
+	name := path.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := taskmaster.OpenAuditLog(name, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	server := createTestServer(t, taskmaster.WithAuditLog(auditLog), taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"build": {"ci"}}))
+	defer server.Close()
+	ctx := contextWithSubject("intruder")
+
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "build", Data: "test"}); err == nil {
+		t.Error("expect the insert to be denied")
+	}
+	if _, err := server.PauseGroup(ctx, &pb.PauseGroupRequest{Group: "build"}); err == nil {
+		t.Error("expect the pause to be denied")
+	}
+	if _, err := server.CancelTask(ctx, &pb.CancelTaskRequest{Group: "build", ID: "task"}); err == nil {
+		t.Error("expect the cancellation to be denied")
+	}
+	if _, err := server.RestoreGroup(ctx, &pb.RestoreGroupRequest{Group: "build"}); err == nil {
+		t.Error("expect the restore to be denied")
+	}
+	if _, err := server.DeleteGroup(ctx, &pb.DeleteGroupRequest{Group: "build"}); err == nil {
+		t.Error("expect the deletion to be denied")
+	}
+
+	records := readAuditLog(t, name)
+	expected := []string{"insert", "pause", "cancel", "restore_group", "delete_group"}
+	if len(records) != len(expected) {
+		t.Fatalf("expect %d records, got %v", len(expected), records)
+	}
+	for i, record := range records {
+		if record.Op != expected[i] || record.Group != "build" || record.Caller != "intruder" || len(record.Error) == 0 {
+			t.Errorf("expect the denied %s to be recorded, got %+v", expected[i], record)
+		}
+	}
+	if records[2].ID != "task" {
+		t.Errorf("expect the ID of the denied cancellation to be recorded, got %+v", records[2])
+	}
+}
@@ -0,0 +1,139 @@
+package taskmaster
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// CallerMetadataKey is the metadata key of the identity of the caller forwarded by a router, see `TrustedRouterInterceptor`.
+const CallerMetadataKey = "x-taskmaster-caller"
+
+// InsertPolicy maps a group name to the client identities allowed to insert into it.
+//
+// An identity is the subject common name of a verified client certificate.
+// The group `*` applies to groups without an explicit entry, and the identity `*` matches any authenticated client.
+type InsertPolicy map[string][]string
+
+// LoadInsertPolicy loads an insert policy from a JSON file.
+func LoadInsertPolicy(Filename string) (InsertPolicy, error) {
+	data, err := os.ReadFile(Filename)
+	if err != nil {
+		return nil, err
+	}
+	policy := InsertPolicy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Allowed returns true if the caller is allowed to insert into `group`.
+// A nil policy allows everyone.
+func (policy InsertPolicy) Allowed(ctx context.Context, group string) bool {
+	if policy == nil {
+		return true
+	}
+	subject, ok := authenticatedSubject(ctx)
+	if !ok {
+		return false
+	}
+	identities, exists := policy[group]
+	if !exists {
+		identities = policy["*"]
+	}
+	for _, identity := range identities {
+		if identity == "*" || identity == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedCallerKey is the context key of the caller forwarded by a trusted router.
+type forwardedCallerKey struct{}
+
+// authenticatedSubject returns the subject common name of the verified client certificate of the caller,
+// or the caller forwarded by a trusted router.
+func authenticatedSubject(ctx context.Context) (string, bool) {
+	if caller, ok := ctx.Value(forwardedCallerKey{}).(string); ok {
+		return caller, true
+	}
+	return certificateSubject(ctx)
+}
+
+// certificateSubject returns the subject common name of the verified client certificate of the peer.
+func certificateSubject(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return "", false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// CallerIdentity returns the identity of the caller: the subject of its client certificate if authenticated,
+// otherwise its peer address.
+func CallerIdentity(ctx context.Context) string {
+	if subject, ok := authenticatedSubject(ctx); ok {
+		return subject
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// forwardedContext returns `ctx` identifying the caller forwarded with `CallerMetadataKey`,
+// if the peer is authenticated as one of `routers`. The metadata of other peers is ignored.
+func forwardedContext(ctx context.Context, routers []string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	callers := md.Get(CallerMetadataKey)
+	if len(callers) != 1 || len(callers[0]) == 0 {
+		return ctx
+	}
+	subject, ok := certificateSubject(ctx)
+	if !ok {
+		return ctx
+	}
+	for _, router := range routers {
+		if router == subject {
+			return context.WithValue(ctx, forwardedCallerKey{}, callers[0])
+		}
+	}
+	return ctx
+}
+
+// TrustedRouterInterceptor identifies the calls forwarded by `Routers` as their original callers, so insert policies,
+// lease holders and audit logs apply to the callers instead of the routers.
+// `Routers` are the subject common names of the client certificates of the routers, see `RouterConfig`.
+func TrustedRouterInterceptor(Routers []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(forwardedContext(ctx, Routers), req)
+	}
+}
+
+// TrustedRouterStreamInterceptor is the same as `TrustedRouterInterceptor` for streaming calls.
+func TrustedRouterStreamInterceptor(Routers []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: stream, ctx: forwardedContext(stream.Context(), Routers)})
+	}
+}
+
+// contextServerStream overrides the context of a server stream.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *contextServerStream) Context() context.Context {
+	return stream.ctx
+}
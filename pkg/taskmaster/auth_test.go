@@ -0,0 +1,130 @@
+package taskmaster_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func contextWithSubject(subject string) context.Context {
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: subject}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{certificate}},
+		}},
+	})
+}
+
+func TestInsertPolicy(t *testing.T) {
+	policy := taskmaster.InsertPolicy{
+		"build": {"ci"},
+		"*":     {"admin"},
+	}
+	testCases := []struct {
+		ctx     context.Context
+		group   string
+		allowed bool
+	}{
+		{contextWithSubject("ci"), "build", true},
+		{contextWithSubject("admin"), "build", false},
+		{contextWithSubject("admin"), "default", true},
+		{contextWithSubject("ci"), "default", false},
+		{peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}}), "build", false},
+	}
+	for i, testCase := range testCases {
+		if allowed := policy.Allowed(testCase.ctx, testCase.group); allowed != testCase.allowed {
+			t.Errorf("case %d: expect %v, got %v", i, testCase.allowed, allowed)
+		}
+	}
+	if !taskmaster.InsertPolicy(nil).Allowed(context.Background(), "build") {
+		t.Error("nil policy should allow everyone")
+	}
+	if identity := taskmaster.CallerIdentity(contextWithSubject("ci")); identity != "ci" {
+		t.Errorf("unexpected identity: %s", identity)
+	}
+}
+
+func TestTaskPayloadAuthorization(t *testing.T) {
+	server := createTestServer(t, taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"*": {"admin"}}))
+	defer server.Close()
+	admin := contextWithSubject("admin")
+	for i := 0; i < 2; i++ {
+		if _, err := server.Insert(admin, &pb.InsertRequest{Group: "default", Data: "secret"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	worker := contextWithSubject("worker")
+	leased, err := server.Query(worker, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range []struct {
+		ctx     context.Context
+		visible bool
+	}{
+		{admin, true},
+		{worker, true},
+		{contextWithSubject("other"), false},
+	} {
+		resp, err := server.GetTask(testCase.ctx, &pb.GetTaskRequest{Group: "default", ID: leased.GetID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visible := resp.GetTask().GetData() == "secret"; visible != testCase.visible {
+			t.Errorf("`%s` reading task: expect the data to be visible=%v", taskmaster.CallerIdentity(testCase.ctx), testCase.visible)
+		}
+		list, err := server.ListTasks(testCase.ctx, &pb.ListTasksRequest{Group: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		visible := 0
+		for _, task := range list.GetTasks() {
+			if task.GetData() == "secret" {
+				visible++
+			}
+		}
+		// The worker only holds the lease of one of the tasks.
+		expected := map[bool]int{true: 2, false: 0}[testCase.visible]
+		if testCase.ctx == worker {
+			expected = 1
+		}
+		if visible != expected {
+			t.Errorf("`%s` listing tasks: expect %d tasks with data, got %d", taskmaster.CallerIdentity(testCase.ctx), expected, visible)
+		}
+	}
+}
+
+func TestTrustedRouterInterceptor(t *testing.T) {
+	interceptor := taskmaster.TrustedRouterInterceptor([]string{"router"})
+	identity := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return taskmaster.CallerIdentity(ctx), nil
+	}
+	forwarded := metadata.Pairs(taskmaster.CallerMetadataKey, "ci")
+	testCases := []struct {
+		ctx      context.Context
+		expected string
+	}{
+		{metadata.NewIncomingContext(contextWithSubject("router"), forwarded), "ci"},
+		{metadata.NewIncomingContext(contextWithSubject("mallory"), forwarded), "mallory"},
+		{contextWithSubject("router"), "router"},
+	}
+	for i, testCase := range testCases {
+		caller, err := interceptor(testCase.ctx, nil, &grpc.UnaryServerInfo{}, identity)
+		if err != nil || caller != testCase.expected {
+			t.Errorf("case %d: expect `%s`, got `%v`, %v", i, testCase.expected, caller, err)
+		}
+	}
+}
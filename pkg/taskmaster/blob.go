@@ -0,0 +1,278 @@
+package taskmaster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// blobFolder stores the blobs in the snapshot folder.
+	blobFolder = "blobs"
+	// blobChunkSize is the size of the chunks streamed by `GetBlob`.
+	blobChunkSize = 64 << 10
+	// blobGracePeriod keeps unreferenced blobs for a while, so blobs written by ongoing inserts are not removed.
+	blobGracePeriod = time.Minute
+)
+
+// BlobStore stores payloads on the disk addressed by their SHA-256 hashes.
+type BlobStore struct {
+	folder string
+}
+
+// NewBlobStore creates a blob store in `Folder`.
+func NewBlobStore(Folder string) (*BlobStore, error) {
+	if err := os.MkdirAll(Folder, fs.ModePerm); err != nil {
+		return nil, err
+	}
+	return &BlobStore{folder: Folder}, nil
+}
+
+// validateBlobHash returns error if `hash` is not a hex encoded SHA-256 hash.
+func validateBlobHash(hash string) error {
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("invalid blob hash `%s`", hash)
+	}
+	return nil
+}
+
+func (store *BlobStore) blobFile(hash string) string {
+	return path.Join(store.folder, hash)
+}
+
+// Put stores `Data` and returns its hash. Storing the same content again only refreshes the blob.
+func (store *BlobStore) Put(Data []byte) (string, error) {
+	digest := sha256.Sum256(Data)
+	hash := hex.EncodeToString(digest[:])
+	now := time.Now()
+	if err := os.Chtimes(store.blobFile(hash), now, now); err == nil {
+		return hash, nil
+	}
+	file, err := os.CreateTemp(store.folder, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(Data); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(file.Name(), store.blobFile(hash))
+}
+
+// errBlobTooLarge is returned by `Write` once the content exceeds the size limit.
+var errBlobTooLarge = errors.New("content exceeds the size limit")
+
+// Write stores the content read from `Reader` and returns its hash and size.
+// Returns `errBlobTooLarge` if `MaxSize` is positive and the content is larger.
+func (store *BlobStore) Write(Reader io.Reader, MaxSize int64) (string, int64, error) {
+	file, err := os.CreateTemp(store.folder, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(file.Name())
+	hasher := sha256.New()
+	source := Reader
+	if MaxSize > 0 {
+		source = io.LimitReader(Reader, MaxSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(file, hasher), source)
+	if err != nil {
+		file.Close()
+		return "", 0, err
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, err
+	}
+	if MaxSize > 0 && size > MaxSize {
+		return "", 0, errBlobTooLarge
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	return hash, size, os.Rename(file.Name(), store.blobFile(hash))
+}
+
+// Open opens the blob with `Hash`.
+func (store *BlobStore) Open(Hash string) (*os.File, error) {
+	if err := validateBlobHash(Hash); err != nil {
+		return nil, err
+	}
+	return os.Open(store.blobFile(Hash))
+}
+
+// Get returns the content of the blob with `Hash`.
+func (store *BlobStore) Get(Hash string) ([]byte, error) {
+	if err := validateBlobHash(Hash); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(store.blobFile(Hash))
+}
+
+// Collect removes the blobs not in `Referenced` and not written within `blobGracePeriod`.
+// Returns the number of removed blobs.
+func (store *BlobStore) Collect(Referenced map[string]bool) (int, error) {
+	entries, err := os.ReadDir(store.folder)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if Referenced[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < blobGracePeriod {
+			continue
+		}
+		if err := os.Remove(store.blobFile(entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// WithBlobStore stores the payloads larger than `Threshold` bytes in the `blobs` folder under the snapshot folder,
+// tasks reference them by hash and workers fetch them with `GetBlob`.
+// Blobs are removed once no active or retained finished task references them.
+// Blobs are only stored on the local disk, so it cannot be used with `WithCluster` or replication, and the server cannot be followed.
+func WithBlobStore(Threshold int) ServerOption {
+	return func(server *ServerImpl) {
+		server.blobThreshold = Threshold
+	}
+}
+
+// startBlobStore opens the blob store and starts removing unreferenced blobs.
+func (server *ServerImpl) startBlobStore() error {
+	if server.replicated() {
+		return fmt.Errorf("blob store cannot be used with cluster or replication")
+	}
+	store, err := NewBlobStore(path.Join(server.snapshotFolder, blobFolder))
+	if err != nil {
+		return err
+	}
+	server.blobs = store
+	go server.collectPeriodically(store, server.referencedBlobs)
+	return nil
+}
+
+// collectPeriodically removes the blobs of `store` not in `referenced` until the server is closed.
+func (server *ServerImpl) collectPeriodically(store *BlobStore, referenced func() map[string]bool) {
+	ticker := time.NewTicker(server.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.closed:
+			return
+		case <-ticker.C:
+		}
+		if _, err := store.Collect(referenced()); err != nil {
+			log.Printf("cannot remove unreferenced blobs in `%s`: %v", store.folder, err)
+		}
+	}
+}
+
+// referencedBlobs returns the hashes of the blobs referenced by any task.
+func (server *ServerImpl) referencedBlobs() map[string]bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	referenced := make(map[string]bool)
+	for _, scheduler := range server.schedulerGroup {
+		for _, task := range scheduler.Tasks() {
+			if len(task.Blob) > 0 {
+				referenced[task.Blob] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// offload moves the payload of `task` into the blob store if it is larger than the threshold.
+func (server *ServerImpl) offload(task *Task) error {
+	if server.blobs == nil || len(task.Data) <= server.blobThreshold {
+		return nil
+	}
+	hash, err := server.blobs.Put([]byte(task.Data))
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot store payload: %v", err)
+	}
+	task.Blob = hash
+	task.BlobSize = int64(len(task.Data))
+	task.Data = ""
+	return nil
+}
+
+// inline moves the payload of `task` back from the blob store.
+func (server *ServerImpl) inline(task *Task) error {
+	if len(task.Blob) == 0 {
+		return nil
+	}
+	if server.blobs == nil {
+		return status.Errorf(codes.FailedPrecondition, "task `%s` references blob `%s` but the blob store is disabled", task.ID, task.Blob)
+	}
+	data, err := server.blobs.Get(task.Blob)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot read blob of task `%s`: %v", task.ID, err)
+	}
+	task.Data = string(data)
+	task.Blob = ""
+	task.BlobSize = 0
+	return nil
+}
+
+// GetBlob implements the RPC method `TaskMaster.GetBlob`.
+// Like the payloads returned by `Query`, blobs are only handed to the lease holder of the task, or the managers of the group.
+func (server *ServerImpl) GetBlob(request *pb.GetBlobRequest, stream pb.TaskMaster_GetBlobServer) error {
+	if server.blobs == nil {
+		return status.Errorf(codes.FailedPrecondition, "blob store is disabled")
+	}
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return err
+	}
+	task, exists := scheduler.GetTask(request.GetID())
+	if !exists || task.Blob != request.GetHash() {
+		return status.Errorf(codes.NotFound, "task `%s` does not reference blob `%s`", request.GetID(), request.GetHash())
+	}
+	if task.LeaseHolder != CallerIdentity(stream.Context()) {
+		if err := server.authorizeAdmin(stream.Context(), command{Op: opGetBlob, Group: request.GetGroup(), ID: request.GetID()}); err != nil {
+			return err
+		}
+	}
+	file, err := server.blobs.Open(request.GetHash())
+	if os.IsNotExist(err) {
+		return status.Errorf(codes.NotFound, "blob `%s` not found", request.GetHash())
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	defer file.Close()
+	buffer := make([]byte, blobChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if err := stream.Send(&pb.GetBlobResponse{Data: buffer[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot read blob: %v", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package taskmaster_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestBlobStoreCollect(t *testing.T) {
+	folder := t.TempDir()
+	store, err := taskmaster.NewBlobStore(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := store.Put([]byte("kept"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.Put([]byte("removed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := store.Put([]byte("recent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, hash := range []string{kept, removed} {
+		if err := os.Chtimes(path.Join(folder, hash), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := store.Collect(map[string]bool{kept: true}); err != nil || count != 1 {
+		t.Errorf("expect 1 blob removed, got %d, %v", count, err)
+	}
+	for hash, exists := range map[string]bool{kept: true, removed: false, recent: true} {
+		if _, err := store.Get(hash); (err == nil) != exists {
+			t.Errorf("blob `%s`: expect exists=%v, got %v", hash, exists, err)
+		}
+	}
+	if _, err := store.Get("../kept"); err == nil {
+		t.Error("expect malformed hashes to be rejected")
+	}
+}
+
+func TestServerBlobOffload(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t, taskmaster.WithBlobStore(16))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	payload := strings.Repeat("payload", 100000)
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: payload}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetData()) > 0 || len(resp.GetBlob()) == 0 || resp.GetBlobSize() != int64(len(payload)) {
+		t.Fatalf("expect the payload to be offloaded, got blob `%s` of %d bytes", resp.GetBlob(), resp.GetBlobSize())
+	}
+	fetched, err := readBlob(ctx, client, resp.GetID(), resp.GetBlob())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fetched) != payload {
+		t.Errorf("unexpected payload of %d bytes", len(fetched))
+	}
+
+	// Snapshots carry the payloads, so they can be restored on servers without the blob.
+	snapshot, err := client.GetGroupSnapshot(ctx, &pb.GetGroupSnapshotRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := taskmaster.Snapshot{}
+	if err := json.Unmarshal(snapshot.GetSnapshot(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if task := decoded.AvailableTasks[resp.GetID()]; task.Data != payload || len(task.Blob) > 0 {
+		t.Errorf("expect the payload inlined in the snapshot, got blob `%s`", task.Blob)
+	}
+}
+
+// readBlob reads the blob of task `ID` through `client`.
+func readBlob(ctx context.Context, client pb.TaskMasterClient, ID string, hash string) ([]byte, error) {
+	stream, err := client.GetBlob(ctx, &pb.GetBlobRequest{Group: "default", Hash: hash, ID: ID})
+	if err != nil {
+		return nil, err
+	}
+	data := []byte{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk.GetData()...)
+	}
+}
+
+func TestGetBlobAuthorization(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t, taskmaster.WithBlobStore(16), taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"*": {"admin"}}))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	clients := make([]pb.TaskMasterClient, 2)
+	for i := range clients {
+		conn, err := grpc.Dial(address, grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients[i] = pb.NewTaskMasterClient(conn)
+	}
+	payload := strings.Repeat("payload", 100)
+	if _, err := server.Insert(contextWithSubject("admin"), &pb.InsertRequest{Group: "default", Data: payload}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := clients[0].Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBlob(ctx, clients[0], "unknown", resp.GetBlob()); status.Code(err) != codes.NotFound {
+		t.Errorf("expect blobs not referenced by the task to be rejected, got %v", err)
+	}
+	if _, err := readBlob(ctx, clients[1], resp.GetID(), resp.GetBlob()); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expect callers other than the lease holder to be rejected, got %v", err)
+	}
+	if data, err := readBlob(ctx, clients[0], resp.GetID(), resp.GetBlob()); err != nil || string(data) != payload {
+		t.Errorf("expect the lease holder to read the blob, got %d bytes, %v", len(data), err)
+	}
+}
+
+func TestBlobStoreWithoutReplication(t *testing.T) {
+	if _, err := taskmaster.NewTaskMasterServer(t.TempDir(), time.Minute, taskmaster.WithBlobStore(16),
+		taskmaster.WithReplication(taskmaster.ReplicationConfig{FailoverTimeout: time.Second})); err == nil {
+		t.Error("expect the blob store to be rejected with replication")
+	}
+	leaderAddress, _ := serveTestServer(t, createTestServer(t, taskmaster.WithBlobStore(16)))
+	conn, err := grpc.Dial(leaderAddress, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewTaskMasterReplicationClient(conn).Follow(context.Background(), &pb.FollowRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect servers with a blob store not to be followed, got %v", err)
+	}
+}
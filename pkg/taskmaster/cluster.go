@@ -0,0 +1,194 @@
+package taskmaster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/raft"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clusterFolder stores the Raft state in the snapshot folder.
+const clusterFolder = "raft"
+
+// ClusterConfig configures a task master cluster replicated with Raft.
+type ClusterConfig struct {
+	// Address is the address of this server, it must be one of `Peers`.
+	Address string
+	// Peers are the addresses of all servers in the cluster including this server, usually 3 or 5 of them.
+	Peers []string
+	// DialOption is used to connect to the other servers.
+	DialOption grpc.DialOption
+	// HeartbeatInterval and ElectionTimeout default to `raft.DefaultHeartbeatInterval` and `raft.DefaultElectionTimeout`.
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration
+}
+
+// WithCluster makes the server a member of a cluster.
+//
+// Every mutation is committed by the majority of the cluster before acknowledged, so the cluster keeps serving
+// without losing acknowledged mutations as long as the majority is available.
+// Only the leader accepts mutations, the other members reject them like followers, see `LeaderRedirectInterceptor`.
+// Reads are served from the local state which may lag behind the leader.
+//
+// The state is persisted in the `raft` folder under the snapshot folder, the snapshot files of groups are not used.
+// Cannot be used with `WithReplication`.
+func WithCluster(Config ClusterConfig) ServerOption {
+	return func(server *ServerImpl) {
+		server.cluster = &cluster{config: Config, stop: make(chan struct{})}
+	}
+}
+
+type cluster struct {
+	config ClusterConfig
+	node   *raft.Node
+	stop   chan struct{}
+}
+
+// clusterStateMachine applies the commands committed by the cluster to the server.
+type clusterStateMachine struct {
+	server *ServerImpl
+}
+
+func (machine clusterStateMachine) Apply(Data []byte) interface{} {
+	cmd := command{}
+	if err := json.Unmarshal(Data, &cmd); err != nil {
+		return commandResult{err: status.Errorf(codes.Internal, "cannot decode command: %v", err)}
+	}
+	return machine.server.applyCommand(&cmd)
+}
+
+// Snapshot encodes the snapshots of all groups keyed by the group names.
+func (machine clusterStateMachine) Snapshot() ([]byte, error) {
+	server := machine.server
+	server.mu.RLock()
+	snapshots := make(map[string]*Snapshot, len(server.schedulerGroup))
+	for group, scheduler := range server.schedulerGroup {
+		snapshots[group] = scheduler.GetSnapshot()
+	}
+	server.mu.RUnlock()
+	return json.Marshal(snapshots)
+}
+
+func (machine clusterStateMachine) Restore(Data []byte) error {
+	encoded := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(Data, &encoded); err != nil {
+		return err
+	}
+	snapshots := make(map[string]*Snapshot, len(encoded))
+	groups := make(map[string]bool, len(encoded))
+	for group, data := range encoded {
+		snapshot, err := DecodeSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("cannot decode snapshot of group `%s`: %v", group, err)
+		}
+		snapshots[group] = snapshot
+		groups[group] = true
+	}
+	if err := machine.server.retainGroups(groups); err != nil {
+		return err
+	}
+	for group, snapshot := range snapshots {
+		if err := machine.server.restoreGroup(group, snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startCluster joins the cluster and starts pruning finished tasks while being the leader.
+func (server *ServerImpl) startCluster() error {
+	if len(server.replication.config.Leader) > 0 || server.replication.config.FailoverTimeout > 0 {
+		return fmt.Errorf("cluster cannot be used with replication")
+	}
+	config := server.cluster.config
+	node, err := raft.NewNode(raft.Config{
+		ID:                config.Address,
+		Peers:             config.Peers,
+		Dir:               path.Join(server.snapshotFolder, clusterFolder),
+		DialOption:        config.DialOption,
+		HeartbeatInterval: config.HeartbeatInterval,
+		ElectionTimeout:   config.ElectionTimeout,
+	}, clusterStateMachine{server: server})
+	if err != nil {
+		return err
+	}
+	server.cluster.node = node
+	go func() {
+		ticker := server.clock.NewTicker(server.snapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-server.cluster.stop:
+				return
+			case <-ticker.C():
+				if node.Status().Role != raft.RoleLeader {
+					continue
+				}
+				if result := server.execute(context.Background(), command{Op: opPrune}); result.err != nil {
+					log.Printf("cannot prune finished tasks: %v", result.err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// clusterError converts an error returned by `raft.Node.Propose` into a RPC status.
+// Only mutations known not to be applied are reported as `Unavailable`, which clients may retry.
+func (server *ServerImpl) clusterError(ctx context.Context, err error) error {
+	notLeader := &raft.NotLeaderError{}
+	switch {
+	case errors.As(err, &notLeader):
+		return notLeaderError(ctx, notLeader.Leader)
+	case errors.Is(err, raft.ErrEntryLost):
+		return status.Errorf(codes.Unavailable, "the mutation is aborted: %v", err)
+	case errors.Is(err, raft.ErrStopped), errors.Is(err, raft.ErrUnknownResult):
+		// The entry may still be committed, retrying blindly could apply the mutation twice.
+		return status.Errorf(codes.Unknown, "the outcome of the mutation is unknown, check its result before retrying: %v", err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	return status.Errorf(codes.Unknown, "%v", err)
+}
+
+// RaftServer returns the `Raft` service of the cluster member, nil if the server is not a member of a cluster.
+func (server *ServerImpl) RaftServer() pb.RaftServer {
+	if server.cluster == nil {
+		return nil
+	}
+	return server.cluster.node
+}
+
+// Close stops the background routines of the server, the persisted state is kept.
+// The queued events are dispatched, webhooks not yet delivered are logged, and the audit log is closed.
+// Cluster members do not accept mutations afterwards.
+func (server *ServerImpl) Close() {
+	select {
+	case <-server.closed:
+		return
+	default:
+	}
+	close(server.closed)
+	if server.cluster != nil {
+		close(server.cluster.stop)
+		server.cluster.node.Stop()
+	}
+	<-server.eventsDispatched
+	if server.webhooks != nil {
+		server.stopWebhooks()
+	}
+	if server.audit != nil {
+		if err := server.audit.Close(); err != nil {
+			log.Printf("cannot close the audit log: %v", err)
+		}
+	}
+}
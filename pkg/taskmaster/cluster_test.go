@@ -0,0 +1,152 @@
+package taskmaster_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/raft"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type clusterMember struct {
+	address    string
+	folder     string
+	server     *taskmaster.ServerImpl
+	grpcServer *grpc.Server
+}
+
+// startClusterMember serves a cluster member on `listener`, or on the address of the member if `listener` is nil.
+func startClusterMember(t *testing.T, member *clusterMember, peers []string, listener net.Listener) {
+	if listener == nil {
+		var err error
+		if listener, err = net.Listen("tcp", member.address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := taskmaster.NewTaskMasterServer(member.folder, time.Minute, taskmaster.WithCluster(taskmaster.ClusterConfig{
+		Address:           member.address,
+		Peers:             peers,
+		DialOption:        grpc.WithInsecure(),
+		HeartbeatInterval: 20 * time.Millisecond,
+		ElectionTimeout:   150 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	member.server = server
+	member.grpcServer = grpc.NewServer()
+	member.grpcServer.RegisterService(&pb.TaskMaster_ServiceDesc, server)
+	member.grpcServer.RegisterService(&pb.Raft_ServiceDesc, server.RaftServer())
+	go member.grpcServer.Serve(listener)
+}
+
+func stopClusterMember(member *clusterMember) {
+	if member.server == nil {
+		return
+	}
+	member.grpcServer.Stop()
+	member.server.Close()
+	member.server = nil
+}
+
+func startTestCluster(t *testing.T, size int) ([]*clusterMember, []string) {
+	members := []*clusterMember{}
+	listeners := []net.Listener{}
+	peers := []string{}
+	for i := 0; i < size; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+		peers = append(peers, listener.Addr().String())
+		members = append(members, &clusterMember{address: listener.Addr().String(), folder: t.TempDir()})
+	}
+	for i, member := range members {
+		startClusterMember(t, member, peers, listeners[i])
+	}
+	t.Cleanup(func() {
+		for _, member := range members {
+			stopClusterMember(member)
+		}
+	})
+	return members, peers
+}
+
+func waitForClusterLeader(t *testing.T, members []*clusterMember) *clusterMember {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, member := range members {
+			if member.server == nil {
+				continue
+			}
+			resp, err := member.server.RaftServer().GetStatus(context.Background(), &pb.RaftStatusRequest{})
+			if err == nil && resp.GetRole() == raft.RoleLeader {
+				return member
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader is elected")
+	return nil
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+	members, peers := startTestCluster(t, 3)
+	leader := waitForClusterLeader(t, members)
+	var follower *clusterMember
+	for _, member := range members {
+		if member != leader {
+			follower = member
+		}
+	}
+
+	// Mutations sent to a follower are redirected to the leader.
+	conn, err := grpc.Dial(follower.address, grpc.WithInsecure(), grpc.WithUnaryInterceptor(taskmaster.LeaderRedirectInterceptor(grpc.WithInsecure())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+	for i := 0; i < 3; i++ {
+		if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, member := range members {
+		waitForTaskCount(t, member.server, "default", 3)
+	}
+	leased, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lease survives the failure of the leader.
+	stopClusterMember(leader)
+	newLeader := waitForClusterLeader(t, members)
+	if _, err := newLeader.server.Finish(ctx, &pb.FinishRequest{Group: "default", ID: leased.GetID()}); err != nil {
+		t.Fatalf("expect the new leader to know the lease, got %v", err)
+	}
+	if _, err := newLeader.server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members {
+		if member.server != nil {
+			waitForTaskCount(t, member.server, "default", 3)
+		}
+	}
+
+	// The restarted member catches up with the cluster.
+	startClusterMember(t, leader, peers, nil)
+	waitForTaskCount(t, leader.server, "default", 3)
+	resp, err := leader.server.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: leased.GetID()})
+	if err != nil || resp.GetTask().GetState() != taskmaster.StateDone {
+		t.Errorf("unexpected task: %v, %v", resp, err)
+	}
+}
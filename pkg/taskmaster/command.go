@@ -0,0 +1,262 @@
+package taskmaster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Command operations.
+const (
+	opInsert         = "insert"
+	opLease          = "lease"
+	opExtend         = "extend"
+	opFinish         = "finish"
+	opCancel         = "cancel"
+	opRequeue        = "requeue"
+	opUpdateSettings = "update_settings"
+	opPause          = "pause"
+	opResume         = "resume"
+	opDrain          = "drain"
+	opDeleteGroup    = "delete_group"
+	opPrune          = "prune"
+	opRestoreGroup   = "restore_group"
+	opMergeGroup     = "merge_group"
+	opAttach         = "attach"
+)
+
+// command is a mutation of the server state.
+// Applying a command only depends on the current state, so every member of a cluster reaches the same state
+// by applying the same commands in order. The time, task IDs and permissions are decided before the command is proposed.
+type command struct {
+	Op       string        `json:"op"`
+	Group    string        `json:"group,omitempty"`
+	Time     time.Time     `json:"time"`
+	ID       string        `json:"id,omitempty"`
+	Data     string        `json:"data,omitempty"`
+	Blob     string        `json:"blob,omitempty"`
+	BlobSize int64         `json:"blob_size,omitempty"`
+	Holder   string        `json:"holder,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Failed   bool          `json:"failed,omitempty"`
+	// Permanent fails the task without retries, see `FinishRequest.permanent`.
+	Permanent bool           `json:"permanent,omitempty"`
+	Log       string         `json:"log,omitempty"`
+	Force     bool           `json:"force,omitempty"`
+	Settings  *GroupSettings `json:"settings,omitempty"`
+	Snapshot  *Snapshot      `json:"snapshot,omitempty"`
+	// Labels are the labels of the inserted task, or the labels of the worker leasing a task.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Constraints []string          `json:"constraints,omitempty"`
+	Tenant      string            `json:"tenant,omitempty"`
+	// ExpiresAt and Deadline are the expiry time and the deadline of the inserted task.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+	// CallbackURL is the callback URL of the inserted task.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Job is the job of the inserted task.
+	Job string `json:"job,omitempty"`
+	// Limits are the resource limits of the inserted task.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// ArtifactPaths are the artifact paths of the inserted task.
+	ArtifactPaths []string `json:"artifact_paths,omitempty"`
+	// Artifact is the artifact attached to the task.
+	Artifact *Artifact `json:"artifact,omitempty"`
+}
+
+// commandResult is returned to the caller proposed the command.
+type commandResult struct {
+	task     *Task
+	state    string
+	count    int
+	deadline time.Time
+	err      error
+}
+
+// execute applies `cmd` at the current time, through the consensus of the cluster if the server is a member of one.
+// The command is recorded in the audit log and reflected by the health service on the server receiving the call.
+func (server *ServerImpl) execute(ctx context.Context, cmd command) commandResult {
+	cmd.Time = server.clock.Now()
+	result := server.propose(ctx, &cmd)
+	server.recordAudit(ctx, &cmd, &result)
+	if result.err == nil {
+		server.updateGroupHealth(cmd.Group)
+	}
+	return result
+}
+
+func (server *ServerImpl) propose(ctx context.Context, cmd *command) commandResult {
+	if server.cluster == nil {
+		return server.applyCommand(cmd)
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return commandResult{err: status.Errorf(codes.Internal, "cannot encode command: %v", err)}
+	}
+	value, err := server.cluster.node.Propose(ctx, data)
+	if err != nil {
+		return commandResult{err: server.clusterError(ctx, err)}
+	}
+	return value.(commandResult)
+}
+
+// applyCommand applies `cmd` to the schedulers.
+func (server *ServerImpl) applyCommand(cmd *command) commandResult {
+	switch cmd.Op {
+	case opInsert:
+		// The lock is held until the task is inserted, so a concurrent `DeleteGroup` cannot remove the group in between.
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		scheduler, err := server.getOrCreateScheduler(cmd.Group)
+		if err != nil {
+			return commandResult{err: err}
+		}
+		if scheduler.Draining() {
+			return commandResult{err: status.Errorf(codes.FailedPrecondition, "group `%s` is draining", cmd.Group)}
+		}
+		scheduler.insertAt(Task{ID: cmd.ID, Data: cmd.Data, Blob: cmd.Blob, BlobSize: cmd.BlobSize, Labels: cmd.Labels, Constraints: cmd.Constraints, Tenant: cmd.Tenant,
+			ExpiresAt: cmd.ExpiresAt, Deadline: cmd.Deadline, CallbackURL: cmd.CallbackURL, Job: cmd.Job, Limits: cmd.Limits, ArtifactPaths: cmd.ArtifactPaths}, cmd.Time)
+		return commandResult{}
+	case opUpdateSettings:
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		scheduler, err := server.getOrCreateScheduler(cmd.Group)
+		if err != nil {
+			return commandResult{err: err}
+		}
+		settings := GroupSettings{}
+		if cmd.Settings != nil {
+			settings = *cmd.Settings
+		}
+		scheduler.UpdateSettings(settings)
+		server.updateLeaseLimiter(cmd.Group, settings)
+		return commandResult{}
+	case opDeleteGroup:
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		scheduler, exists := server.schedulerGroup[cmd.Group]
+		if !exists {
+			return commandResult{err: status.Errorf(codes.NotFound, "group not found")}
+		}
+		taskCount := scheduler.TaskCount()
+		if taskCount > 0 && !cmd.Force {
+			return commandResult{err: status.Errorf(codes.FailedPrecondition, "group `%s` still has %d tasks", cmd.Group, taskCount)}
+		}
+		if err := server.removeGroup(cmd.Group); err != nil {
+			return commandResult{err: status.Errorf(codes.Internal, "cannot remove snapshot: %v", err)}
+		}
+		server.replication.record(Mutation{Group: cmd.Group, Kind: MutationDeleteGroup})
+		return commandResult{count: taskCount}
+	case opRestoreGroup:
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if _, exists := server.schedulerGroup[cmd.Group]; exists {
+			if !cmd.Force {
+				return commandResult{err: status.Errorf(codes.AlreadyExists, "group `%s` already exists", cmd.Group)}
+			}
+			if err := server.removeGroup(cmd.Group); err != nil {
+				return commandResult{err: status.Errorf(codes.Internal, "cannot remove snapshot: %v", err)}
+			}
+			server.replication.record(Mutation{Group: cmd.Group, Kind: MutationDeleteGroup})
+		}
+		scheduler, err := server.getOrCreateScheduler(cmd.Group)
+		if err != nil {
+			return commandResult{err: err}
+		}
+		scheduler.Restore(cmd.Snapshot)
+		scheduler.recordSnapshot()
+		server.updateLeaseLimiter(cmd.Group, cmd.Snapshot.Settings)
+		return commandResult{count: scheduler.TaskCount()}
+	case opMergeGroup:
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if scheduler, exists := server.schedulerGroup[cmd.Group]; exists {
+			return commandResult{count: scheduler.Merge(cmd.Snapshot)}
+		}
+		scheduler, err := server.getOrCreateScheduler(cmd.Group)
+		if err != nil {
+			return commandResult{err: err}
+		}
+		scheduler.Restore(cmd.Snapshot)
+		scheduler.recordSnapshot()
+		server.updateLeaseLimiter(cmd.Group, cmd.Snapshot.Settings)
+		return commandResult{count: len(cmd.Snapshot.AvailableTasks) + len(cmd.Snapshot.FinishedTasks)}
+	case opPrune:
+		server.mu.RLock()
+		defer server.mu.RUnlock()
+		for _, scheduler := range server.schedulerGroup {
+			scheduler.pruneAt(cmd.Time)
+		}
+		return commandResult{}
+	}
+
+	scheduler, err := server.getScheduler(cmd.Group)
+	if err != nil {
+		return commandResult{err: err}
+	}
+	switch cmd.Op {
+	case opLease:
+		if scheduler.Paused() {
+			return commandResult{err: status.Errorf(codes.FailedPrecondition, "group `%s` is paused", cmd.Group)}
+		}
+		settings := scheduler.Settings()
+		task := scheduler.leaseAt(cmd.Holder, cmd.Labels, settings.loanDuration(cmd.Duration), cmd.Time)
+		if task == nil {
+			if maxConcurrency := settings.MaxConcurrency; maxConcurrency > 0 && scheduler.LeasedCount() >= maxConcurrency {
+				return commandResult{err: status.Errorf(codes.ResourceExhausted, "group `%s` reached its concurrency limit", cmd.Group)}
+			}
+			if scheduler.hasAvailable(cmd.Time) {
+				return commandResult{err: status.Errorf(codes.NotFound, "no available tasks match the labels of the worker")}
+			}
+			return commandResult{err: status.Errorf(codes.NotFound, "no available tasks at present")}
+		}
+		return commandResult{task: task}
+	case opExtend:
+		settings := scheduler.Settings()
+		deadline := cmd.Time.Add(settings.loanDuration(cmd.Duration))
+		if err := scheduler.extendAt(cmd.ID, deadline, cmd.Time); err != nil {
+			if errors.Is(err, ErrDeadlineExceeded) {
+				return commandResult{err: status.Errorf(codes.FailedPrecondition, "task `%s` passed its deadline and is cancelled", cmd.ID)}
+			}
+			return commandResult{err: status.Errorf(codes.InvalidArgument, err.Error())}
+		}
+		task, _ := scheduler.GetTask(cmd.ID)
+		return commandResult{deadline: task.AvailableTime}
+	case opFinish:
+		state, err := scheduler.finishAt(cmd.ID, cmd.Failed, cmd.Permanent, cmd.Log, cmd.Time)
+		if err != nil {
+			return commandResult{err: status.Errorf(codes.NotFound, "no active task with ID `%s`", cmd.ID)}
+		}
+		return commandResult{state: state}
+	case opCancel:
+		if err := scheduler.cancelAt(cmd.ID, cmd.Time); err != nil {
+			return commandResult{err: status.Errorf(codes.NotFound, err.Error())}
+		}
+		return commandResult{}
+	case opRequeue:
+		if err := scheduler.requeueAt(cmd.ID, cmd.Time); err != nil {
+			return commandResult{err: status.Errorf(codes.NotFound, err.Error())}
+		}
+		return commandResult{}
+	case opPause:
+		scheduler.SetPaused(true)
+		return commandResult{}
+	case opResume:
+		scheduler.SetPaused(false)
+		scheduler.SetDraining(false)
+		return commandResult{}
+	case opDrain:
+		scheduler.SetDraining(true)
+		return commandResult{}
+	case opAttach:
+		if err := scheduler.attachAt(cmd.ID, *cmd.Artifact, cmd.Time); err != nil {
+			return commandResult{err: status.Errorf(codes.FailedPrecondition, err.Error())}
+		}
+		return commandResult{}
+	}
+	return commandResult{err: status.Errorf(codes.Internal, "unknown command `%s`", cmd.Op)}
+}
@@ -0,0 +1,163 @@
+package taskmaster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	// PriorityFIFO hands out the tasks of a group in the order of creation.
+	PriorityFIFO = "fifo"
+	// PriorityFairShare hands out the tasks of different tenants in proportion to their weights, see `GroupSettings.FairShare`.
+	PriorityFairShare = "fair_share"
+)
+
+// ConfigDuration is a duration encoded as a string such as `90s` or `1h30m` in a config file.
+type ConfigDuration time.Duration
+
+// UnmarshalJSON implements `json.Unmarshaler`.
+func (duration *ConfigDuration) UnmarshalJSON(data []byte) error {
+	value := ""
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid duration %s, expect a string such as \"5m\"", data)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*duration = ConfigDuration(parsed)
+	return nil
+}
+
+// MarshalJSON implements `json.Marshaler`.
+func (duration ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+// GroupConfig describes the settings of a group in a config file, see `GroupSettings` for the meaning of each field.
+type GroupConfig struct {
+	LoanDuration    ConfigDuration `json:"loan_duration,omitempty"`
+	MaxLoanDuration ConfigDuration `json:"max_loan_duration,omitempty"`
+	MaxAttempts     int            `json:"max_attempts,omitempty"`
+	MaxConcurrency  int            `json:"max_concurrency,omitempty"`
+	LeaseRate       float64        `json:"lease_rate,omitempty"`
+	LeaseBurst      int            `json:"lease_burst,omitempty"`
+	Retention       ConfigDuration `json:"retention,omitempty"`
+	// Priority is either `PriorityFIFO` or `PriorityFairShare`, empty means `PriorityFIFO`.
+	Priority      string             `json:"priority,omitempty"`
+	TenantWeights map[string]float64 `json:"tenant_weights,omitempty"`
+	CallbackURL   string             `json:"callback_url,omitempty"`
+}
+
+// Settings returns the group settings described by the config.
+func (config *GroupConfig) Settings() GroupSettings {
+	return GroupSettings{
+		MaxConcurrency:  config.MaxConcurrency,
+		LeaseRate:       config.LeaseRate,
+		LeaseBurst:      config.LeaseBurst,
+		MaxAttempts:     config.MaxAttempts,
+		Retention:       time.Duration(config.Retention),
+		FairShare:       config.Priority == PriorityFairShare,
+		TenantWeights:   config.TenantWeights,
+		CallbackURL:     config.CallbackURL,
+		LoanDuration:    time.Duration(config.LoanDuration),
+		MaxLoanDuration: time.Duration(config.MaxLoanDuration),
+	}
+}
+
+// Validate returns error if the config has invalid settings.
+func (config *GroupConfig) Validate() error {
+	if config.MaxAttempts < 0 || config.MaxConcurrency < 0 || config.LeaseRate < 0 || config.LeaseBurst < 0 ||
+		config.LoanDuration < 0 || config.MaxLoanDuration < 0 || config.Retention < 0 {
+		return fmt.Errorf("settings must not be negative")
+	}
+	if config.MaxLoanDuration > 0 && config.LoanDuration > config.MaxLoanDuration {
+		return fmt.Errorf("loan duration %v exceeds the max loan duration %v", time.Duration(config.LoanDuration), time.Duration(config.MaxLoanDuration))
+	}
+	switch config.Priority {
+	case "", PriorityFIFO, PriorityFairShare:
+	default:
+		return fmt.Errorf("unknown priority `%s`, expect `%s` or `%s`", config.Priority, PriorityFIFO, PriorityFairShare)
+	}
+	if len(config.TenantWeights) > 0 && config.Priority != PriorityFairShare {
+		return fmt.Errorf("tenant weights require priority `%s`", PriorityFairShare)
+	}
+	return ValidateTenantWeights(config.TenantWeights)
+}
+
+// Config is the declarative configuration of a server, applied by `ApplyConfig`.
+type Config struct {
+	// Groups are the settings of groups keyed by the group name.
+	Groups map[string]GroupConfig `json:"groups"`
+}
+
+// Validate returns error if any group of the config is invalid.
+func (config *Config) Validate() error {
+	for group, groupConfig := range config.Groups {
+		if err := validateGroupName(group); err != nil {
+			return err
+		}
+		if err := groupConfig.Validate(); err != nil {
+			return fmt.Errorf("group `%s`: %v", group, err)
+		}
+	}
+	return nil
+}
+
+// LoadConfig loads and validates a JSON config file. Unknown fields are rejected, so a typo is not silently ignored.
+func LoadConfig(Filename string) (*Config, error) {
+	data, err := os.ReadFile(Filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	config := &Config{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("cannot decode `%s`: %v", Filename, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config `%s`: %v", Filename, err)
+	}
+	return config, nil
+}
+
+// ApplyConfig replaces the settings of the groups in `Config`, creating the groups if missing.
+// Groups not in the config keep their settings, so do the groups whose settings are already up to date.
+// The whole config is validated before any group is updated, and only the leader applies configs.
+// Returns the names of the updated groups.
+func (server *ServerImpl) ApplyConfig(ctx context.Context, Config *Config) ([]string, error) {
+	if err := Config.Validate(); err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(Config.Groups))
+	for group, groupConfig := range Config.Groups {
+		if err := server.validateCallbackURL(groupConfig.CallbackURL); err != nil {
+			return nil, fmt.Errorf("group `%s`: %v", group, err)
+		}
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	var updated []string
+	for _, group := range groups {
+		groupConfig := Config.Groups[group]
+		settings := groupConfig.Settings()
+		if scheduler, err := server.getScheduler(group); err == nil && reflect.DeepEqual(scheduler.Settings(), settings) {
+			continue
+		}
+		if result := server.execute(ctx, command{Op: opUpdateSettings, Group: group, Settings: &settings}); result.err != nil {
+			return updated, fmt.Errorf("cannot update group `%s`: %v", group, result.err)
+		}
+		updated = append(updated, group)
+	}
+	return updated, nil
+}
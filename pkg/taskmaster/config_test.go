@@ -0,0 +1,107 @@
+package taskmaster_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, content := range []string{
+		`{"groups": {"default": {"max_attempts": -1}}}`,
+		`{"groups": {"default": {"priority": "lifo"}}}`,
+		`{"groups": {"default": {"tenant_weights": {"alice": 2}}}}`,
+		`{"groups": {"default": {"loan_duration": "10m", "max_loan_duration": "5m"}}}`,
+		`{"groups": {"default": {"loan_duration": 300}}}`,
+		`{"groups": {"default": {"max_attempt": 3}}}`,
+		`{"groups": {"a/b": {}}}`,
+	} {
+		if _, err := taskmaster.LoadConfig(writeTestConfig(t, content)); err == nil {
+			t.Errorf("expect config %s to be rejected", content)
+		}
+	}
+	config, err := taskmaster.LoadConfig(writeTestConfig(t, `{"groups": {"default": {
+		"loan_duration": "1m", "max_loan_duration": "10m", "max_attempts": 3, "retention": "1h",
+		"priority": "fair_share", "tenant_weights": {"alice": 2}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupConfig := config.Groups["default"]
+	settings := groupConfig.Settings()
+	if settings.LoanDuration != time.Minute || settings.MaxLoanDuration != 10*time.Minute || settings.MaxAttempts != 3 ||
+		settings.Retention != time.Hour || !settings.FairShare || settings.TenantWeights["alice"] != 2 {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+}
+
+func TestServerApplyConfig(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t)
+	defer server.Close()
+	config, err := taskmaster.LoadConfig(writeTestConfig(t, `{"groups": {"default": {"loan_duration": "1m", "max_loan_duration": "10m", "max_concurrency": 2}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := server.ApplyConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || updated[0] != "default" {
+		t.Errorf("expect the group to be created, got %v", updated)
+	}
+	if updated, err := server.ApplyConfig(ctx, config); err != nil || len(updated) != 0 {
+		t.Errorf("expect an unchanged config to update nothing, got %v, %v", updated, err)
+	}
+	resp, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetSettings().GetMaxConcurrency() != 2 || resp.GetSettings().GetLoanDuration().AsDuration() != time.Minute {
+		t.Errorf("unexpected settings %v", resp.GetSettings())
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leased, err := server.Query(ctx, &pb.QueryRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease := time.Until(leased.GetDeadline().AsTime()); lease <= 0 || lease > time.Minute {
+		t.Errorf("expect the loan duration of the group, got %v", lease)
+	}
+	leased, err = server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease := time.Until(leased.GetDeadline().AsTime()); lease <= time.Minute || lease > 10*time.Minute {
+		t.Errorf("expect the lease to be capped by the max loan duration, got %v", lease)
+	}
+
+	invalid := &taskmaster.Config{Groups: map[string]taskmaster.GroupConfig{
+		"default": {MaxConcurrency: 1},
+		"hooks":   {CallbackURL: "http://localhost/hook"},
+	}}
+	if _, err := server.ApplyConfig(ctx, invalid); err == nil {
+		t.Error("expect callback URLs to be rejected without webhooks")
+	}
+	if resp, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"}); err != nil || resp.GetSettings().GetMaxConcurrency() != 2 {
+		t.Errorf("expect an invalid config not to be applied, got %v, %v", resp.GetSettings(), err)
+	}
+}
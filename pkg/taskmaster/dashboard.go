@@ -0,0 +1,24 @@
+package taskmaster
+
+import (
+	"net/http"
+
+	_ "embed" // just for embedding
+)
+
+//go:embed assets/dashboard.html
+var dashboardHTML []byte
+
+// DashboardHandler serves the web dashboard of the task master.
+// The dashboard talks to the JSON API, it expects the handler of `NewGatewayHandler` to be served on `GatewayPrefix` of the same host.
+func DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write(dashboardHTML)
+	})
+}
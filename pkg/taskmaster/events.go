@@ -0,0 +1,288 @@
+package taskmaster
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Event kinds.
+const (
+	EventInserted  = "inserted"
+	EventLeased    = "leased"
+	EventExtended  = "extended"
+	EventFinished  = "finished"
+	EventFailed    = "failed"
+	EventExpired   = "expired"
+	EventCancelled = "cancelled"
+	EventRequeued  = "requeued"
+)
+
+// DefaultEventLogSize is the number of recent events kept for resuming watches.
+const DefaultEventLogSize = 10000
+
+// Event is a change of a task, see `SetEventHook`.
+type Event struct {
+	Kind string
+	// Task is the task after the change.
+	Task Task
+	Time time.Time
+}
+
+// SetEventHook registers `Hook` to be called on every change of the tasks of the scheduler.
+// The hook is called with the scheduler locked, so events of the same scheduler are observed in order.
+// Changes applied by `Apply` and `Restore` do not emit events.
+func (master *Scheduler) SetEventHook(Hook func(Event)) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.onEvent = Hook
+}
+
+// emit must be called with `mu` held.
+func (master *Scheduler) emit(kind string, task Task, now time.Time) {
+	if master.onEvent != nil {
+		task.expiredLease = false
+		master.onEvent(Event{Kind: kind, Task: task, Time: now})
+	}
+}
+
+// retiredEvents maps the terminal states to the kinds of their events.
+var retiredEvents = map[string]string{
+	StateDone:      EventFinished,
+	StateFailed:    EventFailed,
+	StateCancelled: EventCancelled,
+	StateExpired:   EventExpired,
+}
+
+// eventLog keeps the recent events of all groups in a ring buffer.
+type eventLog struct {
+	mu sync.Mutex
+	// id distinguishes the logs of different server processes, so cursors of a previous process are rejected.
+	id     string
+	events []*pb.TaskEvent
+	// next is the sequence of the next event, the sequence of the first event is 1.
+	next uint64
+	// notify is closed and replaced once an event is appended.
+	notify chan struct{}
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{
+		id:     uuid.NewString(),
+		events: make([]*pb.TaskEvent, size),
+		next:   1,
+		notify: make(chan struct{}),
+	}
+}
+
+func (history *eventLog) cursor(sequence uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s/%d", history.id, sequence)))
+}
+
+// parseCursor returns the sequence of the event `cursor` points to.
+func (history *eventLog) parseCursor(cursor string) (uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid cursor: %v", err)
+	}
+	parts := strings.SplitN(string(data), "/", 2)
+	if len(parts) != 2 {
+		return 0, status.Errorf(codes.InvalidArgument, "malformed cursor")
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid cursor: %v", err)
+	}
+	if parts[0] != history.id {
+		return 0, status.Errorf(codes.OutOfRange, "the cursor is issued before the server restarts, events may be lost")
+	}
+	return sequence, nil
+}
+
+func (history *eventLog) append(event *pb.TaskEvent) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	event.Cursor = history.cursor(history.next)
+	history.events[history.next%uint64(len(history.events))] = event
+	history.next++
+	close(history.notify)
+	history.notify = make(chan struct{})
+}
+
+// since returns the events after `sequence`, the sequence of the last returned event,
+// and a channel closed once more events are appended.
+// Returns error if some events after `sequence` are no longer kept.
+func (history *eventLog) since(sequence uint64) ([]*pb.TaskEvent, uint64, <-chan struct{}, error) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if sequence >= history.next {
+		return nil, sequence, history.notify, nil
+	}
+	if history.next-sequence-1 > uint64(len(history.events)) {
+		return nil, sequence, nil, status.Errorf(codes.OutOfRange, "events after the cursor are no longer kept, events may be lost")
+	}
+	events := make([]*pb.TaskEvent, 0, history.next-sequence-1)
+	for i := sequence + 1; i < history.next; i++ {
+		events = append(events, history.events[i%uint64(len(history.events))])
+	}
+	return events, history.next - 1, history.notify, nil
+}
+
+// latest returns the sequence of the last event.
+func (history *eventLog) latest() uint64 {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	return history.next - 1
+}
+
+// groupEvent is an event of a task of `group`.
+type groupEvent struct {
+	group string
+	event Event
+}
+
+// eventQueue holds the events emitted by the schedulers until `dispatchEvents` handles them,
+// so the event hooks do little work with the schedulers locked.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []groupEvent
+	// notify has a pending signal once events are queued.
+	notify chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{notify: make(chan struct{}, 1)}
+}
+
+func (queue *eventQueue) push(event groupEvent) {
+	queue.mu.Lock()
+	queue.events = append(queue.events, event)
+	queue.mu.Unlock()
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns all queued events.
+func (queue *eventQueue) take() []groupEvent {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	events := queue.events
+	queue.events = nil
+	return events
+}
+
+// recordEvent returns the event hook queueing the events of `group`, see `dispatchEvents`.
+func (server *ServerImpl) recordEvent(group string) func(Event) {
+	return func(event Event) {
+		server.pendingEvents.push(groupEvent{group: group, event: event})
+	}
+}
+
+// dispatchEvents appends the queued events to the event log and delivers their webhooks in order,
+// until the server is closed. Events queued before the server is closed are still dispatched.
+func (server *ServerImpl) dispatchEvents() {
+	defer close(server.eventsDispatched)
+	for {
+		select {
+		case <-server.pendingEvents.notify:
+		case <-server.closed:
+			for _, queued := range server.pendingEvents.take() {
+				server.dispatchEvent(queued.group, queued.event)
+			}
+			return
+		}
+		for _, queued := range server.pendingEvents.take() {
+			server.dispatchEvent(queued.group, queued.event)
+		}
+	}
+}
+
+// dispatchEvent appends `event` to the event log without the payload and the log of the task, which may be large
+// and are only available to the callers allowed to read the task. Webhooks still receive the whole task.
+func (server *ServerImpl) dispatchEvent(group string, event Event) {
+	newEvent := func(task Task) *pb.TaskEvent {
+		return &pb.TaskEvent{
+			Type:  event.Kind,
+			Group: group,
+			ID:    task.ID,
+			Time:  timestamppb.New(event.Time),
+			Task:  taskToProto(task, event.Time),
+		}
+	}
+	server.enqueueWebhook(newEvent(event.Task), &event.Task)
+	stripped := event.Task
+	stripped.Data = ""
+	stripped.Log = ""
+	server.events.append(newEvent(stripped))
+	if len(event.Task.Job) > 0 {
+		server.jobWaiters.wake(group, event.Task.Job)
+	}
+}
+
+// Watch implements the RPC method `TaskMaster.Watch`.
+// The caller must be allowed to manage the watched group, events of other groups are skipped if no group is specified.
+func (server *ServerImpl) Watch(request *pb.WatchRequest, stream pb.TaskMaster_WatchServer) error {
+	if len(request.GetGroup()) > 0 {
+		if err := server.authorizeAdmin(stream.Context(), command{Op: opWatch, Group: request.GetGroup()}); err != nil {
+			return err
+		}
+	}
+	// allowed caches the authorization of the groups of the events.
+	allowed := make(map[string]bool)
+	sequence := server.events.latest()
+	if len(request.GetCursor()) > 0 {
+		var err error
+		if sequence, err = server.events.parseCursor(request.GetCursor()); err != nil {
+			return err
+		}
+	}
+	// Headers tell the client that events from now on are streamed.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+	for {
+		events, last, notify, err := server.events.since(sequence)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if len(request.GetGroup()) > 0 && event.GetGroup() != request.GetGroup() {
+				continue
+			}
+			if len(request.GetID()) > 0 && event.GetID() != request.GetID() {
+				continue
+			}
+			permitted, exists := allowed[event.GetGroup()]
+			if !exists {
+				permitted = server.insertPolicy.Allowed(stream.Context(), event.GetGroup())
+				allowed[event.GetGroup()] = permitted
+			}
+			if !permitted {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+		sequence = last
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-server.draining:
+			return errDraining
+		case <-notify:
+		}
+	}
+}
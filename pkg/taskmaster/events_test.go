@@ -0,0 +1,137 @@
+package taskmaster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// receiveEvents receives `count` events from `stream`.
+func receiveEvents(t *testing.T, stream pb.TaskMaster_WatchClient, count int) []*pb.TaskEvent {
+	events := []*pb.TaskEvent{}
+	for i := 0; i < count; i++ {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	server := createTestServer(t)
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "other", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Extend(ctx, &pb.TaskExtendRequest{Group: "default", ID: resp.GetID(), LoanDuration: durationpb.New(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Finish(ctx, &pb.FinishRequest{Group: "default", ID: resp.GetID(), Failed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CancelTask(ctx, &pb.CancelTaskRequest{Group: "default", ID: resp.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+	events := receiveEvents(t, stream, 5)
+	for i, expected := range []string{taskmaster.EventInserted, taskmaster.EventLeased, taskmaster.EventExtended, taskmaster.EventFailed, taskmaster.EventCancelled} {
+		if events[i].GetType() != expected || events[i].GetID() != resp.GetID() || events[i].GetGroup() != "default" {
+			t.Errorf("expect event %s of task %s, got %v", expected, resp.GetID(), events[i])
+		}
+	}
+	if state := events[4].GetTask().GetState(); state != taskmaster.StateCancelled {
+		t.Errorf("expect the task after the change, got state %s", state)
+	}
+	for _, event := range events {
+		if len(event.GetTask().GetData()) > 0 {
+			t.Errorf("expect events not to carry the data of the task, got %v", event)
+		}
+	}
+
+	// A watch resumed with a cursor receives the events after it.
+	resumed, err := client.Watch(ctx, &pb.WatchRequest{ID: resp.GetID(), Cursor: events[2].GetCursor()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, event := range receiveEvents(t, resumed, 2) {
+		if event.GetCursor() != events[3+i].GetCursor() {
+			t.Errorf("expect event %v, got %v", events[3+i], event)
+		}
+	}
+
+	stale, err := client.Watch(ctx, &pb.WatchRequest{Cursor: "c3RhbGUvMQ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stale.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Errorf("expect cursors of other servers to be rejected, got %v", err)
+	}
+}
+
+func TestWatchAuthorization(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFn()
+	server := createTestServer(t, taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"build": {"ci"}}))
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{Group: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expect watching a group without permission to be denied, got %v", err)
+	}
+
+	watchCtx, cancelWatch := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelWatch()
+	stream, err = client.Watch(watchCtx, &pb.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Insert(contextWithSubject("ci"), &pb.InsertRequest{Group: "build", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if event, err := stream.Recv(); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expect the events of groups without permission to be skipped, got %v, %v", event, err)
+	}
+}
@@ -0,0 +1,146 @@
+package taskmaster
+
+import (
+	"io"
+	"sort"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// exportChunkSize is the maximum size of the chunks streamed by `Export`.
+	exportChunkSize = 1 << 20
+	// DefaultMaxImportSize is the maximum size of the snapshot of each group imported by `Import` if not specified.
+	DefaultMaxImportSize = 256 << 20
+)
+
+// WithMaxImportSize limits the size of the snapshot of each group imported by `Import`,
+// since the snapshot is buffered in memory until it is applied. Defaults to `DefaultMaxImportSize`.
+func WithMaxImportSize(Size int64) ServerOption {
+	return func(server *ServerImpl) {
+		server.maxImportSize = Size
+	}
+}
+
+// Export implements the RPC method `TaskMaster.Export`.
+func (server *ServerImpl) Export(request *pb.ExportRequest, stream pb.TaskMaster_ExportServer) error {
+	groups := request.GetGroups()
+	for _, group := range groups {
+		if err := server.authorizeAdmin(stream.Context(), command{Op: opExport, Group: group}); err != nil {
+			return err
+		}
+	}
+	// Snapshots of all groups are taken at once, before streaming any of them.
+	server.mu.RLock()
+	if len(groups) == 0 {
+		for group := range server.schedulerGroup {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+	}
+	snapshots := make([]*Snapshot, 0, len(groups))
+	for _, group := range groups {
+		scheduler, exists := server.schedulerGroup[group]
+		if !exists {
+			server.mu.RUnlock()
+			return status.Errorf(codes.NotFound, "group `%s` not found", group)
+		}
+		snapshots = append(snapshots, scheduler.GetSnapshot())
+	}
+	server.mu.RUnlock()
+	if len(request.GetGroups()) == 0 {
+		for _, group := range groups {
+			if err := server.authorizeAdmin(stream.Context(), command{Op: opExport, Group: group}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, snapshot := range snapshots {
+		data, err := server.encodeSnapshot(snapshot)
+		if err != nil {
+			return err
+		}
+		for len(data) > 0 {
+			size := len(data)
+			if size > exportChunkSize {
+				size = exportChunkSize
+			}
+			if err := stream.Send(&pb.ExportChunk{Group: groups[i], Data: data[:size]}); err != nil {
+				return err
+			}
+			data = data[size:]
+		}
+	}
+	return nil
+}
+
+// Import implements the RPC method `TaskMaster.Import`.
+func (server *ServerImpl) Import(stream pb.TaskMaster_ImportServer) error {
+	if err := server.checkLeader(stream.Context()); err != nil {
+		return err
+	}
+	response := &pb.ImportResponse{}
+	var current *pb.ImportChunk
+	var data []byte
+	for {
+		chunk, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if current != nil && (err == io.EOF || chunk.GetGroup() != current.GetGroup()) {
+			imported, err := server.importGroup(stream, current, data)
+			if err != nil {
+				return err
+			}
+			response.Groups = append(response.Groups, imported)
+			current, data = nil, nil
+		}
+		if err == io.EOF {
+			return stream.SendAndClose(response)
+		}
+		if current == nil {
+			for _, imported := range response.Groups {
+				if imported.GetGroup() == chunk.GetGroup() {
+					return status.Errorf(codes.InvalidArgument, "chunks of group `%s` are not consecutive", chunk.GetGroup())
+				}
+			}
+			// Unauthorized callers are rejected before their snapshots are buffered.
+			if err := server.authorizeAdmin(stream.Context(), command{Op: opImport, Group: chunk.GetGroup()}); err != nil {
+				return err
+			}
+			current = chunk
+		}
+		if int64(len(data)+len(chunk.GetData())) > server.maxImportSize {
+			return status.Errorf(codes.ResourceExhausted, "the snapshot of group `%s` exceeds %d bytes", chunk.GetGroup(), server.maxImportSize)
+		}
+		data = append(data, chunk.GetData()...)
+	}
+}
+
+// importGroup merges or replaces the group of `chunk` with the snapshot in `data`.
+func (server *ServerImpl) importGroup(stream pb.TaskMaster_ImportServer, chunk *pb.ImportChunk, data []byte) (*pb.ImportedGroup, error) {
+	group := chunk.GetGroup()
+	if err := validateGroupName(group); err != nil {
+		return nil, err
+	}
+	snapshot, err := server.decodeGroupSnapshot(data)
+	if err != nil {
+		return nil, status.Errorf(status.Code(err), "group `%s`: %s", group, status.Convert(err).Message())
+	}
+	cmd := command{Op: opMergeGroup, Group: group, Snapshot: snapshot}
+	if chunk.GetReplace() {
+		cmd = command{Op: opRestoreGroup, Group: group, Force: true, Snapshot: snapshot}
+	}
+	result := server.execute(stream.Context(), cmd)
+	if result.err != nil {
+		return nil, result.err
+	}
+	total := len(snapshot.AvailableTasks) + len(snapshot.FinishedTasks)
+	if chunk.GetReplace() {
+		return &pb.ImportedGroup{Group: group, ImportedCount: int32(total)}, nil
+	}
+	return &pb.ImportedGroup{Group: group, ImportedCount: int32(result.count), SkippedCount: int32(total - result.count)}, nil
+}
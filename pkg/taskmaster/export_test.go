@@ -0,0 +1,121 @@
+package taskmaster_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func dialTestServer(t *testing.T, address string) pb.TaskMasterClient {
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTaskMasterClient(conn)
+}
+
+// copyGroups streams the export of `source` into the import of `target`.
+func copyGroups(t *testing.T, source, target pb.TaskMasterClient) *pb.ImportResponse {
+	ctx := context.Background()
+	export, err := source.Export(ctx, &pb.ExportRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := target.Import(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		chunk, err := export.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&pb.ImportChunk{Group: chunk.GetGroup(), Data: chunk.GetData()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	sourceServer, targetServer := createTestServer(t), createTestServer(t)
+	defer sourceServer.Close()
+	defer targetServer.Close()
+	sourceAddress, _ := serveTestServer(t, sourceServer)
+	targetAddress, _ := serveTestServer(t, targetServer)
+	source, target := dialTestServer(t, sourceAddress), dialTestServer(t, targetAddress)
+
+	for _, client := range []pb.TaskMasterClient{source, source, target} {
+		if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leased, err := source.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := copyGroups(t, source, target)
+	if len(resp.GetGroups()) != 1 || resp.GetGroups()[0].GetImportedCount() != 2 || resp.GetGroups()[0].GetSkippedCount() != 0 {
+		t.Fatalf("unexpected import result %v", resp.GetGroups())
+	}
+	task, err := target.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: leased.GetID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.GetTask().GetState() != "leased" || task.GetTask().GetAttempts() != 1 {
+		t.Errorf("expect the lease to be kept, got %v", task.GetTask())
+	}
+	if resp := copyGroups(t, source, target); resp.GetGroups()[0].GetImportedCount() != 0 || resp.GetGroups()[0].GetSkippedCount() != 2 {
+		t.Errorf("expect known tasks to be skipped, got %v", resp.GetGroups())
+	}
+	if _, err := target.Finish(ctx, &pb.FinishRequest{Group: "default", ID: leased.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := target.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.GetTaskCount() != 2 {
+		t.Errorf("expect 2 active tasks, got %d", settings.GetTaskCount())
+	}
+}
+
+func TestImportLimit(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t, taskmaster.WithMaxImportSize(16))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	client := dialTestServer(t, address)
+
+	stream, err := client.Import(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		// The stream may be closed by the server before the second chunk.
+		if err := stream.Send(&pb.ImportChunk{Group: "default", Data: []byte("0123456789")}); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expect snapshots over the limit to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package taskmaster
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultTenant is the tenant of the tasks inserted without a tenant by unauthenticated callers.
+const DefaultTenant = ""
+
+// minTenantWeight bounds the number of rounds needed to pick a tenant.
+const minTenantWeight = 0.01
+
+// tenantWeight returns the share of `tenant` under fair-share scheduling, 1 if not specified.
+func (settings *GroupSettings) tenantWeight(tenant string) float64 {
+	if weight, ok := settings.TenantWeights[tenant]; ok {
+		if weight < minTenantWeight {
+			return minTenantWeight
+		}
+		return weight
+	}
+	return 1
+}
+
+// ValidateTenantWeights returns error if any weight cannot be used in fair-share scheduling.
+func ValidateTenantWeights(Weights map[string]float64) error {
+	for tenant, weight := range Weights {
+		if weight < minTenantWeight {
+			return fmt.Errorf("weight of tenant `%s` must be at least %v", tenant, minTenantWeight)
+		}
+	}
+	return nil
+}
+
+// nextTenant picks the tenant to lease a task from with deficit round robin.
+//
+// Tenants are visited in the order of their names starting from the cursor. A tenant earns its weight
+// on each visit and spends one on each lease, so tenants with backlogs get leases in proportion to their weights
+// no matter how many tasks each of them has. A tenant without backlog loses its deficit.
+// Must be called with `mu` held, `backlogged` must not be empty.
+func (master *Scheduler) nextTenant(backlogged map[string]*Task) string {
+	tenants := make([]string, 0, len(backlogged))
+	for tenant := range backlogged {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	if master.deficits == nil {
+		master.deficits = make(map[string]float64)
+	}
+	for tenant := range master.deficits {
+		if _, ok := backlogged[tenant]; !ok {
+			delete(master.deficits, tenant)
+		}
+	}
+	i := sort.SearchStrings(tenants, master.cursor)
+	visiting := i < len(tenants) && tenants[i] == master.cursor
+	for ; ; i++ {
+		tenant := tenants[i%len(tenants)]
+		if !visiting {
+			master.cursor = tenant
+			master.deficits[tenant] += master.settings.tenantWeight(tenant)
+		}
+		if master.deficits[tenant] >= 1 {
+			master.deficits[tenant]--
+			return tenant
+		}
+		visiting = false
+	}
+}
+
+func copyDeficits(deficits map[string]float64) map[string]float64 {
+	if len(deficits) == 0 {
+		return nil
+	}
+	copied := make(map[string]float64, len(deficits))
+	for tenant, deficit := range deficits {
+		copied[tenant] = deficit
+	}
+	return copied
+}
@@ -0,0 +1,91 @@
+package taskmaster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// leaseTenants leases `count` tasks and returns the number of tasks leased from each tenant.
+func leaseTenants(t *testing.T, server pb.TaskMasterServer, count int) map[string]int {
+	ctx := context.Background()
+	leased := make(map[string]int)
+	for i := 0; i < count; i++ {
+		resp, err := server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		task, err := server.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: resp.GetID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leased[task.GetTask().GetTenant()]++
+	}
+	return leased
+}
+
+func TestFairShare(t *testing.T) {
+	server := createTestServer(t)
+	ctx := context.Background()
+	for _, tenant := range []struct {
+		name  string
+		count int
+	}{{"flood", 50}, {"alice", 10}, {"bob", 10}} {
+		for i := 0; i < tenant.count; i++ {
+			if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Tenant: tenant.name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Tasks are handed out in the order of creation by default.
+	if leased := leaseTenants(t, server, 4); leased["flood"] != 4 {
+		t.Errorf("expect tasks leased in the order of creation, got %v", leased)
+	}
+
+	if _, err := server.UpdateGroupSettings(ctx, &pb.UpdateGroupSettingsRequest{
+		Group:    "default",
+		Settings: &pb.GroupSettings{FairShare: true, TenantWeights: map[string]float64{"alice": 2, "bob": -1}},
+	}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect negative weights to be rejected, got %v", err)
+	}
+	if _, err := server.UpdateGroupSettings(ctx, &pb.UpdateGroupSettingsRequest{
+		Group:    "default",
+		Settings: &pb.GroupSettings{FairShare: true, TenantWeights: map[string]float64{"alice": 2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	leased := leaseTenants(t, server, 12)
+	if leased["alice"] != 6 || leased["bob"] != 3 || leased["flood"] != 3 {
+		t.Errorf("expect leases in proportion to the weights, got %v", leased)
+	}
+
+	// Tenants without backlog do not take the share of the others.
+	leased = leaseTenants(t, server, 12)
+	if leased["alice"] != 4 || leased["bob"] != 4 || leased["flood"] != 4 {
+		t.Errorf("expect the rest shared by the backlogged tenants, got %v", leased)
+	}
+}
+
+func TestTenantIdentity(t *testing.T) {
+	server := createTestServer(t)
+	ctx := contextWithSubject("alice")
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Tenant: "bob"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expect inserting as another tenant to be rejected, got %v", err)
+	}
+	for _, tenant := range []string{"", "alice"} {
+		resp, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Tenant: tenant})
+		if err != nil {
+			t.Fatal(err)
+		}
+		task, err := server.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: resp.GetID()})
+		if err != nil || task.GetTask().GetTenant() != "alice" {
+			t.Errorf("expect the tenant of the caller, got %v, %v", task, err)
+		}
+	}
+}
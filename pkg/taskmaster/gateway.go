@@ -0,0 +1,381 @@
+package taskmaster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// GatewayPrefix is the URL prefix of the JSON API served by `NewGatewayHandler`.
+const GatewayPrefix = "/api/v1/"
+
+// maxRequestBodySize limits the size of JSON requests accepted by the gateway.
+const maxRequestBodySize = 16 << 20
+
+var gatewayMarshaler = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+
+// httpStatusFromCode maps a gRPC status code to the corresponding HTTP status.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeGatewayError(rw http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(httpStatusFromCode(st.Code()))
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"code":    int(st.Code()),
+		"status":  st.Code().String(),
+		"message": st.Message(),
+	})
+}
+
+func writeGatewayResponse(rw http.ResponseWriter, message proto.Message, err error) {
+	if err != nil {
+		writeGatewayError(rw, err)
+		return
+	}
+	data, err := gatewayMarshaler.Marshal(message)
+	if err != nil {
+		writeGatewayError(rw, status.Errorf(codes.Internal, "cannot encode response: %v", err))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(data)
+}
+
+// readGatewayRequest decodes the JSON body of `r` into `message`. An empty body is allowed.
+func readGatewayRequest(r *http.Request, message proto.Message) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read request: %v", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil
+	}
+	if err := protojson.Unmarshal(data, message); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot decode request: %v", err)
+	}
+	return nil
+}
+
+// readInsertRequest decodes an insert request, which may carry a `command` object instead of the encoded `data`.
+func readInsertRequest(r *http.Request, request *pb.InsertRequest) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read request: %v", err)
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot decode request: %v", err)
+	}
+	if rawCommand, exists := fields["command"]; exists {
+		delete(fields, "command")
+		command := pb.Command{}
+		if err := protojson.Unmarshal(rawCommand, &command); err != nil {
+			return status.Errorf(codes.InvalidArgument, "cannot decode command: %v", err)
+		}
+		encoded, err := proto.Marshal(&command)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "cannot encode command: %v", err)
+		}
+		request.Data = string(encoded)
+		if data, err = json.Marshal(fields); err != nil {
+			return status.Errorf(codes.Internal, "cannot encode request: %v", err)
+		}
+	}
+	if err := protojson.Unmarshal(data, request); err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot decode request: %v", err)
+	}
+	return nil
+}
+
+// contextFromHTTPRequest attaches the peer information of `r` to its context,
+// so the caller is identified the same way as a gRPC caller.
+func contextFromHTTPRequest(r *http.Request) context.Context {
+	p := &peer.Peer{Addr: &net.TCPAddr{}}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p.Addr = addr
+	}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	return peer.NewContext(r.Context(), p)
+}
+
+// checkCrossSiteRequest rejects the requests a browser may send on behalf of another site.
+// Requests other than GET must be JSON, which cross-site forms cannot send and cross-site scripts cannot send
+// without a CORS preflight the gateway never approves, and the origin of browser requests must be the gateway itself.
+func checkCrossSiteRequest(r *http.Request) error {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return status.Errorf(codes.PermissionDenied, "cross-site requests are not allowed")
+	}
+	if origin := r.Header.Get("Origin"); len(origin) > 0 {
+		if originURL, err := url.Parse(origin); err != nil || originURL.Host != r.Host {
+			return status.Errorf(codes.PermissionDenied, "requests from origin `%s` are not allowed", origin)
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return status.Errorf(codes.InvalidArgument, "the content type must be application/json")
+	}
+	return nil
+}
+
+type gatewayHandler struct {
+	server      pb.TaskMasterServer
+	interceptor grpc.UnaryServerInterceptor
+}
+
+// NewGatewayHandler returns a handler serving `server` as a JSON API under `GatewayPrefix`:
+//
+//	GET    /api/v1/groups                               ListGroups
+//	GET    /api/v1/groups/{group}/settings              GetGroupSettings
+//	PUT    /api/v1/groups/{group}/settings              UpdateGroupSettings
+//	POST   /api/v1/groups/{group}/pause                 PauseGroup
+//	POST   /api/v1/groups/{group}/resume                ResumeGroup
+//	POST   /api/v1/groups/{group}/drain                 DrainGroup
+//	DELETE /api/v1/groups/{group}?force=true            DeleteGroup
+//	GET    /api/v1/groups/{group}/tasks?filter=         ListTasks
+//	POST   /api/v1/groups/{group}/tasks                 Insert
+//	POST   /api/v1/groups/{group}/query                 Query
+//	POST   /api/v1/groups/{group}/tasks/{ID}/extend     Extend
+//	POST   /api/v1/groups/{group}/tasks/{ID}/finish     Finish
+//	GET    /api/v1/groups/{group}/tasks/{ID}            GetTask
+//	POST   /api/v1/groups/{group}/tasks/{ID}/cancel     CancelTask
+//	POST   /api/v1/groups/{group}/tasks/{ID}/requeue    RequeueTask
+//	GET    /api/v1/groups/{group}/jobs/{ID}             GetJob
+//	POST   /api/v1/groups/{group}/jobs/{ID}/wait        WaitJob
+//
+// Request and response bodies are the JSON forms of the RPC messages, the group and ID are taken from the path.
+// Requests other than GET must have the content type `application/json` and come from the same origin, see `checkCrossSiteRequest`.
+// Errors are returned with the HTTP status corresponding to the gRPC status code.
+// Calls go through `Interceptors` in order like the calls of a gRPC server, so they are observed the same way.
+func NewGatewayHandler(server pb.TaskMasterServer, Interceptors ...grpc.UnaryServerInterceptor) http.Handler {
+	handler := &gatewayHandler{server: server}
+	if len(Interceptors) > 0 {
+		handler.interceptor = chainInterceptors(Interceptors)
+	}
+	return handler
+}
+
+// chainInterceptors combines `interceptors` into one, the first interceptor is the outermost.
+func chainInterceptors(interceptors []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// gatewayCall is an RPC call decoded from a HTTP request.
+type gatewayCall struct {
+	method  string
+	request proto.Message
+	invoke  func(ctx context.Context, request proto.Message) (proto.Message, error)
+}
+
+func (handler *gatewayHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if err := checkCrossSiteRequest(r); err != nil {
+		writeGatewayError(rw, err)
+		return
+	}
+	call, err := handler.route(r)
+	if err != nil {
+		writeGatewayError(rw, err)
+		return
+	}
+	ctx := contextFromHTTPRequest(r)
+	invoke := func(ctx context.Context, request interface{}) (interface{}, error) {
+		return call.invoke(ctx, request.(proto.Message))
+	}
+	var resp interface{}
+	if handler.interceptor != nil {
+		info := &grpc.UnaryServerInfo{Server: handler.server, FullMethod: "/" + pb.TaskMaster_ServiceDesc.ServiceName + "/" + call.method}
+		resp, err = handler.interceptor(ctx, call.request, info, invoke)
+	} else {
+		resp, err = invoke(ctx, call.request)
+	}
+	if err != nil {
+		writeGatewayError(rw, err)
+		return
+	}
+	writeGatewayResponse(rw, resp.(proto.Message), nil)
+}
+
+// route decodes the RPC call of `r`.
+func (handler *gatewayHandler) route(r *http.Request) (*gatewayCall, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, GatewayPrefix), "/"), "/")
+	if parts[0] != "groups" {
+		return nil, status.Errorf(codes.NotFound, "unknown path `%s`", r.URL.Path)
+	}
+	route := r.Method + " "
+	var group, ID string
+	if len(parts) > 1 {
+		group = parts[1]
+		route = r.Method + " {group}"
+		if len(parts) > 2 {
+			route += "/" + parts[2]
+		}
+		if len(parts) > 3 {
+			ID = parts[3]
+			route += "/{ID}"
+		}
+		if len(parts) > 4 {
+			route += "/" + strings.Join(parts[4:], "/")
+		}
+	}
+
+	server := handler.server
+	switch route {
+	case "GET ":
+		return &gatewayCall{"ListGroups", &pb.ListGroupsRequest{}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.ListGroups(ctx, request.(*pb.ListGroupsRequest))
+		}}, nil
+	case "GET {group}/settings":
+		return &gatewayCall{"GetGroupSettings", &pb.GetGroupSettingsRequest{Group: group}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.GetGroupSettings(ctx, request.(*pb.GetGroupSettingsRequest))
+		}}, nil
+	case "PUT {group}/settings":
+		request := &pb.UpdateGroupSettingsRequest{}
+		if err := readGatewayRequest(r, request); err != nil {
+			return nil, err
+		}
+		request.Group = group
+		return &gatewayCall{"UpdateGroupSettings", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.UpdateGroupSettings(ctx, request.(*pb.UpdateGroupSettingsRequest))
+		}}, nil
+	case "POST {group}/pause":
+		return &gatewayCall{"PauseGroup", &pb.PauseGroupRequest{Group: group}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.PauseGroup(ctx, request.(*pb.PauseGroupRequest))
+		}}, nil
+	case "POST {group}/resume":
+		return &gatewayCall{"ResumeGroup", &pb.ResumeGroupRequest{Group: group}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.ResumeGroup(ctx, request.(*pb.ResumeGroupRequest))
+		}}, nil
+	case "POST {group}/drain":
+		return &gatewayCall{"DrainGroup", &pb.DrainGroupRequest{Group: group}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.DrainGroup(ctx, request.(*pb.DrainGroupRequest))
+		}}, nil
+	case "DELETE {group}":
+		force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+		return &gatewayCall{"DeleteGroup", &pb.DeleteGroupRequest{Group: group, Force: force}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.DeleteGroup(ctx, request.(*pb.DeleteGroupRequest))
+		}}, nil
+	case "GET {group}/tasks":
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		request := &pb.ListTasksRequest{
+			Group:     group,
+			PageSize:  int32(pageSize),
+			PageToken: r.URL.Query().Get("page_token"),
+			Filter:    r.URL.Query().Get("filter"),
+		}
+		return &gatewayCall{"ListTasks", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.ListTasks(ctx, request.(*pb.ListTasksRequest))
+		}}, nil
+	case "POST {group}/tasks":
+		request := &pb.InsertRequest{}
+		if err := readInsertRequest(r, request); err != nil {
+			return nil, err
+		}
+		request.Group = group
+		return &gatewayCall{"Insert", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.Insert(ctx, request.(*pb.InsertRequest))
+		}}, nil
+	case "POST {group}/query":
+		request := &pb.QueryRequest{}
+		if err := readGatewayRequest(r, request); err != nil {
+			return nil, err
+		}
+		request.Group = group
+		return &gatewayCall{"Query", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.Query(ctx, request.(*pb.QueryRequest))
+		}}, nil
+	case "POST {group}/tasks/{ID}/extend":
+		request := &pb.TaskExtendRequest{}
+		if err := readGatewayRequest(r, request); err != nil {
+			return nil, err
+		}
+		request.Group, request.ID = group, ID
+		return &gatewayCall{"Extend", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.Extend(ctx, request.(*pb.TaskExtendRequest))
+		}}, nil
+	case "POST {group}/tasks/{ID}/finish":
+		request := &pb.FinishRequest{}
+		if err := readGatewayRequest(r, request); err != nil {
+			return nil, err
+		}
+		request.Group, request.ID = group, ID
+		return &gatewayCall{"Finish", request, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.Finish(ctx, request.(*pb.FinishRequest))
+		}}, nil
+	case "GET {group}/tasks/{ID}":
+		return &gatewayCall{"GetTask", &pb.GetTaskRequest{Group: group, ID: ID}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.GetTask(ctx, request.(*pb.GetTaskRequest))
+		}}, nil
+	case "POST {group}/tasks/{ID}/cancel":
+		return &gatewayCall{"CancelTask", &pb.CancelTaskRequest{Group: group, ID: ID}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.CancelTask(ctx, request.(*pb.CancelTaskRequest))
+		}}, nil
+	case "POST {group}/tasks/{ID}/requeue":
+		return &gatewayCall{"RequeueTask", &pb.RequeueTaskRequest{Group: group, ID: ID}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.RequeueTask(ctx, request.(*pb.RequeueTaskRequest))
+		}}, nil
+	case "GET {group}/jobs/{ID}":
+		return &gatewayCall{"GetJob", &pb.GetJobRequest{Group: group, Job: ID}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.GetJob(ctx, request.(*pb.GetJobRequest))
+		}}, nil
+	case "POST {group}/jobs/{ID}/wait":
+		return &gatewayCall{"WaitJob", &pb.WaitJobRequest{Group: group, Job: ID}, func(ctx context.Context, request proto.Message) (proto.Message, error) {
+			return server.WaitJob(ctx, request.(*pb.WaitJobRequest))
+		}}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "unknown route `%s %s`", r.Method, r.URL.Path)
+}
@@ -0,0 +1,180 @@
+package taskmaster_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xpy123993/toolbox/pkg/metrics"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+)
+
+func gatewayCall(t *testing.T, method string, url string, body string, expectedStatus int) map[string]interface{} {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != "GET" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != expectedStatus {
+		t.Fatalf("%s %s: expect status %d, got %d: %s", method, url, expectedStatus, resp.StatusCode, data)
+	}
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("cannot decode %s: %v", data, err)
+	}
+	return result
+}
+
+func TestGateway(t *testing.T) {
+	server := httptest.NewServer(taskmaster.NewGatewayHandler(createTestServer(t)))
+	defer server.Close()
+	baseURL := server.URL + taskmaster.GatewayPrefix + "groups"
+
+	inserted := gatewayCall(t, "POST", baseURL+"/default/tasks", `{"command": {"base_command": "echo", "arguments": ["hello"]}}`, http.StatusOK)
+	ID, ok := inserted["ID"].(string)
+	if !ok || len(ID) == 0 {
+		t.Fatalf("unexpected insert response: %v", inserted)
+	}
+
+	groups := gatewayCall(t, "GET", baseURL, "", http.StatusOK)
+	if summaries := groups["groups"].([]interface{}); len(summaries) != 1 || summaries[0].(map[string]interface{})["pending_count"].(float64) != 1 {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+
+	leased := gatewayCall(t, "POST", baseURL+"/default/query", `{"loan_duration": "60s"}`, http.StatusOK)
+	if leased["ID"] != ID {
+		t.Errorf("unexpected query response: %v", leased)
+	}
+	gatewayCall(t, "POST", baseURL+"/default/query", `{"loan_duration": "60s"}`, http.StatusNotFound)
+	gatewayCall(t, "POST", fmt.Sprintf("%s/default/tasks/%s/extend", baseURL, ID), `{"loan_duration": "120s"}`, http.StatusOK)
+
+	tasks := gatewayCall(t, "GET", baseURL+"/default/tasks?page_size=10", "", http.StatusOK)
+	if list := tasks["tasks"].([]interface{}); len(list) != 1 || list[0].(map[string]interface{})["state"] != "leased" {
+		t.Errorf("unexpected tasks: %v", tasks)
+	}
+
+	gatewayCall(t, "POST", fmt.Sprintf("%s/default/tasks/%s/finish", baseURL, ID), "", http.StatusOK)
+	result := gatewayCall(t, "POST", fmt.Sprintf("%s/default/tasks/%s/finish", baseURL, ID), "", http.StatusNotFound)
+	if result["status"] != "NotFound" {
+		t.Errorf("unexpected error response: %v", result)
+	}
+	gatewayCall(t, "POST", baseURL+"/default/tasks", `{"data": 1}`, http.StatusBadRequest)
+}
+
+func TestListTasksPagination(t *testing.T) {
+	server := httptest.NewServer(taskmaster.NewGatewayHandler(createTestServer(t)))
+	defer server.Close()
+	baseURL := server.URL + taskmaster.GatewayPrefix + "groups/default/tasks"
+	for i := 0; i < 5; i++ {
+		gatewayCall(t, "POST", baseURL, fmt.Sprintf(`{"data": "%d"}`, i), http.StatusOK)
+	}
+	seen := map[string]bool{}
+	pageToken := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("too many pages")
+		}
+		page := gatewayCall(t, "GET", baseURL+"?page_size=2&page_token="+pageToken, "", http.StatusOK)
+		for _, task := range page["tasks"].([]interface{}) {
+			seen[task.(map[string]interface{})["ID"].(string)] = true
+		}
+		pageToken = page["next_page_token"].(string)
+		if len(pageToken) == 0 {
+			break
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("expect 5 tasks, got %d", len(seen))
+	}
+}
+
+func TestGatewayTaskActions(t *testing.T) {
+	server := httptest.NewServer(taskmaster.NewGatewayHandler(createTestServer(t)))
+	defer server.Close()
+	baseURL := server.URL + taskmaster.GatewayPrefix + "groups/default/tasks"
+
+	ID := gatewayCall(t, "POST", baseURL, `{"data": "test"}`, http.StatusOK)["ID"].(string)
+	gatewayCall(t, "POST", baseURL+"/"+ID+"/cancel", "", http.StatusOK)
+	task := gatewayCall(t, "GET", baseURL+"/"+ID, "", http.StatusOK)["task"].(map[string]interface{})
+	if task["state"] != "cancelled" {
+		t.Errorf("unexpected task: %v", task)
+	}
+	gatewayCall(t, "POST", baseURL+"/"+ID+"/cancel", "", http.StatusNotFound)
+
+	gatewayCall(t, "POST", baseURL+"/"+ID+"/requeue", "", http.StatusOK)
+	if tasks := gatewayCall(t, "GET", baseURL+"?filter=pending", "", http.StatusOK)["tasks"].([]interface{}); len(tasks) != 1 {
+		t.Errorf("unexpected tasks: %v", tasks)
+	}
+	gatewayCall(t, "GET", baseURL+"/unknown", "", http.StatusNotFound)
+}
+
+func TestGatewayCrossSiteRequests(t *testing.T) {
+	registry := metrics.NewRegistry()
+	latency := registry.NewHistogram("test_rpc_duration_seconds", "Latency.", nil, "method", "code")
+	server := httptest.NewServer(taskmaster.NewGatewayHandler(createTestServer(t), metrics.UnaryServerInterceptor(latency)))
+	defer server.Close()
+	baseURL := server.URL + taskmaster.GatewayPrefix + "groups/default/tasks"
+
+	for _, header := range []map[string]string{
+		{"Content-Type": "text/plain"},
+		{"Content-Type": "application/x-www-form-urlencoded"},
+		{"Content-Type": "application/json", "Origin": "https://evil.example.com"},
+		{"Content-Type": "application/json", "Sec-Fetch-Site": "cross-site"},
+	} {
+		request, err := http.NewRequest("POST", baseURL, strings.NewReader(`{"data": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range header {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("expect the request with %v to be rejected", header)
+		}
+	}
+	// No task is inserted, so the group is not created.
+	gatewayCall(t, "GET", baseURL, "", http.StatusNotFound)
+
+	request, err := http.NewRequest("POST", baseURL, strings.NewReader(`{"data": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("Origin", server.URL)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expect same-origin JSON requests to be accepted, got %d", resp.StatusCode)
+	}
+
+	output := &strings.Builder{}
+	if err := registry.WriteText(output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), `test_rpc_duration_seconds_count{code="OK",method="/proto.TaskMaster/Insert"} 1`) {
+		t.Errorf("expect gateway calls to be observed by the interceptor, got\n%s", output.String())
+	}
+}
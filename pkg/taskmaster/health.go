@@ -0,0 +1,121 @@
+package taskmaster
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthUpdateInterval is the interval the health service is refreshed at.
+const healthUpdateInterval = time.Second
+
+// errDraining is returned by the long-running calls once the server is draining.
+var errDraining = status.Errorf(codes.Unavailable, "the server is shutting down")
+
+// Drain marks the server as shutting down, it is reported as not serving by the health service
+// and long-running calls such as `Watch` and `WaitJob` return so that clients reconnect elsewhere.
+// Other calls are still served until `Shutdown`.
+func (server *ServerImpl) Drain() {
+	server.drainOnce.Do(func() {
+		close(server.draining)
+		// Every service is reported as not serving from now on, later updates are ignored.
+		server.health.Shutdown()
+	})
+}
+
+// Shutdown drains and closes the server, then writes the final snapshots of all groups.
+// It should be called once the RPC services stopped, so no mutation is lost.
+// Members of a cluster persist every mutation, so only followers and standalone servers write snapshots.
+func (server *ServerImpl) Shutdown() error {
+	server.Drain()
+	server.Close()
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	var result error
+	for group, cancelFn := range server.groupCancels {
+		scheduler := server.schedulerGroup[group]
+		cancelFn()
+		<-scheduler.Stopped()
+		if err := scheduler.dumpTo(server.snapshotFile(group)); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// GroupHealthService returns the name of the service reporting the readiness of `Group` to the health service.
+func GroupHealthService(Group string) string {
+	return pb.TaskMaster_ServiceDesc.ServiceName + "/" + Group
+}
+
+// groupHealth returns the status of `group`, which is serving if the server hands out its tasks,
+// that is the server is the leader and the group is neither paused nor draining.
+func (server *ServerImpl) groupHealth(group string) healthpb.HealthCheckResponse_ServingStatus {
+	scheduler, err := server.getScheduler(group)
+	if err != nil {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+	if scheduler.Paused() || scheduler.Draining() || server.checkLeader(context.Background()) != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// updateGroupHealth reports the status of `group` to the health service.
+func (server *ServerImpl) updateGroupHealth(group string) {
+	if len(group) > 0 {
+		server.health.SetServingStatus(GroupHealthService(group), server.groupHealth(group))
+	}
+}
+
+// updateHealth reports the status of the server and all groups to the health service.
+// Groups deleted since the last update are reported as `SERVICE_UNKNOWN`.
+func (server *ServerImpl) updateHealth() {
+	server.mu.RLock()
+	groups := make([]string, 0, len(server.schedulerGroup))
+	for group := range server.schedulerGroup {
+		groups = append(groups, group)
+	}
+	server.mu.RUnlock()
+
+	server.healthMu.Lock()
+	defer server.healthMu.Unlock()
+	server.health.SetServingStatus(pb.TaskMaster_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	known := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		known[group] = true
+		server.updateGroupHealth(group)
+	}
+	for group := range server.healthGroups {
+		if !known[group] {
+			server.health.SetServingStatus(GroupHealthService(group), healthpb.HealthCheckResponse_SERVICE_UNKNOWN)
+		}
+	}
+	server.healthGroups = known
+}
+
+// maintainHealth refreshes the health service until the server is closed,
+// so that changes not made by the calls to this server, such as leadership changes and replicated mutations, are reported.
+func (server *ServerImpl) maintainHealth() {
+	ticker := time.NewTicker(healthUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.closed:
+			return
+		case <-ticker.C:
+			server.updateHealth()
+		}
+	}
+}
+
+// HealthServer returns the standard gRPC health service of the server.
+// The server itself, named by the empty string or `proto.TaskMaster`, is serving until it is drained,
+// and the readiness of each group is reported under the service `proto.TaskMaster/<group>`.
+func (server *ServerImpl) HealthServer() healthpb.HealthServer {
+	return server.health
+}
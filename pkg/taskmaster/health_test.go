@@ -0,0 +1,107 @@
+package taskmaster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerHealth(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t)
+	defer server.Close()
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data"}); err != nil {
+		t.Fatal(err)
+	}
+	health := server.HealthServer()
+	expectStatus := func(service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetStatus() != expected {
+			t.Errorf("expect `%s` to be %v, got %v", service, expected, resp.GetStatus())
+		}
+	}
+	expectStatus("", healthpb.HealthCheckResponse_SERVING)
+	expectStatus(taskmaster.GroupHealthService("default"), healthpb.HealthCheckResponse_SERVING)
+	if _, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: taskmaster.GroupHealthService("unknown")}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect unknown groups to be not found, got %v", err)
+	}
+	if _, err := server.PauseGroup(ctx, &pb.PauseGroupRequest{Group: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(taskmaster.GroupHealthService("default"), healthpb.HealthCheckResponse_NOT_SERVING)
+	if _, err := server.ResumeGroup(ctx, &pb.ResumeGroupRequest{Group: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(taskmaster.GroupHealthService("default"), healthpb.HealthCheckResponse_SERVING)
+
+	address, grpcServer := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	watch, err := pb.NewTaskMasterClient(conn).Watch(ctx, &pb.WatchRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watch.Header(); err != nil {
+		t.Fatal(err)
+	}
+	server.Drain()
+	expectStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	expectStatus(taskmaster.GroupHealthService("default"), healthpb.HealthCheckResponse_NOT_SERVING)
+	for {
+		if _, err := watch.Recv(); err != nil {
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("expect watches to end once draining, got %v", err)
+			}
+			break
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout draining RPCs")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	ctx := context.Background()
+	folder := t.TempDir()
+	server, err := taskmaster.NewTaskMasterServer(folder, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := taskmaster.NewTaskMasterServer(folder, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if _, err := restarted.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: resp.GetID()}); err != nil {
+		t.Errorf("expect the task to be kept by the final snapshot, got %v", err)
+	}
+}
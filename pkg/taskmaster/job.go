@@ -0,0 +1,257 @@
+package taskmaster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// jobPollInterval is the interval `WaitJob` checks the job without new events, such as on followers.
+const jobPollInterval = 5 * time.Second
+
+// JobHistory records the members of a job no longer kept by the scheduler, such as finished tasks out of the retention,
+// so the status of the job still counts them.
+type JobHistory struct {
+	// Removed counts the removed members by their last state.
+	Removed map[string]int `json:"removed"`
+	// RemovedAt is the time the last member was removed, the history is kept for the retention of the group afterwards.
+	RemovedAt time.Time `json:"removed_at"`
+}
+
+func copyJobHistory(history map[string]JobHistory) map[string]JobHistory {
+	copied := make(map[string]JobHistory, len(history))
+	for job, record := range history {
+		removed := make(map[string]int, len(record.Removed))
+		for state, count := range record.Removed {
+			removed[state] = count
+		}
+		copied[job] = JobHistory{Removed: removed, RemovedAt: record.RemovedAt}
+	}
+	return copied
+}
+
+// indexJob adds `task` to the members of its job.
+// Must be called with `mu` held.
+func (master *Scheduler) indexJob(task Task) {
+	if len(task.Job) == 0 {
+		return
+	}
+	members, exists := master.jobMembers[task.Job]
+	if !exists {
+		members = make(map[string]struct{})
+		master.jobMembers[task.Job] = members
+	}
+	members[task.ID] = struct{}{}
+}
+
+// forgetJobMember removes `task` from the members of its job, and records `state` as its last state.
+// Must be called with `mu` held.
+func (master *Scheduler) forgetJobMember(task Task, state string, now time.Time) {
+	if len(task.Job) == 0 {
+		return
+	}
+	if members, exists := master.jobMembers[task.Job]; exists {
+		delete(members, task.ID)
+		if len(members) == 0 {
+			delete(master.jobMembers, task.Job)
+		}
+	}
+	record := master.jobHistory[task.Job]
+	if record.Removed == nil {
+		record.Removed = make(map[string]int)
+	}
+	record.Removed[state]++
+	record.RemovedAt = now
+	master.jobHistory[task.Job] = record
+}
+
+// rebuildJobIndex rebuilds the members of all jobs from the tasks.
+// Must be called with `mu` held.
+func (master *Scheduler) rebuildJobIndex() {
+	master.jobMembers = make(map[string]map[string]struct{})
+	for _, task := range master.ownedTasks {
+		master.indexJob(task)
+	}
+	for _, task := range master.finishedTasks {
+		master.indexJob(task)
+	}
+}
+
+// pruneJobHistory removes the histories of the jobs without members kept since `deadline`.
+// Returns true if any history is removed.
+// Must be called with `mu` held.
+func (master *Scheduler) pruneJobHistory(deadline time.Time) bool {
+	pruned := false
+	for job, record := range master.jobHistory {
+		if _, exists := master.jobMembers[job]; !exists && record.RemovedAt.Before(deadline) {
+			delete(master.jobHistory, job)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// JobStates returns the number of members of `Job` in each state at present,
+// including the members no longer kept by the scheduler.
+func (master *Scheduler) JobStates(Job string) map[string]int {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	now := master.clock.Now()
+	states := make(map[string]int)
+	for ID := range master.jobMembers[Job] {
+		if task, exists := master.ownedTasks[ID]; exists {
+			states[task.StateAt(now)]++
+		} else if task, exists := master.finishedTasks[ID]; exists {
+			states[task.State]++
+		}
+	}
+	for state, count := range master.jobHistory[Job].Removed {
+		states[state] += count
+	}
+	return states
+}
+
+// jobStatus returns the aggregate status of the tasks of `job` in `scheduler`.
+func jobStatus(scheduler *Scheduler, job string) (*pb.JobStatus, error) {
+	if len(job) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "job must not be empty")
+	}
+	result := &pb.JobStatus{Job: job}
+	for state, count := range scheduler.JobStates(job) {
+		result.Total += int32(count)
+		switch state {
+		case StatePending:
+			result.Pending += int32(count)
+		case StateLeased:
+			result.Running += int32(count)
+		case StateDone:
+			result.Done += int32(count)
+		case StateFailed:
+			result.Failed += int32(count)
+		case StateCancelled:
+			result.Cancelled += int32(count)
+		case StateExpired:
+			result.Expired += int32(count)
+		}
+	}
+	if result.Total == 0 {
+		return nil, status.Errorf(codes.NotFound, "no task of job `%s`", job)
+	}
+	return result, nil
+}
+
+// jobTerminal returns true if no task of the job is pending or running.
+func jobTerminal(job *pb.JobStatus) bool {
+	return job.GetPending() == 0 && job.GetRunning() == 0
+}
+
+// jobKey identifies a job across groups.
+type jobKey struct {
+	group string
+	job   string
+}
+
+// jobWaiters wakes the `WaitJob` calls on the events of their jobs only.
+type jobWaiters struct {
+	mu sync.Mutex
+	// notify holds the jobs being waited for, an entry is dropped once woken or left by all its waiters.
+	notify map[jobKey]*jobWaiter
+}
+
+// jobWaiter is the channel shared by the calls waiting for a job.
+type jobWaiter struct {
+	// notify is closed once an event of the job is dispatched.
+	notify chan struct{}
+	// count is the number of calls waiting on `notify`.
+	count int
+}
+
+func newJobWaiters() *jobWaiters {
+	return &jobWaiters{notify: make(map[jobKey]*jobWaiter)}
+}
+
+// wait returns a channel closed once an event of `job` in `group` is dispatched,
+// and a function to be called once the caller stops waiting on the channel.
+func (waiters *jobWaiters) wait(group string, job string) (<-chan struct{}, func()) {
+	waiters.mu.Lock()
+	defer waiters.mu.Unlock()
+	key := jobKey{group: group, job: job}
+	waiter, exists := waiters.notify[key]
+	if !exists {
+		waiter = &jobWaiter{notify: make(chan struct{})}
+		waiters.notify[key] = waiter
+	}
+	waiter.count++
+	return waiter.notify, func() {
+		waiters.mu.Lock()
+		defer waiters.mu.Unlock()
+		waiter.count--
+		// The entry may already be woken and replaced by the next waiters of the job.
+		if waiter.count == 0 && waiters.notify[key] == waiter {
+			delete(waiters.notify, key)
+		}
+	}
+}
+
+// wake wakes the calls waiting for `job` in `group`.
+func (waiters *jobWaiters) wake(group string, job string) {
+	waiters.mu.Lock()
+	defer waiters.mu.Unlock()
+	key := jobKey{group: group, job: job}
+	if waiter, exists := waiters.notify[key]; exists {
+		close(waiter.notify)
+		delete(waiters.notify, key)
+	}
+}
+
+// GetJob implements the RPC method `TaskMaster.GetJob`.
+func (server *ServerImpl) GetJob(ctx context.Context, request *pb.GetJobRequest) (*pb.GetJobResponse, error) {
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	job, err := jobStatus(scheduler, request.GetJob())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetJobResponse{Status: job}, nil
+}
+
+// WaitJob implements the RPC method `TaskMaster.WaitJob`.
+func (server *ServerImpl) WaitJob(ctx context.Context, request *pb.WaitJobRequest) (*pb.WaitJobResponse, error) {
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		// The channel is taken before checking the job, so no event in between is missed.
+		notify, release := server.jobWaiters.wait(request.GetGroup(), request.GetJob())
+		job, err := jobStatus(scheduler, request.GetJob())
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if jobTerminal(job) {
+			release()
+			return &pb.WaitJobResponse{Status: job}, nil
+		}
+		select {
+		case <-ctx.Done():
+			err = status.FromContextError(ctx.Err()).Err()
+		case <-server.draining:
+			err = errDraining
+		case <-notify:
+		case <-ticker.C:
+		}
+		release()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package taskmaster_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/clock"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+	server := createTestServer(t)
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	IDs := []string{}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "shard", Job: "shards"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		IDs = append(IDs, resp.GetID())
+	}
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetJob(ctx, &pb.GetJobRequest{Group: "default", Job: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect unknown jobs to be not found, got %v", err)
+	}
+	job, err := client.GetJob(ctx, &pb.GetJobRequest{Group: "default", Job: "shards"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.GetStatus().GetTotal() != 3 || job.GetStatus().GetPending() != 3 {
+		t.Errorf("unexpected job status %v", job.GetStatus())
+	}
+
+	waited := make(chan *pb.WaitJobResponse, 1)
+	go func() {
+		resp, err := client.WaitJob(ctx, &pb.WaitJobRequest{Group: "default", Job: "shards"})
+		if err != nil {
+			t.Error(err)
+		}
+		waited <- resp
+	}()
+	if _, err := client.CancelTask(ctx, &pb.CancelTaskRequest{Group: "default", ID: IDs[0]}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Finish(ctx, &pb.FinishRequest{Group: "default", ID: resp.GetID()}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			time.Sleep(100 * time.Millisecond)
+			select {
+			case resp := <-waited:
+				t.Fatalf("expect the wait to block until all tasks are terminal, got %v", resp.GetStatus())
+			default:
+			}
+		}
+	}
+	select {
+	case resp := <-waited:
+		if jobStatus := resp.GetStatus(); jobStatus.GetDone() != 2 || jobStatus.GetCancelled() != 1 || jobStatus.GetTotal() != 3 {
+			t.Errorf("unexpected job status %v", jobStatus)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the job")
+	}
+}
+
+func TestJobHistory(t *testing.T) {
+	snapshotFile := path.Join(t.TempDir(), "test.json")
+	fakeClock := clock.NewFake(time.Now())
+	ctx, cancelFn := context.WithCancel(context.Background())
+	taskMaster, err := taskmaster.NewTaskMasterWithClock(ctx, snapshotFile, time.Minute, fakeClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskMaster.UpdateSettings(taskmaster.GroupSettings{Retention: time.Hour})
+	for i := 0; i < 2; i++ {
+		taskMaster.Insert(taskmaster.Task{Data: "shard", Job: "shards", ExpiresAt: fakeClock.Now().Add(30 * time.Second)})
+	}
+	fakeClock.Advance(time.Millisecond)
+	finished := taskMaster.Lease("worker", time.Minute)
+	if finished == nil || taskMaster.Lease("worker", time.Minute) == nil {
+		t.Fatal("expect both tasks to be leased")
+	}
+	if _, err := taskMaster.Finish(finished.ID, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{taskmaster.StateDone: 1, taskmaster.StateExpired: 1}
+
+	// The expired task is dropped once its lease expires, the finished task is removed after the retention.
+	fakeClock.Advance(time.Minute)
+	waitForFile(t, taskMaster, snapshotFile)
+	if states := taskMaster.JobStates("shards"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expect the dropped task to be counted, got %v", states)
+	}
+	if err := os.Remove(snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Advance(time.Hour)
+	waitForFile(t, taskMaster, snapshotFile)
+	if taskMaster.TaskCount() != 0 || len(taskMaster.Tasks()) != 0 {
+		t.Fatalf("expect all tasks to be removed, got %v", taskMaster.Tasks())
+	}
+	if states := taskMaster.JobStates("shards"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expect the removed tasks to be counted, got %v", states)
+	}
+	cancelFn()
+	<-taskMaster.Stopped()
+
+	ctx, cancelFn = context.WithCancel(context.Background())
+	defer cancelFn()
+	taskMaster, err = taskmaster.NewTaskMasterWithClock(ctx, snapshotFile, time.Minute, fakeClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if states := taskMaster.JobStates("shards"); !reflect.DeepEqual(states, expected) {
+		t.Errorf("expect the history of the job to be persisted, got %v", states)
+	}
+	if err := os.Remove(snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Advance(time.Hour + time.Minute)
+	waitForFile(t, taskMaster, snapshotFile)
+	if states := taskMaster.JobStates("shards"); len(states) != 0 {
+		t.Errorf("expect the history to be removed after the retention, got %v", states)
+	}
+}
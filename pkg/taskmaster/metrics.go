@@ -0,0 +1,69 @@
+package taskmaster
+
+import (
+	"github.com/xpy123993/toolbox/pkg/metrics"
+)
+
+// serverMetrics holds the metrics exported by a task master server.
+type serverMetrics struct {
+	tasks             *metrics.GaugeVec
+	paused            *metrics.GaugeVec
+	inserted          *metrics.CounterVec
+	leased            *metrics.CounterVec
+	leaseExpirations  *metrics.CounterVec
+	finished          *metrics.CounterVec
+	attemptFailures   *metrics.CounterVec
+	queueLatency      *metrics.HistogramVec
+	expirations       *metrics.CounterVec
+	webhookDeliveries *metrics.CounterVec
+}
+
+func newServerMetrics(registry *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		tasks:             registry.NewGauge("taskmaster_tasks", "Number of tasks in each group by state.", "group", "state"),
+		paused:            registry.NewGauge("taskmaster_group_paused", "Whether the group is paused.", "group"),
+		inserted:          registry.NewCounter("taskmaster_tasks_inserted_total", "Number of tasks inserted.", "group"),
+		leased:            registry.NewCounter("taskmaster_leases_total", "Number of leases handed out.", "group"),
+		leaseExpirations:  registry.NewCounter("taskmaster_lease_expirations_total", "Number of tasks leased again after their previous lease expired.", "group"),
+		finished:          registry.NewCounter("taskmaster_tasks_finished_total", "Number of tasks entered a terminal state.", "group", "state"),
+		attemptFailures:   registry.NewCounter("taskmaster_attempt_failures_total", "Number of failed attempts reported by workers.", "group"),
+		queueLatency:      registry.NewHistogram("taskmaster_queue_latency_seconds", "Time between the insertion and the first lease of tasks.", nil, "group"),
+		webhookDeliveries: registry.NewCounter("taskmaster_webhook_deliveries_total", "Number of events delivered to callback URLs, by the result of the delivery.", "group", "result"),
+		expirations:       registry.NewCounter("taskmaster_task_expirations_total", "Number of tasks expired or passed their deadlines, by how they are handled.", "group", "reason"),
+	}
+}
+
+// WithMetrics exports the metrics of the server to `Registry`.
+func WithMetrics(Registry *metrics.Registry) ServerOption {
+	return func(server *ServerImpl) {
+		server.metrics = newServerMetrics(Registry)
+		Registry.OnScrape(server.updateGroupGauges)
+	}
+}
+
+// recordExpiry returns the expiry hook counting the expiry events of `group`.
+// Members of a cluster count the events they apply, so every member reports the same numbers.
+func (server *ServerImpl) recordExpiry(group string) func(string) {
+	return func(reason string) {
+		server.metrics.expirations.With(group, reason).Inc()
+	}
+}
+
+// updateGroupGauges refreshes the gauges derived from the state of each group.
+func (server *ServerImpl) updateGroupGauges() {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	server.metrics.tasks.Reset()
+	server.metrics.paused.Reset()
+	for group, scheduler := range server.schedulerGroup {
+		counts := scheduler.StateCounts()
+		for _, state := range []string{StatePending, StateLeased, StateDone, StateFailed, StateCancelled, StateExpired} {
+			server.metrics.tasks.With(group, state).Set(float64(counts[state]))
+		}
+		paused := 0.
+		if scheduler.Paused() {
+			paused = 1
+		}
+		server.metrics.paused.With(group).Set(paused)
+	}
+}
@@ -0,0 +1,172 @@
+package taskmaster
+
+// Mutation kinds.
+const (
+	// MutationPutTask stores `Task` as an active task.
+	MutationPutTask = "put_task"
+	// MutationFinishTask moves `Task` into its terminal state.
+	MutationFinishTask = "finish_task"
+	// MutationRemoveTask removes the task `TaskID`.
+	MutationRemoveTask = "remove_task"
+	// MutationGroupState replaces the settings, paused and draining states and the job histories of the group.
+	MutationGroupState = "group_state"
+	// MutationDeleteGroup removes the group and all of its tasks.
+	MutationDeleteGroup = "delete_group"
+)
+
+// Mutation describes a state change of a group.
+// Mutations carry the full state of what they change, applying them in order converges to the same state
+// even if some of them are already reflected in the state.
+type Mutation struct {
+	// Epoch and Sequence are the position of the mutation in the log of the leader.
+	Epoch    uint64 `json:"epoch"`
+	Sequence uint64 `json:"sequence"`
+
+	Group    string                `json:"group"`
+	Kind     string                `json:"kind"`
+	Task     *Task                 `json:"task,omitempty"`
+	TaskID   string                `json:"task_id,omitempty"`
+	Settings *GroupSettings        `json:"settings,omitempty"`
+	Paused   bool                  `json:"paused,omitempty"`
+	Draining bool                  `json:"draining,omitempty"`
+	Jobs     map[string]JobHistory `json:"jobs,omitempty"`
+}
+
+// SetMutationHook registers `Hook` to be called on every state change of the scheduler.
+// The hook is called with the scheduler locked, so mutations of the same scheduler are observed in order.
+// `Group` of the mutations is left empty.
+func (master *Scheduler) SetMutationHook(Hook func(Mutation)) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.onMutation = Hook
+}
+
+// record must be called with `mu` held.
+func (master *Scheduler) record(mutation Mutation) {
+	if master.onMutation != nil {
+		master.onMutation(mutation)
+	}
+}
+
+// putTask stores an active task and records the mutation.
+// Must be called with `mu` held.
+func (master *Scheduler) putTask(task Task) {
+	task.expiredLease = false
+	delete(master.finishedTasks, task.ID)
+	master.ownedTasks[task.ID] = task
+	master.indexJob(task)
+	master.unsaved = true
+	master.record(Mutation{Kind: MutationPutTask, Task: &task})
+}
+
+// recordGroupState must be called with `mu` held.
+func (master *Scheduler) recordGroupState() {
+	master.unsaved = true
+	settings := master.settings
+	master.record(Mutation{Kind: MutationGroupState, Settings: &settings, Paused: master.paused, Draining: master.draining,
+		Jobs: copyJobHistory(master.jobHistory)})
+}
+
+// Apply applies a mutation recorded by another scheduler. The mutation hook is not called.
+func (master *Scheduler) Apply(mutation Mutation) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	switch mutation.Kind {
+	case MutationPutTask:
+		if mutation.Task != nil {
+			delete(master.finishedTasks, mutation.Task.ID)
+			master.ownedTasks[mutation.Task.ID] = *mutation.Task
+			master.indexJob(*mutation.Task)
+		}
+	case MutationFinishTask:
+		if mutation.Task != nil {
+			delete(master.ownedTasks, mutation.Task.ID)
+			master.finishedTasks[mutation.Task.ID] = *mutation.Task
+			master.indexJob(*mutation.Task)
+		}
+	case MutationRemoveTask:
+		// Removed tasks are counted by their jobs the same way as by the recording scheduler.
+		if task, exists := master.ownedTasks[mutation.TaskID]; exists {
+			delete(master.ownedTasks, mutation.TaskID)
+			master.forgetJobMember(task, StateExpired, master.clock.Now())
+		} else if task, exists := master.finishedTasks[mutation.TaskID]; exists {
+			delete(master.finishedTasks, mutation.TaskID)
+			master.forgetJobMember(task, task.State, master.clock.Now())
+		}
+	case MutationGroupState:
+		if mutation.Settings != nil {
+			master.settings = *mutation.Settings
+		}
+		master.paused = mutation.Paused
+		master.draining = mutation.Draining
+		master.jobHistory = copyJobHistory(mutation.Jobs)
+	}
+	master.unsaved = true
+}
+
+// Restore replaces the state of the scheduler with `Snapshot`. The mutation hook is not called.
+func (master *Scheduler) Restore(Snapshot *Snapshot) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.ownedTasks = copyTasks(Snapshot.AvailableTasks)
+	master.finishedTasks = copyTasks(Snapshot.FinishedTasks)
+	master.settings = Snapshot.Settings
+	master.paused = Snapshot.Paused
+	master.draining = Snapshot.Draining
+	master.deficits = copyDeficits(Snapshot.Deficits)
+	master.cursor = Snapshot.TenantCursor
+	master.jobHistory = copyJobHistory(Snapshot.Jobs)
+	master.rebuildJobIndex()
+	master.unsaved = true
+}
+
+// Merge adds the tasks of `Snapshot` unknown to the scheduler, keeping their IDs, attempts and leases.
+// Known tasks, the settings and the states of the scheduler are kept. Returns the number of added tasks.
+func (master *Scheduler) Merge(Snapshot *Snapshot) int {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	added := 0
+	for ID, task := range Snapshot.AvailableTasks {
+		if master.knows(ID) {
+			continue
+		}
+		master.putTask(task)
+		added++
+	}
+	for ID, task := range Snapshot.FinishedTasks {
+		if master.knows(ID) {
+			continue
+		}
+		task := task
+		master.finishedTasks[ID] = task
+		master.indexJob(task)
+		master.unsaved = true
+		master.record(Mutation{Kind: MutationFinishTask, Task: &task})
+		added++
+	}
+	return added
+}
+
+// knows must be called with `mu` held.
+func (master *Scheduler) knows(ID string) bool {
+	if _, exists := master.ownedTasks[ID]; exists {
+		return true
+	}
+	_, exists := master.finishedTasks[ID]
+	return exists
+}
+
+// recordSnapshot records the whole state of the scheduler, after the state is replaced by `Restore`.
+func (master *Scheduler) recordSnapshot() {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.recordGroupState()
+	for _, task := range master.ownedTasks {
+		task := task
+		master.record(Mutation{Kind: MutationPutTask, Task: &task})
+	}
+	for _, task := range master.finishedTasks {
+		task := task
+		master.record(Mutation{Kind: MutationFinishTask, Task: &task})
+	}
+}
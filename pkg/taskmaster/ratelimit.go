@@ -0,0 +1,58 @@
+package taskmaster
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of events to `rate` per second, allowing bursts up to `burst` events.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  bucketBurst(rate, burst),
+		tokens: bucketBurst(rate, burst),
+		last:   now,
+	}
+}
+
+// take consumes a token if available at `now`.
+func (bucket *tokenBucket) take(now time.Time) bool {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// refund returns a token consumed by `take` but not used.
+func (bucket *tokenBucket) refund() {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+1)
+}
+
+// limits tells if the bucket enforces `rate` and `burst`.
+func (bucket *tokenBucket) limits(rate float64, burst int) bool {
+	return bucket.rate == rate && bucket.burst == bucketBurst(rate, burst)
+}
+
+// bucketBurst returns the effective burst of a bucket, which defaults to one second of `rate`.
+func bucketBurst(rate float64, burst int) float64 {
+	if burst <= 0 {
+		return math.Max(1, math.Ceil(rate))
+	}
+	return float64(burst)
+}
@@ -0,0 +1,97 @@
+package taskmaster
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// LeaderMetadataKey is the trailer carrying the address of the leader when a follower rejects a call.
+const LeaderMetadataKey = "taskmaster-leader"
+
+// maxLeaderRedirects is the number of redirects followed by a single call.
+const maxLeaderRedirects = 3
+
+type leaderRedirector struct {
+	mu          sync.Mutex
+	dialOptions []grpc.DialOption
+	// leader is the address of the last known leader, empty to use the original connection.
+	leader string
+	conns  map[string]*grpc.ClientConn
+}
+
+// LeaderRedirectInterceptor returns a client interceptor which retries the calls rejected by followers on the leader they report.
+// Only explicit rejections carrying `LeaderMetadataKey` are retried, other errors are returned as the call may have been applied.
+// Calls are sent to the last known leader afterwards, and fall back to the original connection once the leader is unavailable.
+// Connections to the leaders are created with `DialOptions`, which must not include this interceptor.
+func LeaderRedirectInterceptor(DialOptions ...grpc.DialOption) grpc.UnaryClientInterceptor {
+	redirector := &leaderRedirector{
+		dialOptions: DialOptions,
+		conns:       make(map[string]*grpc.ClientConn),
+	}
+	return redirector.intercept
+}
+
+func (redirector *leaderRedirector) leaderConn() *grpc.ClientConn {
+	redirector.mu.Lock()
+	defer redirector.mu.Unlock()
+	if len(redirector.leader) == 0 {
+		return nil
+	}
+	return redirector.conns[redirector.leader]
+}
+
+func (redirector *leaderRedirector) connect(address string) (*grpc.ClientConn, error) {
+	redirector.mu.Lock()
+	defer redirector.mu.Unlock()
+	conn, exists := redirector.conns[address]
+	if !exists {
+		var err error
+		if conn, err = grpc.Dial(address, redirector.dialOptions...); err != nil {
+			return nil, err
+		}
+		redirector.conns[address] = conn
+	}
+	redirector.leader = address
+	return conn, nil
+}
+
+func (redirector *leaderRedirector) forgetLeader() {
+	redirector.mu.Lock()
+	defer redirector.mu.Unlock()
+	redirector.leader = ""
+}
+
+func (redirector *leaderRedirector) intercept(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	conn := redirector.leaderConn()
+	for redirects := 0; ; redirects++ {
+		trailer := metadata.MD{}
+		callOptions := append(opts[:len(opts):len(opts)], grpc.Trailer(&trailer))
+		var err error
+		if conn != nil {
+			err = conn.Invoke(ctx, method, req, reply, callOptions...)
+		} else {
+			err = invoker(ctx, method, req, reply, cc, callOptions...)
+		}
+		if status.Code(err) != codes.Unavailable || redirects >= maxLeaderRedirects {
+			return err
+		}
+		leaders := trailer.Get(LeaderMetadataKey)
+		if len(leaders) == 0 {
+			// Without the trailer, the call may have failed after the leader applied it, such as on a connection reset,
+			// so it is not retried. The next calls fall back to the original connection in case the cached leader is gone.
+			if conn != nil {
+				redirector.forgetLeader()
+			}
+			return err
+		}
+		if conn, err = redirector.connect(leaders[0]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,867 @@
+package taskmaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/raft"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Replication roles.
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+const (
+	// replicationLogSize is the number of recent mutations kept for followers to catch up.
+	// Followers further behind receive the snapshots of all groups instead.
+	replicationLogSize = 4096
+	// replicationHeartbeat is the interval of heartbeats sent to followers when there are no mutations.
+	replicationHeartbeat = time.Second
+	// followRetryInterval is the delay before a follower reconnects to the leader.
+	followRetryInterval = time.Second
+	// replicationStateFile stores the replication state in the snapshot folder.
+	replicationStateFile = "replication.state"
+)
+
+// ReplicationConfig configures the replication of a task master server.
+//
+// Replication is asynchronous: the leader acknowledges mutations before they reach the followers,
+// mutations within the last heartbeat may be lost on failover.
+// A leader which learns a higher epoch from a follower steps down and follows the new leader.
+type ReplicationConfig struct {
+	// AdvertiseAddress is the address of this server reported to the followers and redirected clients.
+	AdvertiseAddress string
+	// Leader is the address of the leader to follow. The server starts as the leader if empty.
+	Leader string
+	// DialOption is used to connect to the other servers.
+	DialOption grpc.DialOption
+	// FailoverTimeout promotes a follower to the leader once the leader is unreachable for this long,
+	// if it reaches more than half of the followers and none of them has a more recent state or still hears from the leader.
+	// Zero means followers are only promoted by `Promote`.
+	//
+	// On the leader, it is the lease of the leader: once it has not heard from at least half of the followers of its epoch
+	// for half of `FailoverTimeout`, the leader stops accepting mutations until they answer again.
+	// Servers of the same replication must use the same value.
+	FailoverTimeout time.Duration
+	// Followers are the client identities allowed to follow this server, `*` matches any authenticated client.
+	// If empty, followers must be allowed to manage every group by the insert policy.
+	Followers []string
+}
+
+// WithReplication enables following a leader and the lease-based promotion.
+// Without this option, the server is a leader without automatic failover.
+func WithReplication(Config ReplicationConfig) ServerOption {
+	return func(server *ServerImpl) {
+		server.replication.config = Config
+	}
+}
+
+// replicationState is persisted in the snapshot folder, so epochs are never reused after restarts.
+type replicationState struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+// replicator holds the replication state of a server.
+type replicator struct {
+	mu     sync.Mutex
+	config ReplicationConfig
+	role   string
+	// leader is the address of the current leader, empty if unknown.
+	leader string
+	// epoch is the highest epoch known by the server, the leader stamps it on new mutations.
+	epoch     uint64
+	stateFile string
+
+	// baseEpoch and baseSequence are the position before the first entry of `entries`.
+	baseEpoch    uint64
+	baseSequence uint64
+	entries      []Mutation
+	// updated is closed and replaced once new entries are appended.
+	updated chan struct{}
+
+	// followers are the addresses of the connected followers, only used by the leader.
+	followers map[string]int
+	// acks are the followers of the current epoch with the time they last answered the leader, only used by the leader.
+	acks map[string]time.Time
+	// peers are the other followers reported by the leader, only used by followers.
+	peers       []string
+	lastContact time.Time
+
+	stopFollowing context.CancelFunc
+	followDone    chan struct{}
+}
+
+func newReplicator(stateFile string) *replicator {
+	return &replicator{
+		role:      RoleLeader,
+		stateFile: stateFile,
+		updated:   make(chan struct{}),
+		followers: make(map[string]int),
+		acks:      make(map[string]time.Time),
+	}
+}
+
+// load reads the persisted epoch, a leader starts a new epoch.
+func (r *replicator) load() error {
+	state := replicationState{}
+	if data, err := os.ReadFile(r.stateFile); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("cannot parse %s: %v", r.stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.epoch = state.Epoch
+	if len(r.config.Leader) > 0 {
+		r.role = RoleFollower
+		r.leader = r.config.Leader
+		return nil
+	}
+	r.epoch++
+	r.leader = r.config.AdvertiseAddress
+	r.baseEpoch = r.epoch
+	return r.persist()
+}
+
+// persist must be called with `mu` held.
+func (r *replicator) persist() error {
+	data, err := json.Marshal(replicationState{Epoch: r.epoch})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.stateFile, data, 0644)
+}
+
+// position returns the position of the last entry, must be called with `mu` held.
+func (r *replicator) position() (uint64, uint64) {
+	if len(r.entries) == 0 {
+		return r.baseEpoch, r.baseSequence
+	}
+	last := r.entries[len(r.entries)-1]
+	return last.Epoch, last.Sequence
+}
+
+// append must be called with `mu` held.
+func (r *replicator) append(mutation Mutation) {
+	if len(r.entries) >= replicationLogSize {
+		dropped := r.entries[0]
+		r.baseEpoch, r.baseSequence = dropped.Epoch, dropped.Sequence
+		r.entries = append(r.entries[:0], r.entries[1:]...)
+	}
+	r.entries = append(r.entries, mutation)
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// record appends a mutation made by this server to the log, ignored unless the server is the leader.
+func (r *replicator) record(mutation Mutation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != RoleLeader {
+		return
+	}
+	_, sequence := r.position()
+	mutation.Epoch = r.epoch
+	mutation.Sequence = sequence + 1
+	r.append(mutation)
+}
+
+// appendReplicated appends a mutation received from the leader.
+func (r *replicator) appendReplicated(mutation Mutation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.append(mutation)
+	if mutation.Epoch > r.epoch {
+		r.epoch = mutation.Epoch
+		if err := r.persist(); err != nil {
+			log.Printf("cannot persist replication state: %v", err)
+		}
+	}
+}
+
+// reset drops the log and starts from the position of a snapshot received from the leader.
+func (r *replicator) reset(epoch uint64, sequence uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+	r.baseEpoch, r.baseSequence = epoch, sequence
+	if epoch > r.epoch {
+		r.epoch = epoch
+		if err := r.persist(); err != nil {
+			log.Printf("cannot persist replication state: %v", err)
+		}
+	}
+}
+
+// entriesAfter returns the entries after the position, and a channel closed on the next append.
+// Returns false if the position is not in the log.
+func (r *replicator) entriesAfter(epoch uint64, sequence uint64) ([]Mutation, <-chan struct{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if epoch == r.baseEpoch && sequence == r.baseSequence {
+		return append([]Mutation(nil), r.entries...), r.updated, true
+	}
+	index := sort.Search(len(r.entries), func(i int) bool { return r.entries[i].Sequence >= sequence })
+	if index == len(r.entries) || r.entries[index].Sequence != sequence || r.entries[index].Epoch != epoch {
+		return nil, r.updated, false
+	}
+	return append([]Mutation(nil), r.entries[index+1:]...), r.updated, true
+}
+
+func (r *replicator) roleAndLeader() (string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role, r.leader
+}
+
+func (r *replicator) setLeader(leader string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leader = leader
+}
+
+// heartbeatInterval returns the interval the leader sends heartbeats to and polls its followers at,
+// short enough that followers with the same failover timeout keep hearing from a quiet leader.
+func (r *replicator) heartbeatInterval() time.Duration {
+	if interval := r.config.FailoverTimeout / 4; interval > 0 && interval < replicationHeartbeat {
+		return interval
+	}
+	return replicationHeartbeat
+}
+
+// contact records a message of the replication stream from the leader.
+func (r *replicator) contact(peers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastContact = time.Now()
+	if peers != nil {
+		r.peers = peers
+	}
+}
+
+// addFollower registers a connected follower, returns the function to unregister it.
+func (r *replicator) addFollower(address string) func() {
+	if len(address) == 0 {
+		return func() {}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.followers[address]++
+	if _, exists := r.acks[address]; !exists {
+		r.acks[address] = time.Now()
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.followers[address]--; r.followers[address] <= 0 {
+			delete(r.followers, address)
+		}
+	}
+}
+
+// otherFollowers returns the followers of the current epoch except `address`.
+// Disconnected followers are kept, so the followers agree on the majority needed for failover.
+func (r *replicator) otherFollowers(address string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	peers := []string{}
+	for follower := range r.acks {
+		if follower != address {
+			peers = append(peers, follower)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// becomeLeader starts a new epoch with this server as the leader.
+func (r *replicator) becomeLeader() (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role == RoleLeader {
+		return r.epoch, status.Errorf(codes.FailedPrecondition, "already the leader")
+	}
+	r.epoch++
+	if err := r.persist(); err != nil {
+		r.epoch--
+		return 0, status.Errorf(codes.Internal, "cannot persist replication state: %v", err)
+	}
+	r.role = RoleLeader
+	r.leader = r.config.AdvertiseAddress
+	r.followers = make(map[string]int)
+	r.acks = make(map[string]time.Time)
+	r.peers = nil
+	return r.epoch, nil
+}
+
+// ack records that `follower` answered the leader at `at`.
+func (r *replicator) ack(follower string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if last, exists := r.acks[follower]; exists && r.role == RoleLeader && at.After(last) {
+		r.acks[follower] = at
+	}
+}
+
+// holdsLease returns false if the leader has not heard from at least half of the followers of its epoch
+// for half of the failover timeout, a follower may be promoted after the whole timeout.
+func (r *replicator) holdsLease(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != RoleLeader || r.config.FailoverTimeout <= 0 || len(r.acks) == 0 {
+		return true
+	}
+	recent := 0
+	for _, ack := range r.acks {
+		if now.Sub(ack) < r.config.FailoverTimeout/2 {
+			recent++
+		}
+	}
+	return recent*2 >= len(r.acks)
+}
+
+// stepDown makes the leader a follower of `leader` if `epoch` is higher than its own.
+// Returns false if the server is not the leader or `epoch` is not newer.
+func (r *replicator) stepDown(epoch uint64, leader string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role != RoleLeader || epoch <= r.epoch {
+		return false
+	}
+	r.epoch = epoch
+	if err := r.persist(); err != nil {
+		log.Printf("cannot persist replication state: %v", err)
+	}
+	r.role = RoleFollower
+	r.leader = leader
+	if leader == r.config.AdvertiseAddress {
+		r.leader = ""
+	}
+	r.followers = make(map[string]int)
+	r.acks = make(map[string]time.Time)
+	return true
+}
+
+// checkLeader returns error if the server does not accept mutations.
+// The address of the leader is attached to the trailer with `LeaderMetadataKey`, see `LeaderRedirectInterceptor`.
+func (server *ServerImpl) checkLeader(ctx context.Context) error {
+	if err := server.checkRole(ctx); err != nil {
+		return err
+	}
+	if server.cluster == nil && !server.replication.holdsLease(time.Now()) {
+		return status.Errorf(codes.Unavailable, "the leader cannot reach its followers, mutations are rejected until they answer")
+	}
+	return nil
+}
+
+// checkRole returns error if the server is not the leader, regardless of its lease.
+func (server *ServerImpl) checkRole(ctx context.Context) error {
+	if server.cluster != nil {
+		status := server.cluster.node.Status()
+		if status.Role == raft.RoleLeader {
+			return nil
+		}
+		return notLeaderError(ctx, status.Leader)
+	}
+	role, leader := server.replication.roleAndLeader()
+	if role == RoleLeader {
+		return nil
+	}
+	return notLeaderError(ctx, leader)
+}
+
+// replicated returns true if the server is configured to replicate its state with other servers.
+// A leader without replication settings can still be followed, see `Follow`.
+func (server *ServerImpl) replicated() bool {
+	config := server.replication.config
+	return server.cluster != nil || len(config.Leader) > 0 || config.FailoverTimeout > 0 || len(config.Followers) > 0
+}
+
+// authorizeFollower returns error if the caller is not allowed to follow this server,
+// the denied request `op` is recorded in the audit log.
+func (server *ServerImpl) authorizeFollower(ctx context.Context, op string) error {
+	followers := server.replication.config.Followers
+	if len(followers) == 0 {
+		return server.authorizeAdmin(ctx, command{Op: op, Group: "*"})
+	}
+	if subject, ok := authenticatedSubject(ctx); ok {
+		for _, follower := range followers {
+			if follower == "*" || follower == subject {
+				return nil
+			}
+		}
+	}
+	err := status.Errorf(codes.PermissionDenied, "`%s` is not allowed to follow this server", CallerIdentity(ctx))
+	server.auditDenial(ctx, command{Op: op}, err)
+	return err
+}
+
+// notLeaderError returns the error of rejecting a mutation when `leader` is the current leader.
+func notLeaderError(ctx context.Context, leader string) error {
+	if len(leader) == 0 {
+		return status.Errorf(codes.Unavailable, "not the leader, the current leader is unknown")
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs(LeaderMetadataKey, leader))
+	return status.Errorf(codes.Unavailable, "not the leader, the current leader is `%s`", leader)
+}
+
+// recordMutation is the mutation hook of the scheduler of `group`.
+func (server *ServerImpl) recordMutation(group string) func(Mutation) {
+	return func(mutation Mutation) {
+		mutation.Group = group
+		server.replication.record(mutation)
+	}
+}
+
+// applyMutation applies a mutation received from the leader.
+func (server *ServerImpl) applyMutation(mutation Mutation) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if mutation.Kind == MutationDeleteGroup {
+		if _, exists := server.schedulerGroup[mutation.Group]; !exists {
+			return nil
+		}
+		return server.removeGroup(mutation.Group)
+	}
+	scheduler, err := server.getOrCreateScheduler(mutation.Group)
+	if err != nil {
+		return err
+	}
+	scheduler.Apply(mutation)
+	if mutation.Kind == MutationGroupState {
+		server.updateLeaseLimiter(mutation.Group, scheduler.Settings())
+	}
+	return nil
+}
+
+// restoreGroup replaces the state of `group` with a snapshot received from the leader.
+func (server *ServerImpl) restoreGroup(group string, snapshot *Snapshot) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	scheduler, err := server.getOrCreateScheduler(group)
+	if err != nil {
+		return err
+	}
+	scheduler.Restore(snapshot)
+	server.updateLeaseLimiter(group, snapshot.Settings)
+	return nil
+}
+
+// retainGroups removes the groups not in `groups`.
+func (server *ServerImpl) retainGroups(groups map[string]bool) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for group := range server.schedulerGroup {
+		if !groups[group] {
+			if err := server.removeGroup(group); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startFollowing starts following the leader in background.
+func (server *ServerImpl) startFollowing() {
+	r := server.replication
+	ctx, cancelFn := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.stopFollowing = cancelFn
+	r.followDone = make(chan struct{})
+	done := r.followDone
+	r.mu.Unlock()
+	go func() {
+		defer close(done)
+		server.follow(ctx)
+	}()
+}
+
+func (server *ServerImpl) follow(ctx context.Context) {
+	r := server.replication
+	r.mu.Lock()
+	r.lastContact = time.Now()
+	r.mu.Unlock()
+	for {
+		if _, leader := r.roleAndLeader(); len(leader) > 0 {
+			if err := server.followOnce(ctx, leader); err != nil && ctx.Err() == nil {
+				log.Printf("replication from `%s` stopped: %v", leader, err)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		r.mu.Lock()
+		failover := r.config.FailoverTimeout > 0 && time.Since(r.lastContact) > r.config.FailoverTimeout
+		r.mu.Unlock()
+		if failover && server.tryFailover(ctx) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryInterval):
+		}
+	}
+}
+
+// followOnce applies the replication stream of `leader` until it breaks.
+func (server *ServerImpl) followOnce(ctx context.Context, leader string) error {
+	r := server.replication
+	conn, err := grpc.DialContext(ctx, leader, r.config.DialOption)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r.mu.Lock()
+	epoch, sequence := r.position()
+	r.mu.Unlock()
+	trailer := metadata.MD{}
+	stream, err := pb.NewTaskMasterReplicationClient(conn).Follow(ctx, &pb.FollowRequest{
+		Epoch:    epoch,
+		Sequence: sequence,
+		Follower: r.config.AdvertiseAddress,
+	}, grpc.MaxCallRecvMsgSize(math.MaxInt32), grpc.Trailer(&trailer))
+	if err != nil {
+		return err
+	}
+	// received is not nil while receiving the snapshots of groups.
+	var received map[string]bool
+	var resetEpoch, resetSequence uint64
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if leaders := trailer.Get(LeaderMetadataKey); status.Code(err) == codes.Unavailable && len(leaders) > 0 {
+				r.setLeader(leaders[0])
+			}
+			return err
+		}
+		r.contact(event.GetPeers())
+		switch {
+		case event.GetReset_():
+			received = make(map[string]bool)
+			resetEpoch, resetSequence = event.GetEpoch(), event.GetSequence()
+		case len(event.GetSnapshots()) > 0:
+			for group, data := range event.GetSnapshots() {
+				snapshot, err := DecodeSnapshot(data)
+				if err != nil {
+					return fmt.Errorf("cannot decode snapshot of group `%s`: %v", group, err)
+				}
+				if err := server.restoreGroup(group, snapshot); err != nil {
+					return err
+				}
+				if received != nil {
+					received[group] = true
+				}
+			}
+		default:
+			if received != nil {
+				if err := server.retainGroups(received); err != nil {
+					return err
+				}
+				r.reset(resetEpoch, resetSequence)
+				received = nil
+				log.Printf("replicated the snapshots from `%s`", leader)
+			}
+			if len(event.GetMutation()) == 0 {
+				continue
+			}
+			mutation := Mutation{}
+			if err := json.Unmarshal(event.GetMutation(), &mutation); err != nil {
+				return fmt.Errorf("cannot decode mutation: %v", err)
+			}
+			if err := server.applyMutation(mutation); err != nil {
+				return err
+			}
+			r.appendReplicated(mutation)
+		}
+	}
+}
+
+// tryFailover promotes this server if it reaches more than half of the followers, and no reachable peer
+// is the leader, has a more recent state or still hears from the leader. Returns true if promoted.
+// The leader stops accepting mutations before, as it cannot hear from at least half of the followers either.
+func (server *ServerImpl) tryFailover(ctx context.Context) bool {
+	r := server.replication
+	r.mu.Lock()
+	leader, peers := r.leader, r.peers
+	epoch, sequence := r.position()
+	self := r.config.AdvertiseAddress
+	failoverTimeout := r.config.FailoverTimeout
+	r.mu.Unlock()
+	// reachable counts the followers reached including this server.
+	reachable := 1
+	for i, peer := range append([]string{leader}, peers...) {
+		if len(peer) == 0 || peer == self {
+			continue
+		}
+		resp, err := server.peerStatus(ctx, peer, &pb.GetReplicationStatusRequest{})
+		if err != nil {
+			continue
+		}
+		if resp.GetRole() == RoleLeader {
+			r.setLeader(peer)
+			return false
+		}
+		if resp.GetEpoch() > epoch || (resp.GetEpoch() == epoch && resp.GetSequence() > sequence) ||
+			(resp.GetEpoch() == epoch && resp.GetSequence() == sequence && peer < self) {
+			// The peer is a better candidate, it is expected to be promoted.
+			return false
+		}
+		if resp.GetLeader() == leader && resp.GetLastContact() != nil && time.Since(resp.GetLastContact().AsTime()) < failoverTimeout {
+			// The leader is only unreachable from this server.
+			return false
+		}
+		if i > 0 {
+			reachable++
+		}
+	}
+	if reachable*2 <= len(peers)+1 {
+		log.Printf("the leader is unreachable, but only %d of %d followers are reachable", reachable, len(peers)+1)
+		return false
+	}
+	newEpoch, err := r.becomeLeader()
+	if err != nil {
+		log.Printf("failover failed: %v", err)
+		return false
+	}
+	log.Printf("the leader is unreachable, promoted to the leader of epoch %d", newEpoch)
+	return true
+}
+
+func (server *ServerImpl) peerStatus(ctx context.Context, peer string, request *pb.GetReplicationStatusRequest) (*pb.GetReplicationStatusResponse, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, replicationHeartbeat)
+	defer cancelFn()
+	conn, err := grpc.DialContext(ctx, peer, server.replication.config.DialOption)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return pb.NewTaskMasterReplicationClient(conn).GetReplicationStatus(ctx, request)
+}
+
+// maintainLease polls the followers of the current epoch while the server is the leader until the server is closed.
+// Answers renew the lease of the leader, see `replicator.holdsLease`, and the leader steps down once
+// a follower reports a higher epoch.
+func (server *ServerImpl) maintainLease() {
+	r := server.replication
+	interval := r.heartbeatInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-server.closed:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		role, epoch, self := r.role, r.epoch, r.config.AdvertiseAddress
+		followers := make([]string, 0, len(r.acks))
+		for follower := range r.acks {
+			followers = append(followers, follower)
+		}
+		r.mu.Unlock()
+		if role != RoleLeader {
+			continue
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), interval)
+		wg := sync.WaitGroup{}
+		for _, follower := range followers {
+			wg.Add(1)
+			go func(follower string) {
+				defer wg.Done()
+				sent := time.Now()
+				resp, err := server.peerStatus(ctx, follower, &pb.GetReplicationStatusRequest{Leader: self})
+				if err != nil {
+					return
+				}
+				if resp.GetKnownEpoch() > epoch {
+					if r.stepDown(resp.GetKnownEpoch(), resp.GetLeader()) {
+						log.Printf("`%s` knows the newer epoch %d, stepped down to follow `%s`", follower, resp.GetKnownEpoch(), resp.GetLeader())
+						server.startFollowing()
+					}
+					return
+				}
+				if resp.GetRole() == RoleFollower && resp.GetLeader() == self {
+					r.ack(follower, sent)
+				}
+			}(follower)
+		}
+		wg.Wait()
+		cancelFn()
+	}
+}
+
+// Promote stops following the leader and makes this server the leader of a new epoch.
+func (server *ServerImpl) Promote() (uint64, error) {
+	r := server.replication
+	r.mu.Lock()
+	stopFollowing, followDone := r.stopFollowing, r.followDone
+	r.mu.Unlock()
+	if stopFollowing != nil {
+		stopFollowing()
+		<-followDone
+	}
+	return r.becomeLeader()
+}
+
+// replicationService implements the RPC service `TaskMasterReplication` of a task master server.
+type replicationService struct {
+	pb.UnimplementedTaskMasterReplicationServer
+
+	server *ServerImpl
+}
+
+// ReplicationServer returns the implementation of the RPC service `TaskMasterReplication` of the server.
+func (server *ServerImpl) ReplicationServer() pb.TaskMasterReplicationServer {
+	return &replicationService{server: server}
+}
+
+// sendSnapshots sends the snapshots of all groups, returns the position the follower continues from.
+func (service *replicationService) sendSnapshots(stream pb.TaskMasterReplication_FollowServer) (uint64, uint64, error) {
+	r := service.server.replication
+	r.mu.Lock()
+	epoch, sequence := r.position()
+	r.mu.Unlock()
+	// Mutations after the position may already be reflected in the snapshots, they converge after being applied again.
+	if err := stream.Send(&pb.ReplicationEvent{Epoch: epoch, Sequence: sequence, Reset_: true}); err != nil {
+		return 0, 0, err
+	}
+	service.server.mu.RLock()
+	schedulers := make(map[string]*Scheduler, len(service.server.schedulerGroup))
+	for group, scheduler := range service.server.schedulerGroup {
+		schedulers[group] = scheduler
+	}
+	service.server.mu.RUnlock()
+	for group, scheduler := range schedulers {
+		data, err := json.Marshal(scheduler.GetSnapshot())
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := stream.Send(&pb.ReplicationEvent{Epoch: epoch, Sequence: sequence, Snapshots: map[string][]byte{group: data}}); err != nil {
+			return 0, 0, err
+		}
+	}
+	return epoch, sequence, stream.Send(&pb.ReplicationEvent{Epoch: epoch, Sequence: sequence})
+}
+
+// Follow implements the RPC method `TaskMasterReplication.Follow`.
+// Followers are not refused when the lease of the leader expires, so they can answer again.
+func (service *replicationService) Follow(request *pb.FollowRequest, stream pb.TaskMasterReplication_FollowServer) error {
+	if err := service.server.authorizeFollower(stream.Context(), opFollow); err != nil {
+		return err
+	}
+	if err := service.server.checkRole(stream.Context()); err != nil {
+		return err
+	}
+	if service.server.blobs != nil || service.server.artifacts != nil {
+		return status.Errorf(codes.FailedPrecondition, "blobs and artifacts are only stored on this server, it cannot be followed")
+	}
+	r := service.server.replication
+	defer r.addFollower(request.GetFollower())()
+
+	epoch, sequence := request.GetEpoch(), request.GetSequence()
+	entries, updated, ok := r.entriesAfter(epoch, sequence)
+	if !ok {
+		var err error
+		if epoch, sequence, err = service.sendSnapshots(stream); err != nil {
+			return err
+		}
+		if entries, updated, ok = r.entriesAfter(epoch, sequence); !ok {
+			return status.Errorf(codes.Aborted, "the replication log is truncated while sending snapshots")
+		}
+	}
+	heartbeat := time.NewTicker(r.heartbeatInterval())
+	defer heartbeat.Stop()
+	for {
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.ReplicationEvent{Epoch: entry.Epoch, Sequence: entry.Sequence, Mutation: data}); err != nil {
+				return err
+			}
+			epoch, sequence = entry.Epoch, entry.Sequence
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-updated:
+		case <-heartbeat.C:
+			if err := service.server.checkRole(stream.Context()); err != nil {
+				return err
+			}
+			if err := stream.Send(&pb.ReplicationEvent{
+				Epoch:    epoch,
+				Sequence: sequence,
+				Peers:    r.otherFollowers(request.GetFollower()),
+			}); err != nil {
+				return err
+			}
+		}
+		if entries, updated, ok = r.entriesAfter(epoch, sequence); !ok {
+			return status.Errorf(codes.Aborted, "the follower fell behind the replication log")
+		}
+	}
+}
+
+// GetReplicationStatus implements the RPC method `TaskMasterReplication.GetReplicationStatus`.
+// The caller must be allowed to follow this server, as the status reveals its peers and epochs.
+// Polls do not count as contacts from the leader, only the replication stream does.
+func (service *replicationService) GetReplicationStatus(ctx context.Context, request *pb.GetReplicationStatusRequest) (*pb.GetReplicationStatusResponse, error) {
+	if err := service.server.authorizeFollower(ctx, opReplicationStatus); err != nil {
+		return nil, err
+	}
+	r := service.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	epoch, sequence := r.position()
+	resp := &pb.GetReplicationStatusResponse{
+		Role:       r.role,
+		Leader:     r.leader,
+		Epoch:      epoch,
+		Sequence:   sequence,
+		Peers:      r.peers,
+		KnownEpoch: r.epoch,
+	}
+	if r.role == RoleLeader {
+		resp.Peers = nil
+		for follower := range r.followers {
+			resp.Peers = append(resp.Peers, follower)
+		}
+		sort.Strings(resp.Peers)
+	} else if !r.lastContact.IsZero() {
+		resp.LastContact = timestamppb.New(r.lastContact)
+	}
+	return resp, nil
+}
+
+// Promote implements the RPC method `TaskMasterReplication.Promote`.
+func (service *replicationService) Promote(ctx context.Context, request *pb.PromoteRequest) (*pb.PromoteResponse, error) {
+	if err := service.server.authorizeAdmin(ctx, command{Op: opPromote, Group: "*"}); err != nil {
+		return nil, err
+	}
+	epoch, err := service.server.Promote()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("promoted to the leader of epoch %d", epoch)
+	return &pb.PromoteResponse{Epoch: epoch}, nil
+}
@@ -0,0 +1,307 @@
+package taskmaster_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// serveTestServer serves `server` on a local port, returns the address and the gRPC server.
+func serveTestServer(t *testing.T, server *taskmaster.ServerImpl) (string, *grpc.Server) {
+	return serveTestServerOn(t, listenTestAddress(t), server)
+}
+
+// listenTestAddress listens on a local port, so the address is known before the server is created.
+func listenTestAddress(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+// serveTestServerOn serves `server` on `listener`, returns the address and the gRPC server.
+func serveTestServerOn(t *testing.T, listener net.Listener, server *taskmaster.ServerImpl) (string, *grpc.Server) {
+	grpcServer := grpc.NewServer()
+	grpcServer.RegisterService(&pb.TaskMaster_ServiceDesc, server)
+	grpcServer.RegisterService(&pb.TaskMasterReplication_ServiceDesc, server.ReplicationServer())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String(), grpcServer
+}
+
+func waitForTaskCount(t *testing.T, server *taskmaster.ServerImpl, group string, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if resp, err := server.GetGroupSettings(context.Background(), &pb.GetGroupSettingsRequest{Group: group}); err == nil && int(resp.GetTaskCount()) == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("group `%s` does not reach %d tasks", group, count)
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	leader := createTestServer(t)
+	if _, err := leader.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	leaderAddress, _ := serveTestServer(t, leader)
+
+	follower := createTestServer(t, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		Leader:     leaderAddress,
+		DialOption: grpc.WithInsecure(),
+	}))
+	waitForTaskCount(t, follower, "default", 1)
+
+	conn, err := grpc.Dial(leaderAddress, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	leaderClient := pb.NewTaskMasterClient(conn)
+	if _, err := leaderClient.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaderClient.UpdateGroupSettings(ctx, &pb.UpdateGroupSettingsRequest{Group: "other", Settings: &pb.GroupSettings{MaxAttempts: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	waitForTaskCount(t, follower, "default", 2)
+	waitForTaskCount(t, follower, "other", 0)
+	if resp, err := follower.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "other"}); err != nil || resp.GetSettings().GetMaxAttempts() != 3 {
+		t.Errorf("unexpected settings: %v, %v", resp, err)
+	}
+
+	if _, err := follower.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "rejected"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expect followers to reject mutations, got %v", err)
+	}
+	if _, err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := follower.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}); err != nil {
+		t.Errorf("expect the promoted follower to accept mutations, got %v", err)
+	}
+}
+
+func TestFollowerSkipsExpiry(t *testing.T) {
+	ctx := context.Background()
+	leader := createTestServer(t)
+	resp, err := leader.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", ExpireTime: timestamppb.New(time.Now().Add(50 * time.Millisecond))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderAddress, _ := serveTestServer(t, leader)
+
+	follower, err := taskmaster.NewTaskMasterServer(t.TempDir(), 10*time.Millisecond, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		Leader:     leaderAddress,
+		DialOption: grpc.WithInsecure(),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForTaskCount(t, follower, "default", 1)
+	time.Sleep(200 * time.Millisecond)
+	task, err := follower.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: resp.GetID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.GetTask().GetState() != taskmaster.StatePending {
+		t.Errorf("expect the follower to leave the expiry to the leader, got state `%s`", task.GetTask().GetState())
+	}
+}
+
+func TestLeaderRedirect(t *testing.T) {
+	ctx := context.Background()
+	leader := createTestServer(t)
+	leaderAddress, _ := serveTestServer(t, leader)
+	follower := createTestServer(t, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		Leader:     leaderAddress,
+		DialOption: grpc.WithInsecure(),
+	}))
+	followerAddress, _ := serveTestServer(t, follower)
+
+	conn, err := grpc.Dial(followerAddress, grpc.WithInsecure(), grpc.WithUnaryInterceptor(taskmaster.LeaderRedirectInterceptor(grpc.WithInsecure())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewTaskMasterClient(conn).Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForTaskCount(t, leader, "default", 1)
+	if _, err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unavailableServer fails every insert with `Unavailable`.
+// It redirects to `leader` like a follower if set, otherwise it fails like a broken connection.
+type unavailableServer struct {
+	pb.UnimplementedTaskMasterServer
+
+	leader  string
+	inserts int32
+}
+
+func (server *unavailableServer) Insert(ctx context.Context, request *pb.InsertRequest) (*pb.InsertResponse, error) {
+	atomic.AddInt32(&server.inserts, 1)
+	if len(server.leader) > 0 {
+		grpc.SetTrailer(ctx, metadata.Pairs(taskmaster.LeaderMetadataKey, server.leader))
+		return nil, status.Errorf(codes.Unavailable, "not the leader")
+	}
+	return nil, status.Errorf(codes.Unavailable, "connection reset")
+}
+
+// serveUnavailable serves `server` on a local port, returns the address.
+func serveUnavailable(t *testing.T, server *unavailableServer) string {
+	listener := listenTestAddress(t)
+	grpcServer := grpc.NewServer()
+	grpcServer.RegisterService(&pb.TaskMaster_ServiceDesc, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return listener.Addr().String()
+}
+
+func TestLeaderRedirectNoRetry(t *testing.T) {
+	leader := &unavailableServer{}
+	follower := &unavailableServer{leader: serveUnavailable(t, leader)}
+	conn, err := grpc.Dial(serveUnavailable(t, follower), grpc.WithInsecure(), grpc.WithUnaryInterceptor(taskmaster.LeaderRedirectInterceptor(grpc.WithInsecure())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewTaskMasterClient(conn).Insert(context.Background(), &pb.InsertRequest{Group: "default", Data: "test"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expect the error to be returned, got %v", err)
+	}
+	if follower, leader := atomic.LoadInt32(&follower.inserts), atomic.LoadInt32(&leader.inserts); follower != 1 || leader != 1 {
+		t.Errorf("expect the call to be redirected once and not retried after the leader failed, got %d calls to the follower and %d to the leader", follower, leader)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	leader := createTestServer(t)
+	leaderAddress, leaderServer := serveTestServer(t, leader)
+	follower := createTestServer(t, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		Leader:          leaderAddress,
+		DialOption:      grpc.WithInsecure(),
+		FailoverTimeout: 100 * time.Millisecond,
+	}))
+	if _, err := leader.Insert(context.Background(), &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForTaskCount(t, follower, "default", 1)
+	leaderServer.Stop()
+
+	service := follower.ReplicationServer()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		// Polls naming the lost leader must not hold the failover.
+		resp, err := service.GetReplicationStatus(context.Background(), &pb.GetReplicationStatusRequest{Leader: leaderAddress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetRole() == taskmaster.RoleLeader {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expect the follower to be promoted")
+}
+
+func TestFollowAuthorization(t *testing.T) {
+	for _, option := range []taskmaster.ServerOption{
+		taskmaster.WithReplication(taskmaster.ReplicationConfig{Followers: []string{"replica"}}),
+		taskmaster.WithInsertPolicy(taskmaster.InsertPolicy{"*": {"admin"}}),
+	} {
+		leaderAddress, _ := serveTestServer(t, createTestServer(t, option))
+		conn, err := grpc.Dial(leaderAddress, grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := pb.NewTaskMasterReplicationClient(conn).Follow(context.Background(), &pb.FollowRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expect unauthenticated followers to be rejected, got %v", err)
+		}
+		if _, err := pb.NewTaskMasterReplicationClient(conn).GetReplicationStatus(context.Background(), &pb.GetReplicationStatusRequest{}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expect the replication status to be hidden from unauthenticated callers, got %v", err)
+		}
+		conn.Close()
+	}
+}
+
+// startLeaseTest starts a leader with `FailoverTimeout` and a follower of it.
+func startLeaseTest(t *testing.T, FailoverTimeout time.Duration) (leader *taskmaster.ServerImpl, follower *taskmaster.ServerImpl, followerAddress string, followerServer *grpc.Server) {
+	leaderListener, followerListener := listenTestAddress(t), listenTestAddress(t)
+	leaderAddress, followerAddress := leaderListener.Addr().String(), followerListener.Addr().String()
+	leader = createTestServer(t, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		AdvertiseAddress: leaderAddress,
+		DialOption:       grpc.WithInsecure(),
+		FailoverTimeout:  FailoverTimeout,
+	}))
+	t.Cleanup(leader.Close)
+	serveTestServerOn(t, leaderListener, leader)
+	follower = createTestServer(t, taskmaster.WithReplication(taskmaster.ReplicationConfig{
+		AdvertiseAddress: followerAddress,
+		Leader:           leaderAddress,
+		DialOption:       grpc.WithInsecure(),
+	}))
+	t.Cleanup(follower.Close)
+	_, followerServer = serveTestServerOn(t, followerListener, follower)
+	if _, err := leader.Insert(context.Background(), &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitForTaskCount(t, follower, "default", 1)
+	return leader, follower, followerAddress, followerServer
+}
+
+func TestLeaderLease(t *testing.T) {
+	leader, _, _, followerServer := startLeaseTest(t, 400*time.Millisecond)
+	followerServer.Stop()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		_, err := leader.Insert(context.Background(), &pb.InsertRequest{Group: "default", Data: "test"})
+		if status.Code(err) == codes.Unavailable {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("expect the leader to stop accepting mutations once its follower is unreachable")
+}
+
+func TestLeaderStepsDown(t *testing.T) {
+	leader, follower, followerAddress, _ := startLeaseTest(t, 400*time.Millisecond)
+	if _, err := follower.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	service := leader.ReplicationServer()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := service.GetReplicationStatus(context.Background(), &pb.GetReplicationStatusRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetRole() == taskmaster.RoleFollower && resp.GetLeader() == followerAddress {
+			if _, err := leader.Insert(context.Background(), &pb.InsertRequest{Group: "default", Data: "test"}); status.Code(err) != codes.Unavailable {
+				t.Errorf("expect the old leader to reject mutations, got %v", err)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("expect the old leader to step down and follow the promoted follower")
+}
@@ -0,0 +1,784 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultRingReplicas is the number of points of each backend on the hash ring.
+	DefaultRingReplicas = 128
+	// DefaultRouterRefreshInterval is the interval of discovering the groups of the backends.
+	DefaultRouterRefreshInterval = 30 * time.Second
+)
+
+// HashRing maps groups to backends by consistent hashing.
+// Adding or removing a backend only moves the groups between it and its neighbours on the ring.
+type HashRing struct {
+	points   []uint64
+	backends map[uint64]string
+}
+
+// hashKey returns the FNV-1a hash of `key` mixed by the finalizer of MurmurHash3,
+// which spreads similar keys such as `group-1` and `group-2` over the ring.
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	h := hash.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// NewHashRing places `Replicas` points of each backend on the ring.
+func NewHashRing(Backends []string, Replicas int) *HashRing {
+	if Replicas <= 0 {
+		Replicas = DefaultRingReplicas
+	}
+	ring := &HashRing{backends: make(map[uint64]string)}
+	for _, backend := range Backends {
+		for i := 0; i < Replicas; i++ {
+			point := hashKey(fmt.Sprintf("%s#%d", backend, i))
+			if _, exists := ring.backends[point]; exists {
+				continue
+			}
+			ring.backends[point] = backend
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// Lookup returns the backend of `Group`, empty if the ring has no backends.
+func (ring *HashRing) Lookup(Group string) string {
+	if len(ring.points) == 0 {
+		return ""
+	}
+	hash := hashKey(Group)
+	i := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hash })
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.backends[ring.points[i]]
+}
+
+// RouterConfig configures a router.
+type RouterConfig struct {
+	// Backends are the addresses of the task master servers.
+	Backends []string
+	// DialOption is used to connect to the backends.
+	DialOption grpc.DialOption
+	// Replicas is the number of points of each backend on the hash ring. Defaults to `DefaultRingReplicas`.
+	Replicas int
+	// RefreshInterval is the interval of discovering the groups of the backends. Defaults to `DefaultRouterRefreshInterval`.
+	RefreshInterval time.Duration
+	// Policy authorizes the callers like the insert policy of a server: inserting into and managing a group
+	// require the caller to be allowed for the group, other calls require an authenticated caller.
+	// A nil policy allows everyone.
+	Policy InsertPolicy
+}
+
+// Router implements the `TaskMaster` service by forwarding calls to the backend serving the group.
+//
+// New groups are placed on the hash ring of the backends, existing groups stay on their backends until migrated,
+// so adding backends does not strand any group. The placement is discovered from the backends on start and periodically.
+// The identity of an authenticated caller is forwarded with `CallerMetadataKey`. Backends trusting the router by
+// `TrustedRouterInterceptor` apply their insert policies and lease holders to the caller, others see the router as the caller.
+type Router struct {
+	pb.UnimplementedTaskMasterServer
+
+	policy  InsertPolicy
+	ring    *HashRing
+	clients map[string]pb.TaskMasterClient
+	conns   []*grpc.ClientConn
+
+	mu sync.Mutex
+	// owners maps the known groups to their backends.
+	owners map[string]string
+	// version is increased on every migration, so a refresh started before it is discarded.
+	version uint64
+	// groupLocks are held for read by forwarded calls and for write by migrations.
+	groupLocks map[string]*sync.RWMutex
+
+	stop chan struct{}
+}
+
+// NewRouter connects to the backends and discovers their groups.
+func NewRouter(Config RouterConfig) (*Router, error) {
+	if len(Config.Backends) == 0 {
+		return nil, fmt.Errorf("no backends are specified")
+	}
+	if Config.DialOption == nil {
+		Config.DialOption = grpc.WithInsecure()
+	}
+	if Config.RefreshInterval <= 0 {
+		Config.RefreshInterval = DefaultRouterRefreshInterval
+	}
+	router := &Router{
+		policy:     Config.Policy,
+		ring:       NewHashRing(Config.Backends, Config.Replicas),
+		clients:    make(map[string]pb.TaskMasterClient),
+		owners:     make(map[string]string),
+		groupLocks: make(map[string]*sync.RWMutex),
+		stop:       make(chan struct{}),
+	}
+	for _, backend := range Config.Backends {
+		// Backends may be clusters or replicated, mutations are redirected to their leaders.
+		conn, err := grpc.Dial(backend, Config.DialOption, grpc.WithUnaryInterceptor(LeaderRedirectInterceptor(Config.DialOption)))
+		if err != nil {
+			router.Close()
+			return nil, err
+		}
+		router.conns = append(router.conns, conn)
+		router.clients[backend] = pb.NewTaskMasterClient(conn)
+	}
+	if err := router.refresh(context.Background()); err != nil {
+		log.Printf("warning: cannot discover groups: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(Config.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-router.stop:
+				return
+			case <-ticker.C:
+				if err := router.refresh(context.Background()); err != nil {
+					log.Printf("warning: cannot discover groups: %v", err)
+				}
+			}
+		}
+	}()
+	return router, nil
+}
+
+// Close stops the router and closes the connections to the backends.
+func (router *Router) Close() {
+	select {
+	case <-router.stop:
+	default:
+		close(router.stop)
+	}
+	for _, conn := range router.conns {
+		conn.Close()
+	}
+}
+
+// refresh discovers the groups of all backends.
+// A group found on multiple backends, which happens if a migration is interrupted, keeps its known backend.
+func (router *Router) refresh(ctx context.Context) error {
+	router.mu.Lock()
+	version := router.version
+	router.mu.Unlock()
+	found := make(map[string][]string)
+	for backend, client := range router.clients {
+		resp, err := client.ListGroups(ctx, &pb.ListGroupsRequest{})
+		if err != nil {
+			return fmt.Errorf("backend `%s`: %v", backend, err)
+		}
+		for _, group := range resp.GetGroups() {
+			found[group.GetGroup()] = append(found[group.GetGroup()], backend)
+		}
+	}
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if router.version != version {
+		return nil
+	}
+	owners := make(map[string]string, len(found))
+	for group, backends := range found {
+		owners[group] = backends[0]
+		if len(backends) == 1 {
+			continue
+		}
+		preferred := router.owners[group]
+		if len(preferred) == 0 {
+			preferred = router.ring.Lookup(group)
+		}
+		for _, backend := range backends {
+			if backend == preferred {
+				owners[group] = backend
+			}
+		}
+		log.Printf("warning: group `%s` is found on %v, routing to `%s`", group, backends, owners[group])
+	}
+	router.owners = owners
+	return nil
+}
+
+// Route returns the backend of `Group`, and whether it is placed by a migration instead of the hash ring.
+func (router *Router) Route(Group string) (string, bool) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	expected := router.ring.Lookup(Group)
+	if backend, exists := router.owners[Group]; exists {
+		return backend, backend != expected
+	}
+	return expected, false
+}
+
+func (router *Router) groupLock(group string) *sync.RWMutex {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	lock, exists := router.groupLocks[group]
+	if !exists {
+		lock = &sync.RWMutex{}
+		router.groupLocks[group] = lock
+	}
+	return lock
+}
+
+// setOwner records the backend of `group`, an empty backend removes the group.
+func (router *Router) setOwner(group string, backend string) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if len(backend) == 0 {
+		delete(router.owners, group)
+	} else {
+		router.owners[group] = backend
+	}
+}
+
+// authenticate returns error if the router has a policy and the caller is not authenticated.
+func (router *Router) authenticate(ctx context.Context) error {
+	if router.policy == nil {
+		return nil
+	}
+	if _, ok := authenticatedSubject(ctx); !ok {
+		return status.Errorf(codes.Unauthenticated, "the router requires a client certificate")
+	}
+	return nil
+}
+
+// authorize returns error if the caller is not allowed to insert into or manage `group` by the policy of the router.
+func (router *Router) authorize(ctx context.Context, group string) error {
+	if !router.policy.Allowed(ctx, group) {
+		return status.Errorf(codes.PermissionDenied, "`%s` is not allowed to manage group `%s`", CallerIdentity(ctx), group)
+	}
+	return nil
+}
+
+// outgoingContext forwards the identity of the authenticated caller to the backends.
+func outgoingContext(ctx context.Context) context.Context {
+	if subject, ok := authenticatedSubject(ctx); ok {
+		return metadata.AppendToOutgoingContext(ctx, CallerMetadataKey, subject)
+	}
+	return ctx
+}
+
+// forward calls the backend of `group`, the group will be owned by the backend if the call succeeds and `creates` is true.
+// The caller must be authorized for the group by `authorize` if the call inserts into or manages the group.
+func (router *Router) forward(ctx context.Context, group string, creates bool, call func(context.Context, pb.TaskMasterClient) error) error {
+	if err := router.authenticate(ctx); err != nil {
+		return err
+	}
+	ctx = outgoingContext(ctx)
+	lock := router.groupLock(group)
+	lock.RLock()
+	defer lock.RUnlock()
+	backend, _ := router.Route(group)
+	if err := call(ctx, router.clients[backend]); err != nil {
+		return err
+	}
+	if creates {
+		router.setOwner(group, backend)
+	}
+	return nil
+}
+
+// MigrateGroup moves `Group` to `Backend` and returns the number of active tasks moved.
+// Forwarded calls of the group wait until the migration completes, leases are kept by the new backend.
+// The backends are called with the identity of the caller of `ctx`, see `Router`.
+func (router *Router) MigrateGroup(ctx context.Context, Group string, Backend string) (int, error) {
+	ctx = outgoingContext(ctx)
+	target, exists := router.clients[Backend]
+	if !exists {
+		return 0, status.Errorf(codes.InvalidArgument, "`%s` is not a backend", Backend)
+	}
+	lock := router.groupLock(Group)
+	lock.Lock()
+	defer lock.Unlock()
+	source, _ := router.Route(Group)
+	if source == Backend {
+		return 0, status.Errorf(codes.FailedPrecondition, "group `%s` is already served by `%s`", Group, Backend)
+	}
+	snapshot, err := router.clients[source].GetGroupSnapshot(ctx, &pb.GetGroupSnapshotRequest{Group: Group})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := target.RestoreGroup(ctx, &pb.RestoreGroupRequest{Group: Group, Snapshot: snapshot.GetSnapshot()})
+	if err != nil {
+		return 0, err
+	}
+	router.mu.Lock()
+	router.owners[Group] = Backend
+	router.version++
+	router.mu.Unlock()
+	if _, err := router.clients[source].DeleteGroup(ctx, &pb.DeleteGroupRequest{Group: Group, Force: true}); err != nil {
+		return 0, status.Errorf(codes.Internal, "group `%s` is moved to `%s` but cannot be deleted from `%s`: %v", Group, Backend, source, err)
+	}
+	return int(resp.GetTaskCount()), nil
+}
+
+// Query implements the RPC method `TaskMaster.Query`.
+func (router *Router) Query(ctx context.Context, request *pb.QueryRequest) (resp *pb.QueryResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.Query(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// Finish implements the RPC method `TaskMaster.Finish`.
+func (router *Router) Finish(ctx context.Context, request *pb.FinishRequest) (resp *pb.FinishResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.Finish(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// Extend implements the RPC method `TaskMaster.Extend`.
+func (router *Router) Extend(ctx context.Context, request *pb.TaskExtendRequest) (resp *pb.TaskExtendResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.Extend(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// Insert implements the RPC method `TaskMaster.Insert`.
+func (router *Router) Insert(ctx context.Context, request *pb.InsertRequest) (resp *pb.InsertResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), true, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.Insert(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// GetGroupSettings implements the RPC method `TaskMaster.GetGroupSettings`.
+func (router *Router) GetGroupSettings(ctx context.Context, request *pb.GetGroupSettingsRequest) (resp *pb.GetGroupSettingsResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.GetGroupSettings(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// UpdateGroupSettings implements the RPC method `TaskMaster.UpdateGroupSettings`.
+func (router *Router) UpdateGroupSettings(ctx context.Context, request *pb.UpdateGroupSettingsRequest) (resp *pb.UpdateGroupSettingsResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), true, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.UpdateGroupSettings(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// PauseGroup implements the RPC method `TaskMaster.PauseGroup`.
+func (router *Router) PauseGroup(ctx context.Context, request *pb.PauseGroupRequest) (resp *pb.PauseGroupResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.PauseGroup(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// ResumeGroup implements the RPC method `TaskMaster.ResumeGroup`.
+func (router *Router) ResumeGroup(ctx context.Context, request *pb.ResumeGroupRequest) (resp *pb.ResumeGroupResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.ResumeGroup(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// DrainGroup implements the RPC method `TaskMaster.DrainGroup`.
+func (router *Router) DrainGroup(ctx context.Context, request *pb.DrainGroupRequest) (resp *pb.DrainGroupResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.DrainGroup(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// DeleteGroup implements the RPC method `TaskMaster.DeleteGroup`.
+func (router *Router) DeleteGroup(ctx context.Context, request *pb.DeleteGroupRequest) (resp *pb.DeleteGroupResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.DeleteGroup(ctx, request)
+		return err
+	})
+	if err == nil {
+		router.setOwner(request.GetGroup(), "")
+	}
+	return resp, err
+}
+
+// ListGroups implements the RPC method `TaskMaster.ListGroups`, the groups of all backends are merged.
+func (router *Router) ListGroups(ctx context.Context, request *pb.ListGroupsRequest) (*pb.ListGroupsResponse, error) {
+	if err := router.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	ctx = outgoingContext(ctx)
+	response := &pb.ListGroupsResponse{}
+	for backend, client := range router.clients {
+		resp, err := client.ListGroups(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range resp.GetGroups() {
+			// Skips the leftovers of interrupted migrations.
+			if owner, _ := router.Route(group.GetGroup()); owner == backend {
+				response.Groups = append(response.Groups, group)
+			}
+		}
+	}
+	sort.Slice(response.Groups, func(i, j int) bool { return response.Groups[i].Group < response.Groups[j].Group })
+	return response, nil
+}
+
+// ListTasks implements the RPC method `TaskMaster.ListTasks`.
+func (router *Router) ListTasks(ctx context.Context, request *pb.ListTasksRequest) (resp *pb.ListTasksResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.ListTasks(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// GetTask implements the RPC method `TaskMaster.GetTask`.
+func (router *Router) GetTask(ctx context.Context, request *pb.GetTaskRequest) (resp *pb.GetTaskResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.GetTask(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// CancelTask implements the RPC method `TaskMaster.CancelTask`.
+func (router *Router) CancelTask(ctx context.Context, request *pb.CancelTaskRequest) (resp *pb.CancelTaskResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.CancelTask(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// RequeueTask implements the RPC method `TaskMaster.RequeueTask`.
+func (router *Router) RequeueTask(ctx context.Context, request *pb.RequeueTaskRequest) (resp *pb.RequeueTaskResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.RequeueTask(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// GetGroupSnapshot implements the RPC method `TaskMaster.GetGroupSnapshot`.
+func (router *Router) GetGroupSnapshot(ctx context.Context, request *pb.GetGroupSnapshotRequest) (resp *pb.GetGroupSnapshotResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.GetGroupSnapshot(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// RestoreGroup implements the RPC method `TaskMaster.RestoreGroup`.
+func (router *Router) RestoreGroup(ctx context.Context, request *pb.RestoreGroupRequest) (resp *pb.RestoreGroupResponse, err error) {
+	if err := router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	err = router.forward(ctx, request.GetGroup(), true, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.RestoreGroup(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// GetBlob implements the RPC method `TaskMaster.GetBlob`.
+func (router *Router) GetBlob(request *pb.GetBlobRequest, stream pb.TaskMaster_GetBlobServer) error {
+	return router.forward(stream.Context(), request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		source, err := client.GetBlob(ctx, request)
+		if err != nil {
+			return err
+		}
+		for {
+			chunk, err := source.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// UploadArtifact implements the RPC method `TaskMaster.UploadArtifact`.
+func (router *Router) UploadArtifact(stream pb.TaskMaster_UploadArtifactServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	return router.forward(stream.Context(), first.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		target, err := client.UploadArtifact(ctx)
+		if err != nil {
+			return err
+		}
+		chunk := first
+		for {
+			if err := target.Send(chunk); err != nil {
+				return err
+			}
+			chunk, err = stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		resp, err := target.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		return stream.SendAndClose(resp)
+	})
+}
+
+// GetArtifact implements the RPC method `TaskMaster.GetArtifact`.
+func (router *Router) GetArtifact(request *pb.GetArtifactRequest, stream pb.TaskMaster_GetArtifactServer) error {
+	return router.forward(stream.Context(), request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		source, err := client.GetArtifact(ctx, request)
+		if err != nil {
+			return err
+		}
+		for {
+			chunk, err := source.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// Watch implements the RPC method `TaskMaster.Watch`, only watching a single group is supported.
+// Cursors are issued by the backend, so watches cannot be resumed after the group is migrated.
+func (router *Router) Watch(request *pb.WatchRequest, stream pb.TaskMaster_WatchServer) error {
+	if len(request.GetGroup()) == 0 {
+		return status.Errorf(codes.InvalidArgument, "the group must be specified to watch through the router")
+	}
+	if err := router.authenticate(stream.Context()); err != nil {
+		return err
+	}
+	backend, _ := router.Route(request.GetGroup())
+	source, err := router.clients[backend].Watch(outgoingContext(stream.Context()), request)
+	if err != nil {
+		return err
+	}
+	header, err := source.Header()
+	if err != nil {
+		return err
+	}
+	if err := stream.SendHeader(header); err != nil {
+		return err
+	}
+	for {
+		event, err := source.Recv()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+}
+
+// GetJob implements the RPC method `TaskMaster.GetJob`.
+func (router *Router) GetJob(ctx context.Context, request *pb.GetJobRequest) (resp *pb.GetJobResponse, err error) {
+	err = router.forward(ctx, request.GetGroup(), false, func(ctx context.Context, client pb.TaskMasterClient) error {
+		resp, err = client.GetJob(ctx, request)
+		return err
+	})
+	return resp, err
+}
+
+// WaitJob implements the RPC method `TaskMaster.WaitJob`.
+// The call does not hold the group like other forwarded calls, so migrations are not blocked by waiting clients.
+func (router *Router) WaitJob(ctx context.Context, request *pb.WaitJobRequest) (*pb.WaitJobResponse, error) {
+	if err := router.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	backend, _ := router.Route(request.GetGroup())
+	return router.clients[backend].WaitJob(outgoingContext(ctx), request)
+}
+
+// Export implements the RPC method `TaskMaster.Export`.
+// Groups on different backends are not snapshotted at the same time.
+func (router *Router) Export(request *pb.ExportRequest, stream pb.TaskMaster_ExportServer) error {
+	groups := request.GetGroups()
+	if len(groups) == 0 {
+		resp, err := router.ListGroups(stream.Context(), &pb.ListGroupsRequest{})
+		if err != nil {
+			return err
+		}
+		for _, group := range resp.GetGroups() {
+			groups = append(groups, group.GetGroup())
+		}
+	}
+	for _, group := range groups {
+		if err := router.authorize(stream.Context(), group); err != nil {
+			return err
+		}
+		err := router.forward(stream.Context(), group, false, func(ctx context.Context, client pb.TaskMasterClient) error {
+			source, err := client.Export(ctx, &pb.ExportRequest{Groups: []string{group}})
+			if err != nil {
+				return err
+			}
+			for {
+				chunk, err := source.Recv()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := stream.Send(chunk); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import implements the RPC method `TaskMaster.Import`, each group is imported into its backend.
+func (router *Router) Import(stream pb.TaskMaster_ImportServer) error {
+	response := &pb.ImportResponse{}
+	chunk, recvErr := stream.Recv()
+	for recvErr == nil {
+		group := chunk.GetGroup()
+		if err := router.authorize(stream.Context(), group); err != nil {
+			return err
+		}
+		// The chunks of the group are streamed to its backend as they arrive, until a chunk of another group.
+		err := router.forward(stream.Context(), group, true, func(ctx context.Context, client pb.TaskMasterClient) error {
+			target, err := client.Import(ctx)
+			if err != nil {
+				return err
+			}
+			for recvErr == nil && chunk.GetGroup() == group {
+				if err := target.Send(chunk); err == io.EOF {
+					// The backend rejected the import, the error is returned by `CloseAndRecv`.
+					break
+				} else if err != nil {
+					return err
+				}
+				chunk, recvErr = stream.Recv()
+			}
+			if recvErr != nil && recvErr != io.EOF {
+				return recvErr
+			}
+			resp, err := target.CloseAndRecv()
+			if err != nil {
+				return err
+			}
+			response.Groups = append(response.Groups, resp.GetGroups()...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if recvErr != io.EOF {
+		return recvErr
+	}
+	return stream.SendAndClose(response)
+}
+
+type routerService struct {
+	pb.UnimplementedTaskMasterRouterServer
+
+	router *Router
+}
+
+// RouterServer returns the `TaskMasterRouter` service managing the placement of groups.
+// Migrations require the caller to be allowed to manage the group by the policy of the router,
+// and the backends authorize the snapshot, restore and deletion of the group as usual.
+func (router *Router) RouterServer() pb.TaskMasterRouterServer {
+	return &routerService{router: router}
+}
+
+// GetRoute implements the RPC method `TaskMasterRouter.GetRoute`.
+func (service *routerService) GetRoute(ctx context.Context, request *pb.GetRouteRequest) (*pb.GetRouteResponse, error) {
+	if err := service.router.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	backend, migrated := service.router.Route(request.GetGroup())
+	return &pb.GetRouteResponse{Backend: backend, Migrated: migrated}, nil
+}
+
+// MigrateGroup implements the RPC method `TaskMasterRouter.MigrateGroup`.
+func (service *routerService) MigrateGroup(ctx context.Context, request *pb.MigrateGroupRequest) (*pb.MigrateGroupResponse, error) {
+	if err := service.router.authorize(ctx, request.GetGroup()); err != nil {
+		return nil, err
+	}
+	taskCount, err := service.router.MigrateGroup(ctx, request.GetGroup(), request.GetBackend())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MigrateGroupResponse{TaskCount: int32(taskCount)}, nil
+}
@@ -0,0 +1,185 @@
+package taskmaster_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := taskmaster.NewHashRing([]string{"a", "b", "c"}, 0)
+	grown := taskmaster.NewHashRing([]string{"a", "b", "c", "d"}, 0)
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		group := fmt.Sprintf("group-%d", i)
+		backend := ring.Lookup(group)
+		if backend != ring.Lookup(group) {
+			t.Fatal("lookup is not stable")
+		}
+		counts[backend]++
+		if newBackend := grown.Lookup(group); newBackend != backend {
+			if newBackend != "d" {
+				t.Errorf("group `%s` moves from `%s` to `%s`", group, backend, newBackend)
+			}
+			moved++
+		}
+	}
+	for _, backend := range []string{"a", "b", "c"} {
+		if counts[backend] < 200 {
+			t.Errorf("unbalanced ring: %v", counts)
+		}
+	}
+	if moved < 100 || moved > 400 {
+		t.Errorf("expect about a quarter of the groups to move, got %d", moved)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	backends := []string{}
+	servers := make(map[string]*taskmaster.ServerImpl)
+	for i := 0; i < 3; i++ {
+		server := createTestServer(t)
+		address, _ := serveTestServer(t, server)
+		backends = append(backends, address)
+		servers[address] = server
+	}
+	router, err := taskmaster.NewRouter(taskmaster.RouterConfig{Backends: backends, DialOption: grpc.WithInsecure()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerServer := grpc.NewServer()
+	routerServer.RegisterService(&pb.TaskMaster_ServiceDesc, router)
+	routerServer.RegisterService(&pb.TaskMasterRouter_ServiceDesc, router.RouterServer())
+	go routerServer.Serve(listener)
+	defer routerServer.Stop()
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	for i := 0; i < 10; i++ {
+		group := fmt.Sprintf("group-%d", i)
+		if _, err := client.Insert(ctx, &pb.InsertRequest{Group: group, Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+		backend, _ := router.Route(group)
+		waitForTaskCount(t, servers[backend], group, 1)
+	}
+	resp, err := client.ListGroups(ctx, &pb.ListGroupsRequest{})
+	if err != nil || len(resp.GetGroups()) != 10 {
+		t.Fatalf("unexpected groups: %v, %v", resp, err)
+	}
+
+	// A leased task can be finished after its group is migrated.
+	leased, err := client.Query(ctx, &pb.QueryRequest{Group: "group-0", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _ := router.Route("group-0")
+	target := backends[0]
+	if target == source {
+		target = backends[1]
+	}
+	admin := pb.NewTaskMasterRouterClient(conn)
+	if _, err := admin.MigrateGroup(ctx, &pb.MigrateGroupRequest{Group: "group-0", Backend: target}); err != nil {
+		t.Fatal(err)
+	}
+	if route, err := admin.GetRoute(ctx, &pb.GetRouteRequest{Group: "group-0"}); err != nil || route.GetBackend() != target || !route.GetMigrated() {
+		t.Errorf("unexpected route: %v, %v", route, err)
+	}
+	if _, err := servers[source].GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "group-0"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect the group to be removed from the source, got %v", err)
+	}
+	if _, err := client.Finish(ctx, &pb.FinishRequest{Group: "group-0", ID: leased.GetID()}); err != nil {
+		t.Errorf("expect the lease to be kept, got %v", err)
+	}
+
+	// The placement is discovered by a new router.
+	discovered, err := taskmaster.NewRouter(taskmaster.RouterConfig{Backends: backends, DialOption: grpc.WithInsecure()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer discovered.Close()
+	if backend, _ := discovered.Route("group-0"); backend != target {
+		t.Errorf("expect group-0 on `%s`, got `%s`", target, backend)
+	}
+
+	// Imported groups are streamed to their backends.
+	exporter := createTestServer(t)
+	defer exporter.Close()
+	exporterAddress, _ := serveTestServer(t, exporter)
+	for _, group := range []string{"imported-a", "imported-b"} {
+		if _, err := exporter.Insert(ctx, &pb.InsertRequest{Group: group, Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imported := copyGroups(t, dialTestServer(t, exporterAddress), client)
+	if len(imported.GetGroups()) != 2 {
+		t.Fatalf("unexpected import result %v", imported.GetGroups())
+	}
+	for _, group := range []string{"imported-a", "imported-b"} {
+		backend, _ := router.Route(group)
+		waitForTaskCount(t, servers[backend], group, 1)
+	}
+}
+
+func TestRouterPolicy(t *testing.T) {
+	listener := listenTestAddress(t)
+	callers := make(chan []string, 1)
+	backend := grpc.NewServer(grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == "/proto.TaskMaster/Insert" {
+			md, _ := metadata.FromIncomingContext(ctx)
+			callers <- md.Get(taskmaster.CallerMetadataKey)
+		}
+		return handler(ctx, req)
+	}))
+	backend.RegisterService(&pb.TaskMaster_ServiceDesc, createTestServer(t))
+	go backend.Serve(listener)
+	defer backend.Stop()
+	router, err := taskmaster.NewRouter(taskmaster.RouterConfig{
+		Backends:   []string{listener.Addr().String()},
+		DialOption: grpc.WithInsecure(),
+		Policy:     taskmaster.InsertPolicy{"build": {"ci"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	for _, ctx := range []context.Context{context.Background(), contextWithSubject("admin")} {
+		if _, err := router.Insert(ctx, &pb.InsertRequest{Group: "build", Data: "test"}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expect inserting without permission to be rejected, got %v", err)
+		}
+		if _, err := router.RouterServer().MigrateGroup(ctx, &pb.MigrateGroupRequest{Group: "build", Backend: listener.Addr().String()}); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expect migrating without permission to be rejected, got %v", err)
+		}
+	}
+	if _, err := router.ListGroups(context.Background(), &pb.ListGroupsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expect unauthenticated callers to be rejected, got %v", err)
+	}
+	if _, err := router.Insert(contextWithSubject("ci"), &pb.InsertRequest{Group: "build", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded := <-callers; len(forwarded) != 1 || forwarded[0] != "ci" {
+		t.Errorf("expect the caller to be forwarded to the backend, got %v", forwarded)
+	}
+}
@@ -2,101 +2,686 @@ package taskmaster
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/xpy123993/toolbox/pkg/clock"
 )
 
 // Task describes a task.
 type Task struct {
 	// ID is the unique identifier of the task.
 	ID string `json:"uuid"`
-	// Data stores content of the task.
+	// Data stores content of the task, empty if the content is stored in the blob store.
 	Data string `json:"data"`
+	// Blob is the hash of the content in the blob store, see `WithBlobStore`.
+	Blob     string `json:"blob,omitempty"`
+	BlobSize int64  `json:"blob_size,omitempty"`
 	// AvailableTime specifies the timestamp of the task to be ready.
 	AvailableTime time.Time `json:"available_timestamp"`
+	// CreatedAt is the timestamp when the task is inserted.
+	CreatedAt time.Time `json:"created_timestamp"`
+	// Attempts is the number of times the task has been leased.
+	Attempts int `json:"attempts,omitempty"`
+	// LeaseHolder is the identity of the last caller leased the task.
+	LeaseHolder string `json:"lease_holder,omitempty"`
+	// Log stores the tail of the output reported by the last attempt.
+	Log string `json:"log,omitempty"`
+	// State is one of the terminal states for finished tasks, empty for active tasks.
+	State string `json:"state,omitempty"`
+	// FinishedAt is the timestamp when the task enters its terminal state.
+	FinishedAt time.Time `json:"finished_timestamp"`
+	// Labels describe the task.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Constraints must be satisfied by the labels of the worker leasing the task, see `MatchConstraints`.
+	Constraints []string `json:"constraints,omitempty"`
+	// Tenant is the owner of the task, tenants share the workers of the group if `FairShare` is set.
+	Tenant string `json:"tenant,omitempty"`
+	// ExpiresAt is the time after which the task is dropped if not leased, zero means never.
+	ExpiresAt time.Time `json:"expires_timestamp"`
+	// Deadline is the time after which the task is cancelled even if it is running, zero means never.
+	Deadline time.Time `json:"deadline_timestamp"`
+	// CallbackURL receives the event once the task is finished, see `WithWebhooks`.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Job groups the tasks inserted together, see `GetJob`.
+	Job string `json:"job,omitempty"`
+	// Limits are the resource limits requested by the task, enforced by the worker running it.
+	Limits *ResourceLimits `json:"limits,omitempty"`
+	// ArtifactPaths are the paths or globs of the files the worker uploads once the task succeeds.
+	ArtifactPaths []string `json:"artifact_paths,omitempty"`
+	// Artifacts are the files uploaded by the worker, see `UploadArtifact`.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// expiredLease is set on the task returned by `Lease` if its previous lease expired.
+	expiredLease bool
+}
+
+// ResourceLimits are the resource limits of a task, zero means the limit of the worker.
+type ResourceLimits struct {
+	CPUSeconds  int64 `json:"cpu_seconds,omitempty"`
+	MemoryBytes int64 `json:"memory_bytes,omitempty"`
+	OpenFiles   int64 `json:"open_files,omitempty"`
+	// OutputBytes is the size of the combined stdout and stderr of the task.
+	OutputBytes int64 `json:"output_bytes,omitempty"`
+}
+
+// Task states.
+const (
+	StatePending   = "pending"
+	StateLeased    = "leased"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+	// StateExpired is the dead-letter state of the tasks expired without being leased.
+	StateExpired = "expired"
+)
+
+// Reasons of expiry events, see `SetExpiryHook`.
+const (
+	// ExpiryDeadLetter moves a task expired without being leased into `StateExpired`.
+	ExpiryDeadLetter = "dead_letter"
+	// ExpiryDropped removes a task expired after failed attempts.
+	ExpiryDropped = "dropped"
+	// ExpiryDeadline cancels a task passed its deadline.
+	ExpiryDeadline = "deadline"
+)
+
+const (
+	// DefaultRetention is how long finished tasks are kept if not specified by the group.
+	DefaultRetention = 24 * time.Hour
+	// DefaultLoanDuration is the lease duration of the requests not asking for one if not specified by the group.
+	DefaultLoanDuration = 5 * time.Minute
+	// FailureRetryDelay is the delay before a failed task can be leased again.
+	FailureRetryDelay = 30 * time.Second
+	// MaxLogSize is the maximum size of the log stored with a task.
+	MaxLogSize = 64 << 10
+)
+
+// localHolder is the lease holder recorded when the caller is not specified.
+const localHolder = "local"
+
+// overdueAt returns true if the task passed its deadline or expiry time at `now`.
+func (task *Task) overdueAt(now time.Time) bool {
+	return (!task.Deadline.IsZero() && !task.Deadline.After(now)) || (!task.ExpiresAt.IsZero() && !task.ExpiresAt.After(now))
+}
+
+// leasedAt returns true if the task is held by a lease at `now`.
+func (task *Task) leasedAt(now time.Time) bool {
+	return len(task.State) == 0 && len(task.LeaseHolder) > 0 && task.AvailableTime.After(now)
+}
+
+// StateAt returns the state of the task at `now`.
+func (task *Task) StateAt(now time.Time) string {
+	if len(task.State) > 0 {
+		return task.State
+	}
+	if task.leasedAt(now) {
+		return StateLeased
+	}
+	return StatePending
+}
+
+// GroupSettings describes the settings of a task group.
+type GroupSettings struct {
+	// MaxConcurrency caps the number of tasks leased at once. Zero means unlimited.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// LeaseRate caps the number of leases handed out per second. Zero means unlimited.
+	LeaseRate float64 `json:"lease_rate,omitempty"`
+	// LeaseBurst is the number of leases can be handed out at once under `LeaseRate`.
+	LeaseBurst int `json:"lease_burst,omitempty"`
+	// MaxAttempts is the number of failed attempts before a task is marked as failed. Zero means unlimited.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Retention is how long finished tasks are kept. Zero means `DefaultRetention`.
+	Retention time.Duration `json:"retention,omitempty"`
+	// FairShare hands out the tasks of different tenants in proportion to their weights instead of in the order of creation.
+	FairShare bool `json:"fair_share,omitempty"`
+	// TenantWeights are the weights of tenants under `FairShare`, tenants not listed have weight 1.
+	TenantWeights map[string]float64 `json:"tenant_weights,omitempty"`
+	// CallbackURL receives the events of the tasks without their own callback URLs, see `WithWebhooks`.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// LoanDuration is the lease duration of the queries and extensions not asking for one. Zero means `DefaultLoanDuration`.
+	LoanDuration time.Duration `json:"loan_duration,omitempty"`
+	// MaxLoanDuration caps the lease duration handed out. Zero means unlimited.
+	MaxLoanDuration time.Duration `json:"max_loan_duration,omitempty"`
+}
+
+func (settings *GroupSettings) retention() time.Duration {
+	if settings.Retention > 0 {
+		return settings.Retention
+	}
+	return DefaultRetention
+}
+
+// loanDuration returns the lease duration handed out to a request of `requested`, zero if not specified.
+func (settings *GroupSettings) loanDuration(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		requested = settings.LoanDuration
+	}
+	if requested <= 0 {
+		requested = DefaultLoanDuration
+	}
+	if settings.MaxLoanDuration > 0 && requested > settings.MaxLoanDuration {
+		return settings.MaxLoanDuration
+	}
+	return requested
 }
 
 // Snapshot describes a task master snapshot.
 type Snapshot struct {
+	// Version is the schema version of the snapshot, see `SnapshotVersion` and `DecodeSnapshot`.
+	Version        int             `json:"version"`
 	CreatedAt      time.Time       `json:"creation"`
 	AvailableTasks map[string]Task `json:"tasks"`
+	FinishedTasks  map[string]Task `json:"finished_tasks,omitempty"`
+	Settings       GroupSettings   `json:"settings"`
+	Paused         bool            `json:"paused,omitempty"`
+	Draining       bool            `json:"draining,omitempty"`
+	// Deficits and TenantCursor are the state of fair-share scheduling, see `GroupSettings.FairShare`.
+	Deficits     map[string]float64 `json:"deficits,omitempty"`
+	TenantCursor string             `json:"tenant_cursor,omitempty"`
+	// Jobs are the histories of the jobs with removed members, see `JobHistory`.
+	Jobs map[string]JobHistory `json:"jobs,omitempty"`
 }
 
-// Scheduler stores all the active tasks.
+// Scheduler stores all the active tasks, and the finished tasks within the retention.
 type Scheduler struct {
-	mu         sync.RWMutex
-	unsaved    bool
-	ownedTasks map[string]Task
+	mu            sync.RWMutex
+	unsaved       bool
+	ownedTasks    map[string]Task
+	finishedTasks map[string]Task
+	settings      GroupSettings
+	paused        bool
+	draining      bool
+	// deficits and cursor are the state of the deficit round robin across tenants.
+	deficits map[string]float64
+	cursor   string
+	// jobMembers are the IDs of the tasks of each job, jobHistory records the removed members of each job.
+	jobMembers map[string]map[string]struct{}
+	jobHistory map[string]JobHistory
+	// stopped is closed once the snapshot routine exits.
+	stopped chan struct{}
+	// clock tells the time of the operations without explicit times.
+	clock clock.Clock
+	// onMutation is called with `mu` held on every state change, see `SetMutationHook`.
+	onMutation func(Mutation)
+	// onExpiry is called with `mu` held on every expiry event, see `SetExpiryHook`.
+	onExpiry func(string)
+	// onEvent is called with `mu` held on every change of tasks, see `SetEventHook`.
+	onEvent func(Event)
+	// canPrune tells if the snapshot routine may prune and expire tasks, see `SetPruneCondition`.
+	canPrune func() bool
+}
+
+// leasedCount returns the number of tasks currently leased.
+// Must be called with `mu` held.
+func (master *Scheduler) leasedCount(now time.Time) int {
+	count := 0
+	for _, task := range master.ownedTasks {
+		if task.leasedAt(now) {
+			count++
+		}
+	}
+	return count
 }
 
 // Query returns an available task and marked it as assigned.
 // This task will be available to assign to other callers after the timeout.
-// Returns nil if there is no available task at present.
+// Returns nil if there is no available task at present, the scheduler is paused,
+// or the group has reached its concurrency limit.
 func (master *Scheduler) Query(timeout time.Duration) *Task {
+	return master.Lease("", timeout)
+}
+
+// Lease is the same as `Query` but records `Holder` as the lease holder of the returned task.
+// Available tasks are handed out in the order of their creation, or shared across tenants if `FairShare` is set.
+func (master *Scheduler) Lease(Holder string, timeout time.Duration) *Task {
+	return master.LeaseMatching(Holder, nil, timeout)
+}
+
+// LeaseMatching is the same as `Lease` but only hands out tasks whose constraints are satisfied by `Labels`.
+func (master *Scheduler) LeaseMatching(Holder string, Labels map[string]string, timeout time.Duration) *Task {
+	return master.leaseAt(Holder, Labels, timeout, master.clock.Now())
+}
+
+// leaseAt is `LeaseMatching` at `now`, the result only depends on the state of the scheduler.
+func (master *Scheduler) leaseAt(Holder string, Labels map[string]string, timeout time.Duration, now time.Time) *Task {
+	if len(Holder) == 0 {
+		Holder = localHolder
+	}
 	master.mu.Lock()
 	defer master.mu.Unlock()
-	for ID, task := range master.ownedTasks {
-		if task.AvailableTime.Before(time.Now()) {
-			task.AvailableTime = time.Now().Add(timeout)
-			master.ownedTasks[ID] = task
-			master.unsaved = true
-			return &task
+	if master.paused {
+		return nil
+	}
+	master.expire(now)
+	if master.settings.MaxConcurrency > 0 && master.leasedCount(now) >= master.settings.MaxConcurrency {
+		return nil
+	}
+	// oldest keeps the oldest available task of each tenant, or of the group if not sharing across tenants.
+	oldest := make(map[string]*Task)
+	for _, task := range master.ownedTasks {
+		if !task.AvailableTime.Before(now) || !MatchConstraints(task.Constraints, Labels) {
+			continue
+		}
+		key := DefaultTenant
+		if master.settings.FairShare {
+			key = task.Tenant
+		}
+		if selected, ok := oldest[key]; !ok || task.CreatedAt.Before(selected.CreatedAt) ||
+			(task.CreatedAt.Equal(selected.CreatedAt) && task.ID < selected.ID) {
+			task := task
+			oldest[key] = &task
 		}
 	}
-	return nil
+	if len(oldest) == 0 {
+		return nil
+	}
+	key := DefaultTenant
+	if master.settings.FairShare {
+		key = master.nextTenant(oldest)
+	}
+	task := *oldest[key]
+	expiredLease := len(task.LeaseHolder) > 0
+	task.AvailableTime = now.Add(timeout)
+	if !task.Deadline.IsZero() && task.Deadline.Before(task.AvailableTime) {
+		task.AvailableTime = task.Deadline
+	}
+	task.Attempts++
+	task.LeaseHolder = Holder
+	master.putTask(task)
+	master.emit(EventLeased, task, now)
+	task.expiredLease = expiredLease
+	return &task
+}
+
+// hasAvailable returns true if any task is available at `now` regardless of its constraints.
+func (master *Scheduler) hasAvailable(now time.Time) bool {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	for _, task := range master.ownedTasks {
+		if task.AvailableTime.Before(now) && !task.overdueAt(now) {
+			return true
+		}
+	}
+	return false
 }
 
+// ErrDeadlineExceeded is returned by `ExtendLoan` once the task passed its deadline and is cancelled.
+var ErrDeadlineExceeded = errors.New("the task passed its deadline and is cancelled")
+
+// ExtendLoan extends the lease of an active task until `deadline`, but not after the deadline of the task.
+// The task is cancelled if it already passed its deadline, the lease holder is expected to stop working on it.
 func (master *Scheduler) ExtendLoan(ID string, deadline time.Time) error {
+	return master.extendAt(ID, deadline, master.clock.Now())
+}
+
+func (master *Scheduler) extendAt(ID string, deadline time.Time, now time.Time) error {
 	master.mu.Lock()
 	defer master.mu.Unlock()
 	task, ok := master.ownedTasks[ID]
 	if !ok {
 		return fmt.Errorf("Task `%s` is not found", ID)
 	}
+	if !task.Deadline.IsZero() {
+		if !task.Deadline.After(now) {
+			master.retire(task, StateCancelled, now)
+			master.notifyExpiry(ExpiryDeadline)
+			return ErrDeadlineExceeded
+		}
+		if task.Deadline.Before(deadline) {
+			deadline = task.Deadline
+		}
+	}
 	task.AvailableTime = deadline
-	master.unsaved = true
+	master.putTask(task)
+	master.emit(EventExtended, task, now)
 	return nil
 }
 
+// SetExpiryHook registers `Hook` to be called with the reason of every expiry event of the scheduler.
+// The hook is called with the scheduler locked.
+func (master *Scheduler) SetExpiryHook(Hook func(Reason string)) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.onExpiry = Hook
+}
+
+// SetPruneCondition makes the snapshot routine prune and expire tasks only while `Condition` returns true.
+// Replicas use it to leave the retirement of tasks to the mutations of their leader.
+func (master *Scheduler) SetPruneCondition(Condition func() bool) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.canPrune = Condition
+}
+
+// prunable tells if the snapshot routine may prune and expire tasks.
+func (master *Scheduler) prunable() bool {
+	master.mu.RLock()
+	condition := master.canPrune
+	master.mu.RUnlock()
+	return condition == nil || condition()
+}
+
+// notifyExpiry must be called with `mu` held.
+func (master *Scheduler) notifyExpiry(reason string) {
+	if master.onExpiry != nil {
+		master.onExpiry(reason)
+	}
+}
+
+// expire handles the tasks not leased at `now` but passed their deadline or expiry time.
+// Tasks passed their deadline are cancelled, expired tasks are moved into `StateExpired` if never leased,
+// otherwise dropped. Leased tasks are left to their lease holders.
+// Must be called with `mu` held.
+func (master *Scheduler) expire(now time.Time) {
+	for ID, task := range master.ownedTasks {
+		if task.leasedAt(now) || !task.overdueAt(now) {
+			continue
+		}
+		switch {
+		case !task.Deadline.IsZero() && !task.Deadline.After(now):
+			master.retire(task, StateCancelled, now)
+			master.notifyExpiry(ExpiryDeadline)
+		case task.Attempts == 0:
+			master.retire(task, StateExpired, now)
+			master.notifyExpiry(ExpiryDeadLetter)
+		default:
+			delete(master.ownedTasks, ID)
+			master.forgetJobMember(task, StateExpired, now)
+			master.unsaved = true
+			master.record(Mutation{Kind: MutationRemoveTask, TaskID: ID})
+			master.notifyExpiry(ExpiryDropped)
+			master.emit(EventExpired, task, now)
+		}
+	}
+}
+
+// StateCounts returns the number of tasks in each state.
+func (master *Scheduler) StateCounts() map[string]int {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	now := master.clock.Now()
+	counts := make(map[string]int)
+	for _, task := range master.ownedTasks {
+		counts[task.StateAt(now)]++
+	}
+	for _, task := range master.finishedTasks {
+		counts[task.State]++
+	}
+	return counts
+}
+
+// Stats returns the number of pending and leased tasks.
+func (master *Scheduler) Stats() (Pending int, Leased int) {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	Leased = master.leasedCount(master.clock.Now())
+	return len(master.ownedTasks) - Leased, Leased
+}
+
+// LeasedCount returns the number of tasks currently leased.
+func (master *Scheduler) LeasedCount() int {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	return master.leasedCount(master.clock.Now())
+}
+
+// Settings returns the settings of the scheduler.
+func (master *Scheduler) Settings() GroupSettings {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	return master.settings
+}
+
+// UpdateSettings replaces the settings of the scheduler.
+func (master *Scheduler) UpdateSettings(Settings GroupSettings) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.settings = Settings
+	master.recordGroupState()
+}
+
+// SetPaused sets whether the scheduler stops handing out tasks.
+func (master *Scheduler) SetPaused(Paused bool) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.paused = Paused
+	master.recordGroupState()
+}
+
+// SetDraining sets whether the scheduler stops accepting new tasks.
+func (master *Scheduler) SetDraining(Draining bool) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.draining = Draining
+	master.recordGroupState()
+}
+
+// Paused returns true if the scheduler stops handing out tasks.
+func (master *Scheduler) Paused() bool {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	return master.paused
+}
+
+// Draining returns true if the scheduler stops accepting new tasks.
+func (master *Scheduler) Draining() bool {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	return master.draining
+}
+
+// Tasks returns a copy of all active and finished tasks ordered by their creation time.
+func (master *Scheduler) Tasks() []Task {
+	master.mu.RLock()
+	tasks := make([]Task, 0, len(master.ownedTasks)+len(master.finishedTasks))
+	for _, task := range master.ownedTasks {
+		tasks = append(tasks, task)
+	}
+	for _, task := range master.finishedTasks {
+		tasks = append(tasks, task)
+	}
+	master.mu.RUnlock()
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+// GetTask returns an active or finished task with `ID`.
+func (master *Scheduler) GetTask(ID string) (Task, bool) {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	if task, ok := master.ownedTasks[ID]; ok {
+		return task, true
+	}
+	task, ok := master.finishedTasks[ID]
+	return task, ok
+}
+
+// TaskCount returns the number of active tasks in the scheduler.
+func (master *Scheduler) TaskCount() int {
+	master.mu.RLock()
+	defer master.mu.RUnlock()
+	return len(master.ownedTasks)
+}
+
 func (master *Scheduler) needsDump() bool {
 	master.mu.RLock()
 	defer master.mu.RUnlock()
 	return master.unsaved
 }
 
+// truncateLog keeps the tail of `log` within `MaxLogSize`.
+func truncateLog(log string) string {
+	if len(log) > MaxLogSize {
+		return log[len(log)-MaxLogSize:]
+	}
+	return log
+}
+
+// retire moves an active task into a terminal state at `now`.
+// Must be called with `mu` held.
+func (master *Scheduler) retire(task Task, state string, now time.Time) {
+	delete(master.ownedTasks, task.ID)
+	task.State = state
+	task.FinishedAt = now
+	master.finishedTasks[task.ID] = task
+	master.unsaved = true
+	master.record(Mutation{Kind: MutationFinishTask, Task: &task})
+	master.emit(retiredEvents[state], task, now)
+}
+
 // MarkAsComplete marks a task with `ID` as completed state.
 // Returns error if task is not found.
 func (master *Scheduler) MarkAsComplete(ID string) error {
+	_, err := master.Finish(ID, false, "")
+	return err
+}
+
+// Finish reports the result of the current attempt of an active task with `Log` as its output.
+// A failed task is scheduled again after `FailureRetryDelay` until it reaches the maximum attempts of the group.
+// Returns the state of the task afterwards, or error if the task is not active.
+func (master *Scheduler) Finish(ID string, Failed bool, Log string) (string, error) {
+	return master.finishAt(ID, Failed, false, Log, master.clock.Now())
+}
+
+// finishAt is the same as `Finish` at `now`, a failed task is not retried if `permanent` is set.
+func (master *Scheduler) finishAt(ID string, Failed bool, permanent bool, Log string, now time.Time) (string, error) {
 	master.mu.Lock()
 	defer master.mu.Unlock()
-	if _, ok := master.ownedTasks[ID]; !ok {
-		return fmt.Errorf("Task `%s` is not found", ID)
+	task, ok := master.ownedTasks[ID]
+	if !ok {
+		return "", fmt.Errorf("Task `%s` is not found", ID)
 	}
-	delete(master.ownedTasks, ID)
-	master.unsaved = true
+	task.Log = truncateLog(Log)
+	if !Failed {
+		master.retire(task, StateDone, now)
+		return StateDone, nil
+	}
+	if permanent || (master.settings.MaxAttempts > 0 && task.Attempts >= master.settings.MaxAttempts) {
+		master.retire(task, StateFailed, now)
+		return StateFailed, nil
+	}
+	task.AvailableTime = now.Add(FailureRetryDelay)
+	task.LeaseHolder = ""
+	master.putTask(task)
+	master.emit(EventFailed, task, now)
+	return StatePending, nil
+}
+
+// Cancel moves an active task into the cancelled state.
+// The lease holder will be notified on its next loan extension.
+func (master *Scheduler) Cancel(ID string) error {
+	return master.cancelAt(ID, master.clock.Now())
+}
+
+func (master *Scheduler) cancelAt(ID string, now time.Time) error {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	task, ok := master.ownedTasks[ID]
+	if !ok {
+		return fmt.Errorf("Task `%s` is not active", ID)
+	}
+	master.retire(task, StateCancelled, now)
 	return nil
 }
 
+// Requeue makes a leased or finished task available to lease immediately.
+func (master *Scheduler) Requeue(ID string) error {
+	return master.requeueAt(ID, master.clock.Now())
+}
+
+func (master *Scheduler) requeueAt(ID string, now time.Time) error {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	task, ok := master.ownedTasks[ID]
+	if !ok {
+		if task, ok = master.finishedTasks[ID]; !ok {
+			return fmt.Errorf("Task `%s` is not found", ID)
+		}
+		delete(master.finishedTasks, ID)
+	}
+	task.State = ""
+	task.FinishedAt = time.Time{}
+	task.LeaseHolder = ""
+	task.AvailableTime = now
+	// A requeued task gets another chance regardless of its expiry time and deadline.
+	if task.overdueAt(now) {
+		task.ExpiresAt = time.Time{}
+		task.Deadline = time.Time{}
+	}
+	master.putTask(task)
+	master.emit(EventRequeued, task, now)
+	return nil
+}
+
+// pruneFinishedTasks removes the finished tasks out of the retention, and the active tasks out of their expiry time.
+func (master *Scheduler) pruneFinishedTasks() {
+	master.pruneAt(master.clock.Now())
+}
+
+func (master *Scheduler) pruneAt(now time.Time) {
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.expire(now)
+	deadline := now.Add(-master.settings.retention())
+	for ID, task := range master.finishedTasks {
+		if task.FinishedAt.Before(deadline) {
+			delete(master.finishedTasks, ID)
+			master.forgetJobMember(task, task.State, now)
+			master.unsaved = true
+			master.record(Mutation{Kind: MutationRemoveTask, TaskID: ID})
+		}
+	}
+	if master.pruneJobHistory(deadline) {
+		master.recordGroupState()
+	}
+}
+
 // NewTask creates a task that can be assigned immediately.
 // Returns the ID in the task master.
 func (master *Scheduler) NewTask(Data string) string {
-	task := Task{
-		ID:            uuid.NewString(),
-		Data:          Data,
-		AvailableTime: time.Now(),
+	ID := uuid.NewString()
+	master.insertAt(Task{ID: ID, Data: Data}, master.clock.Now())
+	return ID
+}
+
+// Insert creates `Task` available immediately, keeping its ID, payload, labels, constraints, tenant and job.
+// A new ID is generated if `Task.ID` is empty. Returns the ID in the task master.
+func (master *Scheduler) Insert(Task Task) string {
+	if len(Task.ID) == 0 {
+		Task.ID = uuid.NewString()
 	}
+	master.insertAt(Task, master.clock.Now())
+	return Task.ID
+}
+
+// insertAt creates `task` available from `now`.
+func (master *Scheduler) insertAt(task Task, now time.Time) {
 	master.mu.Lock()
 	defer master.mu.Unlock()
-	master.ownedTasks[task.ID] = task
-	master.unsaved = true
-	return task.ID
+	task.AvailableTime = now
+	task.CreatedAt = now
+	master.putTask(task)
+	master.emit(EventInserted, task, now)
+}
+
+func copyTasks(tasks map[string]Task) map[string]Task {
+	copied := make(map[string]Task, len(tasks))
+	for ID, task := range tasks {
+		copied[ID] = task
+	}
+	return copied
 }
 
 // GetSnapshot returns a snapshot of the task master.
@@ -104,46 +689,67 @@ func (master *Scheduler) GetSnapshot() *Snapshot {
 	master.mu.RLock()
 	defer master.mu.RUnlock()
 	return &Snapshot{
-		CreatedAt:      time.Now(),
-		AvailableTasks: master.ownedTasks,
+		Version:        SnapshotVersion,
+		CreatedAt:      master.clock.Now(),
+		AvailableTasks: copyTasks(master.ownedTasks),
+		FinishedTasks:  copyTasks(master.finishedTasks),
+		Settings:       master.settings,
+		Paused:         master.paused,
+		Draining:       master.draining,
+		Deficits:       copyDeficits(master.deficits),
+		TenantCursor:   master.cursor,
+		Jobs:           copyJobHistory(master.jobHistory),
 	}
 }
 
 func (master *Scheduler) dumpTo(Filename string) error {
-	data, err := json.MarshalIndent(*master.GetSnapshot(), "", "    ")
-	if err != nil {
-		log.Fatal(err)
-	}
+	snapshot := master.GetSnapshot()
 	master.mu.Lock()
 	master.unsaved = false
 	master.mu.Unlock()
-	return os.WriteFile(Filename, data, fs.ModePerm)
+	return writeSnapshotFile(Filename, snapshot)
 }
 
 // NewTaskMaster creates a task master which dumps its state to `SnapshotFileName` every `SnapshotInterval`.
+// The snapshot routine stops once `Context` is done, see `Stopped`.
+// Returns error if the existing snapshot cannot be decoded, see `CheckSnapshotFolder` to repair it.
 func NewTaskMaster(Context context.Context, SnapshotFileName string, SnapshotInterval time.Duration) (*Scheduler, error) {
-	taskmaster := Scheduler{
-		mu:         sync.RWMutex{},
-		unsaved:    true,
-		ownedTasks: make(map[string]Task),
-	}
+	return NewTaskMasterWithClock(Context, SnapshotFileName, SnapshotInterval, clock.Real)
+}
+
+// NewTaskMasterWithClock is the same as `NewTaskMaster` but the scheduler and its snapshot routine follow `Clock`.
+func NewTaskMasterWithClock(Context context.Context, SnapshotFileName string, SnapshotInterval time.Duration, Clock clock.Clock) (*Scheduler, error) {
+	taskmaster := newScheduler(Clock)
+	taskmaster.unsaved = true
 	if data, err := os.ReadFile(SnapshotFileName); err == nil {
-		snapshot := Snapshot{}
-		if err := json.Unmarshal(data, &snapshot); err != nil {
-			log.Printf("warning: data corruptted: %v", err)
+		snapshot, err := decodeSnapshotAt(data, Clock.Now())
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode snapshot `%s`: %v", SnapshotFileName, err)
 		}
-		taskmaster.unsaved = false
+		taskmaster.unsaved = snapshotVersion(data) < SnapshotVersion
 		taskmaster.ownedTasks = snapshot.AvailableTasks
-		log.Printf("loaded snapshot created %v ago", time.Since(snapshot.CreatedAt))
+		taskmaster.finishedTasks = snapshot.FinishedTasks
+		taskmaster.settings = snapshot.Settings
+		taskmaster.paused = snapshot.Paused
+		taskmaster.draining = snapshot.Draining
+		taskmaster.deficits = snapshot.Deficits
+		taskmaster.cursor = snapshot.TenantCursor
+		taskmaster.jobHistory = copyJobHistory(snapshot.Jobs)
+		taskmaster.rebuildJobIndex()
+		log.Printf("loaded snapshot created %v ago", taskmaster.clock.Now().Sub(snapshot.CreatedAt))
 	}
-	ticker := time.NewTicker(SnapshotInterval)
+	ticker := Clock.NewTicker(SnapshotInterval)
 	go func() {
+		defer close(taskmaster.stopped)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-Context.Done():
 				return
-			case <-ticker.C:
+			case <-ticker.C():
+				if taskmaster.prunable() {
+					taskmaster.pruneFinishedTasks()
+				}
 				if taskmaster.needsDump() {
 					if err := taskmaster.dumpTo(SnapshotFileName); err != nil {
 						log.Fatal(err)
@@ -152,5 +758,29 @@ func NewTaskMaster(Context context.Context, SnapshotFileName string, SnapshotInt
 			}
 		}
 	}()
-	return &taskmaster, nil
+	return taskmaster, nil
+}
+
+// newScheduler creates an empty scheduler following `Clock` without the snapshot routine.
+func newScheduler(Clock clock.Clock) *Scheduler {
+	return &Scheduler{
+		ownedTasks:    make(map[string]Task),
+		finishedTasks: make(map[string]Task),
+		jobMembers:    make(map[string]map[string]struct{}),
+		jobHistory:    make(map[string]JobHistory),
+		stopped:       make(chan struct{}),
+		clock:         Clock,
+	}
+}
+
+// NewScheduler creates an empty scheduler following `Clock`, which is neither persisted nor pruned periodically.
+func NewScheduler(Clock clock.Clock) *Scheduler {
+	scheduler := newScheduler(Clock)
+	close(scheduler.stopped)
+	return scheduler
+}
+
+// Stopped returns a channel which is closed once the snapshot routine of the scheduler exits.
+func (master *Scheduler) Stopped() <-chan struct{} {
+	return master.stopped
 }
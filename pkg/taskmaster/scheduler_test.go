@@ -9,15 +9,15 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/xpy123993/toolbox/pkg/clock"
 	"github.com/xpy123993/toolbox/pkg/taskmaster"
 )
 
 func TestQuery(t *testing.T) {
-	taskMaster, err := taskmaster.NewTaskMaster(context.Background(), path.Join(t.TempDir(), "test.json"), time.Minute)
-	if err != nil {
-		t.Fatal(err)
-	}
+	fakeClock := clock.NewFake(time.Now())
+	taskMaster := taskmaster.NewScheduler(fakeClock)
 	taskMaster.NewTask("test")
+	fakeClock.Advance(time.Millisecond)
 	task := taskMaster.Query(5 * time.Millisecond)
 	if task == nil {
 		t.Fatal("expect a task to be returned")
@@ -25,25 +25,50 @@ func TestQuery(t *testing.T) {
 	if task = taskMaster.Query(time.Millisecond); task != nil {
 		t.Error("expect nothing to be returned")
 	}
-	time.Sleep(5 * time.Millisecond)
+	fakeClock.Advance(6 * time.Millisecond)
 	if task = taskMaster.Query(time.Millisecond); task == nil {
 		t.Error("expect a task to be returned")
 	}
 }
 
+func TestExtendLoan(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	taskMaster := taskmaster.NewScheduler(fakeClock)
+	ID := taskMaster.NewTask("test")
+	fakeClock.Advance(time.Millisecond)
+	if task := taskMaster.Query(time.Minute); task == nil || task.ID != ID {
+		t.Fatalf("expect task `%s` to be leased, got %v", ID, task)
+	}
+	if err := taskMaster.ExtendLoan(ID, fakeClock.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// The extension is kept, so the task is not handed out once the original lease passes.
+	fakeClock.Advance(2 * time.Minute)
+	if task := taskMaster.Query(time.Minute); task != nil {
+		t.Errorf("expect the extended task not to be leased again, got %v", task)
+	}
+	if err := taskMaster.ExtendLoan("unknown", fakeClock.Now()); err == nil {
+		t.Error("expect extending an unknown task to fail")
+	}
+}
+
 func TestDumpSnapshot(t *testing.T) {
 	snapshotFile := path.Join(t.TempDir(), "test.json")
-	taskMaster, err := taskmaster.NewTaskMaster(context.Background(), snapshotFile, 2*time.Millisecond)
+	fakeClock := clock.NewFake(time.Now())
+	ctx, cancelFn := context.WithCancel(context.Background())
+	taskMaster, err := taskmaster.NewTaskMasterWithClock(ctx, snapshotFile, time.Minute, fakeClock)
 	if err != nil {
 		t.Fatal(err)
 	}
 	taskMaster.NewTask("test")
-	time.Sleep(3 * time.Millisecond)
-	taskMaster.GetSnapshot()
+	fakeClock.Advance(time.Minute)
+	waitForFile(t, taskMaster, snapshotFile)
 	data, err := os.ReadFile(snapshotFile)
 	if err != nil {
 		t.Fatal(err)
 	}
+	cancelFn()
+	<-taskMaster.Stopped()
 	snapshot := taskmaster.Snapshot{}
 	if err := json.Unmarshal(data, &snapshot); err != nil {
 		t.Fatal(err)
@@ -107,3 +132,133 @@ func TestMarkAsComplete(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConcurrencyLimit(t *testing.T) {
+	taskMaster, err := taskmaster.NewTaskMaster(context.Background(), path.Join(t.TempDir(), "test.json"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskMaster.UpdateSettings(taskmaster.GroupSettings{MaxConcurrency: 1})
+	taskMaster.NewTask("a")
+	taskMaster.NewTask("b")
+	if task := taskMaster.Query(time.Minute); task == nil {
+		t.Fatal("expect a task to be returned")
+	}
+	if task := taskMaster.Query(time.Minute); task != nil {
+		t.Error("expect nothing to be returned under the concurrency limit")
+	}
+	if taskMaster.LeasedCount() != 1 {
+		t.Errorf("unexpected leased count: %d", taskMaster.LeasedCount())
+	}
+}
+
+func TestFinishStates(t *testing.T) {
+	taskMaster, err := taskmaster.NewTaskMaster(context.Background(), path.Join(t.TempDir(), "test.json"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskMaster.UpdateSettings(taskmaster.GroupSettings{MaxAttempts: 2})
+	taskID := taskMaster.NewTask("test")
+	taskMaster.Lease("worker", time.Minute)
+	if state, err := taskMaster.Finish(taskID, true, "first"); err != nil || state != taskmaster.StatePending {
+		t.Fatalf("unexpected result after the first failure: %s, %v", state, err)
+	}
+	if err := taskMaster.Requeue(taskID); err != nil {
+		t.Fatal(err)
+	}
+	if task := taskMaster.Lease("worker", time.Minute); task == nil || task.Attempts != 2 {
+		t.Fatalf("unexpected task: %v", task)
+	}
+	if state, err := taskMaster.Finish(taskID, true, "second"); err != nil || state != taskmaster.StateFailed {
+		t.Fatalf("unexpected result after the last attempt: %s, %v", state, err)
+	}
+	task, ok := taskMaster.GetTask(taskID)
+	if !ok || task.State != taskmaster.StateFailed || task.Log != "second" {
+		t.Errorf("unexpected task: %v", task)
+	}
+	if err := taskMaster.Cancel(taskID); err == nil {
+		t.Error("expect finished tasks cannot be cancelled")
+	}
+
+	taskID = taskMaster.NewTask("test")
+	if err := taskMaster.Cancel(taskID); err != nil {
+		t.Fatal(err)
+	}
+	if counts := taskMaster.StateCounts(); counts[taskmaster.StateFailed] != 1 || counts[taskmaster.StateCancelled] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestFailureRetryDelay(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	taskMaster := taskmaster.NewScheduler(fakeClock)
+	taskMaster.UpdateSettings(taskmaster.GroupSettings{MaxAttempts: 3})
+	taskID := taskMaster.NewTask("test")
+	fakeClock.Advance(time.Millisecond)
+	taskMaster.Lease("worker", time.Minute)
+	if state, err := taskMaster.Finish(taskID, true, "failed"); err != nil || state != taskmaster.StatePending {
+		t.Fatalf("unexpected result after the failure: %s, %v", state, err)
+	}
+	fakeClock.Advance(taskmaster.FailureRetryDelay)
+	if task := taskMaster.Lease("worker", time.Minute); task != nil {
+		t.Fatalf("expect the failed task not to be leased before the retry delay, got %v", task)
+	}
+	fakeClock.Advance(time.Millisecond)
+	if task := taskMaster.Lease("worker", time.Minute); task == nil || task.ID != taskID || task.Attempts != 2 {
+		t.Errorf("expect the failed task to be leased again after the retry delay, got %v", task)
+	}
+}
+
+// waitForFile waits until the snapshot routine of `taskMaster` writes `filename`.
+func waitForFile(t *testing.T, taskMaster *taskmaster.Scheduler, filename string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-taskMaster.Stopped():
+			t.Fatal("unexpected exit of the snapshot routine")
+		default:
+		}
+		if _, err := os.Stat(filename); err == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for `%s`", filename)
+}
+
+func TestRetentionOfFinishedTasks(t *testing.T) {
+	snapshotFile := path.Join(t.TempDir(), "test.json")
+	fakeClock := clock.NewFake(time.Now())
+	ctx, cancelFn := context.WithCancel(context.Background())
+	taskMaster, err := taskmaster.NewTaskMasterWithClock(ctx, snapshotFile, time.Minute, fakeClock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancelFn()
+		<-taskMaster.Stopped()
+	}()
+	taskMaster.UpdateSettings(taskmaster.GroupSettings{Retention: time.Hour})
+	taskID := taskMaster.NewTask("test")
+	fakeClock.Advance(time.Millisecond)
+	taskMaster.Lease("worker", time.Minute)
+	if state, err := taskMaster.Finish(taskID, false, "done"); err != nil || state != taskmaster.StateDone {
+		t.Fatalf("unexpected result: %s, %v", state, err)
+	}
+
+	// Each tick prunes the group before writing the snapshot, so a written snapshot means the group was pruned.
+	fakeClock.Advance(time.Minute)
+	waitForFile(t, taskMaster, snapshotFile)
+	if _, ok := taskMaster.GetTask(taskID); !ok {
+		t.Fatal("expect the finished task to be kept within the retention")
+	}
+	if err := os.Remove(snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Advance(time.Hour)
+	waitForFile(t, taskMaster, snapshotFile)
+	if task, ok := taskMaster.GetTask(taskID); ok {
+		t.Errorf("expect the finished task to be removed after the retention, got %v", task)
+	}
+}
@@ -0,0 +1,303 @@
+package taskmaster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the schema version of the snapshots written by this package.
+// Snapshots written before versioning have no version field and are version 0.
+const SnapshotVersion = 2
+
+// snapshotUpgrades maps a schema version to the function upgrading a snapshot of the version to the next version.
+// Upgrades operate on the raw JSON fields, so they do not depend on the current definition of `Snapshot`.
+// `loadTime` is the time the snapshot is loaded, used to fill the times unknown to older versions.
+// A change to the schema bumps `SnapshotVersion` and registers the upgrade from the previous version here.
+var snapshotUpgrades = map[int]func(fields map[string]json.RawMessage, loadTime time.Time) error{
+	0: upgradeSnapshotV0,
+	1: upgradeSnapshotV1,
+}
+
+// upgradeSnapshotV0 replaces the missing or null task maps of unversioned snapshots with empty ones,
+// and sets the creation time of the tasks inserted before it was recorded to `loadTime`.
+func upgradeSnapshotV0(fields map[string]json.RawMessage, loadTime time.Time) error {
+	for _, key := range []string{"tasks", "finished_tasks"} {
+		if value, exists := fields[key]; !exists || string(value) == "null" {
+			fields[key] = json.RawMessage("{}")
+			continue
+		}
+		tasks := make(map[string]map[string]json.RawMessage)
+		if err := json.Unmarshal(fields[key], &tasks); err != nil {
+			return fmt.Errorf("invalid `%s`: %v", key, err)
+		}
+		for ID, task := range tasks {
+			createdAt := time.Time{}
+			if value, exists := task["created_timestamp"]; exists {
+				if err := json.Unmarshal(value, &createdAt); err != nil {
+					return fmt.Errorf("task `%s`: invalid creation time: %v", ID, err)
+				}
+			}
+			if createdAt.IsZero() {
+				value, err := json.Marshal(loadTime)
+				if err != nil {
+					return err
+				}
+				task["created_timestamp"] = value
+			}
+		}
+		value, err := json.Marshal(tasks)
+		if err != nil {
+			return err
+		}
+		fields[key] = value
+	}
+	return nil
+}
+
+// upgradeSnapshotV1 has nothing to convert. Version 2 adds the resource limits, artifact paths and artifacts of tasks,
+// and the histories of jobs, which are empty in older snapshots.
+func upgradeSnapshotV1(fields map[string]json.RawMessage, loadTime time.Time) error {
+	return nil
+}
+
+// DecodeSnapshot decodes a JSON encoded snapshot, upgrading it from older schema versions loaded at present.
+// Returns error if the snapshot is malformed or written by a newer version.
+func DecodeSnapshot(Data []byte) (*Snapshot, error) {
+	return decodeSnapshotAt(Data, time.Now())
+}
+
+// decodeSnapshotAt is the same as `DecodeSnapshot` but the snapshot is loaded at `loadTime`.
+func decodeSnapshotAt(Data []byte, loadTime time.Time) (*Snapshot, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(Data, &fields); err != nil {
+		return nil, err
+	}
+	version := 0
+	if value, exists := fields["version"]; exists {
+		if err := json.Unmarshal(value, &version); err != nil {
+			return nil, fmt.Errorf("invalid schema version: %v", err)
+		}
+	}
+	if version > SnapshotVersion {
+		return nil, fmt.Errorf("schema version %d is newer than the supported version %d", version, SnapshotVersion)
+	}
+	for ; version < SnapshotVersion; version++ {
+		upgrade, exists := snapshotUpgrades[version]
+		if !exists {
+			return nil, fmt.Errorf("no upgrade from schema version %d", version)
+		}
+		if err := upgrade(fields, loadTime); err != nil {
+			return nil, fmt.Errorf("cannot upgrade from schema version %d: %v", version, err)
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	snapshot.Version = SnapshotVersion
+	if snapshot.AvailableTasks == nil {
+		snapshot.AvailableTasks = make(map[string]Task)
+	}
+	if snapshot.FinishedTasks == nil {
+		snapshot.FinishedTasks = make(map[string]Task)
+	}
+	return snapshot, nil
+}
+
+// snapshotVersion returns the schema version of a JSON encoded snapshot, 0 if unknown.
+func snapshotVersion(data []byte) int {
+	header := struct {
+		Version int `json:"version"`
+	}{}
+	json.Unmarshal(data, &header)
+	return header.Version
+}
+
+// writeSnapshotFile replaces `filename` with the JSON encoded `snapshot`.
+// The snapshot is written to a temporary file first, so a crash never leaves a truncated snapshot.
+func writeSnapshotFile(filename string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "    ")
+	if err != nil {
+		return err
+	}
+	temp := filename + ".tmp"
+	if err := os.WriteFile(temp, data, fs.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+// SnapshotIssue is a problem found in a snapshot by `CheckSnapshot`.
+type SnapshotIssue struct {
+	// Task is the ID of the task with the issue, empty for the issues of the group.
+	Task        string
+	Description string
+	// Repaired is true if the issue is fixed.
+	Repaired bool
+}
+
+func (issue SnapshotIssue) String() string {
+	result := issue.Description
+	if len(issue.Task) > 0 {
+		result = fmt.Sprintf("task `%s`: %s", issue.Task, result)
+	}
+	if issue.Repaired {
+		result += " (repaired)"
+	}
+	return result
+}
+
+// CheckSnapshot returns the inconsistencies of `Snapshot`, which are fixed in place if `Repair` is set.
+func CheckSnapshot(Snapshot *Snapshot, Repair bool) []SnapshotIssue {
+	var issues []SnapshotIssue
+	report := func(task string, repairable bool, format string, args ...interface{}) {
+		issues = append(issues, SnapshotIssue{Task: task, Description: fmt.Sprintf(format, args...), Repaired: Repair && repairable})
+	}
+	if Snapshot.AvailableTasks == nil || Snapshot.FinishedTasks == nil {
+		report("", true, "missing task maps")
+		if Repair && Snapshot.AvailableTasks == nil {
+			Snapshot.AvailableTasks = make(map[string]Task)
+		}
+		if Repair && Snapshot.FinishedTasks == nil {
+			Snapshot.FinishedTasks = make(map[string]Task)
+		}
+	}
+	settings := &Snapshot.Settings
+	if settings.MaxConcurrency < 0 || settings.LeaseRate < 0 || settings.LeaseBurst < 0 || settings.MaxAttempts < 0 || settings.Retention < 0 ||
+		settings.LoanDuration < 0 || settings.MaxLoanDuration < 0 {
+		report("", true, "negative settings")
+		if Repair {
+			*settings = GroupSettings{FairShare: settings.FairShare, TenantWeights: settings.TenantWeights, CallbackURL: settings.CallbackURL}
+		}
+	}
+	if err := ValidateTenantWeights(settings.TenantWeights); err != nil {
+		report("", true, "%v", err)
+		if Repair {
+			settings.TenantWeights = nil
+		}
+	}
+
+	for _, active := range []bool{true, false} {
+		tasks := Snapshot.AvailableTasks
+		if !active {
+			tasks = Snapshot.FinishedTasks
+		}
+		IDs := make([]string, 0, len(tasks))
+		for ID := range tasks {
+			IDs = append(IDs, ID)
+		}
+		sort.Strings(IDs)
+		for _, ID := range IDs {
+			task := tasks[ID]
+			if task.ID != ID {
+				report(ID, true, "stored under a different ID `%s`", task.ID)
+				task.ID = ID
+			}
+			if task.CreatedAt.IsZero() {
+				report(ID, true, "missing creation time")
+				task.CreatedAt = Snapshot.CreatedAt
+			}
+			if err := ValidateLabels(task.Labels, task.Constraints); err != nil {
+				report(ID, false, "%v", err)
+			}
+			switch {
+			case active && len(task.State) > 0:
+				report(ID, true, "active task in terminal state `%s`", task.State)
+				if Repair {
+					delete(tasks, ID)
+					Snapshot.FinishedTasks[ID] = task
+				}
+				continue
+			case !active && len(task.State) == 0:
+				report(ID, true, "finished task without terminal state, made available again")
+				if Repair {
+					delete(tasks, ID)
+					task.LeaseHolder = ""
+					Snapshot.AvailableTasks[ID] = task
+				}
+				continue
+			case !active:
+				if _, exists := Snapshot.AvailableTasks[ID]; exists {
+					report(ID, true, "both active and finished, the finished copy is dropped")
+					if Repair {
+						delete(tasks, ID)
+					}
+					continue
+				}
+			}
+			if Repair {
+				tasks[ID] = task
+			}
+		}
+	}
+	return issues
+}
+
+// CheckSnapshotFolder checks the snapshots of the groups in `Folder`, which must not be in use by a server.
+// Returns the issues of each group keyed by the group name.
+//
+// With `Repair`, repairable issues are fixed, snapshots of older schema versions are upgraded,
+// and snapshots which cannot be decoded are renamed with the `.corrupt` suffix, so the server can start without them.
+func CheckSnapshotFolder(Folder string, Repair bool) (map[string][]SnapshotIssue, error) {
+	files, err := filepath.Glob(path.Join(Folder, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]SnapshotIssue)
+	for _, file := range files {
+		group := strings.TrimSuffix(path.Base(file), ".json")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := DecodeSnapshot(data)
+		if err != nil {
+			issue := SnapshotIssue{Description: fmt.Sprintf("cannot decode snapshot: %v", err), Repaired: Repair}
+			if Repair {
+				if err := os.Rename(file, file+".corrupt"); err != nil {
+					return nil, err
+				}
+			}
+			result[group] = append(result[group], issue)
+			continue
+		}
+		issues := CheckSnapshot(snapshot, Repair)
+		if version := snapshotVersion(data); version < SnapshotVersion {
+			issues = append(issues, SnapshotIssue{Description: fmt.Sprintf("schema version %d is older than %d", version, SnapshotVersion), Repaired: Repair})
+		}
+		if Repair && len(issues) > 0 {
+			if err := writeSnapshotFile(file, snapshot); err != nil {
+				return nil, err
+			}
+		}
+		for _, tasks := range []map[string]Task{snapshot.AvailableTasks, snapshot.FinishedTasks} {
+			for _, task := range tasks {
+				if len(task.Blob) > 0 {
+					if _, err := os.Stat(path.Join(Folder, blobFolder, task.Blob)); err != nil {
+						issues = append(issues, SnapshotIssue{Task: task.ID, Description: fmt.Sprintf("missing blob `%s`", task.Blob)})
+					}
+				}
+				for _, artifact := range task.Artifacts {
+					if _, err := os.Stat(path.Join(Folder, artifactFolder, artifact.Hash)); err != nil {
+						issues = append(issues, SnapshotIssue{Task: task.ID, Description: fmt.Sprintf("missing artifact `%s`", artifact.Name)})
+					}
+				}
+			}
+		}
+		if len(issues) > 0 {
+			result[group] = issues
+		}
+	}
+	return result, nil
+}
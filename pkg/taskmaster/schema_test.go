@@ -0,0 +1,96 @@
+package taskmaster_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+func TestDecodeSnapshot(t *testing.T) {
+	snapshot, err := taskmaster.DecodeSnapshot([]byte(`{"creation": "2021-01-01T00:00:00Z", "tasks": null, "settings": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != taskmaster.SnapshotVersion || snapshot.AvailableTasks == nil || snapshot.FinishedTasks == nil {
+		t.Errorf("expect unversioned snapshots to be upgraded, got %+v", snapshot)
+	}
+	snapshot, err = taskmaster.DecodeSnapshot([]byte(`{"creation": "2021-01-01T00:00:00Z", "tasks": {"a": {"uuid": "a"}},
+		"finished_tasks": {"b": {"uuid": "b", "state": "done", "created_timestamp": "2021-01-01T00:00:00Z"}}, "settings": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if createdAt := snapshot.AvailableTasks["a"].CreatedAt; createdAt.IsZero() {
+		t.Error("expect the missing creation time to be filled")
+	}
+	if createdAt := snapshot.FinishedTasks["b"].CreatedAt; !createdAt.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expect the creation time to be kept, got %v", createdAt)
+	}
+	snapshot, err = taskmaster.DecodeSnapshot([]byte(`{"version": 1, "creation": "2021-01-01T00:00:00Z", "tasks": {}, "settings": {}}`))
+	if err != nil || snapshot.Version != taskmaster.SnapshotVersion {
+		t.Errorf("expect snapshots of version 1 to be upgraded, got %+v, %v", snapshot, err)
+	}
+	if _, err := taskmaster.DecodeSnapshot([]byte(`{"version": 1000, "tasks": {}}`)); err == nil {
+		t.Error("expect snapshots of newer versions to be rejected")
+	}
+}
+
+func TestCheckSnapshotFolder(t *testing.T) {
+	folder := t.TempDir()
+	files := map[string]string{
+		"corrupt.json": `{"tasks": `,
+		"default.json": `{"creation": "2021-01-01T00:00:00Z", "settings": {"max_concurrency": -1},
+			"tasks": {"a": {"uuid": "b", "created_timestamp": "2021-01-01T00:00:00Z"}, "c": {"uuid": "c", "state": "done"}}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(folder, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := taskmaster.NewTaskMasterServer(folder, time.Minute); err == nil {
+		t.Fatal("expect corrupted snapshots to fail the server")
+	}
+
+	issues, err := taskmaster.CheckSnapshotFolder(folder, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mismatched ID, terminal state of `c`, negative settings and the old schema.
+	// The missing creation time of `c` is filled by the upgrade of the schema.
+	if len(issues["corrupt"]) != 1 || len(issues["default"]) != 4 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+	for _, issue := range issues["default"] {
+		if issue.Repaired {
+			t.Errorf("expect no repair without --repair, got %v", issue)
+		}
+	}
+
+	if _, err := taskmaster.CheckSnapshotFolder(folder, true); err != nil {
+		t.Fatal(err)
+	}
+	if issues, err := taskmaster.CheckSnapshotFolder(folder, false); err != nil || len(issues) > 0 {
+		t.Fatalf("expect all issues repaired, got %v, %v", issues, err)
+	}
+	server, err := taskmaster.NewTaskMasterServer(folder, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	for ID, state := range map[string]string{"a": "pending", "c": "done"} {
+		resp, err := server.GetTask(context.Background(), &pb.GetTaskRequest{Group: "default", ID: ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetTask().GetState() != state {
+			t.Errorf("expect task `%s` to be %s, got %s", ID, state, resp.GetTask().GetState())
+		}
+	}
+	if _, err := os.Stat(path.Join(folder, "corrupt.json.corrupt")); err != nil {
+		t.Errorf("expect the corrupted snapshot to be kept aside: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package taskmaster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// workerExpiry is how long a worker is considered when explaining why a task is unschedulable.
+const workerExpiry = 5 * time.Minute
+
+// constraint is a requirement on the labels of workers.
+type constraint struct {
+	key   string
+	value string
+	// negated inverts the requirement.
+	negated bool
+	// exists requires the key to exist regardless of its value.
+	exists bool
+}
+
+// validateLabelKey returns error if `key` cannot be used in constraints.
+func validateLabelKey(key string) error {
+	if len(key) == 0 || strings.ContainsAny(key, "=!, \t\n") {
+		return fmt.Errorf("invalid label key `%s`", key)
+	}
+	return nil
+}
+
+// parseConstraint parses `key=value`, `key!=value`, `key` or `!key`.
+func parseConstraint(expression string) (constraint, error) {
+	if i := strings.Index(expression, "!="); i >= 0 {
+		return constraint{key: expression[:i], value: expression[i+2:], negated: true}, validateLabelKey(expression[:i])
+	}
+	if i := strings.Index(expression, "="); i >= 0 {
+		return constraint{key: expression[:i], value: expression[i+1:]}, validateLabelKey(expression[:i])
+	}
+	if strings.HasPrefix(expression, "!") {
+		return constraint{key: expression[1:], exists: true, negated: true}, validateLabelKey(expression[1:])
+	}
+	return constraint{key: expression, exists: true}, validateLabelKey(expression)
+}
+
+func (c constraint) match(labels map[string]string) bool {
+	value, exists := labels[c.key]
+	if c.exists {
+		return exists != c.negated
+	}
+	return (exists && value == c.value) != c.negated
+}
+
+// ValidateLabels returns error if the labels or the constraints of a task are malformed.
+func ValidateLabels(Labels map[string]string, Constraints []string) error {
+	for key := range Labels {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		}
+	}
+	for _, expression := range Constraints {
+		if _, err := parseConstraint(expression); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchConstraints returns true if `Labels` satisfies all the constraints.
+// Malformed constraints are never satisfied.
+func MatchConstraints(Constraints []string, Labels map[string]string) bool {
+	for _, expression := range Constraints {
+		c, err := parseConstraint(expression)
+		if err != nil || !c.match(Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// workerSeen is a worker recently queried a group.
+type workerSeen struct {
+	labels   map[string]string
+	lastSeen time.Time
+}
+
+// workerTracker remembers the labels of the workers recently queried each group.
+type workerTracker struct {
+	groups map[string]map[string]workerSeen
+}
+
+// observe records a query of `holder`.
+// Must be called with the lock of the server held.
+func (tracker *workerTracker) observe(group string, holder string, labels map[string]string, now time.Time) {
+	if tracker.groups == nil {
+		tracker.groups = make(map[string]map[string]workerSeen)
+	}
+	workers, exists := tracker.groups[group]
+	if !exists {
+		workers = make(map[string]workerSeen)
+		tracker.groups[group] = workers
+	}
+	workers[holder] = workerSeen{labels: labels, lastSeen: now}
+	for holder, worker := range workers {
+		if now.Sub(worker.lastSeen) > workerExpiry {
+			delete(workers, holder)
+		}
+	}
+}
+
+// unschedulableReason explains why no recent worker of `group` can lease `task`, empty if some worker can.
+func (tracker *workerTracker) unschedulableReason(group string, task *Task, now time.Time) string {
+	if len(task.Constraints) == 0 || task.StateAt(now) != StatePending {
+		return ""
+	}
+	workers := []workerSeen{}
+	for _, worker := range tracker.groups[group] {
+		if now.Sub(worker.lastSeen) <= workerExpiry {
+			workers = append(workers, worker)
+		}
+	}
+	if len(workers) == 0 {
+		return "no worker has queried the group recently"
+	}
+	unsatisfied := []string{}
+	for _, expression := range task.Constraints {
+		satisfied := false
+		for _, worker := range workers {
+			satisfied = satisfied || MatchConstraints([]string{expression}, worker.labels)
+		}
+		if !satisfied {
+			unsatisfied = append(unsatisfied, expression)
+		}
+	}
+	if len(unsatisfied) > 0 {
+		sort.Strings(unsatisfied)
+		return fmt.Sprintf("no recent worker satisfies `%s`", strings.Join(unsatisfied, "`, `"))
+	}
+	for _, worker := range workers {
+		if MatchConstraints(task.Constraints, worker.labels) {
+			return ""
+		}
+	}
+	return "no recent worker satisfies all the constraints"
+}
@@ -0,0 +1,82 @@
+package taskmaster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestMatchConstraints(t *testing.T) {
+	labels := map[string]string{"memory": "large", "dataset": "imagenet"}
+	for _, test := range []struct {
+		constraints []string
+		expected    bool
+	}{
+		{nil, true},
+		{[]string{"memory=large"}, true},
+		{[]string{"memory=small"}, false},
+		{[]string{"memory!=small", "dataset"}, true},
+		{[]string{"!gpu"}, true},
+		{[]string{"gpu"}, false},
+		{[]string{"dataset!=imagenet"}, false},
+		{[]string{"=invalid"}, false},
+	} {
+		if actual := taskmaster.MatchConstraints(test.constraints, labels); actual != test.expected {
+			t.Errorf("constraints %v: expect %v, got %v", test.constraints, test.expected, actual)
+		}
+	}
+	if err := taskmaster.ValidateLabels(map[string]string{"a b": "c"}, nil); err == nil {
+		t.Error("expect malformed label keys to be rejected")
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	server := createTestServer(t)
+	ctx := context.Background()
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Constraints: []string{"!=gpu"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect malformed constraints to be rejected, got %v", err)
+	}
+	resp, err := server.Insert(ctx, &pb.InsertRequest{
+		Group:       "default",
+		Data:        "test",
+		Labels:      map[string]string{"owner": "test"},
+		Constraints: []string{"memory=large", "!gpu"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := &pb.QueryRequest{
+		Group:        "default",
+		LoanDuration: durationpb.New(time.Minute),
+		WorkerLabels: map[string]string{"memory": "small"},
+	}
+	if _, err := server.Query(ctx, request); status.Code(err) != codes.NotFound {
+		t.Errorf("expect no matching tasks, got %v", err)
+	}
+	task, err := server.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: resp.GetID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := task.GetTask().GetUnschedulableReason(); !strings.Contains(reason, "memory=large") {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+	if task.GetTask().GetLabels()["owner"] != "test" {
+		t.Errorf("unexpected labels: %v", task.GetTask().GetLabels())
+	}
+
+	request.WorkerLabels["memory"] = "large"
+	leased, err := server.Query(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leased.GetID() != resp.GetID() {
+		t.Errorf("unexpected task: %v", leased)
+	}
+}
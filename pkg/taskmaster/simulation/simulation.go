@@ -0,0 +1,154 @@
+// Package simulation drives a task master scheduler with virtual workers on a fake clock.
+// Every run is determined by its seed, so scheduling policies can be tested without real time.
+package simulation
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/clock"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+)
+
+// Config describes a simulation.
+type Config struct {
+	// Seed determines the execution time, crashes and failures of the tasks.
+	Seed int64
+	// Workers is the number of virtual workers.
+	Workers int
+	// Tasks is the number of tasks inserted at the start, assigned to `Tenants` in turn.
+	Tasks   int
+	Tenants []string
+	// Settings are the settings of the simulated group.
+	Settings taskmaster.GroupSettings
+	// Step is the resolution of the simulation, idle workers poll the scheduler once per step. Defaults to 1 second.
+	Step time.Duration
+	// LeaseDuration is the lease duration requested by workers, which extend their leases while running.
+	// Defaults to 10 steps.
+	LeaseDuration time.Duration
+	// The execution time of each attempt is uniformly distributed in [MinTaskDuration, MaxTaskDuration].
+	MinTaskDuration time.Duration
+	MaxTaskDuration time.Duration
+	// CrashRate is the probability of a worker crashing during an attempt, the lease of the task then expires.
+	CrashRate float64
+	// RestartDelay is how long a crashed worker takes to come back.
+	RestartDelay time.Duration
+	// FailureRate is the probability of an attempt failing.
+	FailureRate float64
+	// MaxDuration stops the simulation even if some tasks are not finished. Defaults to 24 hours.
+	MaxDuration time.Duration
+}
+
+// Result summarizes a simulation.
+type Result struct {
+	// Elapsed is the simulated time until all tasks are finished, or `MaxDuration`.
+	Elapsed  time.Duration
+	Leases   int
+	Crashes  int
+	Failures int
+	// States counts the tasks by their states at the end.
+	States map[string]int
+	// LeasesByTenant counts the leases handed out to each tenant.
+	LeasesByTenant map[string]int
+	// MaxLeased is the maximum number of tasks leased at once.
+	MaxLeased int
+}
+
+// worker is a virtual worker, which is idle if `task` is empty and `downUntil` has passed.
+type worker struct {
+	name string
+	task string
+	// finishAt is when the current attempt ends, by finishing, failing or crashing.
+	finishAt  time.Time
+	crashes   bool
+	fails     bool
+	downUntil time.Time
+}
+
+// Run runs the simulation described by `Config`.
+func Run(Config Config) Result {
+	if Config.Step <= 0 {
+		Config.Step = time.Second
+	}
+	if Config.LeaseDuration <= 0 {
+		Config.LeaseDuration = 10 * Config.Step
+	}
+	if Config.MaxDuration <= 0 {
+		Config.MaxDuration = 24 * time.Hour
+	}
+	if len(Config.Tenants) == 0 {
+		Config.Tenants = []string{taskmaster.DefaultTenant}
+	}
+	random := rand.New(rand.NewSource(Config.Seed))
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	fakeClock := clock.NewFake(start)
+	scheduler := taskmaster.NewScheduler(fakeClock)
+	scheduler.UpdateSettings(Config.Settings)
+	for i := 0; i < Config.Tasks; i++ {
+		scheduler.Insert(taskmaster.Task{ID: fmt.Sprintf("task-%08d", i), Tenant: Config.Tenants[i%len(Config.Tenants)]})
+	}
+	workers := make([]worker, Config.Workers)
+	for i := range workers {
+		workers[i].name = fmt.Sprintf("worker-%06d", i)
+	}
+
+	result := Result{LeasesByTenant: make(map[string]int)}
+	for {
+		fakeClock.Advance(Config.Step)
+		now := fakeClock.Now()
+		for i := range workers {
+			current := &workers[i]
+			if len(current.task) > 0 {
+				if now.Before(current.finishAt) {
+					// Keeps the lease while running.
+					if err := scheduler.ExtendLoan(current.task, now.Add(Config.LeaseDuration)); err != nil {
+						current.task = ""
+					}
+					continue
+				}
+				switch {
+				case current.crashes:
+					result.Crashes++
+					current.downUntil = current.finishAt.Add(Config.RestartDelay)
+				case current.fails:
+					result.Failures++
+					scheduler.Finish(current.task, true, "")
+				default:
+					scheduler.Finish(current.task, false, "")
+				}
+				current.task = ""
+			}
+			if now.Before(current.downUntil) {
+				continue
+			}
+			task := scheduler.Lease(current.name, Config.LeaseDuration)
+			if task == nil {
+				continue
+			}
+			result.Leases++
+			result.LeasesByTenant[task.Tenant]++
+			duration := Config.MinTaskDuration
+			if spread := Config.MaxTaskDuration - Config.MinTaskDuration; spread > 0 {
+				duration += time.Duration(random.Int63n(int64(spread)))
+			}
+			current.task = task.ID
+			current.crashes = random.Float64() < Config.CrashRate
+			current.fails = random.Float64() < Config.FailureRate
+			if current.crashes {
+				// Crashes at a random point of the attempt.
+				duration = time.Duration(random.Float64() * float64(duration))
+			}
+			current.finishAt = now.Add(duration)
+		}
+		if leased := scheduler.LeasedCount(); leased > result.MaxLeased {
+			result.MaxLeased = leased
+		}
+		result.Elapsed = now.Sub(start)
+		if pending, leased := scheduler.Stats(); (pending == 0 && leased == 0) || result.Elapsed >= Config.MaxDuration {
+			break
+		}
+	}
+	result.States = scheduler.StateCounts()
+	return result
+}
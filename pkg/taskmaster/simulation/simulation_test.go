@@ -0,0 +1,67 @@
+package simulation_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	"github.com/xpy123993/toolbox/pkg/taskmaster/simulation"
+)
+
+func TestSimulationDeterministic(t *testing.T) {
+	config := simulation.Config{
+		Seed:            42,
+		Workers:         1000,
+		Tasks:           3000,
+		Settings:        taskmaster.GroupSettings{MaxAttempts: 3},
+		MinTaskDuration: 5 * time.Second,
+		MaxTaskDuration: time.Minute,
+		CrashRate:       0.1,
+		RestartDelay:    time.Minute,
+		FailureRate:     0.1,
+	}
+	first := simulation.Run(config)
+	if states := first.States; states[taskmaster.StateDone]+states[taskmaster.StateFailed] != config.Tasks {
+		t.Fatalf("expect all tasks finished despite crashes, got %v", states)
+	}
+	if first.Crashes == 0 || first.Leases <= config.Tasks {
+		t.Errorf("expect crashed attempts to be leased again, got %d crashes and %d leases", first.Crashes, first.Leases)
+	}
+	if second := simulation.Run(config); !reflect.DeepEqual(first, second) {
+		t.Errorf("expect the same result from the same seed, got %+v and %+v", first, second)
+	}
+}
+
+func TestSimulationConcurrencyLimit(t *testing.T) {
+	result := simulation.Run(simulation.Config{
+		Seed:            1,
+		Workers:         100,
+		Tasks:           200,
+		Settings:        taskmaster.GroupSettings{MaxConcurrency: 10},
+		MinTaskDuration: 10 * time.Second,
+		MaxTaskDuration: 20 * time.Second,
+		CrashRate:       0.2,
+	})
+	if result.MaxLeased != 10 || result.States[taskmaster.StateDone] != 200 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSimulationFairShare(t *testing.T) {
+	result := simulation.Run(simulation.Config{
+		Seed:            7,
+		Workers:         20,
+		Tasks:           2000,
+		Tenants:         []string{"alice", "bob"},
+		Settings:        taskmaster.GroupSettings{FairShare: true, TenantWeights: map[string]float64{"alice": 3}},
+		MinTaskDuration: 10 * time.Second,
+		MaxTaskDuration: 10 * time.Second,
+		MaxDuration:     10 * time.Minute,
+	})
+	// Both tenants are backlogged during the whole simulation.
+	alice, bob := result.LeasesByTenant["alice"], result.LeasesByTenant["bob"]
+	if bob == 0 || alice < 2*bob || alice > 4*bob {
+		t.Errorf("expect leases shared 3:1, got %d and %d", alice, bob)
+	}
+}
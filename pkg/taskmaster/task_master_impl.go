@@ -2,19 +2,27 @@ package taskmaster
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/xpy123993/toolbox/pkg/clock"
+	"github.com/xpy123993/toolbox/pkg/metrics"
 	pb "github.com/xpy123993/toolbox/proto"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -24,21 +32,119 @@ type ServerImpl struct {
 
 	mu             sync.RWMutex
 	schedulerGroup map[string]*Scheduler
+	leaseLimiters  map[string]*tokenBucket
+	groupCancels   map[string]context.CancelFunc
 
 	snapshotFolder   string
 	snapshotInterval time.Duration
+	insertPolicy     InsertPolicy
+	metrics          *serverMetrics
+	replication      *replicator
+	cluster          *cluster
+	// workers are the workers recently queried each group, guarded by `mu`.
+	workers workerTracker
+	// blobs stores the payloads larger than `blobThreshold`, nil if disabled.
+	blobs         *BlobStore
+	blobThreshold int
+	// artifacts stores the files attached to tasks, nil if disabled.
+	artifacts       *BlobStore
+	artifactMaxSize int64
+	// maxImportSize is the maximum size of the snapshot of each group imported by `Import`.
+	maxImportSize int64
+	// webhooks delivers the events to callback URLs, nil if disabled.
+	webhooks *webhookSender
+	// events keeps the recent events of all groups for `Watch`.
+	events *eventLog
+	// pendingEvents are the events not yet appended to `events`.
+	pendingEvents *eventQueue
+	// jobWaiters wakes `WaitJob` on the events of the waited jobs.
+	jobWaiters *jobWaiters
+	// clock tells the time of the mutations.
+	clock clock.Clock
+	// audit records the mutations, nil if disabled.
+	audit *AuditLog
+	// health reports the readiness of the server and its groups, see `HealthServer`.
+	health *health.Server
+	// healthGroups are the groups reported to `health`, guarded by `healthMu`.
+	healthGroups map[string]bool
+	healthMu     sync.Mutex
+	// eventsDispatched is closed once `dispatchEvents` exits.
+	eventsDispatched chan struct{}
+	// draining is closed by `Drain`.
+	draining  chan struct{}
+	drainOnce sync.Once
+	// closed is closed by `Close`.
+	closed chan struct{}
+}
+
+// ServerOption configures optional behaviors of a task master server.
+type ServerOption func(*ServerImpl)
+
+// WithInsertPolicy restricts which client identities can insert into each group.
+func WithInsertPolicy(Policy InsertPolicy) ServerOption {
+	return func(server *ServerImpl) {
+		server.insertPolicy = Policy
+	}
+}
+
+// WithClock makes the server follow `Clock` instead of the system clock, such as in simulations.
+func WithClock(Clock clock.Clock) ServerOption {
+	return func(server *ServerImpl) {
+		server.clock = Clock
+	}
 }
 
 // NewTaskMasterServer creates a ready to use task master server.
-func NewTaskMasterServer(SnapshotFolder string, SnapshotInterval time.Duration) (*ServerImpl, error) {
+func NewTaskMasterServer(SnapshotFolder string, SnapshotInterval time.Duration, Options ...ServerOption) (*ServerImpl, error) {
 	if err := os.MkdirAll(SnapshotFolder, fs.ModePerm); err != nil {
 		return nil, err
 	}
 	taskMaster := ServerImpl{
 		mu:               sync.RWMutex{},
 		schedulerGroup:   make(map[string]*Scheduler),
+		leaseLimiters:    make(map[string]*tokenBucket),
+		groupCancels:     make(map[string]context.CancelFunc),
 		snapshotFolder:   SnapshotFolder,
 		snapshotInterval: SnapshotInterval,
+		metrics:          newServerMetrics(metrics.NewRegistry()),
+		replication:      newReplicator(path.Join(SnapshotFolder, replicationStateFile)),
+		closed:           make(chan struct{}),
+		draining:         make(chan struct{}),
+		events:           newEventLog(DefaultEventLogSize),
+		pendingEvents:    newEventQueue(),
+		eventsDispatched: make(chan struct{}),
+		health:           health.NewServer(),
+		jobWaiters:       newJobWaiters(),
+		clock:            clock.Real,
+		maxImportSize:    DefaultMaxImportSize,
+	}
+	for _, option := range Options {
+		option(&taskMaster)
+	}
+	if err := taskMaster.replication.load(); err != nil {
+		return nil, err
+	}
+	go taskMaster.dispatchEvents()
+	if taskMaster.webhooks != nil {
+		taskMaster.startWebhooks()
+	}
+	if taskMaster.blobThreshold > 0 {
+		if err := taskMaster.startBlobStore(); err != nil {
+			return nil, err
+		}
+	}
+	if taskMaster.artifactMaxSize > 0 {
+		if err := taskMaster.startArtifactStore(); err != nil {
+			return nil, err
+		}
+	}
+	go taskMaster.maintainHealth()
+	if taskMaster.cluster != nil {
+		if err := taskMaster.startCluster(); err != nil {
+			return nil, err
+		}
+		taskMaster.updateHealth()
+		return &taskMaster, nil
 	}
 	files, err := filepath.Glob(path.Join(SnapshotFolder, "*.json"))
 	if err != nil {
@@ -46,101 +152,767 @@ func NewTaskMasterServer(SnapshotFolder string, SnapshotInterval time.Duration)
 	}
 	for _, file := range files {
 		group := strings.TrimSuffix(path.Base(file), ".json")
-		taskMaster.schedulerGroup[group], err = NewTaskMaster(context.Background(), file, SnapshotInterval)
+		scheduler, err := taskMaster.openScheduler(group)
 		if err != nil {
 			return nil, fmt.Errorf("error while loading group `%s`: %v", group, err)
 		}
+		taskMaster.updateLeaseLimiter(group, scheduler.Settings())
+	}
+	if role, _ := taskMaster.replication.roleAndLeader(); role == RoleFollower {
+		taskMaster.startFollowing()
 	}
+	if taskMaster.replication.config.FailoverTimeout > 0 {
+		go taskMaster.maintainLease()
+	}
+	taskMaster.updateHealth()
 	return &taskMaster, nil
 }
 
+func (server *ServerImpl) snapshotFile(group string) string {
+	return path.Join(server.snapshotFolder, fmt.Sprintf("%s.json", group))
+}
+
+// openScheduler loads or creates the scheduler of `group`.
+// Must be called with `mu` held.
+func (server *ServerImpl) openScheduler(group string) (*Scheduler, error) {
+	if server.cluster != nil {
+		// The state of cluster members is persisted by the cluster.
+		scheduler := NewScheduler(server.clock)
+		scheduler.SetExpiryHook(server.recordExpiry(group))
+		scheduler.SetEventHook(server.recordEvent(group))
+		server.schedulerGroup[group] = scheduler
+		return scheduler, nil
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	scheduler, err := NewTaskMasterWithClock(ctx, server.snapshotFile(group), server.snapshotInterval, server.clock)
+	if err != nil {
+		cancelFn()
+		return nil, err
+	}
+	scheduler.SetMutationHook(server.recordMutation(group))
+	scheduler.SetExpiryHook(server.recordExpiry(group))
+	scheduler.SetEventHook(server.recordEvent(group))
+	// Followers only apply the mutations of the leader, which retires tasks on its own clock.
+	scheduler.SetPruneCondition(func() bool {
+		role, _ := server.replication.roleAndLeader()
+		return role != RoleFollower
+	})
+	server.schedulerGroup[group] = scheduler
+	server.groupCancels[group] = cancelFn
+	return scheduler, nil
+}
+
+// validateGroupName returns error if `group` cannot be used as a snapshot file name.
+func validateGroupName(group string) error {
+	if len(group) == 0 || group == "." || group == ".." || strings.ContainsAny(group, "/\\") {
+		return status.Errorf(codes.InvalidArgument, "invalid group name `%s`", group)
+	}
+	return nil
+}
+
+// updateLeaseLimiter rebuilds the lease rate limiter of `group` once its limits change.
+// An unchanged limiter keeps its tokens, so rewriting the settings does not refill the burst.
+// Must be called with `mu` held.
+func (server *ServerImpl) updateLeaseLimiter(group string, settings GroupSettings) {
+	if settings.LeaseRate > 0 {
+		if limiter, exists := server.leaseLimiters[group]; exists && limiter.limits(settings.LeaseRate, settings.LeaseBurst) {
+			return
+		}
+		server.leaseLimiters[group] = newTokenBucket(settings.LeaseRate, settings.LeaseBurst, server.clock.Now())
+	} else {
+		delete(server.leaseLimiters, group)
+	}
+}
+
+// getOrCreateScheduler returns the scheduler of `group`, the group will be created if not exists.
+// Must be called with `mu` held.
+func (server *ServerImpl) getOrCreateScheduler(group string) (*Scheduler, error) {
+	if scheduler, exists := server.schedulerGroup[group]; exists {
+		return scheduler, nil
+	}
+	if err := validateGroupName(group); err != nil {
+		return nil, err
+	}
+	scheduler, err := server.openScheduler(group)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error returned while the initialization: %v", err.Error())
+	}
+	return scheduler, nil
+}
+
 // Query implements the RPC method `TaskMaster.Query`.
 func (server *ServerImpl) Query(ctx context.Context, request *pb.QueryRequest) (*pb.QueryResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	server.mu.Lock()
+	_, exists := server.schedulerGroup[request.GetGroup()]
+	limiter := server.leaseLimiters[request.GetGroup()]
+	if exists {
+		server.workers.observe(request.GetGroup(), CallerIdentity(ctx), request.GetWorkerLabels(), server.clock.Now())
+	}
+	server.mu.Unlock()
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "group not found")
+	}
+	if limiter != nil && !limiter.take(server.clock.Now()) {
+		return nil, status.Errorf(codes.ResourceExhausted, "group `%s` reached its lease rate limit", request.GetGroup())
+	}
+	result := server.execute(ctx, command{
+		Op:       opLease,
+		Group:    request.GetGroup(),
+		Holder:   CallerIdentity(ctx),
+		Duration: request.LoanDuration.AsDuration(),
+		Labels:   request.GetWorkerLabels(),
+	})
+	if result.err != nil {
+		if limiter != nil {
+			limiter.refund()
+		}
+		return nil, result.err
+	}
+	task := result.task
+	server.metrics.leased.With(request.GetGroup()).Inc()
+	if task.expiredLease {
+		server.metrics.leaseExpirations.With(request.GetGroup()).Inc()
+	}
+	if task.Attempts == 1 {
+		server.metrics.queueLatency.With(request.GetGroup()).Observe(server.clock.Now().Sub(task.CreatedAt).Seconds())
+	}
+	return &pb.QueryResponse{
+		ID:            task.ID,
+		Data:          task.Data,
+		Deadline:      timestamppb.New(task.AvailableTime),
+		Blob:          task.Blob,
+		BlobSize:      task.BlobSize,
+		Limits:        limitsToProto(task.Limits),
+		ArtifactPaths: task.ArtifactPaths,
+	}, nil
+}
+
+// Finish implements the RPC method `TaskMaster.Finish`.
+func (server *ServerImpl) Finish(ctx context.Context, request *pb.FinishRequest) (*pb.FinishResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	result := server.execute(ctx, command{
+		Op:        opFinish,
+		Group:     request.GetGroup(),
+		ID:        request.GetID(),
+		Failed:    request.GetFailed(),
+		Permanent: request.GetPermanent(),
+		Log:       request.GetLog(),
+	})
+	if result.err != nil {
+		return nil, result.err
+	}
+	if request.GetFailed() {
+		server.metrics.attemptFailures.With(request.GetGroup()).Inc()
+	}
+	if result.state != StatePending {
+		server.metrics.finished.With(request.GetGroup(), result.state).Inc()
+	}
+	return &pb.FinishResponse{State: result.state}, nil
+}
+
+func (server *ServerImpl) Extend(ctx context.Context, request *pb.TaskExtendRequest) (*pb.TaskExtendResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	result := server.execute(ctx, command{
+		Op:       opExtend,
+		Group:    request.GetGroup(),
+		ID:       request.GetID(),
+		Duration: request.LoanDuration.AsDuration(),
+	})
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &pb.TaskExtendResponse{
+		Deadline: timestamppb.New(result.deadline),
+	}, nil
+}
+
+// Insert implements the RPC method `TaskMaster.Insert`.
+func (server *ServerImpl) Insert(ctx context.Context, request *pb.InsertRequest) (*pb.InsertResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if !server.insertPolicy.Allowed(ctx, request.GetGroup()) {
+		err := status.Errorf(codes.PermissionDenied, "`%s` is not allowed to insert into group `%s`", CallerIdentity(ctx), request.GetGroup())
+		server.auditDenial(ctx, command{Op: opInsert, Group: request.GetGroup(), Data: request.GetData()}, err)
+		return nil, err
+	}
+	if err := validateGroupName(request.GetGroup()); err != nil {
+		return nil, err
+	}
+	if err := ValidateLabels(request.GetLabels(), request.GetConstraints()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if err := server.validateCallbackURL(request.GetCallbackUrl()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	limits, err := limitsFromProto(request.GetLimits())
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateArtifactPaths(request.GetArtifactPaths()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	now := server.clock.Now()
+	var expiresAt, deadline time.Time
+	if request.GetExpireTime() != nil {
+		if expiresAt = request.GetExpireTime().AsTime(); !expiresAt.After(now) {
+			return nil, status.Errorf(codes.InvalidArgument, "expiry time `%v` is in the past", expiresAt)
+		}
+	}
+	if request.GetDeadline() != nil {
+		if deadline = request.GetDeadline().AsTime(); !deadline.After(now) {
+			return nil, status.Errorf(codes.InvalidArgument, "deadline `%v` is in the past", deadline)
+		}
+	}
+	tenant, err := insertTenant(ctx, request.GetTenant())
+	if err != nil {
+		server.auditDenial(ctx, command{Op: opInsert, Group: request.GetGroup(), Data: request.GetData()}, err)
+		return nil, err
+	}
+	payload := Task{Data: request.GetData()}
+	if err := server.offload(&payload); err != nil {
+		return nil, err
+	}
+	ID := uuid.NewString()
+	result := server.execute(ctx, command{
+		Op:            opInsert,
+		Group:         request.GetGroup(),
+		ID:            ID,
+		Data:          payload.Data,
+		Blob:          payload.Blob,
+		BlobSize:      payload.BlobSize,
+		Labels:        request.GetLabels(),
+		Constraints:   request.GetConstraints(),
+		Tenant:        tenant,
+		ExpiresAt:     expiresAt,
+		Deadline:      deadline,
+		CallbackURL:   request.GetCallbackUrl(),
+		Job:           request.GetJob(),
+		Limits:        limits,
+		ArtifactPaths: request.GetArtifactPaths(),
+	})
+	if result.err != nil {
+		return nil, result.err
+	}
+	server.metrics.inserted.With(request.GetGroup()).Inc()
+	return &pb.InsertResponse{
+		ID: ID,
+	}, nil
+}
+
+func groupSettingsToProto(settings GroupSettings) *pb.GroupSettings {
+	result := &pb.GroupSettings{
+		MaxConcurrency: int32(settings.MaxConcurrency),
+		LeaseRate:      settings.LeaseRate,
+		LeaseBurst:     int32(settings.LeaseBurst),
+		MaxAttempts:    int32(settings.MaxAttempts),
+		FairShare:      settings.FairShare,
+		TenantWeights:  settings.TenantWeights,
+		CallbackUrl:    settings.CallbackURL,
+	}
+	if settings.Retention > 0 {
+		result.Retention = durationpb.New(settings.Retention)
+	}
+	if settings.LoanDuration > 0 {
+		result.LoanDuration = durationpb.New(settings.LoanDuration)
+	}
+	if settings.MaxLoanDuration > 0 {
+		result.MaxLoanDuration = durationpb.New(settings.MaxLoanDuration)
+	}
+	return result
+}
+
+func groupSettingsFromProto(settings *pb.GroupSettings) (GroupSettings, error) {
+	if settings.GetMaxConcurrency() < 0 || settings.GetLeaseRate() < 0 || settings.GetLeaseBurst() < 0 ||
+		settings.GetMaxAttempts() < 0 || settings.GetRetention().AsDuration() < 0 ||
+		settings.GetLoanDuration().AsDuration() < 0 || settings.GetMaxLoanDuration().AsDuration() < 0 {
+		return GroupSettings{}, fmt.Errorf("settings must not be negative")
+	}
+	if err := ValidateTenantWeights(settings.GetTenantWeights()); err != nil {
+		return GroupSettings{}, err
+	}
+	return GroupSettings{
+		MaxConcurrency:  int(settings.GetMaxConcurrency()),
+		LeaseRate:       settings.GetLeaseRate(),
+		LeaseBurst:      int(settings.GetLeaseBurst()),
+		MaxAttempts:     int(settings.GetMaxAttempts()),
+		Retention:       settings.GetRetention().AsDuration(),
+		FairShare:       settings.GetFairShare(),
+		TenantWeights:   settings.GetTenantWeights(),
+		CallbackURL:     settings.GetCallbackUrl(),
+		LoanDuration:    settings.GetLoanDuration().AsDuration(),
+		MaxLoanDuration: settings.GetMaxLoanDuration().AsDuration(),
+	}, nil
+}
+
+// GetGroupSettings implements the RPC method `TaskMaster.GetGroupSettings`.
+func (server *ServerImpl) GetGroupSettings(ctx context.Context, request *pb.GetGroupSettingsRequest) (*pb.GetGroupSettingsResponse, error) {
 	server.mu.RLock()
 	defer server.mu.RUnlock()
 
 	if scheduler, exists := server.schedulerGroup[request.GetGroup()]; exists && scheduler != nil {
-		task := scheduler.Query(request.LoanDuration.AsDuration())
-		if task == nil {
-			return nil, status.Errorf(codes.NotFound, "no available tasks at present")
-		}
-		return &pb.QueryResponse{
-			ID:       task.ID,
-			Data:     task.Data,
-			Deadline: timestamppb.New(task.AvailableTime),
+		return &pb.GetGroupSettingsResponse{
+			Settings:  groupSettingsToProto(scheduler.Settings()),
+			Paused:    scheduler.Paused(),
+			Draining:  scheduler.Draining(),
+			TaskCount: int32(scheduler.TaskCount()),
 		}, nil
 	}
 	return nil, status.Errorf(codes.NotFound, "group not found")
 }
 
-// Finish implements the RPC method `TaskMaster.Finish`.
-func (server *ServerImpl) Finish(ctx context.Context, request *pb.FinishRequest) (*pb.FinishResponse, error) {
+// UpdateGroupSettings implements the RPC method `TaskMaster.UpdateGroupSettings`.
+func (server *ServerImpl) UpdateGroupSettings(ctx context.Context, request *pb.UpdateGroupSettingsRequest) (*pb.UpdateGroupSettingsResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opUpdateSettings, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	settings, err := groupSettingsFromProto(request.GetSettings())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if err := server.validateCallbackURL(settings.CallbackURL); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+	if err := validateGroupName(request.GetGroup()); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opUpdateSettings, Group: request.GetGroup(), Settings: &settings}); result.err != nil {
+		return nil, result.err
+	}
+	return &pb.UpdateGroupSettingsResponse{Settings: groupSettingsToProto(settings)}, nil
+}
+
+// insertTenant returns the tenant of a task inserted by the caller.
+// Authenticated callers always insert as themselves, so a tenant cannot take the fair share of another one.
+// The tenant of unauthenticated callers is taken as given, they are rejected by insert policies anyway.
+func insertTenant(ctx context.Context, tenant string) (string, error) {
+	subject, ok := authenticatedSubject(ctx)
+	if !ok {
+		return tenant, nil
+	}
+	if len(tenant) > 0 && tenant != subject {
+		return "", status.Errorf(codes.PermissionDenied, "`%s` is not allowed to insert as tenant `%s`", subject, tenant)
+	}
+	return subject, nil
+}
+
+// authorizeAdmin returns error if the caller is not allowed to manage the group of `request`,
+// the denied request is recorded in the audit log.
+// Group managers are the identities allowed to insert into the group.
+func (server *ServerImpl) authorizeAdmin(ctx context.Context, request command) error {
+	if !server.insertPolicy.Allowed(ctx, request.Group) {
+		err := status.Errorf(codes.PermissionDenied, "`%s` is not allowed to manage group `%s`", CallerIdentity(ctx), request.Group)
+		server.auditDenial(ctx, request, err)
+		return err
+	}
+	return nil
+}
+
+// getScheduler returns the scheduler of an existing group.
+func (server *ServerImpl) getScheduler(group string) (*Scheduler, error) {
 	server.mu.RLock()
 	defer server.mu.RUnlock()
-
-	if scheduler, exists := server.schedulerGroup[request.GetGroup()]; exists && scheduler != nil {
-		err := scheduler.MarkAsComplete(request.GetID())
-		if err != nil {
-			return nil, status.Errorf(codes.NotFound, "no active task with ID `%s`", request.GetID())
-		}
-		return &pb.FinishResponse{}, nil
+	if scheduler, exists := server.schedulerGroup[group]; exists && scheduler != nil {
+		return scheduler, nil
 	}
 	return nil, status.Errorf(codes.NotFound, "group not found")
 }
 
-func (server *ServerImpl) Extend(ctx context.Context, request *pb.TaskExtendRequest) (*pb.TaskExtendResponse, error) {
+// PauseGroup implements the RPC method `TaskMaster.PauseGroup`.
+func (server *ServerImpl) PauseGroup(ctx context.Context, request *pb.PauseGroupRequest) (*pb.PauseGroupResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opPause, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opPause, Group: request.GetGroup()}); result.err != nil {
+		return nil, result.err
+	}
+	return &pb.PauseGroupResponse{}, nil
+}
+
+// ResumeGroup implements the RPC method `TaskMaster.ResumeGroup`.
+func (server *ServerImpl) ResumeGroup(ctx context.Context, request *pb.ResumeGroupRequest) (*pb.ResumeGroupResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opResume, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opResume, Group: request.GetGroup()}); result.err != nil {
+		return nil, result.err
+	}
+	return &pb.ResumeGroupResponse{}, nil
+}
+
+// DrainGroup implements the RPC method `TaskMaster.DrainGroup`.
+func (server *ServerImpl) DrainGroup(ctx context.Context, request *pb.DrainGroupRequest) (*pb.DrainGroupResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opDrain, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opDrain, Group: request.GetGroup()}); result.err != nil {
+		return nil, result.err
+	}
+	return &pb.DrainGroupResponse{}, nil
+}
+
+// DeleteGroup implements the RPC method `TaskMaster.DeleteGroup`.
+func (server *ServerImpl) DeleteGroup(ctx context.Context, request *pb.DeleteGroupRequest) (*pb.DeleteGroupResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opDeleteGroup, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	result := server.execute(ctx, command{Op: opDeleteGroup, Group: request.GetGroup(), Force: request.GetForce()})
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &pb.DeleteGroupResponse{TaskCount: int32(result.count)}, nil
+}
+
+// removeGroup stops the scheduler of `group` and removes its snapshot.
+// Must be called with `mu` held.
+func (server *ServerImpl) removeGroup(group string) error {
+	if cancelFn, exists := server.groupCancels[group]; exists {
+		cancelFn()
+		<-server.schedulerGroup[group].Stopped()
+	}
+	delete(server.schedulerGroup, group)
+	delete(server.groupCancels, group)
+	delete(server.leaseLimiters, group)
+	delete(server.workers.groups, group)
+	if server.cluster != nil {
+		return nil
+	}
+	if err := os.Remove(server.snapshotFile(group)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListGroups implements the RPC method `TaskMaster.ListGroups`.
+func (server *ServerImpl) ListGroups(ctx context.Context, request *pb.ListGroupsRequest) (*pb.ListGroupsResponse, error) {
 	server.mu.RLock()
 	defer server.mu.RUnlock()
+	response := &pb.ListGroupsResponse{}
+	for group, scheduler := range server.schedulerGroup {
+		counts := scheduler.StateCounts()
+		response.Groups = append(response.Groups, &pb.GroupSummary{
+			Group:          group,
+			PendingCount:   int32(counts[StatePending]),
+			LeasedCount:    int32(counts[StateLeased]),
+			DoneCount:      int32(counts[StateDone]),
+			FailedCount:    int32(counts[StateFailed]),
+			CancelledCount: int32(counts[StateCancelled]),
+			ExpiredCount:   int32(counts[StateExpired]),
+			Paused:         scheduler.Paused(),
+			Draining:       scheduler.Draining(),
+			Settings:       groupSettingsToProto(scheduler.Settings()),
+		})
+	}
+	sort.Slice(response.Groups, func(i, j int) bool { return response.Groups[i].Group < response.Groups[j].Group })
+	return response, nil
+}
 
-	if scheduler, exists := server.schedulerGroup[request.GetGroup()]; exists && scheduler != nil {
-		newDeadline := time.Now().Add(request.LoanDuration.AsDuration())
-		err := scheduler.ExtendLoan(request.GetID(), newDeadline)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, err.Error())
-		}
-		return &pb.TaskExtendResponse{
-			Deadline: timestamppb.New(newDeadline),
-		}, nil
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+func encodePageToken(task Task) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%s", task.CreatedAt.UnixNano(), task.ID)))
+}
+
+// decodePageToken returns the creation time and ID of the last task of the previous page.
+func decodePageToken(token string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, "", err
 	}
-	return nil, status.Errorf(codes.NotFound, "group not found")
+	parts := strings.SplitN(string(data), "/", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", fmt.Errorf("malformed page token")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, nanos), parts[1], nil
 }
 
-// Insert implements the RPC method `TaskMaster.Insert`.
-func (server *ServerImpl) Insert(ctx context.Context, request *pb.InsertRequest) (*pb.InsertResponse, error) {
-	server.mu.Lock()
-	scheduler, exists := server.schedulerGroup[request.GetGroup()]
-	if !exists {
-		var err error
-		scheduler, err = NewTaskMaster(context.Background(), path.Join(server.snapshotFolder, fmt.Sprintf("%s.json", request.GetGroup())), server.snapshotInterval)
+func taskToProto(task Task, now time.Time) *pb.TaskInfo {
+	info := &pb.TaskInfo{
+		ID:            task.ID,
+		Data:          task.Data,
+		State:         task.StateAt(now),
+		AvailableTime: timestamppb.New(task.AvailableTime),
+		CreatedTime:   timestamppb.New(task.CreatedAt),
+		Attempts:      int32(task.Attempts),
+		LeaseHolder:   task.LeaseHolder,
+		Log:           task.Log,
+		Labels:        task.Labels,
+		Constraints:   task.Constraints,
+		Tenant:        task.Tenant,
+		Blob:          task.Blob,
+		BlobSize:      task.BlobSize,
+		CallbackUrl:   task.CallbackURL,
+		Job:           task.Job,
+		ArtifactPaths: task.ArtifactPaths,
+	}
+	for _, artifact := range task.Artifacts {
+		info.Artifacts = append(info.Artifacts, &pb.Artifact{Name: artifact.Name, Hash: artifact.Hash, Size: artifact.Size})
+	}
+	if len(task.State) > 0 {
+		info.FinishedTime = timestamppb.New(task.FinishedAt)
+	}
+	if !task.ExpiresAt.IsZero() {
+		info.ExpireTime = timestamppb.New(task.ExpiresAt)
+	}
+	if !task.Deadline.IsZero() {
+		info.Deadline = timestamppb.New(task.Deadline)
+	}
+	return info
+}
+
+// hidePayload clears the data and the log of `info`, which are only returned to the lease holder of the task
+// and the managers of its group, like the payloads in the blob store, see `GetBlob`.
+func hidePayload(info *pb.TaskInfo) {
+	info.Data = ""
+	info.Log = ""
+}
+
+// matchTask returns true if the state, ID, lease holder, tenant or job of the task contains `filter`.
+func matchTask(task Task, now time.Time, filter string) bool {
+	return len(filter) == 0 || strings.Contains(task.StateAt(now), filter) ||
+		strings.Contains(task.ID, filter) || strings.Contains(task.LeaseHolder, filter) ||
+		strings.Contains(task.Tenant, filter) || strings.Contains(task.Job, filter)
+}
+
+// ListTasks implements the RPC method `TaskMaster.ListTasks`.
+// The data and the logs are hidden from callers other than the managers of the group and the lease holders of the tasks.
+func (server *ServerImpl) ListTasks(ctx context.Context, request *pb.ListTasksRequest) (*pb.ListTasksResponse, error) {
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	pageSize := int(request.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	now := server.clock.Now()
+	tasks := scheduler.Tasks()
+	if len(request.GetFilter()) > 0 {
+		matched := tasks[:0]
+		for _, task := range tasks {
+			if matchTask(task, now, request.GetFilter()) {
+				matched = append(matched, task)
+			}
+		}
+		tasks = matched
+	}
+	start := 0
+	if len(request.GetPageToken()) > 0 {
+		createdAt, ID, err := decodePageToken(request.GetPageToken())
 		if err != nil {
-			server.mu.Unlock()
-			return nil, status.Errorf(codes.Internal, "error returned while the initialization: %v", err.Error())
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
 		}
-		server.schedulerGroup[request.GetGroup()] = scheduler
+		start = sort.Search(len(tasks), func(i int) bool {
+			if !tasks[i].CreatedAt.Equal(createdAt) {
+				return tasks[i].CreatedAt.After(createdAt)
+			}
+			return tasks[i].ID > ID
+		})
 	}
-	server.mu.Unlock()
-	return &pb.InsertResponse{
-		ID: scheduler.NewTask(request.Data),
-	}, nil
+	response := &pb.ListTasksResponse{}
+	manager := server.insertPolicy.Allowed(ctx, request.GetGroup())
+	server.mu.RLock()
+	for i := start; i < len(tasks) && len(response.Tasks) < pageSize; i++ {
+		info := taskToProto(tasks[i], now)
+		if !manager && tasks[i].LeaseHolder != CallerIdentity(ctx) {
+			hidePayload(info)
+		}
+		info.UnschedulableReason = server.workers.unschedulableReason(request.GetGroup(), &tasks[i], now)
+		response.Tasks = append(response.Tasks, info)
+	}
+	server.mu.RUnlock()
+	if end := start + len(response.Tasks); end < len(tasks) && len(response.Tasks) > 0 {
+		response.NextPageToken = encodePageToken(tasks[end-1])
+	}
+	return response, nil
 }
 
-// RenderStatusPage renders a basic HTML page to show the contents inside the server.
-func (server *ServerImpl) RenderStatusPage(ctx context.Context, writer io.Writer) {
+// GetTask implements the RPC method `TaskMaster.GetTask`.
+// The data and the log are hidden from callers other than the lease holder and the managers of the group.
+func (server *ServerImpl) GetTask(ctx context.Context, request *pb.GetTaskRequest) (*pb.GetTaskResponse, error) {
+	scheduler, err := server.getScheduler(request.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	task, ok := scheduler.GetTask(request.GetID())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no task with ID `%s`", request.GetID())
+	}
+	now := server.clock.Now()
+	info := taskToProto(task, now)
+	if task.LeaseHolder != CallerIdentity(ctx) && !server.insertPolicy.Allowed(ctx, request.GetGroup()) {
+		hidePayload(info)
+	}
 	server.mu.RLock()
-	defer server.mu.RUnlock()
-	fmt.Fprintf(writer, "<h2> Total group count: %d </h2>\n", len(server.schedulerGroup))
-	for groupName, scheduler := range server.schedulerGroup {
-		snapshot := scheduler.GetSnapshot()
-		fmt.Fprintf(writer, "<div>\n")
-		fmt.Fprintf(writer, "<h3> Group `%s` </h3>\n", groupName)
-		fmt.Fprintf(writer, "<h4> Task Number: %d </h4>\n", len(snapshot.AvailableTasks))
-		for ID, task := range snapshot.AvailableTasks {
-			label := "Pending"
-			if task.AvailableTime.After(time.Now()) {
-				label = "Working"
+	info.UnschedulableReason = server.workers.unschedulableReason(request.GetGroup(), &task, now)
+	server.mu.RUnlock()
+	return &pb.GetTaskResponse{Task: info}, nil
+}
+
+// CancelTask implements the RPC method `TaskMaster.CancelTask`.
+func (server *ServerImpl) CancelTask(ctx context.Context, request *pb.CancelTaskRequest) (*pb.CancelTaskResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opCancel, Group: request.GetGroup(), ID: request.GetID()}); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opCancel, Group: request.GetGroup(), ID: request.GetID()}); result.err != nil {
+		return nil, result.err
+	}
+	server.metrics.finished.With(request.GetGroup(), StateCancelled).Inc()
+	return &pb.CancelTaskResponse{}, nil
+}
+
+// RequeueTask implements the RPC method `TaskMaster.RequeueTask`.
+func (server *ServerImpl) RequeueTask(ctx context.Context, request *pb.RequeueTaskRequest) (*pb.RequeueTaskResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opRequeue, Group: request.GetGroup(), ID: request.GetID()}); err != nil {
+		return nil, err
+	}
+	if result := server.execute(ctx, command{Op: opRequeue, Group: request.GetGroup(), ID: request.GetID()}); result.err != nil {
+		return nil, result.err
+	}
+	return &pb.RequeueTaskResponse{}, nil
+}
+
+// GetGroupSnapshot implements the RPC method `TaskMaster.GetGroupSnapshot`.
+func (server *ServerImpl) GetGroupSnapshot(ctx context.Context, request *pb.GetGroupSnapshotRequest) (*pb.GetGroupSnapshotResponse, error) {
+	if err := server.authorizeAdmin(ctx, command{Op: opGetGroupSnapshot, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	data, err := server.encodeGroupSnapshot(request.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetGroupSnapshotResponse{Snapshot: data}, nil
+}
+
+// encodeGroupSnapshot returns the JSON encoded snapshot of `group`.
+// Payloads are inlined, so the snapshot can be restored on servers without the blobs.
+func (server *ServerImpl) encodeGroupSnapshot(group string) ([]byte, error) {
+	scheduler, err := server.getScheduler(group)
+	if err != nil {
+		return nil, err
+	}
+	return server.encodeSnapshot(scheduler.GetSnapshot())
+}
+
+// encodeSnapshot returns the JSON encoded `snapshot` with payloads inlined.
+func (server *ServerImpl) encodeSnapshot(snapshot *Snapshot) ([]byte, error) {
+	for _, tasks := range []map[string]Task{snapshot.AvailableTasks, snapshot.FinishedTasks} {
+		for ID, task := range tasks {
+			if err := server.inline(&task); err != nil {
+				return nil, err
+			}
+			tasks[ID] = task
+		}
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot encode snapshot: %v", err)
+	}
+	return data, nil
+}
+
+// decodeGroupSnapshot decodes a snapshot returned by `encodeGroupSnapshot`, moving large payloads into the blob store.
+func (server *ServerImpl) decodeGroupSnapshot(data []byte) (*Snapshot, error) {
+	snapshot, err := DecodeSnapshot(data)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot: %v", err)
+	}
+	for _, tasks := range []map[string]Task{snapshot.AvailableTasks, snapshot.FinishedTasks} {
+		for ID, task := range tasks {
+			if err := server.offload(&task); err != nil {
+				return nil, err
 			}
-			fmt.Fprintf(writer, "<div><b>[%s]</b> %s</div>\n", label, ID)
+			tasks[ID] = task
 		}
-		fmt.Fprintf(writer, "</div>\n")
+	}
+	return snapshot, nil
+}
+
+// RestoreGroup implements the RPC method `TaskMaster.RestoreGroup`.
+func (server *ServerImpl) RestoreGroup(ctx context.Context, request *pb.RestoreGroupRequest) (*pb.RestoreGroupResponse, error) {
+	if err := server.checkLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := server.authorizeAdmin(ctx, command{Op: opRestoreGroup, Group: request.GetGroup()}); err != nil {
+		return nil, err
+	}
+	if err := validateGroupName(request.GetGroup()); err != nil {
+		return nil, err
+	}
+	snapshot, err := server.decodeGroupSnapshot(request.GetSnapshot())
+	if err != nil {
+		return nil, err
+	}
+	result := server.execute(ctx, command{Op: opRestoreGroup, Group: request.GetGroup(), Force: request.GetReplace(), Snapshot: snapshot})
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &pb.RestoreGroupResponse{TaskCount: int32(result.count)}, nil
+}
+
+// limitsFromProto converts the limits requested by an insert, nil if no limit is set.
+func limitsFromProto(limits *pb.ResourceLimits) (*ResourceLimits, error) {
+	if limits.GetCpuSeconds() < 0 || limits.GetMemoryBytes() < 0 || limits.GetOpenFiles() < 0 || limits.GetOutputBytes() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "resource limits must not be negative")
+	}
+	result := &ResourceLimits{
+		CPUSeconds:  limits.GetCpuSeconds(),
+		MemoryBytes: limits.GetMemoryBytes(),
+		OpenFiles:   limits.GetOpenFiles(),
+		OutputBytes: limits.GetOutputBytes(),
+	}
+	if *result == (ResourceLimits{}) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+func limitsToProto(limits *ResourceLimits) *pb.ResourceLimits {
+	if limits == nil {
+		return nil
+	}
+	return &pb.ResourceLimits{
+		CpuSeconds:  limits.CPUSeconds,
+		MemoryBytes: limits.MemoryBytes,
+		OpenFiles:   limits.OpenFiles,
+		OutputBytes: limits.OutputBytes,
 	}
 }
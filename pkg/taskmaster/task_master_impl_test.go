@@ -0,0 +1,209 @@
+package taskmaster_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/clock"
+	"github.com/xpy123993/toolbox/pkg/metrics"
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func createTestServer(t *testing.T, Options ...taskmaster.ServerOption) *taskmaster.ServerImpl {
+	server, err := taskmaster.NewTaskMasterServer(t.TempDir(), time.Minute, Options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestServerLeaseRateLimit(t *testing.T) {
+	server := createTestServer(t)
+	ctx := context.Background()
+	if _, err := server.UpdateGroupSettings(ctx, &pb.UpdateGroupSettingsRequest{
+		Group:    "default",
+		Settings: &pb.GroupSettings{LeaseRate: 0.001, LeaseBurst: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request := &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}
+	if _, err := server.Query(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Query(ctx, request); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expect ResourceExhausted, got %v", err)
+	}
+	// Rewriting the settings with the same limits must not refill the burst.
+	if _, err := server.UpdateGroupSettings(ctx, &pb.UpdateGroupSettingsRequest{
+		Group:    "default",
+		Settings: &pb.GroupSettings{LeaseRate: 0.001, LeaseBurst: 1, MaxConcurrency: 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Query(ctx, request); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expect ResourceExhausted after rewriting the settings, got %v", err)
+	}
+	resp, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetSettings().GetLeaseBurst() != 1 {
+		t.Errorf("unexpected settings: %v", resp.GetSettings())
+	}
+}
+
+func TestServerGroupLifecycle(t *testing.T) {
+	snapshotFolder := t.TempDir()
+	// The group is loaded from its snapshot, so deleting it must remove the file.
+	if err := os.WriteFile(path.Join(snapshotFolder, "default.json"), []byte(`{"version": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, err := taskmaster.NewTaskMasterServer(snapshotFolder, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.PauseGroup(ctx, &pb.PauseGroupRequest{Group: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	request := &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}
+	if _, err := server.Query(ctx, request); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect FailedPrecondition, got %v", err)
+	}
+	if _, err := server.DrainGroup(ctx, &pb.DrainGroupRequest{Group: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect FailedPrecondition, got %v", err)
+	}
+	if _, err := server.DeleteGroup(ctx, &pb.DeleteGroupRequest{Group: "default"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect FailedPrecondition, got %v", err)
+	}
+	if _, err := server.ResumeGroup(ctx, &pb.ResumeGroupRequest{Group: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Query(ctx, request); err != nil {
+		t.Error(err)
+	}
+	resp, err := server.DeleteGroup(ctx, &pb.DeleteGroupRequest{Group: "default", Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetTaskCount() != 1 {
+		t.Errorf("unexpected task count: %d", resp.GetTaskCount())
+	}
+	if _, err := os.Stat(path.Join(snapshotFolder, "default.json")); !os.IsNotExist(err) {
+		t.Errorf("expect snapshot to be removed, got %v", err)
+	}
+	if _, err := server.GetGroupSettings(ctx, &pb.GetGroupSettingsRequest{Group: "default"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect NotFound, got %v", err)
+	}
+}
+
+func TestServerTaskExpiry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	fakeClock := clock.NewFake(time.Now())
+	server := createTestServer(t, taskmaster.WithMetrics(registry), taskmaster.WithClock(fakeClock))
+	ctx := context.Background()
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", ExpireTime: timestamppb.New(fakeClock.Now().Add(-time.Second))}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect expiry time in the past to be rejected, got %v", err)
+	}
+	running, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Deadline: timestamppb.New(fakeClock.Now().Add(100 * time.Millisecond))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tasks become available strictly after their insertion.
+	fakeClock.Advance(time.Millisecond)
+	if _, err := server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", ExpireTime: timestamppb.New(fakeClock.Now().Add(100 * time.Millisecond))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Advance(150 * time.Millisecond)
+
+	// The running task is cancelled on its next loan extension.
+	if _, err := server.Extend(ctx, &pb.TaskExtendRequest{Group: "default", ID: running.GetID(), LoanDuration: durationpb.New(time.Minute)}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expect the extension to fail past the deadline, got %v", err)
+	}
+	if _, err := server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}); status.Code(err) != codes.NotFound {
+		t.Errorf("expect expired tasks not to be leased, got %v", err)
+	}
+	for ID, state := range map[string]string{running.GetID(): taskmaster.StateCancelled, expired.GetID(): taskmaster.StateExpired} {
+		resp, err := server.GetTask(ctx, &pb.GetTaskRequest{Group: "default", ID: ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetTask().GetState() != state {
+			t.Errorf("expect task in state %s, got %s", state, resp.GetTask().GetState())
+		}
+	}
+
+	// Requeued tasks get another chance.
+	if _, err := server.RequeueTask(ctx, &pb.RequeueTaskRequest{Group: "default", ID: expired.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock.Advance(time.Millisecond)
+	if resp, err := server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)}); err != nil || resp.GetID() != expired.GetID() {
+		t.Errorf("expect the requeued task to be leased, got %v, %v", resp, err)
+	}
+
+	output := &strings.Builder{}
+	if err := registry.WriteText(output); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`taskmaster_task_expirations_total{group="default",reason="deadline"} 1`,
+		`taskmaster_task_expirations_total{group="default",reason="dead_letter"} 1`,
+	} {
+		if !strings.Contains(output.String(), line) {
+			t.Errorf("expect metric %s, got\n%s", line, output.String())
+		}
+	}
+}
+
+func TestServerResourceLimits(t *testing.T) {
+	server := createTestServer(t)
+	ctx := context.Background()
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Limits: &pb.ResourceLimits{MemoryBytes: -1}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect negative limits to be rejected, got %v", err)
+	}
+	limits := &pb.ResourceLimits{CpuSeconds: 10, MemoryBytes: 1 << 30, OpenFiles: 64, OutputBytes: 4096}
+	if _, err := server.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "test", Limits: limits}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(resp.GetLimits(), limits) {
+		t.Errorf("expect limits %v, got %v", limits, resp.GetLimits())
+	}
+	// A task no worker can run is failed without retries, even if the group retries failures indefinitely.
+	finished, err := server.Finish(ctx, &pb.FinishRequest{Group: "default", ID: resp.GetID(), Failed: true, Permanent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.GetState() != taskmaster.StateFailed {
+		t.Errorf("expect the task to fail permanently, got %s", finished.GetState())
+	}
+}
@@ -0,0 +1,227 @@
+package taskmaster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/xpy123993/toolbox/proto"
+)
+
+const (
+	// WebhookSignatureHeader carries `sha256=` followed by the hex encoded HMAC-SHA256 of the body keyed by the secret.
+	WebhookSignatureHeader = "X-WEBHOOK-SIGNATURE"
+
+	// DefaultWebhookAttempts is the number of attempts to deliver an event if not specified.
+	DefaultWebhookAttempts = 5
+	// webhookQueueSize is the number of events waiting for delivery, new events are dropped once the queue is full.
+	webhookQueueSize = 1024
+	// webhookSenders is the number of concurrent deliveries.
+	webhookSenders = 4
+	// webhookTimeout is the timeout of each delivery attempt.
+	webhookTimeout = 10 * time.Second
+	// webhookInitialBackoff is the delay before the first retry, doubled on each retry.
+	webhookInitialBackoff = time.Second
+)
+
+// WebhookConfig configures the delivery of completion callbacks.
+type WebhookConfig struct {
+	// Secret signs the events in `WebhookSignatureHeader`, so receivers knowing the secret can authenticate the server.
+	// The secret itself is never sent.
+	Secret string
+	// AllowedURLPrefixes restricts the callback URLs, since the events carry the tasks.
+	AllowedURLPrefixes []string
+	// Attempts is the number of attempts to deliver an event. Defaults to `DefaultWebhookAttempts`.
+	Attempts int
+	// Client sends the events. Defaults to `http.DefaultClient`.
+	Client *http.Client
+}
+
+// WithWebhooks enables callback URLs of tasks and groups.
+// Once a task is finished, failed, expired or cancelled, the `TaskEvent` is posted as JSON to the callback URL of the task,
+// or of its group if the task has none. Deliveries are retried with exponential backoff, but are not persisted.
+// The events are signed in the format verified by `cmd/webhook --secret-file`.
+func WithWebhooks(Config WebhookConfig) ServerOption {
+	return func(server *ServerImpl) {
+		if Config.Attempts <= 0 {
+			Config.Attempts = DefaultWebhookAttempts
+		}
+		if Config.Client == nil {
+			Config.Client = http.DefaultClient
+		}
+		server.webhooks = &webhookSender{config: Config, queue: make(chan webhookDelivery, webhookQueueSize)}
+	}
+}
+
+// SignWebhook returns the value of `WebhookSignatureHeader` of `Body`.
+func SignWebhook(Secret string, Body []byte) string {
+	mac := hmac.New(sha256.New, []byte(Secret))
+	mac.Write(Body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook returns true if `Signature` is the signature of `Body`.
+func VerifyWebhook(Secret string, Body []byte, Signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(Secret, Body)), []byte(Signature))
+}
+
+type webhookDelivery struct {
+	event *pb.TaskEvent
+	// url is the callback URL of the task, the callback URL of the group is used if empty.
+	url string
+}
+
+type webhookSender struct {
+	config WebhookConfig
+	queue  chan webhookDelivery
+	// senders are the running delivery routines.
+	senders sync.WaitGroup
+}
+
+// validateCallbackURL returns error if `url` cannot be used as a callback URL.
+func (server *ServerImpl) validateCallbackURL(url string) error {
+	if len(url) == 0 {
+		return nil
+	}
+	if server.webhooks == nil {
+		return fmt.Errorf("webhooks are disabled on the server")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("callback URL `%s` is not a HTTP URL", url)
+	}
+	for _, prefix := range server.webhooks.config.AllowedURLPrefixes {
+		if strings.HasPrefix(url, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback URL `%s` is not allowed by the server", url)
+}
+
+// enqueueWebhook queues the delivery of terminal events, it is called by `dispatchEvent` in the order of the events,
+// after the scheduler lock is released.
+func (server *ServerImpl) enqueueWebhook(event *pb.TaskEvent, task *Task) {
+	if server.webhooks == nil {
+		return
+	}
+	switch event.GetType() {
+	case EventFinished, EventExpired, EventCancelled:
+	case EventFailed:
+		// Failed attempts to be retried are not reported.
+		if task.State != StateFailed {
+			return
+		}
+	default:
+		return
+	}
+	select {
+	case server.webhooks.queue <- webhookDelivery{event: event, url: task.CallbackURL}:
+	default:
+		log.Printf("webhook queue is full, dropping event of task `%s`", task.ID)
+		server.metrics.webhookDeliveries.With(event.GetGroup(), "dropped").Inc()
+	}
+}
+
+// startWebhooks starts delivering events until the server is closed.
+func (server *ServerImpl) startWebhooks() {
+	server.webhooks.senders.Add(webhookSenders)
+	for i := 0; i < webhookSenders; i++ {
+		go func() {
+			defer server.webhooks.senders.Done()
+			for {
+				select {
+				case <-server.closed:
+					return
+				case delivery := <-server.webhooks.queue:
+					server.deliverWebhook(delivery)
+				}
+			}
+		}()
+	}
+}
+
+// deliverWebhook posts the event to its callback URL. Only the leader delivers events.
+func (server *ServerImpl) deliverWebhook(delivery webhookDelivery) {
+	if server.checkLeader(context.Background()) != nil {
+		return
+	}
+	url := delivery.url
+	if len(url) == 0 {
+		scheduler, err := server.getScheduler(delivery.event.GetGroup())
+		if err != nil {
+			return
+		}
+		url = scheduler.Settings().CallbackURL
+	}
+	if len(url) == 0 {
+		return
+	}
+	body, err := gatewayMarshaler.Marshal(delivery.event)
+	if err != nil {
+		log.Printf("cannot encode event: %v", err)
+		return
+	}
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := server.postWebhook(url, body)
+		if err == nil {
+			server.metrics.webhookDeliveries.With(delivery.event.GetGroup(), "success").Inc()
+			return
+		}
+		if attempt >= server.webhooks.config.Attempts {
+			log.Printf("cannot deliver event of task `%s` to `%s` after %d attempts: %v", delivery.event.GetID(), url, attempt, err)
+			server.metrics.webhookDeliveries.With(delivery.event.GetGroup(), "failure").Inc()
+			return
+		}
+		select {
+		case <-server.closed:
+			log.Printf("server is closed, dropping event of task `%s` after %d attempts: %v", delivery.event.GetID(), attempt, err)
+			server.metrics.webhookDeliveries.With(delivery.event.GetGroup(), "dropped").Inc()
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// stopWebhooks waits for the deliveries in progress once the server is closed,
+// then logs the queued events which are never delivered.
+func (server *ServerImpl) stopWebhooks() {
+	server.webhooks.senders.Wait()
+	for {
+		select {
+		case delivery := <-server.webhooks.queue:
+			log.Printf("server is closed, dropping queued event of task `%s`", delivery.event.GetID())
+			server.metrics.webhookDeliveries.With(delivery.event.GetGroup(), "dropped").Inc()
+		default:
+			return
+		}
+	}
+}
+
+func (server *ServerImpl) postWebhook(url string, body []byte) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancelFn()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(server.webhooks.config.Secret, body))
+	resp, err := server.webhooks.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status `%s`", resp.Status)
+	}
+	return nil
+}
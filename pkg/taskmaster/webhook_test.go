@@ -0,0 +1,90 @@
+package taskmaster_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpy123993/toolbox/pkg/taskmaster"
+	pb "github.com/xpy123993/toolbox/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func TestServerWebhook(t *testing.T) {
+	ctx := context.Background()
+	requests := make(chan webhookRequest, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- webhookRequest{header: r.Header, body: body}
+	}))
+	defer receiver.Close()
+
+	server := createTestServer(t, taskmaster.WithWebhooks(taskmaster.WebhookConfig{
+		Secret:             "secret",
+		AllowedURLPrefixes: []string{receiver.URL},
+	}))
+	defer server.Close()
+	address, _ := serveTestServer(t, server)
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewTaskMasterClient(conn)
+
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data", CallbackUrl: "http://disallowed.example/"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expect disallowed callback URLs to be rejected, got %v", err)
+	}
+	if _, err := client.Insert(ctx, &pb.InsertRequest{Group: "default", Data: "data", CallbackUrl: receiver.URL + "/hook"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Query(ctx, &pb.QueryRequest{Group: "default", LoanDuration: durationpb.New(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Finish(ctx, &pb.FinishRequest{Group: "default", ID: resp.GetID()}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case request := <-requests:
+		for name, values := range request.header {
+			for _, value := range values {
+				if strings.Contains(value, "secret") {
+					t.Errorf("expect the secret not to be sent, got header %s: %s", name, value)
+				}
+			}
+		}
+		if !taskmaster.VerifyWebhook("secret", request.body, request.header.Get(taskmaster.WebhookSignatureHeader)) {
+			t.Error("invalid signature")
+		}
+		event := struct {
+			Type string `json:"type"`
+			ID   string `json:"ID"`
+		}{}
+		if err := json.Unmarshal(request.body, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != taskmaster.EventFinished || event.ID != resp.GetID() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the webhook")
+	}
+}
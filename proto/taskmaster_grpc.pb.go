@@ -21,13 +21,63 @@ type TaskMasterClient interface {
 	// Query marks a task as "owned" and returns the task content.
 	// Returns error if no available tasks.
 	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
-	// Finish marks a task as "done".
+	// Finish marks a task as "done", or reports a failed attempt if `failed` is set.
 	// This will prevent the task master from scheduling again after expired.
+	// A failed task is scheduled again until it reaches the maximum attempts of its group.
 	Finish(ctx context.Context, in *FinishRequest, opts ...grpc.CallOption) (*FinishResponse, error)
-	// Extend extends an ongoing task's loan.
+	// Extend extends an ongoing task's loan, but not after the deadline of the task.
+	// Returns `FAILED_PRECONDITION` once the task passed its deadline and is cancelled.
 	Extend(ctx context.Context, in *TaskExtendRequest, opts ...grpc.CallOption) (*TaskExtendResponse, error)
 	// Insert inserts a new task into the task master.
 	Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error)
+	// GetGroupSettings returns the settings of a group.
+	GetGroupSettings(ctx context.Context, in *GetGroupSettingsRequest, opts ...grpc.CallOption) (*GetGroupSettingsResponse, error)
+	// UpdateGroupSettings replaces the settings of a group.
+	// The group will be created if not exists.
+	UpdateGroupSettings(ctx context.Context, in *UpdateGroupSettingsRequest, opts ...grpc.CallOption) (*UpdateGroupSettingsResponse, error)
+	// PauseGroup stops handing out leases of a group. Ongoing leases are not affected.
+	PauseGroup(ctx context.Context, in *PauseGroupRequest, opts ...grpc.CallOption) (*PauseGroupResponse, error)
+	// ResumeGroup clears the paused and draining states of a group.
+	ResumeGroup(ctx context.Context, in *ResumeGroupRequest, opts ...grpc.CallOption) (*ResumeGroupResponse, error)
+	// DrainGroup stops accepting new tasks into a group, remaining tasks will still be leased.
+	DrainGroup(ctx context.Context, in *DrainGroupRequest, opts ...grpc.CallOption) (*DrainGroupResponse, error)
+	// DeleteGroup removes a group and its snapshot.
+	// Returns error if the group still has tasks unless `force` is set.
+	DeleteGroup(ctx context.Context, in *DeleteGroupRequest, opts ...grpc.CallOption) (*DeleteGroupResponse, error)
+	// ListGroups returns the summaries of all groups.
+	ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error)
+	// ListTasks returns the tasks of a group ordered by their creation time.
+	// The data and the logs of the tasks are only returned to the managers of the group and the lease holders of the tasks.
+	ListTasks(ctx context.Context, in *ListTasksRequest, opts ...grpc.CallOption) (*ListTasksResponse, error)
+	// GetTask returns an active or finished task.
+	// The data and the log of the task are only returned to its lease holder and the managers of its group.
+	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error)
+	// CancelTask moves an active task into the cancelled state.
+	// The worker holding its lease will be stopped on its next loan extension.
+	CancelTask(ctx context.Context, in *CancelTaskRequest, opts ...grpc.CallOption) (*CancelTaskResponse, error)
+	// RequeueTask makes a leased or finished task available to lease immediately.
+	RequeueTask(ctx context.Context, in *RequeueTaskRequest, opts ...grpc.CallOption) (*RequeueTaskResponse, error)
+	// GetGroupSnapshot returns a point-in-time snapshot of a group.
+	GetGroupSnapshot(ctx context.Context, in *GetGroupSnapshotRequest, opts ...grpc.CallOption) (*GetGroupSnapshotResponse, error)
+	// RestoreGroup creates a group from a snapshot returned by `GetGroupSnapshot`.
+	RestoreGroup(ctx context.Context, in *RestoreGroupRequest, opts ...grpc.CallOption) (*RestoreGroupResponse, error)
+	// Export streams the snapshots of groups, each group is a consistent point-in-time snapshot.
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TaskMaster_ExportClient, error)
+	// Import merges or replaces groups with the snapshots streamed in the form of `Export`.
+	Import(ctx context.Context, opts ...grpc.CallOption) (TaskMaster_ImportClient, error)
+	// GetBlob streams the payload stored in the blob store.
+	GetBlob(ctx context.Context, in *GetBlobRequest, opts ...grpc.CallOption) (TaskMaster_GetBlobClient, error)
+	// Watch streams the changes of tasks until the client cancels. Headers are sent once the watch starts.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (TaskMaster_WatchClient, error)
+	// GetJob returns the aggregate status of the tasks inserted with the same `job` in a group.
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*GetJobResponse, error)
+	// WaitJob blocks until all tasks of a job are finished, failed, expired or cancelled.
+	WaitJob(ctx context.Context, in *WaitJobRequest, opts ...grpc.CallOption) (*WaitJobResponse, error)
+	// UploadArtifact attaches a file produced by a leased task to it. The first chunk names the task and the file.
+	// Only the lease holder of the task can upload.
+	UploadArtifact(ctx context.Context, opts ...grpc.CallOption) (TaskMaster_UploadArtifactClient, error)
+	// GetArtifact streams an artifact attached to a task, to its lease holder or the managers of its group.
+	GetArtifact(ctx context.Context, in *GetArtifactRequest, opts ...grpc.CallOption) (TaskMaster_GetArtifactClient, error)
 }
 
 type taskMasterClient struct {
@@ -74,6 +124,337 @@ func (c *taskMasterClient) Insert(ctx context.Context, in *InsertRequest, opts .
 	return out, nil
 }
 
+func (c *taskMasterClient) GetGroupSettings(ctx context.Context, in *GetGroupSettingsRequest, opts ...grpc.CallOption) (*GetGroupSettingsResponse, error) {
+	out := new(GetGroupSettingsResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/GetGroupSettings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) UpdateGroupSettings(ctx context.Context, in *UpdateGroupSettingsRequest, opts ...grpc.CallOption) (*UpdateGroupSettingsResponse, error) {
+	out := new(UpdateGroupSettingsResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/UpdateGroupSettings", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) PauseGroup(ctx context.Context, in *PauseGroupRequest, opts ...grpc.CallOption) (*PauseGroupResponse, error) {
+	out := new(PauseGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/PauseGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) ResumeGroup(ctx context.Context, in *ResumeGroupRequest, opts ...grpc.CallOption) (*ResumeGroupResponse, error) {
+	out := new(ResumeGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/ResumeGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) DrainGroup(ctx context.Context, in *DrainGroupRequest, opts ...grpc.CallOption) (*DrainGroupResponse, error) {
+	out := new(DrainGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/DrainGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) DeleteGroup(ctx context.Context, in *DeleteGroupRequest, opts ...grpc.CallOption) (*DeleteGroupResponse, error) {
+	out := new(DeleteGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/DeleteGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error) {
+	out := new(ListGroupsResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/ListGroups", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) ListTasks(ctx context.Context, in *ListTasksRequest, opts ...grpc.CallOption) (*ListTasksResponse, error) {
+	out := new(ListTasksResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/ListTasks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error) {
+	out := new(GetTaskResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/GetTask", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) CancelTask(ctx context.Context, in *CancelTaskRequest, opts ...grpc.CallOption) (*CancelTaskResponse, error) {
+	out := new(CancelTaskResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/CancelTask", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) RequeueTask(ctx context.Context, in *RequeueTaskRequest, opts ...grpc.CallOption) (*RequeueTaskResponse, error) {
+	out := new(RequeueTaskResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/RequeueTask", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) GetGroupSnapshot(ctx context.Context, in *GetGroupSnapshotRequest, opts ...grpc.CallOption) (*GetGroupSnapshotResponse, error) {
+	out := new(GetGroupSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/GetGroupSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) RestoreGroup(ctx context.Context, in *RestoreGroupRequest, opts ...grpc.CallOption) (*RestoreGroupResponse, error) {
+	out := new(RestoreGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/RestoreGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (TaskMaster_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[0], "/proto.TaskMaster/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskMaster_ExportClient interface {
+	Recv() (*ExportChunk, error)
+	grpc.ClientStream
+}
+
+type taskMasterExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterExportClient) Recv() (*ExportChunk, error) {
+	m := new(ExportChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterClient) Import(ctx context.Context, opts ...grpc.CallOption) (TaskMaster_ImportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[1], "/proto.TaskMaster/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterImportClient{stream}
+	return x, nil
+}
+
+type TaskMaster_ImportClient interface {
+	Send(*ImportChunk) error
+	CloseAndRecv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type taskMasterImportClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterImportClient) Send(m *ImportChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *taskMasterImportClient) CloseAndRecv() (*ImportResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterClient) GetBlob(ctx context.Context, in *GetBlobRequest, opts ...grpc.CallOption) (TaskMaster_GetBlobClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[2], "/proto.TaskMaster/GetBlob", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterGetBlobClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskMaster_GetBlobClient interface {
+	Recv() (*GetBlobResponse, error)
+	grpc.ClientStream
+}
+
+type taskMasterGetBlobClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterGetBlobClient) Recv() (*GetBlobResponse, error) {
+	m := new(GetBlobResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (TaskMaster_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[3], "/proto.TaskMaster/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskMaster_WatchClient interface {
+	Recv() (*TaskEvent, error)
+	grpc.ClientStream
+}
+
+type taskMasterWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterWatchClient) Recv() (*TaskEvent, error) {
+	m := new(TaskEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*GetJobResponse, error) {
+	out := new(GetJobResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/GetJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) WaitJob(ctx context.Context, in *WaitJobRequest, opts ...grpc.CallOption) (*WaitJobResponse, error) {
+	out := new(WaitJobResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMaster/WaitJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterClient) UploadArtifact(ctx context.Context, opts ...grpc.CallOption) (TaskMaster_UploadArtifactClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[4], "/proto.TaskMaster/UploadArtifact", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterUploadArtifactClient{stream}
+	return x, nil
+}
+
+type TaskMaster_UploadArtifactClient interface {
+	Send(*UploadArtifactRequest) error
+	CloseAndRecv() (*UploadArtifactResponse, error)
+	grpc.ClientStream
+}
+
+type taskMasterUploadArtifactClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterUploadArtifactClient) Send(m *UploadArtifactRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *taskMasterUploadArtifactClient) CloseAndRecv() (*UploadArtifactResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadArtifactResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterClient) GetArtifact(ctx context.Context, in *GetArtifactRequest, opts ...grpc.CallOption) (TaskMaster_GetArtifactClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMaster_ServiceDesc.Streams[5], "/proto.TaskMaster/GetArtifact", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterGetArtifactClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskMaster_GetArtifactClient interface {
+	Recv() (*GetArtifactResponse, error)
+	grpc.ClientStream
+}
+
+type taskMasterGetArtifactClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterGetArtifactClient) Recv() (*GetArtifactResponse, error) {
+	m := new(GetArtifactResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // TaskMasterServer is the server API for TaskMaster service.
 // All implementations must embed UnimplementedTaskMasterServer
 // for forward compatibility
@@ -81,13 +462,63 @@ type TaskMasterServer interface {
 	// Query marks a task as "owned" and returns the task content.
 	// Returns error if no available tasks.
 	Query(context.Context, *QueryRequest) (*QueryResponse, error)
-	// Finish marks a task as "done".
+	// Finish marks a task as "done", or reports a failed attempt if `failed` is set.
 	// This will prevent the task master from scheduling again after expired.
+	// A failed task is scheduled again until it reaches the maximum attempts of its group.
 	Finish(context.Context, *FinishRequest) (*FinishResponse, error)
-	// Extend extends an ongoing task's loan.
+	// Extend extends an ongoing task's loan, but not after the deadline of the task.
+	// Returns `FAILED_PRECONDITION` once the task passed its deadline and is cancelled.
 	Extend(context.Context, *TaskExtendRequest) (*TaskExtendResponse, error)
 	// Insert inserts a new task into the task master.
 	Insert(context.Context, *InsertRequest) (*InsertResponse, error)
+	// GetGroupSettings returns the settings of a group.
+	GetGroupSettings(context.Context, *GetGroupSettingsRequest) (*GetGroupSettingsResponse, error)
+	// UpdateGroupSettings replaces the settings of a group.
+	// The group will be created if not exists.
+	UpdateGroupSettings(context.Context, *UpdateGroupSettingsRequest) (*UpdateGroupSettingsResponse, error)
+	// PauseGroup stops handing out leases of a group. Ongoing leases are not affected.
+	PauseGroup(context.Context, *PauseGroupRequest) (*PauseGroupResponse, error)
+	// ResumeGroup clears the paused and draining states of a group.
+	ResumeGroup(context.Context, *ResumeGroupRequest) (*ResumeGroupResponse, error)
+	// DrainGroup stops accepting new tasks into a group, remaining tasks will still be leased.
+	DrainGroup(context.Context, *DrainGroupRequest) (*DrainGroupResponse, error)
+	// DeleteGroup removes a group and its snapshot.
+	// Returns error if the group still has tasks unless `force` is set.
+	DeleteGroup(context.Context, *DeleteGroupRequest) (*DeleteGroupResponse, error)
+	// ListGroups returns the summaries of all groups.
+	ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error)
+	// ListTasks returns the tasks of a group ordered by their creation time.
+	// The data and the logs of the tasks are only returned to the managers of the group and the lease holders of the tasks.
+	ListTasks(context.Context, *ListTasksRequest) (*ListTasksResponse, error)
+	// GetTask returns an active or finished task.
+	// The data and the log of the task are only returned to its lease holder and the managers of its group.
+	GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error)
+	// CancelTask moves an active task into the cancelled state.
+	// The worker holding its lease will be stopped on its next loan extension.
+	CancelTask(context.Context, *CancelTaskRequest) (*CancelTaskResponse, error)
+	// RequeueTask makes a leased or finished task available to lease immediately.
+	RequeueTask(context.Context, *RequeueTaskRequest) (*RequeueTaskResponse, error)
+	// GetGroupSnapshot returns a point-in-time snapshot of a group.
+	GetGroupSnapshot(context.Context, *GetGroupSnapshotRequest) (*GetGroupSnapshotResponse, error)
+	// RestoreGroup creates a group from a snapshot returned by `GetGroupSnapshot`.
+	RestoreGroup(context.Context, *RestoreGroupRequest) (*RestoreGroupResponse, error)
+	// Export streams the snapshots of groups, each group is a consistent point-in-time snapshot.
+	Export(*ExportRequest, TaskMaster_ExportServer) error
+	// Import merges or replaces groups with the snapshots streamed in the form of `Export`.
+	Import(TaskMaster_ImportServer) error
+	// GetBlob streams the payload stored in the blob store.
+	GetBlob(*GetBlobRequest, TaskMaster_GetBlobServer) error
+	// Watch streams the changes of tasks until the client cancels. Headers are sent once the watch starts.
+	Watch(*WatchRequest, TaskMaster_WatchServer) error
+	// GetJob returns the aggregate status of the tasks inserted with the same `job` in a group.
+	GetJob(context.Context, *GetJobRequest) (*GetJobResponse, error)
+	// WaitJob blocks until all tasks of a job are finished, failed, expired or cancelled.
+	WaitJob(context.Context, *WaitJobRequest) (*WaitJobResponse, error)
+	// UploadArtifact attaches a file produced by a leased task to it. The first chunk names the task and the file.
+	// Only the lease holder of the task can upload.
+	UploadArtifact(TaskMaster_UploadArtifactServer) error
+	// GetArtifact streams an artifact attached to a task, to its lease holder or the managers of its group.
+	GetArtifact(*GetArtifactRequest, TaskMaster_GetArtifactServer) error
 	mustEmbedUnimplementedTaskMasterServer()
 }
 
@@ -107,6 +538,69 @@ func (UnimplementedTaskMasterServer) Extend(context.Context, *TaskExtendRequest)
 func (UnimplementedTaskMasterServer) Insert(context.Context, *InsertRequest) (*InsertResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Insert not implemented")
 }
+func (UnimplementedTaskMasterServer) GetGroupSettings(context.Context, *GetGroupSettingsRequest) (*GetGroupSettingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGroupSettings not implemented")
+}
+func (UnimplementedTaskMasterServer) UpdateGroupSettings(context.Context, *UpdateGroupSettingsRequest) (*UpdateGroupSettingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateGroupSettings not implemented")
+}
+func (UnimplementedTaskMasterServer) PauseGroup(context.Context, *PauseGroupRequest) (*PauseGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseGroup not implemented")
+}
+func (UnimplementedTaskMasterServer) ResumeGroup(context.Context, *ResumeGroupRequest) (*ResumeGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeGroup not implemented")
+}
+func (UnimplementedTaskMasterServer) DrainGroup(context.Context, *DrainGroupRequest) (*DrainGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainGroup not implemented")
+}
+func (UnimplementedTaskMasterServer) DeleteGroup(context.Context, *DeleteGroupRequest) (*DeleteGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteGroup not implemented")
+}
+func (UnimplementedTaskMasterServer) ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGroups not implemented")
+}
+func (UnimplementedTaskMasterServer) ListTasks(context.Context, *ListTasksRequest) (*ListTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTasks not implemented")
+}
+func (UnimplementedTaskMasterServer) GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTask not implemented")
+}
+func (UnimplementedTaskMasterServer) CancelTask(context.Context, *CancelTaskRequest) (*CancelTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTask not implemented")
+}
+func (UnimplementedTaskMasterServer) RequeueTask(context.Context, *RequeueTaskRequest) (*RequeueTaskResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequeueTask not implemented")
+}
+func (UnimplementedTaskMasterServer) GetGroupSnapshot(context.Context, *GetGroupSnapshotRequest) (*GetGroupSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGroupSnapshot not implemented")
+}
+func (UnimplementedTaskMasterServer) RestoreGroup(context.Context, *RestoreGroupRequest) (*RestoreGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreGroup not implemented")
+}
+func (UnimplementedTaskMasterServer) Export(*ExportRequest, TaskMaster_ExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedTaskMasterServer) Import(TaskMaster_ImportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
+func (UnimplementedTaskMasterServer) GetBlob(*GetBlobRequest, TaskMaster_GetBlobServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlob not implemented")
+}
+func (UnimplementedTaskMasterServer) Watch(*WatchRequest, TaskMaster_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedTaskMasterServer) GetJob(context.Context, *GetJobRequest) (*GetJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedTaskMasterServer) WaitJob(context.Context, *WaitJobRequest) (*WaitJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitJob not implemented")
+}
+func (UnimplementedTaskMasterServer) UploadArtifact(TaskMaster_UploadArtifactServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadArtifact not implemented")
+}
+func (UnimplementedTaskMasterServer) GetArtifact(*GetArtifactRequest, TaskMaster_GetArtifactServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetArtifact not implemented")
+}
 func (UnimplementedTaskMasterServer) mustEmbedUnimplementedTaskMasterServer() {}
 
 // UnsafeTaskMasterServer may be embedded to opt out of forward compatibility for this service.
@@ -192,28 +686,845 @@ func _TaskMaster_Insert_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
-// TaskMaster_ServiceDesc is the grpc.ServiceDesc for TaskMaster service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var TaskMaster_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "proto.TaskMaster",
-	HandlerType: (*TaskMasterServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "Query",
-			Handler:    _TaskMaster_Query_Handler,
-		},
-		{
-			MethodName: "Finish",
-			Handler:    _TaskMaster_Finish_Handler,
-		},
-		{
-			MethodName: "Extend",
-			Handler:    _TaskMaster_Extend_Handler,
-		},
-		{
-			MethodName: "Insert",
-			Handler:    _TaskMaster_Insert_Handler,
+func _TaskMaster_GetGroupSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGroupSettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).GetGroupSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/GetGroupSettings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).GetGroupSettings(ctx, req.(*GetGroupSettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_UpdateGroupSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateGroupSettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).UpdateGroupSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/UpdateGroupSettings",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).UpdateGroupSettings(ctx, req.(*UpdateGroupSettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_PauseGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).PauseGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/PauseGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).PauseGroup(ctx, req.(*PauseGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_ResumeGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).ResumeGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/ResumeGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).ResumeGroup(ctx, req.(*ResumeGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_DrainGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).DrainGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/DrainGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).DrainGroup(ctx, req.(*DrainGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_DeleteGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).DeleteGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/DeleteGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).DeleteGroup(ctx, req.(*DeleteGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_ListGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGroupsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).ListGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/ListGroups",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).ListGroups(ctx, req.(*ListGroupsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_ListTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).ListTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/ListTasks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).ListTasks(ctx, req.(*ListTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_GetTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).GetTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/GetTask",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).GetTask(ctx, req.(*GetTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_CancelTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).CancelTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/CancelTask",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).CancelTask(ctx, req.(*CancelTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_RequeueTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequeueTaskRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).RequeueTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/RequeueTask",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).RequeueTask(ctx, req.(*RequeueTaskRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_GetGroupSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGroupSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).GetGroupSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/GetGroupSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).GetGroupSnapshot(ctx, req.(*GetGroupSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_RestoreGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).RestoreGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/RestoreGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).RestoreGroup(ctx, req.(*RestoreGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskMasterServer).Export(m, &taskMasterExportServer{stream})
+}
+
+type TaskMaster_ExportServer interface {
+	Send(*ExportChunk) error
+	grpc.ServerStream
+}
+
+type taskMasterExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterExportServer) Send(m *ExportChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TaskMaster_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TaskMasterServer).Import(&taskMasterImportServer{stream})
+}
+
+type TaskMaster_ImportServer interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ImportChunk, error)
+	grpc.ServerStream
+}
+
+type taskMasterImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterImportServer) SendAndClose(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *taskMasterImportServer) Recv() (*ImportChunk, error) {
+	m := new(ImportChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _TaskMaster_GetBlob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetBlobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskMasterServer).GetBlob(m, &taskMasterGetBlobServer{stream})
+}
+
+type TaskMaster_GetBlobServer interface {
+	Send(*GetBlobResponse) error
+	grpc.ServerStream
+}
+
+type taskMasterGetBlobServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterGetBlobServer) Send(m *GetBlobResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TaskMaster_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskMasterServer).Watch(m, &taskMasterWatchServer{stream})
+}
+
+type TaskMaster_WatchServer interface {
+	Send(*TaskEvent) error
+	grpc.ServerStream
+}
+
+type taskMasterWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterWatchServer) Send(m *TaskEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TaskMaster_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/GetJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_WaitJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WaitJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterServer).WaitJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMaster/WaitJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterServer).WaitJob(ctx, req.(*WaitJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMaster_UploadArtifact_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TaskMasterServer).UploadArtifact(&taskMasterUploadArtifactServer{stream})
+}
+
+type TaskMaster_UploadArtifactServer interface {
+	SendAndClose(*UploadArtifactResponse) error
+	Recv() (*UploadArtifactRequest, error)
+	grpc.ServerStream
+}
+
+type taskMasterUploadArtifactServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterUploadArtifactServer) SendAndClose(m *UploadArtifactResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *taskMasterUploadArtifactServer) Recv() (*UploadArtifactRequest, error) {
+	m := new(UploadArtifactRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _TaskMaster_GetArtifact_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetArtifactRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskMasterServer).GetArtifact(m, &taskMasterGetArtifactServer{stream})
+}
+
+type TaskMaster_GetArtifactServer interface {
+	Send(*GetArtifactResponse) error
+	grpc.ServerStream
+}
+
+type taskMasterGetArtifactServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterGetArtifactServer) Send(m *GetArtifactResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TaskMaster_ServiceDesc is the grpc.ServiceDesc for TaskMaster service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TaskMaster_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.TaskMaster",
+	HandlerType: (*TaskMasterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _TaskMaster_Query_Handler,
+		},
+		{
+			MethodName: "Finish",
+			Handler:    _TaskMaster_Finish_Handler,
+		},
+		{
+			MethodName: "Extend",
+			Handler:    _TaskMaster_Extend_Handler,
+		},
+		{
+			MethodName: "Insert",
+			Handler:    _TaskMaster_Insert_Handler,
+		},
+		{
+			MethodName: "GetGroupSettings",
+			Handler:    _TaskMaster_GetGroupSettings_Handler,
+		},
+		{
+			MethodName: "UpdateGroupSettings",
+			Handler:    _TaskMaster_UpdateGroupSettings_Handler,
+		},
+		{
+			MethodName: "PauseGroup",
+			Handler:    _TaskMaster_PauseGroup_Handler,
+		},
+		{
+			MethodName: "ResumeGroup",
+			Handler:    _TaskMaster_ResumeGroup_Handler,
+		},
+		{
+			MethodName: "DrainGroup",
+			Handler:    _TaskMaster_DrainGroup_Handler,
+		},
+		{
+			MethodName: "DeleteGroup",
+			Handler:    _TaskMaster_DeleteGroup_Handler,
+		},
+		{
+			MethodName: "ListGroups",
+			Handler:    _TaskMaster_ListGroups_Handler,
+		},
+		{
+			MethodName: "ListTasks",
+			Handler:    _TaskMaster_ListTasks_Handler,
+		},
+		{
+			MethodName: "GetTask",
+			Handler:    _TaskMaster_GetTask_Handler,
+		},
+		{
+			MethodName: "CancelTask",
+			Handler:    _TaskMaster_CancelTask_Handler,
+		},
+		{
+			MethodName: "RequeueTask",
+			Handler:    _TaskMaster_RequeueTask_Handler,
+		},
+		{
+			MethodName: "GetGroupSnapshot",
+			Handler:    _TaskMaster_GetGroupSnapshot_Handler,
+		},
+		{
+			MethodName: "RestoreGroup",
+			Handler:    _TaskMaster_RestoreGroup_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _TaskMaster_GetJob_Handler,
+		},
+		{
+			MethodName: "WaitJob",
+			Handler:    _TaskMaster_WaitJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Export",
+			Handler:       _TaskMaster_Export_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _TaskMaster_Import_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetBlob",
+			Handler:       _TaskMaster_GetBlob_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _TaskMaster_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadArtifact",
+			Handler:       _TaskMaster_UploadArtifact_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetArtifact",
+			Handler:       _TaskMaster_GetArtifact_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "taskmaster.proto",
+}
+
+// TaskMasterReplicationClient is the client API for TaskMasterReplication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TaskMasterReplicationClient interface {
+	// Follow streams the mutation log of the leader after the position of the follower.
+	// The stream starts with the snapshots of all groups if the position is not in the log of the leader.
+	Follow(ctx context.Context, in *FollowRequest, opts ...grpc.CallOption) (TaskMasterReplication_FollowClient, error)
+	// GetReplicationStatus returns the role and the replication position of the server to the callers allowed to follow it.
+	GetReplicationStatus(ctx context.Context, in *GetReplicationStatusRequest, opts ...grpc.CallOption) (*GetReplicationStatusResponse, error)
+	// Promote makes a follower become the leader.
+	Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error)
+}
+
+type taskMasterReplicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTaskMasterReplicationClient(cc grpc.ClientConnInterface) TaskMasterReplicationClient {
+	return &taskMasterReplicationClient{cc}
+}
+
+func (c *taskMasterReplicationClient) Follow(ctx context.Context, in *FollowRequest, opts ...grpc.CallOption) (TaskMasterReplication_FollowClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskMasterReplication_ServiceDesc.Streams[0], "/proto.TaskMasterReplication/Follow", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskMasterReplicationFollowClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskMasterReplication_FollowClient interface {
+	Recv() (*ReplicationEvent, error)
+	grpc.ClientStream
+}
+
+type taskMasterReplicationFollowClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskMasterReplicationFollowClient) Recv() (*ReplicationEvent, error) {
+	m := new(ReplicationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskMasterReplicationClient) GetReplicationStatus(ctx context.Context, in *GetReplicationStatusRequest, opts ...grpc.CallOption) (*GetReplicationStatusResponse, error) {
+	out := new(GetReplicationStatusResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMasterReplication/GetReplicationStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterReplicationClient) Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error) {
+	out := new(PromoteResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMasterReplication/Promote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TaskMasterReplicationServer is the server API for TaskMasterReplication service.
+// All implementations must embed UnimplementedTaskMasterReplicationServer
+// for forward compatibility
+type TaskMasterReplicationServer interface {
+	// Follow streams the mutation log of the leader after the position of the follower.
+	// The stream starts with the snapshots of all groups if the position is not in the log of the leader.
+	Follow(*FollowRequest, TaskMasterReplication_FollowServer) error
+	// GetReplicationStatus returns the role and the replication position of the server to the callers allowed to follow it.
+	GetReplicationStatus(context.Context, *GetReplicationStatusRequest) (*GetReplicationStatusResponse, error)
+	// Promote makes a follower become the leader.
+	Promote(context.Context, *PromoteRequest) (*PromoteResponse, error)
+	mustEmbedUnimplementedTaskMasterReplicationServer()
+}
+
+// UnimplementedTaskMasterReplicationServer must be embedded to have forward compatible implementations.
+type UnimplementedTaskMasterReplicationServer struct {
+}
+
+func (UnimplementedTaskMasterReplicationServer) Follow(*FollowRequest, TaskMasterReplication_FollowServer) error {
+	return status.Errorf(codes.Unimplemented, "method Follow not implemented")
+}
+func (UnimplementedTaskMasterReplicationServer) GetReplicationStatus(context.Context, *GetReplicationStatusRequest) (*GetReplicationStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReplicationStatus not implemented")
+}
+func (UnimplementedTaskMasterReplicationServer) Promote(context.Context, *PromoteRequest) (*PromoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Promote not implemented")
+}
+func (UnimplementedTaskMasterReplicationServer) mustEmbedUnimplementedTaskMasterReplicationServer() {}
+
+// UnsafeTaskMasterReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TaskMasterReplicationServer will
+// result in compilation errors.
+type UnsafeTaskMasterReplicationServer interface {
+	mustEmbedUnimplementedTaskMasterReplicationServer()
+}
+
+func RegisterTaskMasterReplicationServer(s grpc.ServiceRegistrar, srv TaskMasterReplicationServer) {
+	s.RegisterService(&TaskMasterReplication_ServiceDesc, srv)
+}
+
+func _TaskMasterReplication_Follow_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FollowRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskMasterReplicationServer).Follow(m, &taskMasterReplicationFollowServer{stream})
+}
+
+type TaskMasterReplication_FollowServer interface {
+	Send(*ReplicationEvent) error
+	grpc.ServerStream
+}
+
+type taskMasterReplicationFollowServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskMasterReplicationFollowServer) Send(m *ReplicationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TaskMasterReplication_GetReplicationStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetReplicationStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterReplicationServer).GetReplicationStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMasterReplication/GetReplicationStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterReplicationServer).GetReplicationStatus(ctx, req.(*GetReplicationStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMasterReplication_Promote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterReplicationServer).Promote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMasterReplication/Promote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterReplicationServer).Promote(ctx, req.(*PromoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TaskMasterReplication_ServiceDesc is the grpc.ServiceDesc for TaskMasterReplication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TaskMasterReplication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.TaskMasterReplication",
+	HandlerType: (*TaskMasterReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetReplicationStatus",
+			Handler:    _TaskMasterReplication_GetReplicationStatus_Handler,
+		},
+		{
+			MethodName: "Promote",
+			Handler:    _TaskMasterReplication_Promote_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Follow",
+			Handler:       _TaskMasterReplication_Follow_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "taskmaster.proto",
+}
+
+// TaskMasterRouterClient is the client API for TaskMasterRouter service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TaskMasterRouterClient interface {
+	// GetRoute returns the backend serving a group.
+	GetRoute(ctx context.Context, in *GetRouteRequest, opts ...grpc.CallOption) (*GetRouteResponse, error)
+	// MigrateGroup moves a group to another backend with its snapshot.
+	MigrateGroup(ctx context.Context, in *MigrateGroupRequest, opts ...grpc.CallOption) (*MigrateGroupResponse, error)
+}
+
+type taskMasterRouterClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTaskMasterRouterClient(cc grpc.ClientConnInterface) TaskMasterRouterClient {
+	return &taskMasterRouterClient{cc}
+}
+
+func (c *taskMasterRouterClient) GetRoute(ctx context.Context, in *GetRouteRequest, opts ...grpc.CallOption) (*GetRouteResponse, error) {
+	out := new(GetRouteResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMasterRouter/GetRoute", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskMasterRouterClient) MigrateGroup(ctx context.Context, in *MigrateGroupRequest, opts ...grpc.CallOption) (*MigrateGroupResponse, error) {
+	out := new(MigrateGroupResponse)
+	err := c.cc.Invoke(ctx, "/proto.TaskMasterRouter/MigrateGroup", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TaskMasterRouterServer is the server API for TaskMasterRouter service.
+// All implementations must embed UnimplementedTaskMasterRouterServer
+// for forward compatibility
+type TaskMasterRouterServer interface {
+	// GetRoute returns the backend serving a group.
+	GetRoute(context.Context, *GetRouteRequest) (*GetRouteResponse, error)
+	// MigrateGroup moves a group to another backend with its snapshot.
+	MigrateGroup(context.Context, *MigrateGroupRequest) (*MigrateGroupResponse, error)
+	mustEmbedUnimplementedTaskMasterRouterServer()
+}
+
+// UnimplementedTaskMasterRouterServer must be embedded to have forward compatible implementations.
+type UnimplementedTaskMasterRouterServer struct {
+}
+
+func (UnimplementedTaskMasterRouterServer) GetRoute(context.Context, *GetRouteRequest) (*GetRouteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoute not implemented")
+}
+func (UnimplementedTaskMasterRouterServer) MigrateGroup(context.Context, *MigrateGroupRequest) (*MigrateGroupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MigrateGroup not implemented")
+}
+func (UnimplementedTaskMasterRouterServer) mustEmbedUnimplementedTaskMasterRouterServer() {}
+
+// UnsafeTaskMasterRouterServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TaskMasterRouterServer will
+// result in compilation errors.
+type UnsafeTaskMasterRouterServer interface {
+	mustEmbedUnimplementedTaskMasterRouterServer()
+}
+
+func RegisterTaskMasterRouterServer(s grpc.ServiceRegistrar, srv TaskMasterRouterServer) {
+	s.RegisterService(&TaskMasterRouter_ServiceDesc, srv)
+}
+
+func _TaskMasterRouter_GetRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterRouterServer).GetRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMasterRouter/GetRoute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterRouterServer).GetRoute(ctx, req.(*GetRouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskMasterRouter_MigrateGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MigrateGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskMasterRouterServer).MigrateGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TaskMasterRouter/MigrateGroup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskMasterRouterServer).MigrateGroup(ctx, req.(*MigrateGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TaskMasterRouter_ServiceDesc is the grpc.ServiceDesc for TaskMasterRouter service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TaskMasterRouter_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.TaskMasterRouter",
+	HandlerType: (*TaskMasterRouterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRoute",
+			Handler:    _TaskMasterRouter_GetRoute_Handler,
+		},
+		{
+			MethodName: "MigrateGroup",
+			Handler:    _TaskMasterRouter_MigrateGroup_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},